@@ -0,0 +1,35 @@
+package client
+
+// ForServer returns the auth code of the server at url. It is safe to call from
+// several goroutines, also while another one calls SetServer.
+func (a *AuthCodes) ForServer(url string) (string, bool) {
+	if a == nil {
+		return "", false
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	code, ok := a.ServerAuthCodes[url]
+	return code, ok
+}
+
+// SetServer sets the auth code of the server at url, excluding concurrent calls
+// to ForServer, SetServer and Clone
+func (a *AuthCodes) SetServer(url, code string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.ServerAuthCodes == nil {
+		a.ServerAuthCodes = make(map[string]string)
+	}
+	a.ServerAuthCodes[url] = code
+}
+
+// Clone returns a copy of the auth codes that shares no state with a
+func (a *AuthCodes) Clone() *AuthCodes {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	clone := &AuthCodes{BaseAuthCode: a.BaseAuthCode, ServerAuthCodes: make(map[string]string, len(a.ServerAuthCodes))}
+	for url, code := range a.ServerAuthCodes {
+		clone.ServerAuthCodes[url] = code
+	}
+	return clone
+}
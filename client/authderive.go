@@ -0,0 +1,72 @@
+package client
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/hkdf"
+)
+
+// authCodeSalt separates derived auth codes from other HKDF uses of the password
+var authCodeSalt = []byte("OpenADP-AuthCodes-v1")
+
+// DeriveAuthCodes derives the auth codes of a backup from its identity and
+// password, so that they can be recomputed at recovery instead of stored.
+//
+// WARNING: a backup registered with derived auth codes has no guess limit.
+// Servers reject a wrong auth code without counting a guess, and the auth codes
+// are a public function of the identity and the password. Anyone who knows the
+// identity can therefore test passwords online against any one server, as often
+// as that server answers, by sending the codes derived from each guess: the
+// codes are accepted exactly when the guess is right. maxGuesses only limits
+// guesses made with the right auth codes, which a derived-code backup never
+// sees from an attacker.
+//
+// The password, normalized as by PasswordToPin, is stretched with Argon2id
+// (the defaults of PinKDFArgon2id) salted with the identity, and the base auth
+// code is the hex of 32 bytes of HKDF-SHA256 of the result with the identity as
+// info. The per-server codes follow from the base code as in GenerateAuthCodes
+// (see AuthCodesFromBase), so the result differs per server and per identity
+// and is stored and restored like random codes.
+//
+// Prefer GenerateAuthCodes, which remains the default. Random codes are
+// independent of the password, so a server learns nothing from the code it is
+// sent, an attacker without them cannot make a guess count, and one with them is
+// held to maxGuesses. Beyond the online attack above, a single server, or anyone
+// who observes a derived code, can also test guesses offline at the cost of one
+// Argon2id evaluation each. Use derived codes only where storing the codes is
+// impossible and the password alone resists guessing, as a long random
+// passphrase does; the Argon2id stretching is then the only rate limit.
+func DeriveAuthCodes(identity *Identity, password string, serverURLs []string) (*AuthCodes, error) {
+	if err := identity.Validate(); err != nil {
+		return nil, err
+	}
+
+	context := identityContext(identity)
+	salt := sha256.Sum256(context)
+	pin := PasswordToPin(password)
+	defer zeroize(pin)
+	stretched := argon2.IDKey(pin, salt[:], defaultArgon2Time, defaultArgon2MemoryKiB, defaultArgon2Threads, 32)
+	defer zeroize(stretched)
+
+	base := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, stretched, authCodeSalt, context), base); err != nil {
+		return nil, fmt.Errorf("failed to derive base auth code: %w", err)
+	}
+	return AuthCodesFromBase(hex.EncodeToString(base), serverURLs), nil
+}
+
+// identityContext encodes identity unambiguously, each field prefixed with its
+// length
+func identityContext(identity *Identity) []byte {
+	var context []byte
+	for _, field := range []string{identity.UID, identity.DID, identity.BID} {
+		context = binary.BigEndian.AppendUint16(context, uint16(len(field)))
+		context = append(context, field...)
+	}
+	return context
+}
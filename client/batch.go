@@ -0,0 +1,39 @@
+package client
+
+import "time"
+
+// GenerationRequest is one backup to register with GenerateEncryptionKeyBatch
+type GenerationRequest struct {
+	Identity   *Identity
+	Password   string
+	MaxGuesses int
+	ExpiresAt  time.Time // Zero for a backup that never expires
+}
+
+// GenerationResult is the outcome of one GenerationRequest
+type GenerationResult = GenerateEncryptionKeyResult
+
+// GenerateEncryptionKeyBatch registers many backups on the same servers, as
+// GenerateEncryptionKeyWithExpiry would one by one, and returns their results in
+// the order of requests.
+//
+// Requests run concurrently, at most opts.BatchConcurrency at a time, over one
+// connection pool per server (see Pool) that lasts for the whole batch. Each request
+// succeeds or fails on its own: an invalid request or a server failure during one
+// registration is reported in that request's result only.
+func GenerateEncryptionKeyBatch(requests []GenerationRequest, serverInfos []ServerInfo, opts *Options) []GenerationResult {
+	results := make([]GenerationResult, len(requests))
+	if len(requests) == 0 {
+		return results
+	}
+
+	pool := NewPool(serverInfos, opts, nil)
+	defer pool.Close()
+
+	forEachServer(len(requests), opts.batchConcurrency(len(requests)), func(i int) {
+		request := requests[i]
+		results[i] = *pool.Generate(request.Identity, request.Password, request.MaxGuesses, request.ExpiresAt)
+	})
+
+	return results
+}
@@ -0,0 +1,53 @@
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestGenerateEncryptionKeyBatch(t *testing.T) {
+	_, serverInfos := newTestServers(t, 3)
+
+	requests := make([]GenerationRequest, 10)
+	for i := range requests {
+		requests[i] = GenerationRequest{
+			Identity:   &Identity{UID: fmt.Sprintf("user%d", i), DID: "device", BID: "backup"},
+			Password:   fmt.Sprintf("password%d", i),
+			MaxGuesses: 10,
+		}
+	}
+	// An invalid request fails on its own
+	requests[4].Identity = &Identity{UID: "user4", DID: "device"}
+
+	results := GenerateEncryptionKeyBatch(requests, serverInfos, &Options{BatchConcurrency: 4})
+	if len(results) != len(requests) {
+		t.Fatalf("Got %d results for %d requests", len(results), len(requests))
+	}
+
+	keys := make(map[string]bool)
+	for i, result := range results {
+		request := requests[i]
+		if i == 4 {
+			if result.ErrCode != ErrInvalidIdentity {
+				t.Errorf("Request %d: ErrCode = %q, want %q", i, result.ErrCode, ErrInvalidIdentity)
+			}
+			continue
+		}
+		if result.Error != "" {
+			t.Fatalf("Request %d failed: %s", i, result.Error)
+		}
+		keys[string(result.EncryptionKey)] = true
+
+		recovered := RecoverEncryptionKeyWithServerInfo(request.Identity, request.Password, result.ServerInfos, result.Threshold, result.AuthCodes)
+		if recovered.Error != "" {
+			t.Fatalf("Request %d: recovery failed: %s", i, recovered.Error)
+		}
+		if !bytes.Equal(recovered.EncryptionKey, result.EncryptionKey) {
+			t.Errorf("Request %d: recovered key does not match", i)
+		}
+	}
+	if len(keys) != len(requests)-1 {
+		t.Errorf("Got %d distinct keys for %d backups", len(keys), len(requests)-1)
+	}
+}
@@ -0,0 +1,41 @@
+package client
+
+import "encoding/binary"
+
+// identityBindingInfo prefixes the HKDF info of keys bound to an identity
+const identityBindingInfo = "OpenADP-Identity-v1"
+
+// DeriveKeyBound binds masterKey, the EncryptionKey of a backup, to identity by
+// expanding it with HKDF-SHA256 into a key of the same length whose info string
+// encodes the UID, DID and BID. Keys bound to different identities are
+// independent even if the master keys collide, so data encrypted for one
+// application cannot be decrypted with a key recovered for another.
+//
+// The binding is part of the key: data encrypted under a bound key can only be
+// decrypted under a key bound to the same identity, so the same identity must be
+// used at encryption and decryption time. Options.BindIdentity applies this
+// binding to the key returned by key generation and recovery. The master key
+// may be at most 8160 bytes long, far beyond MaxKeyLength.
+func DeriveKeyBound(masterKey []byte, identity *Identity) []byte {
+	return expandKey(masterKey, subkeySalt, identityBinding(identity), len(masterKey))
+}
+
+// identityBinding encodes identity unambiguously for the HKDF info, prefixing each
+// field with its 32-bit big-endian length
+func identityBinding(identity *Identity) []byte {
+	info := []byte(identityBindingInfo)
+	for _, field := range []string{identity.UID, identity.DID, identity.BID} {
+		info = binary.BigEndian.AppendUint32(info, uint32(len(field)))
+		info = append(info, field...)
+	}
+	return info
+}
+
+// boundKey returns key bound to identity if opts selects BindIdentity, and key
+// unchanged otherwise
+func (o *Options) boundKey(key []byte, identity *Identity) []byte {
+	if o == nil || !o.BindIdentity {
+		return key
+	}
+	return DeriveKeyBound(key, identity)
+}
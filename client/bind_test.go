@@ -0,0 +1,101 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDeriveKeyBound(t *testing.T) {
+	master := bytes.Repeat([]byte{0x42}, 32)
+	identity := &Identity{UID: "user", DID: "app", BID: "even"}
+
+	tests := []struct {
+		name  string
+		other *Identity
+	}{
+		{"different DID", &Identity{UID: "user", DID: "other-app", BID: "even"}},
+		{"different BID", &Identity{UID: "user", DID: "app", BID: "odd"}},
+		{"different UID", &Identity{UID: "other", DID: "app", BID: "even"}},
+		{"shifted field boundary", &Identity{UID: "user", DID: "ap", BID: "peven"}},
+	}
+
+	bound := DeriveKeyBound(master, identity)
+	if len(bound) != len(master) || bytes.Equal(bound, master) {
+		t.Fatalf("DeriveKeyBound() = %x, want a different %d-byte key", bound, len(master))
+	}
+	if !bytes.Equal(bound, DeriveKeyBound(master, identity)) {
+		t.Error("DeriveKeyBound() is not deterministic")
+	}
+
+	var ciphertext bytes.Buffer
+	if err := EncryptStream(bound, bytes.NewReader([]byte("secret data")), &ciphertext); err != nil {
+		t.Fatalf("EncryptStream() error: %v", err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			other := DeriveKeyBound(master, tt.other)
+			if bytes.Equal(other, bound) {
+				t.Fatal("Keys bound to different identities are equal")
+			}
+			var plaintext bytes.Buffer
+			err := DecryptStream(other, bytes.NewReader(ciphertext.Bytes()), &plaintext)
+			if !errors.Is(err, ErrCorruptStream) {
+				t.Errorf("DecryptStream() with the wrong binding: error = %v, want ErrCorruptStream", err)
+			}
+		})
+	}
+}
+
+func TestBindIdentityOption(t *testing.T) {
+	_, serverInfos := newTestServers(t, 3)
+	identity := &Identity{UID: "user", DID: "app", BID: "bound"}
+
+	generated := GenerateEncryptionKeyWithOpts(identity, "password", 10, 0, serverInfos, &Options{BindIdentity: true})
+	if generated.Error != "" {
+		t.Fatalf("Key generation failed: %s", generated.Error)
+	}
+	if !generated.IdentityBound {
+		t.Error("IdentityBound is not set")
+	}
+
+	metadata, err := MetadataFromResult(identity, generated)
+	if err != nil {
+		t.Fatalf("MetadataFromResult() error: %v", err)
+	}
+	encoded, _ := json.Marshal(metadata)
+	var decoded Metadata
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatalf("Unmarshal() error: %v", err)
+	}
+	opts, err := decoded.RecoveryOptions(&Options{KeyCache: NewKeyCache(time.Minute)})
+	if err != nil {
+		t.Fatalf("RecoveryOptions() error: %v", err)
+	}
+
+	// The cached key is bound the same way as the recovered one
+	for _, wantCached := range []bool{false, true} {
+		recovered := RecoverEncryptionKeyWithOpts(identity, "password", MetadataToServerInfo(&decoded), decoded.Threshold, decoded.AuthCodes, opts)
+		if recovered.Error != "" {
+			t.Fatalf("Recovery failed: %s", recovered.Error)
+		}
+		if recovered.Cached != wantCached {
+			t.Errorf("Cached = %v, want %v", recovered.Cached, wantCached)
+		}
+		if !bytes.Equal(recovered.EncryptionKey, generated.EncryptionKey) {
+			t.Error("Recovered key does not match")
+		}
+	}
+
+	// Without the binding the servers return the master key
+	unbound := RecoverEncryptionKeyWithServerInfo(identity, "password", serverInfos, generated.Threshold, generated.AuthCodes)
+	if unbound.Error != "" {
+		t.Fatalf("Recovery failed: %s", unbound.Error)
+	}
+	if !bytes.Equal(DeriveKeyBound(unbound.EncryptionKey, identity), generated.EncryptionKey) {
+		t.Error("Bound key is not DeriveKeyBound of the master key")
+	}
+}
@@ -0,0 +1,55 @@
+package client
+
+import (
+	"crypto/sha256"
+	"errors"
+	"math/big"
+	"sync"
+)
+
+// errBlindingReuse is returned when a recovery draws a blinding factor that an
+// earlier recovery in the process already used. Reusing r across two recoveries
+// lets a server relate the blinded points r·U it receives, so this only happens
+// if the source of randomness is broken, such as a fixed Options.Rand.
+var errBlindingReuse = errors.New("blinding factor reused across recoveries")
+
+// blindingGuard records fingerprints of the blinding factors drawn by recoveries to
+// detect reuse
+type blindingGuard struct {
+	mu   sync.Mutex
+	seen map[[sha256.Size]byte]bool
+}
+
+// activeBlindingGuard is consulted by every recovery if set. It is a test hook:
+// the fingerprints it keeps would outlive the zeroized factors, so production
+// code leaves it nil.
+var activeBlindingGuard *blindingGuard
+
+// newBlindingGuard returns an empty guard
+func newBlindingGuard() *blindingGuard {
+	return &blindingGuard{seen: make(map[[sha256.Size]byte]bool)}
+}
+
+// check records r and fails with errBlindingReuse if it was seen before. A nil
+// guard accepts every factor.
+func (g *blindingGuard) check(r *big.Int) error {
+	if g == nil {
+		return nil
+	}
+	fingerprint := sha256.Sum256(r.Bytes())
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.seen[fingerprint] {
+		return errBlindingReuse
+	}
+	g.seen[fingerprint] = true
+	return nil
+}
+
+// count returns how many distinct blinding factors the guard has seen
+func (g *blindingGuard) count() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return len(g.seen)
+}
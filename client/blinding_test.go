@@ -0,0 +1,56 @@
+package client
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRecoveryBlindingFactorsAreFresh(t *testing.T) {
+	servers, serverInfos := newTestServers(t, 3)
+	identity := &Identity{UID: "user", DID: "laptop", BID: "blinding"}
+
+	guard := newBlindingGuard()
+	activeBlindingGuard = guard
+	t.Cleanup(func() { activeBlindingGuard = nil })
+
+	generated := GenerateEncryptionKey(identity, "password", 10, 0, serverInfos)
+	if generated.Error != "" {
+		t.Fatalf("Key generation failed: %s", generated.Error)
+	}
+
+	for i := 0; i < 2; i++ {
+		recovered := RecoverEncryptionKeyWithServerInfo(identity, "password", serverInfos, generated.Threshold, generated.AuthCodes)
+		if recovered.Error != "" {
+			t.Fatalf("Recovery %d failed: %s", i+1, recovered.Error)
+		}
+		if !bytes.Equal(recovered.EncryptionKey, generated.EncryptionKey) {
+			t.Errorf("Recovery %d returned a different key", i+1)
+		}
+	}
+	if got := guard.count(); got != 2 {
+		t.Errorf("Two recoveries used %d distinct blinding factors, want 2", got)
+	}
+
+	// A fixed source of randomness repeats the factor; the guard stops the second
+	// recovery before any guess is spent
+	for i := 0; i < 2; i++ {
+		result := RecoverEncryptionKeyWithOpts(identity, "password", serverInfos, generated.Threshold, generated.AuthCodes, &Options{Rand: seededReader(9)})
+		if i == 0 && result.Error != "" {
+			t.Fatalf("Recovery with a fixed Rand failed: %s", result.Error)
+		}
+		if i == 1 && (result.ErrCode != ErrInternal || result.Error != errBlindingReuse.Error()) {
+			t.Errorf("Reused blinding factor: got %q (%s), want %q (%s)", result.ErrCode, result.Error, ErrInternal, errBlindingReuse)
+		}
+	}
+	// Recovery stops once it has threshold shares, so only the first servers
+	// are asked
+	for i, server := range servers {
+		want := 3
+		if i >= generated.Threshold {
+			want = 0
+		}
+		if got := server.NumGuesses(identity.UID, identity.DID, identity.BID); got != want {
+			t.Errorf("Server %d has %d guesses recorded, want %d", i, got, want)
+		}
+	}
+}
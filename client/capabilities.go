@@ -0,0 +1,245 @@
+package client
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ProtocolVersion is the version of the share format this client registers
+const ProtocolVersion = 1
+
+// shareSize is the size in bytes of the share y registered with each server
+const shareSize = 32
+
+// Capabilities describes the protocol features a server supports, as advertised
+// in its GetServerInfo response. Servers that predate capability discovery
+// advertise nothing; the zero-knowledge defaults then describe what every server
+// supports: ProtocolVersion 1, no known size limit and any PIN KDF.
+type Capabilities struct {
+	// Known reports that the server answered GetServerInfo
+	Known bool
+
+	// ProtocolVersions lists the share format versions the server accepts, from
+	// "protocol_versions". Servers that do not advertise them accept version 1.
+	ProtocolVersions []int
+
+	// MaxBackupSize is the largest share in bytes the server stores, from
+	// "max_backup_size". Zero means no limit is advertised.
+	MaxBackupSize int
+
+	// PinKDFs lists the PIN derivations the server accepts backups for, from
+	// "pin_kdfs", with PinKDFNone advertised as "none". Nil means the server does
+	// not restrict them.
+	PinKDFs []PinKDF
+
+	// Idempotent reports that the server advertises CapabilityIdempotentRegister
+	Idempotent bool
+
+	// ResetGuesses reports that the server advertises CapabilityResetGuesses
+	ResetGuesses bool
+
+	// ReplayProtection reports that the server advertises CapabilityReplayProtection
+	ReplayProtection bool
+
+	// DeleteBackup reports that the server advertises CapabilityDeleteBackup
+	DeleteBackup bool
+
+	// MaxExpiration is the furthest in the future a backup may expire, from
+	// "max_expiration" in seconds. Backups that never expire exceed it. Zero
+	// means no limit is advertised.
+	MaxExpiration time.Duration
+}
+
+// SupportsProtocolVersion reports whether the server accepts shares of version
+func (c Capabilities) SupportsProtocolVersion(version int) bool {
+	for _, v := range c.ProtocolVersions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+// SupportsPinKDF reports whether the server accepts backups whose PIN was derived with kdf
+func (c Capabilities) SupportsPinKDF(kdf PinKDF) bool {
+	if c.PinKDFs == nil {
+		return true
+	}
+	for _, k := range c.PinKDFs {
+		if k == kdf {
+			return true
+		}
+	}
+	return false
+}
+
+// unsupported returns why a server with c cannot store a backup of share format
+// version whose PIN is derived with kdf, or "" if it can
+func (c Capabilities) unsupported(kdf PinKDF, version int) string {
+	switch {
+	case !c.SupportsProtocolVersion(version):
+		return fmt.Sprintf("protocol version %d", version)
+	case c.MaxBackupSize > 0 && c.MaxBackupSize < shareSize:
+		return fmt.Sprintf("%d-byte shares", shareSize)
+	case !c.SupportsPinKDF(kdf):
+		return fmt.Sprintf("PIN KDF %q", pinKDFName(kdf))
+	}
+	return ""
+}
+
+// IntersectCapabilities returns the capabilities that all of caps share: the
+// common protocol versions and PIN KDFs, the smallest size limit, and
+// idempotency only if every server supports it. Known is set only if every
+// server answered.
+func IntersectCapabilities(caps ...Capabilities) Capabilities {
+	if len(caps) == 0 {
+		return Capabilities{}
+	}
+
+	common := caps[0]
+	common.ProtocolVersions = append([]int(nil), common.ProtocolVersions...)
+	if common.PinKDFs != nil {
+		common.PinKDFs = append([]PinKDF{}, common.PinKDFs...)
+	}
+	for _, c := range caps[1:] {
+		common.Known = common.Known && c.Known
+		common.Idempotent = common.Idempotent && c.Idempotent
+		common.ResetGuesses = common.ResetGuesses && c.ResetGuesses
+		common.ReplayProtection = common.ReplayProtection && c.ReplayProtection
+		common.DeleteBackup = common.DeleteBackup && c.DeleteBackup
+		if c.MaxBackupSize > 0 && (common.MaxBackupSize == 0 || c.MaxBackupSize < common.MaxBackupSize) {
+			common.MaxBackupSize = c.MaxBackupSize
+		}
+		if c.MaxExpiration > 0 && (common.MaxExpiration == 0 || c.MaxExpiration < common.MaxExpiration) {
+			common.MaxExpiration = c.MaxExpiration
+		}
+
+		versions := common.ProtocolVersions[:0]
+		for _, v := range common.ProtocolVersions {
+			if c.SupportsProtocolVersion(v) {
+				versions = append(versions, v)
+			}
+		}
+		common.ProtocolVersions = versions
+
+		if c.PinKDFs != nil {
+			if common.PinKDFs == nil {
+				common.PinKDFs = append([]PinKDF{}, c.PinKDFs...)
+				continue
+			}
+			kdfs := common.PinKDFs[:0]
+			for _, k := range common.PinKDFs {
+				if c.SupportsPinKDF(k) {
+					kdfs = append(kdfs, k)
+				}
+			}
+			common.PinKDFs = kdfs
+		}
+	}
+	return common
+}
+
+// capabilitiesFromInfo reads the capabilities advertised in a GetServerInfo response
+func capabilitiesFromInfo(info map[string]interface{}) Capabilities {
+	caps := Capabilities{
+		Known:            true,
+		ProtocolVersions: []int{1},
+		Idempotent:       hasCapability(serverCapabilities(info), CapabilityIdempotentRegister),
+		ResetGuesses:     hasCapability(serverCapabilities(info), CapabilityResetGuesses),
+		ReplayProtection: hasCapability(serverCapabilities(info), CapabilityReplayProtection),
+		DeleteBackup:     hasCapability(serverCapabilities(info), CapabilityDeleteBackup),
+	}
+
+	if list, ok := info["protocol_versions"].([]interface{}); ok {
+		caps.ProtocolVersions = []int{}
+		for _, item := range list {
+			if version, ok := item.(float64); ok {
+				caps.ProtocolVersions = append(caps.ProtocolVersions, int(version))
+			}
+		}
+	}
+	if size, ok := info["max_backup_size"].(float64); ok && size > 0 {
+		caps.MaxBackupSize = int(size)
+	}
+	if seconds, ok := info["max_expiration"].(float64); ok && seconds > 0 {
+		caps.MaxExpiration = time.Duration(seconds) * time.Second
+	}
+	if list, ok := info["pin_kdfs"].([]interface{}); ok {
+		caps.PinKDFs = []PinKDF{}
+		for _, item := range list {
+			if name, ok := item.(string); ok {
+				caps.PinKDFs = append(caps.PinKDFs, pinKDFFromName(name))
+			}
+		}
+	}
+	return caps
+}
+
+// unknownCapabilities are assumed for servers that do not answer GetServerInfo
+func unknownCapabilities() Capabilities {
+	return Capabilities{ProtocolVersions: []int{1}}
+}
+
+// pinKDFName returns the name under which servers advertise kdf
+func pinKDFName(kdf PinKDF) string {
+	if kdf == PinKDFNone {
+		return "none"
+	}
+	return string(kdf)
+}
+
+// pinKDFFromName is the inverse of pinKDFName
+func pinKDFFromName(name string) PinKDF {
+	if name == "none" {
+		return PinKDFNone
+	}
+	return PinKDF(name)
+}
+
+// capabilityCache holds what a client has learned about its server. It is shared
+// by a client and the copies WithContext makes of it.
+type capabilityCache struct {
+	once         sync.Once
+	capabilities Capabilities
+
+	mu         sync.Mutex
+	noiseSuite string // Cipher suite negotiated with the server, empty until negotiated
+}
+
+// Capabilities returns the capabilities the server advertises, reading them once
+// from GetServerInfo and caching them on the client. A server that does not
+// answer is assumed to have the capabilities of servers that predate discovery.
+// It is safe for concurrent use. Clients not made by NewEncryptedOpenADPClient
+// have no cache and read the capabilities on every call.
+func (c *EncryptedOpenADPClient) Capabilities() Capabilities {
+	cache := c.capabilities
+	if cache == nil {
+		return c.readCapabilities()
+	}
+	cache.once.Do(func() { cache.capabilities = c.readCapabilities() })
+	return cache.capabilities
+}
+
+// readCapabilities asks the server for its capabilities
+func (c *EncryptedOpenADPClient) readCapabilities() Capabilities {
+	if result, err := c.makeUnencryptedRequest("GetServerInfo", nil); err == nil {
+		if info, ok := result.(map[string]interface{}); ok {
+			return capabilitiesFromInfo(info)
+		}
+	}
+	return unknownCapabilities()
+}
+
+// Capabilities returns the capabilities shared by all live servers; see
+// IntersectCapabilities. Each server is asked once; the answers are cached.
+func (c *Client) Capabilities() Capabilities {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	caps := make([]Capabilities, len(c.liveServers))
+	for i, server := range c.liveServers {
+		caps[i] = server.Capabilities()
+	}
+	return IntersectCapabilities(caps...)
+}
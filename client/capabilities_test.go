@@ -0,0 +1,169 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestIntersectCapabilities(t *testing.T) {
+	legacy := unknownCapabilities()
+	v12 := Capabilities{Known: true, ProtocolVersions: []int{1, 2}, MaxBackupSize: 64, PinKDFs: []PinKDF{PinKDFNone, PinKDFSHA256}, Idempotent: true}
+	v2 := Capabilities{Known: true, ProtocolVersions: []int{2}, MaxBackupSize: 32, PinKDFs: []PinKDF{PinKDFSHA256, PinKDFArgon2id}, Idempotent: true}
+
+	tests := []struct {
+		name string
+		caps []Capabilities
+		want Capabilities
+	}{
+		{"none", nil, Capabilities{}},
+		{"one", []Capabilities{v12}, v12},
+		{"legacy server", []Capabilities{v12, legacy}, Capabilities{ProtocolVersions: []int{1}, MaxBackupSize: 64, PinKDFs: []PinKDF{PinKDFNone, PinKDFSHA256}}},
+		{"disjoint versions", []Capabilities{v12, v2}, Capabilities{Known: true, ProtocolVersions: []int{2}, MaxBackupSize: 32, PinKDFs: []PinKDF{PinKDFSHA256}, Idempotent: true}},
+		{"unrestricted KDFs first", []Capabilities{legacy, v2}, Capabilities{ProtocolVersions: []int{}, MaxBackupSize: 32, PinKDFs: []PinKDF{PinKDFSHA256, PinKDFArgon2id}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IntersectCapabilities(tt.caps...); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("IntersectCapabilities() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	// The inputs are not modified
+	if !reflect.DeepEqual(v12.PinKDFs, []PinKDF{PinKDFNone, PinKDFSHA256}) || !reflect.DeepEqual(v12.ProtocolVersions, []int{1, 2}) {
+		t.Errorf("IntersectCapabilities() modified its input: %+v", v12)
+	}
+}
+
+func TestProbeServersCapabilities(t *testing.T) {
+	servers, serverInfos := newTestServers(t, 2)
+	servers[0].SetCapabilities(CapabilityIdempotentRegister)
+	servers[0].SetServerInfo("protocol_versions", []int{1, 2})
+	servers[0].SetServerInfo("max_backup_size", 4096)
+	servers[0].SetServerInfo("pin_kdfs", []string{"none", "argon2id"})
+
+	probes := ProbeServers(context.Background(), serverInfos)
+	want := []Capabilities{
+		{Known: true, ProtocolVersions: []int{1, 2}, MaxBackupSize: 4096, PinKDFs: []PinKDF{PinKDFNone, PinKDFArgon2id}, Idempotent: true},
+		{Known: true, ProtocolVersions: []int{1}},
+	}
+	for i, probe := range probes {
+		if !reflect.DeepEqual(probe.Capabilities, want[i]) {
+			t.Errorf("Server %d: Capabilities = %+v, want %+v", i, probe.Capabilities, want[i])
+		}
+	}
+}
+
+func TestGenerateEncryptionKeyCapabilities(t *testing.T) {
+	tests := []struct {
+		name        string
+		field       string        // GetServerInfo field advertised by the servers
+		values      []interface{} // Per server
+		params      *PinParams
+		wantCode    ErrCode
+		wantServers int
+		wantError   string
+	}{
+		{"all support the KDF", "", nil, &PinParams{KDF: PinKDFSHA256}, ErrNone, 3, ""},
+		{"one lacks the KDF", "pin_kdfs", []interface{}{[]string{"none"}, []string{"none", "sha256"}, []string{"sha256"}},
+			&PinParams{KDF: PinKDFSHA256}, ErrNone, 2, ""},
+		{"two lack the KDF", "pin_kdfs", []interface{}{[]string{"none"}, []string{"none"}, []string{"sha256"}},
+			&PinParams{KDF: PinKDFSHA256}, ErrInsufficientServers, 0, `Only 1 of 3 live servers support PIN KDF "sha256", need at least 2`},
+		{"two lack the protocol version", "protocol_versions", []interface{}{[]int{2}, []int{2}, []int{1, 2}},
+			nil, ErrInsufficientServers, 0, "support protocol version 1"},
+		{"shares too large", "max_backup_size", []interface{}{16, 16, 16},
+			nil, ErrInsufficientServers, 0, "support 32-byte shares"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			servers, serverInfos := newTestServers(t, 3)
+			for i, value := range tt.values {
+				servers[i].SetServerInfo(tt.field, value)
+			}
+			identity := &Identity{UID: "user", DID: "app", BID: "capabilities"}
+
+			// A dry run leaves out the same servers
+			report := ValidateGenerationWithOpts(identity, 10, 0, serverInfos, &Options{PinParams: tt.params})
+			if report.ErrCode != tt.wantCode || !strings.Contains(report.Error, tt.wantError) {
+				t.Errorf("ValidateGeneration() ErrCode = %q, Error = %q, want %q containing %q", report.ErrCode, report.Error, tt.wantCode, tt.wantError)
+			}
+			if tt.wantCode == ErrNone && len(report.ServerURLs) != tt.wantServers {
+				t.Errorf("ValidateGeneration() would use %d servers, want %d", len(report.ServerURLs), tt.wantServers)
+			}
+
+			result := GenerateEncryptionKeyWithExpiry(identity, "password", 10, time.Time{}, serverInfos, &Options{PinParams: tt.params})
+			if result.ErrCode != tt.wantCode {
+				t.Fatalf("ErrCode = %q, want %q (error: %s)", result.ErrCode, tt.wantCode, result.Error)
+			}
+			if !strings.Contains(result.Error, tt.wantError) {
+				t.Errorf("Error = %q, want it to contain %q", result.Error, tt.wantError)
+			}
+			if len(result.ServerURLs) != tt.wantServers {
+				t.Errorf("Registered with %d servers, want %d", len(result.ServerURLs), tt.wantServers)
+			}
+			if tt.wantCode == ErrNone && !result.Capabilities.SupportsPinKDF(tt.params.KDF) {
+				t.Errorf("Capabilities = %+v do not include the KDF", result.Capabilities)
+			}
+
+			// Failing early registers nothing
+			if tt.wantCode != ErrNone {
+				for i, server := range servers {
+					if n := server.NumGuesses(identity.UID, identity.DID, identity.BID); n != -1 {
+						t.Errorf("Server %d holds the backup", i)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestCapabilitiesConcurrent(t *testing.T) {
+	const chacha = "25519_ChaChaPoly_BLAKE2s"
+	servers, serverInfos := newTestServers(t, 1)
+	servers[0].SetCapabilities(CapabilityReplayProtection)
+	servers[0].SetNoiseSuites(DefaultNoiseSuite, chacha)
+
+	opts := &Options{Noise: &NoiseConfig{CipherSuites: []string{chacha, DefaultNoiseSuite}}}
+	client := opts.newClient(context.Background(), serverInfos[0].URL, servers[0].PublicKey)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := client.ListBackups("test-user", true, nil); err != nil {
+				t.Errorf("ListBackups() error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if !client.Capabilities().ReplayProtection {
+		t.Errorf("Capabilities().ReplayProtection = false, want true")
+	}
+	if suite, _ := client.negotiateNoiseSuite(); suite != chacha {
+		t.Errorf("Negotiated suite %s, want %s", suite, chacha)
+	}
+}
+
+func TestZeroValueClient(t *testing.T) {
+	servers, serverInfos := newTestServers(t, 1)
+	servers[0].SetCapabilities(CapabilityReplayProtection)
+
+	// A client not made by NewEncryptedOpenADPClient works, without caching
+	client := &EncryptedOpenADPClient{URL: serverInfos[0].URL, HTTPClient: &http.Client{}, serverPublicKey: servers[0].PublicKey}
+	for i := 0; i < 2; i++ {
+		if _, err := client.ListBackups("test-user", true, nil); err != nil {
+			t.Fatalf("ListBackups() error: %v", err)
+		}
+	}
+	if !client.Capabilities().ReplayProtection {
+		t.Errorf("Capabilities().ReplayProtection = false, want true")
+	}
+}
@@ -0,0 +1,111 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// MaxChunkedSecretSize is the largest secret, in bytes, accepted by
+// SplitLargeSecret: 64 KiB, enough for seeds and small key files. Larger data
+// should be encrypted under a random key, or wrapped as ocrypt.Register does, and
+// only the key split.
+const MaxChunkedSecretSize = 64 * 1024
+
+// ChunkedShare is one server's share of a secret split with SplitLargeSecret.
+// The secret is cut into chunks of MaxSplitSecretSize bytes, each split with its
+// own random polynomial, and the share holds the value of every polynomial at X.
+type ChunkedShare struct {
+	X *big.Int
+
+	// Ys holds the share of each chunk, in the order of the chunks in the secret
+	Ys []*big.Int
+
+	// Threshold is the number of shares needed to recombine the secret
+	Threshold int
+
+	// Length is the length in bytes of the whole secret, checked when recombining
+	Length int
+}
+
+// SplitLargeSecret splits a secret of up to MaxChunkedSecretSize bytes into the
+// given number of shares, any threshold of which recombine it with
+// RecombineLargeSecret. Secrets of up to MaxSplitSecretSize bytes are better split
+// with SplitSecret, whose shares are a single field element each.
+func SplitLargeSecret(secret []byte, threshold, shares int) ([]ChunkedShare, error) {
+	if len(secret) > MaxChunkedSecretSize {
+		return nil, fmt.Errorf("secret is %d bytes, at most %d are supported", len(secret), MaxChunkedSecretSize)
+	}
+
+	chunks := chunkCount(len(secret))
+	var result []ChunkedShare
+	for i := 0; i < chunks; i++ {
+		end := min((i+1)*MaxSplitSecretSize, len(secret))
+		chunkShares, err := SplitSecret(secret[i*MaxSplitSecretSize:end], threshold, shares)
+		if err != nil {
+			return nil, fmt.Errorf("chunk %d: %w", i, err)
+		}
+		if i == 0 {
+			result = make([]ChunkedShare, len(chunkShares))
+		}
+		for j, share := range chunkShares {
+			if i == 0 {
+				result[j] = ChunkedShare{X: share.X, Ys: make([]*big.Int, chunks), Threshold: threshold, Length: len(secret)}
+			}
+			result[j].Ys[i] = share.Y
+		}
+	}
+	return result, nil
+}
+
+// RecombineLargeSecret recombines a secret split with SplitLargeSecret,
+// reassembling its chunks in order. It fails if fewer than threshold shares are
+// given, if shares disagree on the threshold or length of the secret, or if any
+// chunk does not recombine to exactly the bytes the length calls for, so that
+// truncated shares or shares of different splits never yield a corrupt secret.
+func RecombineLargeSecret(shares []ChunkedShare) ([]byte, error) {
+	if len(shares) == 0 {
+		return nil, errors.New("no shares provided")
+	}
+
+	length := shares[0].Length
+	if length < 0 || length > MaxChunkedSecretSize {
+		return nil, fmt.Errorf("shares record a secret of %d bytes, at most %d are supported", length, MaxChunkedSecretSize)
+	}
+	chunks := chunkCount(length)
+	for i, share := range shares {
+		if share.Length != length {
+			return nil, errors.New("shares have different secret lengths")
+		}
+		if len(share.Ys) != chunks {
+			return nil, fmt.Errorf("share %d has %d chunks, a %d-byte secret needs %d", i, len(share.Ys), length, chunks)
+		}
+	}
+
+	secret := make([]byte, 0, length)
+	chunkShares := make([]Share, len(shares))
+	for i := 0; i < chunks; i++ {
+		for j, share := range shares {
+			chunkShares[j] = Share{X: share.X, Y: share.Ys[i], Threshold: share.Threshold}
+		}
+		chunk, err := RecombineSecret(chunkShares)
+		if err != nil {
+			zeroize(secret)
+			return nil, fmt.Errorf("chunk %d: %w", i, err)
+		}
+		if want := min(MaxSplitSecretSize, length-len(secret)); len(chunk) != want {
+			zeroize(secret)
+			zeroize(chunk)
+			return nil, fmt.Errorf("chunk %d recombines to %d bytes, want %d", i, len(chunk), want)
+		}
+		secret = append(secret, chunk...)
+		zeroize(chunk)
+	}
+	return secret, nil
+}
+
+// chunkCount returns how many chunks a secret of length bytes is cut into; an
+// empty secret still takes one, so that its shares carry the threshold
+func chunkCount(length int) int {
+	return max(1, (length+MaxSplitSecretSize-1)/MaxSplitSecretSize)
+}
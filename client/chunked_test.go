@@ -0,0 +1,95 @@
+package client
+
+import (
+	"bytes"
+	"crypto/rand"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+func TestSplitLargeSecret(t *testing.T) {
+	for _, size := range []int{0, 1, MaxSplitSecretSize, MaxSplitSecretSize + 1, 2 * MaxSplitSecretSize, 4096, MaxChunkedSecretSize} {
+		secret := make([]byte, size)
+		if _, err := rand.Read(secret); err != nil {
+			t.Fatal(err)
+		}
+		if size > 0 {
+			secret[0] = 0 // A leading zero must survive
+		}
+
+		shares, err := SplitLargeSecret(secret, 3, 5)
+		if err != nil {
+			t.Fatalf("SplitLargeSecret(%d bytes) error: %v", size, err)
+		}
+		if len(shares) != 5 {
+			t.Fatalf("SplitLargeSecret(%d bytes) returned %d shares, want 5", size, len(shares))
+		}
+		for _, subset := range [][]ChunkedShare{shares[:3], shares[2:], {shares[4], shares[0], shares[2]}, shares} {
+			got, err := RecombineLargeSecret(subset)
+			if err != nil {
+				t.Fatalf("RecombineLargeSecret(%d bytes) error: %v", size, err)
+			}
+			if !bytes.Equal(got, secret) {
+				t.Errorf("RecombineLargeSecret(%d bytes) does not match the secret", size)
+			}
+		}
+	}
+
+	if _, err := SplitLargeSecret(make([]byte, MaxChunkedSecretSize+1), 3, 5); err == nil || !strings.Contains(err.Error(), "at most") {
+		t.Errorf("SplitLargeSecret(%d bytes) error = %v, want a size error", MaxChunkedSecretSize+1, err)
+	}
+	if _, err := SplitLargeSecret([]byte("secret"), 3, 2); err == nil {
+		t.Error("SplitLargeSecret() accepted fewer shares than the threshold")
+	}
+}
+
+func TestRecombineLargeSecretErrors(t *testing.T) {
+	secret := bytes.Repeat([]byte("0123456789"), 10)
+	shares, err := SplitLargeSecret(secret, 2, 3)
+	if err != nil {
+		t.Fatalf("SplitLargeSecret() error: %v", err)
+	}
+	other, err := SplitLargeSecret(secret[:len(secret)-1], 2, 3)
+	if err != nil {
+		t.Fatalf("SplitLargeSecret() error: %v", err)
+	}
+
+	truncated := shares[1]
+	truncated.Ys = truncated.Ys[:len(truncated.Ys)-1]
+	shortened := []ChunkedShare{shares[0], shares[1]}
+	for i := range shortened {
+		shortened[i].Length -= MaxSplitSecretSize
+		shortened[i].Ys = shortened[i].Ys[:len(shortened[i].Ys)-1]
+	}
+	lengthened := []ChunkedShare{shares[0], shares[1]}
+	for i := range lengthened {
+		lengthened[i].Length++
+	}
+	swapped := []ChunkedShare{shares[0], shares[1]}
+	for i := range swapped {
+		swapped[i].Ys = append([]*big.Int{}, swapped[i].Ys...)
+		last := len(swapped[i].Ys) - 1
+		swapped[i].Ys[0], swapped[i].Ys[last] = swapped[i].Ys[last], swapped[i].Ys[0]
+	}
+
+	tests := []struct {
+		name   string
+		shares []ChunkedShare
+	}{
+		{"no shares", nil},
+		{"below threshold", shares[:1]},
+		{"truncated share", []ChunkedShare{shares[0], truncated}},
+		{"different lengths", []ChunkedShare{shares[0], other[1]}},
+		{"length off by a chunk", shortened},
+		{"length off by a byte", lengthened},
+		{"last chunk moved", swapped},
+		{"oversized", []ChunkedShare{{Length: MaxChunkedSecretSize + 1}}},
+	}
+
+	for _, tt := range tests {
+		if got, err := RecombineLargeSecret(tt.shares); err == nil {
+			t.Errorf("%s: RecombineLargeSecret() = %q, want an error", tt.name, got)
+		}
+	}
+}
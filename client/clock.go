@@ -0,0 +1,22 @@
+package client
+
+import "time"
+
+// Clock tells the current time. Options.Clock replaces the system clock, so that
+// tests can move time forward instead of waiting for it.
+type Clock interface {
+	Now() time.Time
+}
+
+// systemClock is the Clock used by default
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// clock returns the clock selected by o
+func (o *Options) clock() Clock {
+	if o == nil || o.Clock == nil {
+		return systemClock{}
+	}
+	return o.Clock
+}
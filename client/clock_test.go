@@ -0,0 +1,79 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/openadp/ocrypt/testserver"
+)
+
+// fakeClock is a Clock that tests move forward by hand
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func TestClockExpiration(t *testing.T) {
+	servers, serverInfos := newTestServers(t, 3)
+	identity := &Identity{UID: "user", DID: "app", BID: "expiring"}
+	clock := &fakeClock{now: time.Now()}
+	opts := &Options{Clock: clock}
+
+	expiresAt := clock.now.Add(time.Hour)
+	generated := GenerateEncryptionKeyWithExpiry(identity, "password", 10, expiresAt, serverInfos, opts)
+	if generated.Error != "" {
+		t.Fatalf("Key generation failed: %s", generated.Error)
+	}
+
+	recovered := RecoverEncryptionKeyWithOpts(identity, "password", serverInfos, generated.Threshold, generated.AuthCodes, opts)
+	if recovered.Error != "" {
+		t.Fatalf("Recovery before the expiration failed: %s", recovered.Error)
+	}
+	if !bytes.Equal(recovered.EncryptionKey, generated.EncryptionKey) {
+		t.Error("Recovered key does not match")
+	}
+
+	// Past the expiration, recovery fails without spending a guess
+	clock.now = expiresAt.Add(time.Minute)
+	expired := RecoverEncryptionKeyWithOpts(identity, "password", serverInfos, generated.Threshold, generated.AuthCodes, opts)
+	if expired.ErrCode != ErrBackupExpired {
+		t.Fatalf("ErrCode = %q, want %q (%s)", expired.ErrCode, ErrBackupExpired, expired.Error)
+	}
+	for i, server := range servers {
+		want := 1
+		if i >= generated.Threshold {
+			want = 0 // Not asked once the threshold was met
+		}
+		if got := server.NumGuesses(identity.UID, identity.DID, identity.BID); got != want {
+			t.Errorf("Server %d guess counter = %d, want %d", i, got, want)
+		}
+	}
+
+	// The clock also decides whether a new expiration is in the past
+	result := GenerateEncryptionKeyWithExpiry(&Identity{UID: "user", DID: "app", BID: "late"}, "password", 10, expiresAt, serverInfos, opts)
+	if result.ErrCode != ErrInvalidInput || result.Field != FieldExpiration {
+		t.Errorf("Generation with a past expiration: %q on %v (%s), want %q on %v", result.ErrCode, result.Field, result.Error, ErrInvalidInput, FieldExpiration)
+	}
+}
+
+func TestClockReplayTimestamp(t *testing.T) {
+	server := testserver.New()
+	defer server.Close()
+	server.SetCapabilities(CapabilityReplayProtection)
+
+	// Requests are stamped with the clock's time, which the server finds stale
+	opts := &Options{Clock: &fakeClock{now: time.Now().Add(-time.Hour)}}
+	client := opts.newClient(context.Background(), server.URL, server.PublicKey)
+	if _, err := client.Echo("stale", true); !errors.Is(err, ErrReplayDetected) {
+		t.Errorf("Echo() with a clock an hour behind error = %v, want ErrReplayDetected", err)
+	}
+
+	client = (&Options{Clock: &fakeClock{now: time.Now()}}).newClient(context.Background(), server.URL, server.PublicKey)
+	if _, err := client.Echo("fresh", true); err != nil {
+		t.Errorf("Echo() error: %v", err)
+	}
+}
@@ -0,0 +1,69 @@
+package client
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"io"
+)
+
+// keyCommitmentSaltSize is the length in bytes of the random salt of a KeyCommitment
+const keyCommitmentSaltSize = 16
+
+// keyCommitmentLabel separates key commitments from other MACs under the key
+var keyCommitmentLabel = []byte("OpenADP-KeyCommitment-v1")
+
+// KeyCommitment lets a recovered key be checked against the key generated,
+// without any data encrypted under it; see Options.KeyCommitment and
+// VerifyRecoveredKey. It reveals nothing useful about the key, which is too long
+// to be guessed against it.
+type KeyCommitment struct {
+	Salt []byte `json:"salt"`
+	MAC  []byte `json:"mac"` // HMAC-SHA256 of the label and Salt under the key
+}
+
+// newKeyCommitment commits to key under a fresh salt from random
+func newKeyCommitment(random io.Reader, key []byte) (*KeyCommitment, error) {
+	salt := make([]byte, keyCommitmentSaltSize)
+	if _, err := io.ReadFull(random, salt); err != nil {
+		return nil, err
+	}
+	return &KeyCommitment{Salt: salt, MAC: keyCommitmentMAC(key, salt)}, nil
+}
+
+// keyCommitmentMAC computes the MAC of a KeyCommitment
+func keyCommitmentMAC(key, salt []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(keyCommitmentLabel)
+	mac.Write(salt)
+	return mac.Sum(nil)
+}
+
+// VerifyRecoveredKey reports whether key is the key whose commitment metadata
+// records, comparing in constant time. It catches a key recovered wrongly, say
+// from a corrupted share, before it is used to decrypt anything. It returns false
+// if metadata records no commitment; check metadata.KeyCommitment to tell that
+// case apart.
+func VerifyRecoveredKey(key []byte, metadata Metadata) bool {
+	return metadata.KeyCommitment.verify(key)
+}
+
+// verify reports whether key is the key c commits to, comparing in constant time
+func (c *KeyCommitment) verify(key []byte) bool {
+	if c == nil || len(c.Salt) == 0 || len(key) == 0 {
+		return false
+	}
+	return hmac.Equal(keyCommitmentMAC(key, c.Salt), c.MAC)
+}
+
+// keyCommitmentMismatchMessage is the Error of a recovery that failed with
+// ErrKeyCommitmentMismatch
+const keyCommitmentMismatchMessage = "Recovered key does not match the key commitment: the password is wrong or a share is corrupted"
+
+// commitmentMatches reports whether key matches o.ExpectedKeyCommitment, or
+// true if there is none
+func (o *Options) commitmentMatches(key []byte) bool {
+	if o == nil || o.ExpectedKeyCommitment == nil {
+		return true
+	}
+	return o.ExpectedKeyCommitment.verify(key)
+}
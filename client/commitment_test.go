@@ -0,0 +1,109 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestVerifyRecoveredKey(t *testing.T) {
+	_, serverInfos := newTestServers(t, 3)
+	identity := &Identity{UID: "user", DID: "app", BID: "commitment"}
+
+	generated := GenerateEncryptionKeyWithOpts(identity, "password", 10, 0, serverInfos, &Options{KeyCommitment: true})
+	if generated.Error != "" {
+		t.Fatalf("Key generation failed: %s", generated.Error)
+	}
+	metadata, err := MetadataFromResult(identity, generated)
+	if err != nil {
+		t.Fatalf("MetadataFromResult() error: %v", err)
+	}
+	if metadata.KeyCommitment == nil {
+		t.Fatal("Metadata records no key commitment")
+	}
+
+	// The commitment survives encoding
+	encoded, err := json.Marshal(metadata)
+	if err != nil {
+		t.Fatalf("Marshal() error: %v", err)
+	}
+	var decoded Metadata
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatalf("Unmarshal() error: %v", err)
+	}
+
+	recovered := RecoverEncryptionKeyWithServerInfo(identity, "password", MetadataToServerInfo(&decoded), decoded.Threshold, decoded.AuthCodes)
+	if recovered.Error != "" {
+		t.Fatalf("Recovery failed: %s", recovered.Error)
+	}
+
+	corrupted := append([]byte{}, recovered.EncryptionKey...)
+	corrupted[0] ^= 1
+	tests := []struct {
+		name     string
+		key      []byte
+		metadata Metadata
+		want     bool
+	}{
+		{"recovered key", recovered.EncryptionKey, decoded, true},
+		{"corrupted key", corrupted, decoded, false},
+		{"truncated key", recovered.EncryptionKey[:16], decoded, false},
+		{"empty key", nil, decoded, false},
+		{"no commitment", recovered.EncryptionKey, Metadata{}, false},
+	}
+	for _, tt := range tests {
+		if got := VerifyRecoveredKey(tt.key, tt.metadata); got != tt.want {
+			t.Errorf("%s: VerifyRecoveredKey() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// Without the option no commitment is recorded
+	plain := GenerateEncryptionKeyWithOpts(&Identity{UID: "user", DID: "app", BID: "no-commitment"}, "password", 10, 0, serverInfos, nil)
+	if plain.Error != "" {
+		t.Fatalf("Key generation failed: %s", plain.Error)
+	}
+	if plain.KeyCommitment != nil {
+		t.Error("KeyCommitment set without Options.KeyCommitment")
+	}
+}
+
+func TestRecoverFromMetadataChecksCommitment(t *testing.T) {
+	_, serverInfos := newTestServers(t, 3)
+	identity := &Identity{UID: "user", DID: "app", BID: "commitment"}
+	generated := GenerateEncryptionKeyWithOpts(identity, "password", 10, 0, serverInfos, &Options{KeyCommitment: true})
+	if generated.Error != "" {
+		t.Fatalf("Key generation failed: %s", generated.Error)
+	}
+	metadata, err := MetadataFromResult(identity, generated)
+	if err != nil {
+		t.Fatalf("MetadataFromResult() error: %v", err)
+	}
+
+	// A wrong password reconstructs a valid but wrong key, which only the commitment catches
+	recovered := RecoverFromMetadata(*metadata, identity.UID, "wrong password")
+	if recovered.ErrCode != ErrKeyCommitmentMismatch || recovered.EncryptionKey != nil {
+		t.Errorf("Recovery with the wrong password: ErrCode = %q (%s), key returned %v; want %q and no key",
+			recovered.ErrCode, recovered.Error, recovered.EncryptionKey != nil, ErrKeyCommitmentMismatch)
+	}
+
+	recovered = RecoverFromMetadata(*metadata, identity.UID, "password")
+	if recovered.Error != "" {
+		t.Fatalf("Recovery failed: %s", recovered.Error)
+	}
+	if !bytes.Equal(recovered.EncryptionKey, generated.EncryptionKey) {
+		t.Error("Recovered key does not match")
+	}
+
+	// A cached key is checked too
+	opts := &Options{KeyCache: NewKeyCache(time.Minute)}
+	if recovered := RecoverFromMetadataWithOpts(*metadata, identity.UID, "password", opts); recovered.Error != "" {
+		t.Fatalf("Recovery failed: %s", recovered.Error)
+	}
+	other := *metadata
+	other.KeyCommitment = &KeyCommitment{Salt: metadata.KeyCommitment.Salt, MAC: make([]byte, len(metadata.KeyCommitment.MAC))}
+	recovered = RecoverFromMetadataWithOpts(other, identity.UID, "password", opts)
+	if recovered.ErrCode != ErrKeyCommitmentMismatch || recovered.EncryptionKey != nil {
+		t.Errorf("Cached recovery against another commitment: ErrCode = %q, want %q and no key", recovered.ErrCode, ErrKeyCommitmentMismatch)
+	}
+}
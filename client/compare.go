@@ -0,0 +1,13 @@
+package client
+
+import "crypto/subtle"
+
+// SecureEqual reports whether a and b are equal, in time that depends only on
+// their lengths and not on their contents. Use it instead of bytes.Equal or ==
+// to compare recovered keys, auth codes or other secrets.
+//
+// Inputs of different lengths are unequal. The lengths themselves are not
+// protected, which is harmless for fixed-size values such as keys and auth codes.
+func SecureEqual(a, b []byte) bool {
+	return subtle.ConstantTimeCompare(a, b) == 1
+}
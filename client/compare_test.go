@@ -0,0 +1,30 @@
+package client
+
+import "testing"
+
+func TestSecureEqual(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []byte
+		want bool
+	}{
+		{"equal", []byte("key material"), []byte("key material"), true},
+		{"both empty", []byte{}, nil, true},
+		{"last byte differs", []byte("key material"), []byte("key materiaL"), false},
+		{"first byte differs", []byte("key material"), []byte("Key material"), false},
+		{"prefix", []byte("key"), []byte("key material"), false},
+		{"longer", []byte("key material!"), []byte("key material"), false},
+		{"empty and non-empty", nil, []byte{0}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SecureEqual(tt.a, tt.b); got != tt.want {
+				t.Errorf("SecureEqual(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+			}
+			if got := SecureEqual(tt.b, tt.a); got != tt.want {
+				t.Errorf("SecureEqual(%q, %q) = %v, want %v", tt.b, tt.a, got, tt.want)
+			}
+		})
+	}
+}
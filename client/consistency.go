@@ -0,0 +1,47 @@
+package client
+
+import "time"
+
+// ConsistencyReport lists the registration parameters each server reports for a
+// backup. Servers can disagree after a partial re-registration, for example when
+// a backup was registered again with a different guess budget but only some
+// servers accepted the new share.
+type ConsistencyReport struct {
+	MaxGuesses map[string]int // Guess budget reported by each server, by URL
+	Expiration map[string]int // Expiration reported by each server (Unix seconds, 0 for never), by URL
+
+	// Consistent is true if all servers that reported the backup agree on the
+	// guess budget and, within the tolerance, on the expiration
+	Consistent bool
+}
+
+// evaluate sets r.Consistent, tolerating expirations that differ by at most tolerance
+func (r *ConsistencyReport) evaluate(tolerance time.Duration) {
+	r.Consistent = true
+
+	first := true
+	var maxGuesses int
+	for _, value := range r.MaxGuesses {
+		if first {
+			maxGuesses, first = value, false
+		} else if value != maxGuesses {
+			r.Consistent = false
+		}
+	}
+
+	first = true
+	var earliest, latest int
+	for _, value := range r.Expiration {
+		if first {
+			earliest, latest, first = value, value, false
+		}
+		earliest, latest = min(earliest, value), max(latest, value)
+	}
+	// A backup that never expires on one server disagrees with any expiration
+	if earliest == 0 && latest != 0 {
+		r.Consistent = false
+	}
+	if time.Duration(latest-earliest)*time.Second > tolerance {
+		r.Consistent = false
+	}
+}
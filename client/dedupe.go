@@ -0,0 +1,86 @@
+package client
+
+import (
+	"context"
+	"net/url"
+	"strings"
+)
+
+// normalizeServerURL returns the form of a server URL used to detect duplicates:
+// scheme and host in the canonical form of NormalizeServerURL, and any trailing
+// slash removed. URLs that do not parse are only trimmed.
+func normalizeServerURL(raw string) string {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || u.Host == "" {
+		return strings.TrimRight(strings.TrimSpace(raw), "/")
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	if host, err := canonicalHost(u.Scheme, u.Hostname(), u.Port()); err == nil {
+		u.Host = host
+	}
+	u.Path = strings.TrimRight(u.Path, "/")
+	return u.String()
+}
+
+// dedupeServers returns serverInfos without the entries that name a server listed
+// earlier, and the URLs of the entries it removed, warning about each through the
+// options' logger. A server listed twice would receive two shares and be counted
+// twice towards the threshold, defeating its purpose.
+//
+// Entries are duplicates if their normalized URLs are equal, or if they pin the
+// same public key and both servers prove to hold it. An entry whose pin is wrong
+// is not a duplicate of the server it names the key of; it is left for the
+// handshake to exclude, so a typo cannot remove the genuine server.
+func (o *Options) dedupeServers(serverInfos []ServerInfo) ([]ServerInfo, []string) {
+	seenURLs := make(map[string]bool, len(serverInfos))
+	firstByKey := make(map[string]ServerInfo, len(serverInfos))
+	holds := make(map[string]bool) // Servers known to hold their pinned key, by URL
+	holdsKey := func(server ServerInfo) bool {
+		held, ok := holds[server.URL]
+		if !ok {
+			held = o.holdsKey(server)
+			holds[server.URL] = held
+		}
+		return held
+	}
+
+	var unique []ServerInfo
+	var removed []string
+	for _, server := range serverInfos {
+		normalized := normalizeServerURL(server.URL)
+		if seenURLs[normalized] {
+			removed = append(removed, server.URL)
+			continue
+		}
+		key := pinnedKey(server)
+		first, pinnedBefore := firstByKey[key]
+		if key != "" && pinnedBefore && holdsKey(first) && holdsKey(server) {
+			removed = append(removed, server.URL)
+			continue
+		}
+		seenURLs[normalized] = true
+		if key != "" && !pinnedBefore {
+			firstByKey[key] = server
+		}
+		unique = append(unique, server)
+	}
+
+	for _, url := range removed {
+		o.logger().Warn("duplicate server removed", "server", url)
+	}
+	if removed == nil {
+		return serverInfos, nil
+	}
+	return unique, removed
+}
+
+// holdsKey reports whether server completes an encrypted request under its pinned
+// public key
+func (o *Options) holdsKey(server ServerInfo) bool {
+	publicKey, err := decodeServerPublicKey(server.PublicKey)
+	if err != nil || publicKey == nil {
+		return false
+	}
+	_, err = o.newClient(context.Background(), server.URL, publicKey).Echo("ping", true)
+	return err == nil
+}
@@ -0,0 +1,98 @@
+package client
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalizeServerURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://server.example.com", "https://server.example.com"},
+		{"HTTPS://Server.Example.COM/", "https://server.example.com"},
+		{"https://server.example.com:443", "https://server.example.com"},
+		{"http://server.example.com:80/", "http://server.example.com"},
+		{"https://server.example.com:8443", "https://server.example.com:8443"},
+		{"https://[::1]:443/", "https://[::1]"},
+		{"https://[::1]:9200", "https://[::1]:9200"},
+		{"https://[0:0:0:0:0:0:0:1]:9200/", "https://[::1]:9200"},
+		{"https://[2001:DB8::1]:09200", "https://[2001:db8::1]:9200"},
+		{"https://[fe80::1%25eth0]:9200", "https://[fe80::1%25eth0]:9200"},
+		{"http://localhost:8080/", "http://localhost:8080"},
+		{"https://server.example.com/rpc/", "https://server.example.com/rpc"},
+		{" not a url/ ", "not a url"},
+	}
+
+	for _, tt := range tests {
+		if got := normalizeServerURL(tt.url); got != tt.want {
+			t.Errorf("normalizeServerURL(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestDuplicateServers(t *testing.T) {
+	servers, serverInfos := newTestServers(t, 3)
+
+	// The same server under another host name, pinned to the same key
+	alias := ServerInfo{URL: strings.Replace(servers[0].URL, "127.0.0.1", "localhost", 1), PublicKey: serverInfos[0].PublicKey}
+	literal := ServerInfo{URL: servers[0].URL + "/", PublicKey: serverInfos[0].PublicKey}
+
+	tests := []struct {
+		name string
+		list []ServerInfo
+	}{
+		{"literal duplicate", []ServerInfo{serverInfos[0], serverInfos[1], literal, serverInfos[2]}},
+		{"same pinned key", []ServerInfo{serverInfos[0], serverInfos[1], alias, serverInfos[2]}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identity := &Identity{UID: "user", DID: "app", BID: strings.ReplaceAll(tt.name, " ", "-")}
+			logger := &recordingLogger{}
+			opts := &Options{Logger: logger}
+
+			report := ValidateGenerationWithOpts(identity, 10, 0, tt.list, &Options{})
+			if !report.Valid || len(report.ServerURLs) != 3 || report.Threshold != 2 {
+				t.Errorf("ValidateGeneration() = Valid %v with %d servers at threshold %d, want 3 at 2 (%s)",
+					report.Valid, len(report.ServerURLs), report.Threshold, report.Error)
+			}
+
+			generated := GenerateEncryptionKeyWithOpts(identity, "password", 10, 0, tt.list, opts)
+			if generated.Error != "" {
+				t.Fatalf("Key generation failed: %s", generated.Error)
+			}
+			if len(generated.ServerURLs) != 3 || generated.Threshold != 2 {
+				t.Errorf("Registered with %d servers at threshold %d, want 3 at 2", len(generated.ServerURLs), generated.Threshold)
+			}
+			if n := logger.count("WARN duplicate server removed"); n != 1 {
+				t.Errorf("Logged %d duplicate warnings, want 1", n)
+			}
+
+			recovered := RecoverEncryptionKeyWithOpts(identity, "password", tt.list, generated.Threshold, generated.AuthCodes, opts)
+			if recovered.Error != "" {
+				t.Fatalf("Recovery failed: %s", recovered.Error)
+			}
+			if n := servers[0].NumGuesses(identity.UID, identity.DID, identity.BID); n != 1 {
+				t.Errorf("Duplicated server counted %d guesses, want 1", n)
+			}
+
+			// Without the duplicate, too few servers remain for the threshold
+			result := GenerateEncryptionKeyWithOpts(identity, "password", 10, 0, tt.list, &Options{Threshold: 4})
+			if result.ErrCode != ErrThresholdNotMet || !strings.Contains(result.Error, "duplicate") {
+				t.Errorf("Generation at threshold 4: %q (%s), want %q mentioning the duplicate", result.ErrCode, result.Error, ErrThresholdNotMet)
+			}
+			if report := ValidateGenerationWithOpts(identity, 10, 0, tt.list, &Options{Threshold: 4}); report.Error != result.Error {
+				t.Errorf("ValidateGeneration() at threshold 4: %s, want %s", report.Error, result.Error)
+			}
+			recovered = RecoverEncryptionKeyWithOpts(identity, "password", []ServerInfo{tt.list[0], tt.list[2]}, 2, generated.AuthCodes, opts)
+			if recovered.ErrCode != ErrThresholdNotMet || !strings.Contains(recovered.Error, "duplicate") {
+				t.Errorf("Recovery from one server listed twice: %q (%s), want %q mentioning the duplicate", recovered.ErrCode, recovered.Error, ErrThresholdNotMet)
+			}
+			if n := servers[0].NumGuesses(identity.UID, identity.DID, identity.BID); n != 1 {
+				t.Errorf("Rejected recovery spent a guess: counter %d, want 1", n)
+			}
+		})
+	}
+}
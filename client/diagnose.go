@@ -0,0 +1,178 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/openadp/ocrypt/common"
+)
+
+// MaxDiagnoseShares is the largest number of shares DiagnoseShares accepts. The
+// search is exponential in the number of shares, so it is capped to keep a call
+// to a few seconds.
+const MaxDiagnoseShares = 16
+
+// ShareDiagnosis is the result of DiagnoseShares
+type ShareDiagnosis struct {
+	// Consistent is true if every share lies on one polynomial of degree below the
+	// threshold, that is, if every subset of threshold shares recombines to the
+	// same secret
+	Consistent bool
+
+	// Verified is true if more than threshold shares agree, so that the agreement
+	// is evidence rather than a tautology: any threshold shares define some
+	// polynomial, and with exactly threshold shares a corrupted one cannot be told
+	// apart from the others.
+	Verified bool
+
+	// Agreeing lists the indexes, into the shares passed in, of the largest set of
+	// shares found on one polynomial; recombining any threshold of them gives the
+	// secret they agree on. Inconsistent lists the remaining indexes, the shares
+	// that are corrupted or were not produced by the same split.
+	Agreeing     []int
+	Inconsistent []int
+
+	// SubsetsTried is the number of threshold-sized subsets interpolated
+	SubsetsTried int
+
+	// Error is set if the shares could not be diagnosed at all, for example because
+	// there are fewer than threshold of them
+	Error string
+}
+
+// DiagnoseShares reports whether the shares, for example those gathered from the
+// servers of a broken setup, are consistent, and if not which of them disagree with
+// the rest. It interpolates the polynomial through each subset of threshold shares
+// and counts the shares that lie on it, keeping the polynomial most shares agree
+// on, so a minority of corrupted or malicious shares is identified by index.
+//
+// This is error detection for Shamir sharing, not correction: with n shares it
+// takes O(n choose threshold) interpolations, so n is capped at MaxDiagnoseShares,
+// and it cannot single out corrupted shares once they are as many as the valid
+// shares beyond the threshold. The shares are not modified.
+func DiagnoseShares(shares []Share, threshold int) ShareDiagnosis {
+	if msg := validateDiagnosis(shares, threshold); msg != "" {
+		return ShareDiagnosis{Error: msg}
+	}
+
+	var diagnosis ShareDiagnosis
+	best := -1
+	subset := make([]int, threshold)
+	for i := range subset {
+		subset[i] = i
+	}
+
+	for {
+		diagnosis.SubsetsTried++
+		agreeing := sharesOnPolynomial(shares, subset)
+		if len(agreeing) > best {
+			best = len(agreeing)
+			diagnosis.Agreeing = agreeing
+		}
+		if best == len(shares) || !nextSubset(subset, len(shares)) {
+			break
+		}
+	}
+
+	inAgreement := make(map[int]bool, len(diagnosis.Agreeing))
+	for _, i := range diagnosis.Agreeing {
+		inAgreement[i] = true
+	}
+	for i := range shares {
+		if !inAgreement[i] {
+			diagnosis.Inconsistent = append(diagnosis.Inconsistent, i)
+		}
+	}
+	diagnosis.Consistent = len(diagnosis.Inconsistent) == 0
+	diagnosis.Verified = len(diagnosis.Agreeing) > threshold
+	return diagnosis
+}
+
+// validateDiagnosis returns why shares cannot be diagnosed, or ""
+func validateDiagnosis(shares []Share, threshold int) string {
+	switch {
+	case threshold < 1:
+		return fmt.Sprintf("threshold must be at least 1, got %d", threshold)
+	case len(shares) < threshold:
+		return fmt.Sprintf("need at least %d shares, got %d", threshold, len(shares))
+	case len(shares) > MaxDiagnoseShares:
+		return fmt.Sprintf("at most %d shares can be diagnosed, got %d", MaxDiagnoseShares, len(shares))
+	}
+
+	seen := make(map[string]bool, len(shares))
+	for i, share := range shares {
+		if share.X == nil || share.Y == nil {
+			return fmt.Sprintf("share %d is incomplete", i)
+		}
+		if seen[share.X.String()] {
+			return fmt.Sprintf("duplicate share index %s", share.X)
+		}
+		seen[share.X.String()] = true
+	}
+	return ""
+}
+
+// sharesOnPolynomial returns the indexes of the shares that lie on the polynomial
+// through the shares at the subset indexes
+func sharesOnPolynomial(shares []Share, subset []int) []int {
+	points := make([]*Share, len(subset))
+	for i, index := range subset {
+		points[i] = &shares[index]
+	}
+
+	var agreeing []int
+	for i := range shares {
+		y, err := interpolateAt(points, shares[i].X)
+		if err == nil && y.Cmp(new(big.Int).Mod(shares[i].Y, common.Q)) == 0 {
+			agreeing = append(agreeing, i)
+		}
+	}
+	return agreeing
+}
+
+// interpolateAt evaluates at x the Lagrange polynomial through points over the
+// field of order common.Q
+func interpolateAt(points []*Share, x *big.Int) (*big.Int, error) {
+	prime := common.Q
+	result := big.NewInt(0)
+	for j, pointJ := range points {
+		numerator := big.NewInt(1)
+		denominator := big.NewInt(1)
+		for m, pointM := range points {
+			if j == m {
+				continue
+			}
+			numerator.Mul(numerator, new(big.Int).Sub(x, pointM.X))
+			numerator.Mod(numerator, prime)
+			denominator.Mul(denominator, new(big.Int).Sub(pointJ.X, pointM.X))
+			denominator.Mod(denominator, prime)
+		}
+
+		denominatorInv := new(big.Int).ModInverse(denominator, prime)
+		if denominatorInv == nil {
+			return nil, errors.New("failed to compute modular inverse")
+		}
+		term := numerator.Mul(numerator, denominatorInv)
+		term.Mul(term, pointJ.Y)
+		result.Add(result, term)
+		result.Mod(result, prime)
+	}
+	return result, nil
+}
+
+// nextSubset advances subset, a strictly increasing list of indexes below n, to
+// the next combination in lexicographic order, and reports whether there was one
+func nextSubset(subset []int, n int) bool {
+	k := len(subset)
+	for i := k - 1; i >= 0; i-- {
+		if subset[i] < n-k+i {
+			subset[i]++
+			for j := i + 1; j < k; j++ {
+				subset[j] = subset[j-1] + 1
+			}
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+package client
+
+import (
+	"math/big"
+	"reflect"
+	"testing"
+)
+
+func TestDiagnoseShares(t *testing.T) {
+	split := func(threshold, count int) []Share {
+		t.Helper()
+		shares, err := SplitSecret([]byte("diagnose me"), threshold, count)
+		if err != nil {
+			t.Fatalf("SplitSecret() error: %v", err)
+		}
+		return shares
+	}
+	corrupt := func(shares []Share, indexes ...int) []Share {
+		for _, i := range indexes {
+			shares[i].Y = new(big.Int).Add(shares[i].Y, big.NewInt(1))
+		}
+		return shares
+	}
+	other := split(3, 6)
+
+	tests := []struct {
+		name             string
+		shares           []Share
+		threshold        int
+		wantConsistent   bool
+		wantVerified     bool
+		wantInconsistent []int
+	}{
+		{"all valid", split(3, 6), 3, true, true, nil},
+		{"one corrupted", corrupt(split(3, 6), 2), 3, false, true, []int{2}},
+		{"two corrupted", corrupt(split(2, 7), 0, 5), 2, false, true, []int{0, 5}},
+		{"mixed splits", append(split(3, 5), other[5]), 3, false, true, []int{5}},
+		{"exactly threshold", corrupt(split(3, 3), 1), 3, true, false, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diagnosis := DiagnoseShares(tt.shares, tt.threshold)
+			if diagnosis.Error != "" {
+				t.Fatalf("DiagnoseShares() error: %s", diagnosis.Error)
+			}
+			if diagnosis.Consistent != tt.wantConsistent || diagnosis.Verified != tt.wantVerified {
+				t.Errorf("Consistent, Verified = %v, %v, want %v, %v", diagnosis.Consistent, diagnosis.Verified, tt.wantConsistent, tt.wantVerified)
+			}
+			if !reflect.DeepEqual(diagnosis.Inconsistent, tt.wantInconsistent) {
+				t.Errorf("Inconsistent = %v, want %v", diagnosis.Inconsistent, tt.wantInconsistent)
+			}
+			if len(diagnosis.Agreeing)+len(diagnosis.Inconsistent) != len(tt.shares) {
+				t.Errorf("Agreeing %v and Inconsistent %v do not cover %d shares", diagnosis.Agreeing, diagnosis.Inconsistent, len(tt.shares))
+			}
+
+			// The agreeing shares recombine to the secret
+			var agreeing []Share
+			for _, i := range diagnosis.Agreeing {
+				agreeing = append(agreeing, tt.shares[i])
+			}
+			if secret, err := RecombineSecret(agreeing); tt.wantVerified && (err != nil || string(secret) != "diagnose me") {
+				t.Errorf("Agreeing shares recombine to %q, %v", secret, err)
+			}
+		})
+	}
+}
+
+func TestDiagnoseSharesErrors(t *testing.T) {
+	shares, _ := SplitSecret([]byte("secret"), 2, MaxDiagnoseShares+1)
+	duplicate := []Share{shares[0], shares[1], shares[0]}
+
+	tests := []struct {
+		name      string
+		shares    []Share
+		threshold int
+	}{
+		{"too many shares", shares, 2},
+		{"too few shares", shares[:1], 2},
+		{"zero threshold", shares[:3], 0},
+		{"duplicate index", duplicate, 2},
+		{"incomplete share", []Share{shares[0], {X: big.NewInt(9)}}, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if diagnosis := DiagnoseShares(tt.shares, tt.threshold); diagnosis.Error == "" {
+				t.Errorf("DiagnoseShares() = %+v, want an error", diagnosis)
+			}
+		})
+	}
+}
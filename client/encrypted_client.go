@@ -2,25 +2,50 @@ package client
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/openadp/ocrypt/common"
 	"github.com/openadp/ocrypt/debug"
 )
 
+// ErrPublicKeyMismatch is wrapped by errors from encrypted requests whose Noise-NK
+// handshake fails: the server does not hold the private key for the pinned public
+// key, so it is either misconfigured or being impersonated.
+var ErrPublicKeyMismatch = errors.New("server public key does not match the pinned key")
+
 // EncryptedOpenADPClient extends the basic client with Noise-NK encryption support
 type EncryptedOpenADPClient struct {
 	URL             string
 	HTTPClient      *http.Client
-	requestID       int
+	requestIDs      *int64 // Last JSON-RPC request ID used, shared with copies
 	serverPublicKey []byte // Ed25519 public key for Noise-NK
+	ctx             context.Context
+	logger          Logger            // Receives retry events; nil uses the package logger
+	metrics         MetricsObserver   // Receives request events; nil discards them
+	noiseConfig     *NoiseConfig      // Allowed handshake pattern and cipher suites; nil uses the default
+	maxClockSkew    time.Duration     // Skew requested from servers with CapabilityReplayProtection; zero leaves theirs
+	nonceReader     io.Reader         // Source of replay nonces; nil uses crypto/rand
+	clock           Clock             // Stamps replay-protected requests; nil uses the system clock
+	ephemerals      *ephemeralLog     // Ephemeral keys the server has sent in handshakes
+	health          ServerHealthStore // Records the outcome of every request; nil records nothing
+	limiter         *RateLimiter      // Holds back requests over the rate limit; nil sends them at once
+
+	timing       *LatencyBreakdown // Handshake and exchange time of this copy's requests; nil discards it
+	capabilities *capabilityCache  // Capabilities advertised by GetServerInfo, shared with copies
+
+	// RetryPolicy controls retries of transient failures. Nil disables retries.
+	RetryPolicy *RetryPolicy
 }
 
 // NewEncryptedOpenADPClient creates a new encrypted OpenADP client
@@ -29,10 +54,57 @@ func NewEncryptedOpenADPClient(url string, serverPublicKey []byte) *EncryptedOpe
 		URL:             url,
 		serverPublicKey: serverPublicKey,
 		HTTPClient: &http.Client{
-			Timeout: 30 * time.Second,
+			Transport: defaultTransport,
+			Timeout:   30 * time.Second,
 		},
-		requestID: 1,
+		requestIDs:   new(int64),
+		ephemerals:   &ephemeralLog{},
+		capabilities: &capabilityCache{},
+	}
+}
+
+// WithContext returns a shallow copy of the client whose HTTP requests are bound to
+// ctx, so that cancelling ctx aborts any request in flight.
+func (c *EncryptedOpenADPClient) WithContext(ctx context.Context) *EncryptedOpenADPClient {
+	c2 := *c
+	c2.ctx = ctx
+	return &c2
+}
+
+// unsharedRequestIDs numbers the requests of clients not made by
+// NewEncryptedOpenADPClient, which have no counter of their own
+var unsharedRequestIDs int64
+
+// nextRequestID returns the ID for a new JSON-RPC request
+func (c *EncryptedOpenADPClient) nextRequestID() int {
+	if c.requestIDs == nil {
+		return int(atomic.AddInt64(&unsharedRequestIDs, 1))
 	}
+	return int(atomic.AddInt64(c.requestIDs, 1))
+}
+
+// addTiming adds the time of a request to the client's latency breakdown, if any
+func (c *EncryptedOpenADPClient) addTiming(handshake, exchange time.Duration) {
+	if c.timing != nil {
+		c.timing.Handshake += handshake
+		c.timing.Exchange += exchange
+	}
+}
+
+// post sends a JSON-RPC request body to the server using the client's context
+func (c *EncryptedOpenADPClient) post(body []byte) (*http.Response, error) {
+	ctx := c.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	return c.HTTPClient.Do(req)
 }
 
 // HasPublicKey returns true if the client has a server public key for encryption
@@ -40,28 +112,105 @@ func (c *EncryptedOpenADPClient) HasPublicKey() bool {
 	return len(c.serverPublicKey) > 0
 }
 
-// makeRequest makes a JSON-RPC request with optional Noise-NK encryption
+// makeRequest makes a JSON-RPC request with optional Noise-NK encryption, retrying
+// transient failures according to the client's RetryPolicy
 func (c *EncryptedOpenADPClient) makeRequest(method string, params interface{}, encrypted bool, authData map[string]interface{}) (interface{}, error) {
 	if encrypted && !c.HasPublicKey() {
 		return nil, fmt.Errorf("encryption requested but no server public key available")
 	}
 
-	if encrypted {
-		return c.makeEncryptedRequest(method, params, authData)
+	// Servers that reject replays are sent a fresh nonce with every attempt
+	replay := encrypted && c.Capabilities().ReplayProtection
+
+	for attempt := 1; ; attempt++ {
+		var result interface{}
+		var err error
+
+		if err := c.limiter.wait(c); err != nil {
+			return nil, err
+		}
+		if c.metrics != nil {
+			c.metrics.RequestStarted(c.URL, method)
+		}
+		start := time.Now()
+
+		// Each attempt runs a fresh handshake, since Noise sessions are single-use
+		if encrypted {
+			result, err = c.makeEncryptedRequest(method, params, authData, replay)
+		} else {
+			result, err = c.makeUnencryptedRequest(method, params)
+		}
+
+		if c.metrics != nil {
+			c.metrics.RequestFinished(c.URL, method, time.Since(start), err)
+		}
+		if c.health != nil {
+			if answered, counts := serverAnswered(err); counts {
+				c.health.Record(c.URL, answered, time.Since(start))
+			}
+		}
+
+		if err == nil || !c.RetryPolicy.shouldRetry(attempt, method, err) {
+			return result, err
+		}
+
+		if debug.IsDebugModeEnabled() {
+			debug.DebugLog(fmt.Sprintf("Retrying %s after transient error (attempt %d): %v", method, attempt, err))
+		}
+		delay := c.RetryPolicy.delay(attempt)
+		c.log().Warn("retrying request after transient error",
+			"server", c.URL, "method", method, "attempt", attempt, "delay", delay, "error", err.Error())
+		if waitErr := c.sleep(delay); waitErr != nil {
+			return nil, err
+		}
+	}
+}
+
+// handshakeUndecryptable reports whether a handshake error is the server failing
+// to decrypt the first handshake message, rather than an error unrelated to Noise
+func handshakeUndecryptable(e *JSONRPCError) bool {
+	message := strings.ToLower(e.Message)
+	return strings.Contains(message, "handshake") &&
+		(strings.Contains(message, "authentication failed") || strings.Contains(message, "decrypt"))
+}
+
+// log returns the logger for client events
+func (c *EncryptedOpenADPClient) log() Logger {
+	if c.logger == nil {
+		return packageLogger()
 	}
+	return c.logger
+}
 
-	return c.makeUnencryptedRequest(method, params)
+// sleep waits for d or until the client's context is done
+func (c *EncryptedOpenADPClient) sleep(d time.Duration) error {
+	ctx := c.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 // makeUnencryptedRequest makes a standard JSON-RPC request without encryption
 func (c *EncryptedOpenADPClient) makeUnencryptedRequest(method string, params interface{}) (interface{}, error) {
+	start := time.Now()
+	defer func() { c.addTiming(0, time.Since(start)) }()
+
 	request := JSONRPCRequest{
 		JSONRPC: "2.0",
 		Method:  method,
 		Params:  params,
-		ID:      c.requestID,
+		ID:      c.nextRequestID(),
 	}
-	c.requestID++
 
 	requestBody, err := json.Marshal(request)
 	if err != nil {
@@ -74,19 +223,19 @@ func (c *EncryptedOpenADPClient) makeUnencryptedRequest(method string, params in
 		debug.DebugLog(fmt.Sprintf("📤 GO: Unencrypted JSON request: %s", string(reqJSON)))
 	}
 
-	resp, err := c.HTTPClient.Post(c.URL, "application/json", bytes.NewBuffer(requestBody))
+	resp, err := c.post(requestBody)
 	if err != nil {
-		return nil, fmt.Errorf("failed to make HTTP request: %v", err)
+		return nil, fmt.Errorf("failed to make HTTP request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("HTTP error: %d %s", resp.StatusCode, resp.Status)
+		return nil, fmt.Errorf("HTTP error: %w", &statusError{Code: resp.StatusCode, Status: resp.Status})
 	}
 
 	responseBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %v", err)
+		return nil, fmt.Errorf("failed to read response body: %w", err)
 	}
 
 	var response JSONRPCResponse
@@ -105,14 +254,24 @@ func (c *EncryptedOpenADPClient) makeUnencryptedRequest(method string, params in
 	}
 
 	if response.Error != nil {
-		return nil, fmt.Errorf("JSON-RPC error %d: %s", response.Error.Code, response.Error.Message)
+		return nil, jsonRPCError("JSON-RPC error", response.Error)
 	}
 
 	return response.Result, nil
 }
 
 // makeEncryptedRequest makes a Noise-NK encrypted JSON-RPC request
-func (c *EncryptedOpenADPClient) makeEncryptedRequest(method string, params interface{}, authData map[string]interface{}) (interface{}, error) {
+func (c *EncryptedOpenADPClient) makeEncryptedRequest(method string, params interface{}, authData map[string]interface{}, replay bool) (interface{}, error) {
+	start := time.Now()
+	var handshakeDone time.Time
+	defer func() {
+		if handshakeDone.IsZero() {
+			c.addTiming(time.Since(start), 0)
+		} else {
+			c.addTiming(handshakeDone.Sub(start), time.Since(handshakeDone))
+		}
+	}()
+
 	// Add debug logging to match Python output
 	if debug.IsDebugModeEnabled() {
 		debug.DebugLog(fmt.Sprintf("Making encrypted request to %s", c.URL))
@@ -138,8 +297,12 @@ func (c *EncryptedOpenADPClient) makeEncryptedRequest(method string, params inte
 		debug.DebugLog(fmt.Sprintf("Generated session ID: %s", sessionID))
 	}
 
-	// Step 2: Create Noise client
-	noiseClient, err := common.NewNoiseNK("initiator", nil, c.serverPublicKey, []byte(""))
+	// Step 2: Create Noise client with a cipher suite the server supports
+	suite, err := c.negotiateNoiseSuite()
+	if err != nil {
+		return nil, err
+	}
+	noiseClient, err := common.NewNoiseNKWithSuite("initiator", nil, c.serverPublicKey, []byte(""), suite)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create Noise client: %v", err)
 	}
@@ -162,19 +325,23 @@ func (c *EncryptedOpenADPClient) makeEncryptedRequest(method string, params inte
 	}
 
 	// Step 4: Send handshake to server
-	requestID := c.requestID
-	c.requestID++
+	requestID := c.nextRequestID()
+
+	handshakeParams := map[string]interface{}{
+		"session": sessionID,
+		"message": base64.StdEncoding.EncodeToString(handshakeMsg1),
+	}
+	// Servers that predate negotiation only speak the default suite and are never
+	// sent the field
+	if suite != DefaultNoiseSuite {
+		handshakeParams["suite"] = suite
+	}
 
 	handshakeRequest := JSONRPCRequest{
 		JSONRPC: "2.0",
 		Method:  "noise_handshake",
-		Params: []interface{}{
-			map[string]interface{}{
-				"session": sessionID,
-				"message": base64.StdEncoding.EncodeToString(handshakeMsg1),
-			},
-		},
-		ID: requestID,
+		Params:  []interface{}{handshakeParams},
+		ID:      requestID,
 	}
 
 	handshakeReqBytes, err := json.Marshal(handshakeRequest)
@@ -190,19 +357,19 @@ func (c *EncryptedOpenADPClient) makeEncryptedRequest(method string, params inte
 	}
 
 	// Send handshake request
-	resp, err := c.HTTPClient.Post(c.URL, "application/json", bytes.NewBuffer(handshakeReqBytes))
+	resp, err := c.post(handshakeReqBytes)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send handshake request: %v", err)
+		return nil, fmt.Errorf("failed to send handshake request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("handshake HTTP error: %d %s", resp.StatusCode, resp.Status)
+		return nil, fmt.Errorf("handshake HTTP error: %w", &statusError{Code: resp.StatusCode, Status: resp.Status})
 	}
 
 	handshakeRespBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read handshake response: %v", err)
+		return nil, fmt.Errorf("failed to read handshake response: %w", err)
 	}
 
 	var handshakeResponse JSONRPCResponse
@@ -221,7 +388,14 @@ func (c *EncryptedOpenADPClient) makeEncryptedRequest(method string, params inte
 	}
 
 	if handshakeResponse.Error != nil {
-		return nil, fmt.Errorf("handshake JSON-RPC error %d: %s", handshakeResponse.Error.Code, handshakeResponse.Error.Message)
+		if handshakeUndecryptable(handshakeResponse.Error) {
+			// The server could not decrypt the first handshake message, which is
+			// encrypted to the pinned key; the server holds a different one
+			return nil, fmt.Errorf("%w: handshake JSON-RPC error %d: %s", ErrPublicKeyMismatch, handshakeResponse.Error.Code, handshakeResponse.Error.Message)
+		}
+		// Errors such as rate limiting or faults of the server say nothing about
+		// the pinned key
+		return nil, jsonRPCError("handshake JSON-RPC error", handshakeResponse.Error)
 	}
 
 	// Step 5: Process server's handshake response
@@ -248,7 +422,17 @@ func (c *EncryptedOpenADPClient) makeEncryptedRequest(method string, params inte
 	// Complete handshake
 	_, err = noiseClient.ReadHandshakeMessage(handshakeMsg2)
 	if err != nil {
-		return nil, fmt.Errorf("failed to complete handshake: %v", err)
+		// The reply was not produced by the holder of the pinned key
+		return nil, fmt.Errorf("%w: failed to complete handshake: %v", ErrPublicKeyMismatch, err)
+	}
+	handshakeDone = time.Now()
+
+	// The reply is authenticated, so a repeated ephemeral key came from the server
+	if c.ephemerals.observe(handshakeMsg2) {
+		if c.noiseConfig.rejectReusedEphemerals() {
+			return nil, fmt.Errorf("%w: %s", ErrReusedEphemeral, c.URL)
+		}
+		c.log().Warn("server reused its Noise ephemeral key", "server", c.URL)
 	}
 
 	if debug.IsDebugModeEnabled() {
@@ -291,6 +475,14 @@ func (c *EncryptedOpenADPClient) makeEncryptedRequest(method string, params inte
 		methodCall["auth"] = authData
 	}
 
+	if replay {
+		fields, err := replayFields(c.nonceReader, c.clock, c.maxClockSkew)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate request nonce: %v", err)
+		}
+		methodCall["replay"] = fields
+	}
+
 	if debug.IsDebugModeEnabled() {
 		debug.DebugLog(fmt.Sprintf("Method call (before encryption): %v", methodCall))
 	}
@@ -341,19 +533,19 @@ func (c *EncryptedOpenADPClient) makeEncryptedRequest(method string, params inte
 	}
 
 	// Send encrypted request
-	resp2, err := c.HTTPClient.Post(c.URL, "application/json", bytes.NewBuffer(encryptedReqBytes))
+	resp2, err := c.post(encryptedReqBytes)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send encrypted request: %v", err)
+		return nil, fmt.Errorf("failed to send encrypted request: %w", err)
 	}
 	defer resp2.Body.Close()
 
 	if resp2.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("encrypted call HTTP error: %d %s", resp2.StatusCode, resp2.Status)
+		return nil, fmt.Errorf("encrypted call HTTP error: %w", &statusError{Code: resp2.StatusCode, Status: resp2.Status})
 	}
 
 	encryptedRespBody, err := io.ReadAll(resp2.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read encrypted response: %v", err)
+		return nil, fmt.Errorf("failed to read encrypted response: %w", err)
 	}
 
 	var encryptedResponse JSONRPCResponse
@@ -372,7 +564,7 @@ func (c *EncryptedOpenADPClient) makeEncryptedRequest(method string, params inte
 	}
 
 	if encryptedResponse.Error != nil {
-		return nil, fmt.Errorf("encrypted call JSON-RPC error %d: %s", encryptedResponse.Error.Code, encryptedResponse.Error.Message)
+		return nil, jsonRPCError("encrypted call JSON-RPC error", encryptedResponse.Error)
 	}
 
 	// Step 9: Decrypt the response
@@ -422,7 +614,7 @@ func (c *EncryptedOpenADPClient) makeEncryptedRequest(method string, params inte
 	}
 
 	if decryptedResponse.Error != nil {
-		return nil, fmt.Errorf("decrypted JSON-RPC error %d: %s", decryptedResponse.Error.Code, decryptedResponse.Error.Message)
+		return nil, jsonRPCError("decrypted JSON-RPC error", decryptedResponse.Error)
 	}
 
 	if debug.IsDebugModeEnabled() {
@@ -434,8 +626,7 @@ func (c *EncryptedOpenADPClient) makeEncryptedRequest(method string, params inte
 
 // RegisterSecret registers a secret share with the server
 func (c *EncryptedOpenADPClient) RegisterSecret(authCode, uid, did, bid string, version, x int, y string, maxGuesses, expiration int, encrypted bool, authData map[string]interface{}) (bool, error) {
-	// Server expects: [auth_code, uid, did, bid, version, x, y, max_guesses, expiration] (9 parameters)
-	params := []interface{}{authCode, uid, did, bid, version, x, y, maxGuesses, expiration}
+	params := registerSecretParams{AuthCode: authCode, UID: uid, DID: did, BID: bid, Version: version, X: x, Y: y, MaxGuesses: maxGuesses, Expiration: expiration}
 
 	// Debug logging for request
 	if debug.IsDebugModeEnabled() {
@@ -444,22 +635,19 @@ func (c *EncryptedOpenADPClient) RegisterSecret(authCode, uid, did, bid string,
 		debug.DebugLog(fmt.Sprintf("RegisterSecret auth_code: %s", authCode))
 	}
 
-	result, err := c.makeRequest("RegisterSecret", params, encrypted, authData)
-	if err != nil {
+	return c.registerSecret(params, encrypted, authData)
+}
+
+// registerSecret sends a RegisterSecret request with the given parameters
+func (c *EncryptedOpenADPClient) registerSecret(params registerSecretParams, encrypted bool, authData map[string]interface{}) (bool, error) {
+	var success bool
+	if err := c.call("RegisterSecret", params, encrypted, authData, &success); err != nil {
 		if debug.IsDebugModeEnabled() {
 			debug.DebugLog(fmt.Sprintf("RegisterSecret error: %v", err))
 		}
 		return false, err
 	}
 
-	success, ok := result.(bool)
-	if !ok {
-		if debug.IsDebugModeEnabled() {
-			debug.DebugLog(fmt.Sprintf("RegisterSecret unexpected response type: %T", result))
-		}
-		return false, fmt.Errorf("unexpected response type: %T", result)
-	}
-
 	// Debug logging for response
 	if debug.IsDebugModeEnabled() {
 		debug.DebugLog(fmt.Sprintf("RegisterSecret response: success=%t", success))
@@ -468,10 +656,10 @@ func (c *EncryptedOpenADPClient) RegisterSecret(authCode, uid, did, bid string,
 	return success, nil
 }
 
-// RecoverSecret recovers a secret share from the server
+// RecoverSecret recovers a secret share from the server. The result holds the
+// fields of the response as decoded from JSON, so numbers are float64.
 func (c *EncryptedOpenADPClient) RecoverSecret(authCode, uid, did, bid, b string, guessNum int, encrypted bool, authData map[string]interface{}) (map[string]interface{}, error) {
-	// Server expects: [auth_code, uid, did, bid, b, guess_num] (6 parameters)
-	params := []interface{}{authCode, uid, did, bid, b, guessNum}
+	params := recoverSecretParams{AuthCode: authCode, UID: uid, DID: did, BID: bid, B: b, GuessNum: guessNum}
 
 	// Debug logging for request
 	if debug.IsDebugModeEnabled() {
@@ -480,85 +668,105 @@ func (c *EncryptedOpenADPClient) RecoverSecret(authCode, uid, did, bid, b string
 		debug.DebugLog(fmt.Sprintf("RecoverSecret auth_code: %s", authCode))
 	}
 
-	result, err := c.makeRequest("RecoverSecret", params, encrypted, authData)
-	if err != nil {
+	var resultMap map[string]interface{}
+	if err := c.call("RecoverSecret", params, encrypted, authData, &resultMap); err != nil {
 		if debug.IsDebugModeEnabled() {
 			debug.DebugLog(fmt.Sprintf("RecoverSecret error: %v", err))
 		}
 		return nil, err
 	}
 
-	resultMap, ok := result.(map[string]interface{})
-	if !ok {
-		if debug.IsDebugModeEnabled() {
-			debug.DebugLog(fmt.Sprintf("RecoverSecret unexpected response type: %T", result))
-		}
-		return nil, fmt.Errorf("unexpected response type: %T", result)
-	}
-
 	// Debug logging for response
 	if debug.IsDebugModeEnabled() {
-		if version, ok := resultMap["version"].(int); ok {
-			debug.DebugLog(fmt.Sprintf("RecoverSecret response: version=%d", version))
-		}
-		if x, ok := resultMap["x"].(int); ok {
-			debug.DebugLog(fmt.Sprintf("RecoverSecret response: x=%d", x))
-		}
-		if siB, ok := resultMap["si_b"].(string); ok {
-			debug.DebugLog(fmt.Sprintf("RecoverSecret response: si_b=%s", siB))
-		}
-		if numGuesses, ok := resultMap["num_guesses"].(int); ok {
-			debug.DebugLog(fmt.Sprintf("RecoverSecret response: num_guesses=%d", numGuesses))
-		}
-		if maxGuesses, ok := resultMap["max_guesses"].(int); ok {
-			debug.DebugLog(fmt.Sprintf("RecoverSecret response: max_guesses=%d", maxGuesses))
-		}
+		debug.DebugLog(fmt.Sprintf("RecoverSecret response: version=%v, x=%v, si_b=%v, num_guesses=%v, max_guesses=%v",
+			resultMap["version"], resultMap["x"], resultMap["si_b"], resultMap["num_guesses"], resultMap["max_guesses"]))
 	}
 
 	return resultMap, nil
 }
 
-// ListBackups lists all backups for a user
+// ListBackups lists all backups for a user. Each entry holds the fields of the
+// response as decoded from JSON, so numbers are float64.
 func (c *EncryptedOpenADPClient) ListBackups(uid string, encrypted bool, authData map[string]interface{}) ([]map[string]interface{}, error) {
-	// Server expects: [uid] (1 parameter)
-	params := []interface{}{uid}
-
-	result, err := c.makeRequest("ListBackups", params, encrypted, authData)
-	if err != nil {
+	var backups []map[string]interface{}
+	if err := c.call("ListBackups", listBackupsParams{UID: uid}, encrypted, authData, &backups); err != nil {
 		return nil, err
 	}
+	return backups, nil
+}
 
-	backups, ok := result.([]interface{})
-	if !ok {
-		return nil, fmt.Errorf("unexpected response type: %T", result)
+// ResetGuesses sets the guess counter of a backup back to zero, restoring its full
+// guess budget. Only servers advertising CapabilityResetGuesses support it.
+func (c *EncryptedOpenADPClient) ResetGuesses(authCode, uid, did, bid string, encrypted bool, authData map[string]interface{}) error {
+	var success bool
+	if err := c.call("ResetGuesses", backupParams{AuthCode: authCode, UID: uid, DID: did, BID: bid}, encrypted, authData, &success); err != nil {
+		return err
 	}
-
-	// Convert to proper format
-	var backupList []map[string]interface{}
-	for _, backup := range backups {
-		if backupMap, ok := backup.(map[string]interface{}); ok {
-			backupList = append(backupList, backupMap)
-		}
+	if !success {
+		return fmt.Errorf("server did not reset the guess counter")
 	}
+	return nil
+}
 
-	return backupList, nil
+// DeleteBackup removes a backup from the server. Only servers advertising
+// CapabilityDeleteBackup support it.
+func (c *EncryptedOpenADPClient) DeleteBackup(authCode, uid, did, bid string, encrypted bool, authData map[string]interface{}) error {
+	var success bool
+	if err := c.call("DeleteBackup", backupParams{AuthCode: authCode, UID: uid, DID: did, BID: bid}, encrypted, authData, &success); err != nil {
+		return err
+	}
+	if !success {
+		return fmt.Errorf("server did not delete the backup")
+	}
+	return nil
 }
 
 // Echo sends an echo message with optional encryption
 func (c *EncryptedOpenADPClient) Echo(message string, encrypted bool) (string, error) {
-	params := []interface{}{message}
+	var response string
+	if err := c.call("Echo", echoParams{Message: message}, encrypted, nil, &response); err != nil {
+		return "", err
+	}
+	return response, nil
+}
 
-	result, err := c.makeRequest("Echo", params, encrypted, nil)
-	if err != nil {
+// negotiateNoiseSuite returns the cipher suite to use with the server. Unless the
+// client allows only the default suite, the server's suites are read once from
+// GetServerInfo; a server that does not answer is assumed to speak only the default.
+func (c *EncryptedOpenADPClient) negotiateNoiseSuite() (string, error) {
+	cache := c.capabilities
+	if cache != nil {
+		cache.mu.Lock()
+		suite := cache.noiseSuite
+		cache.mu.Unlock()
+		if suite != "" {
+			return suite, nil
+		}
+	}
+	if err := c.noiseConfig.validate(); err != nil {
 		return "", err
 	}
+	if c.noiseConfig.onlyDefault() {
+		return DefaultNoiseSuite, nil
+	}
 
-	response, ok := result.(string)
-	if !ok {
-		return "", fmt.Errorf("unexpected response type: %T", result)
+	var serverSuites []string
+	if result, err := c.makeUnencryptedRequest("GetServerInfo", nil); err == nil {
+		if info, ok := result.(map[string]interface{}); ok {
+			serverSuites = serverNoiseSuites(info)
+		}
 	}
 
-	return response, nil
+	suite, err := c.noiseConfig.negotiate(serverSuites)
+	if err != nil {
+		return "", err
+	}
+	if cache != nil {
+		cache.mu.Lock()
+		cache.noiseSuite = suite
+		cache.mu.Unlock()
+	}
+	return suite, nil
 }
 
 // Ping tests connectivity to the server (alias for Echo with "ping" message)
@@ -612,36 +820,25 @@ func (c *EncryptedOpenADPClient) RegisterSecretStandardized(request *RegisterSec
 
 // RecoverSecretStandardized implements the standardized interface
 func (c *EncryptedOpenADPClient) RecoverSecretStandardized(request *RecoverSecretRequest) (*RecoverSecretResponse, error) {
-	result, err := c.RecoverSecret(
-		request.AuthCode, "", request.DID, request.BID,
-		request.B, request.GuessNum,
-		request.Encrypted, request.AuthData,
-	)
+	params := recoverSecretParams{AuthCode: request.AuthCode, DID: request.DID, BID: request.BID, B: request.B, GuessNum: request.GuessNum}
+	result, err := c.recoverSecret(params, request.Encrypted, request.AuthData)
 	if err != nil {
 		return nil, err
 	}
 
-	// Convert map response to standardized struct
-	version, _ := result["version"].(int)
-	x, _ := result["x"].(int)
-	siBStr, _ := result["si_b"].(string)
-	numGuesses, _ := result["num_guesses"].(int)
-	maxGuesses, _ := result["max_guesses"].(int)
-	expiration, _ := result["expiration"].(int)
-
 	return &RecoverSecretResponse{
-		Version:    version,
-		X:          x,
-		SiB:        siBStr,
-		NumGuesses: numGuesses,
-		MaxGuesses: maxGuesses,
-		Expiration: expiration,
+		Version:    result.Version,
+		X:          result.X,
+		SiB:        result.SiB,
+		NumGuesses: result.NumGuesses,
+		MaxGuesses: result.MaxGuesses,
+		Expiration: result.Expiration,
 	}, nil
 }
 
 // ListBackupsStandardized implements the standardized interface
 func (c *EncryptedOpenADPClient) ListBackupsStandardized(request *ListBackupsRequest) (*ListBackupsResponse, error) {
-	backups, err := c.ListBackups(request.UID, request.Encrypted, request.AuthData)
+	backups, err := c.listBackups(request.UID, request.Encrypted, request.AuthData)
 	if err != nil {
 		return nil, err
 	}
@@ -649,20 +846,13 @@ func (c *EncryptedOpenADPClient) ListBackupsStandardized(request *ListBackupsReq
 	// Convert to standardized format
 	standardBackups := make([]BackupInfo, len(backups))
 	for i, backup := range backups {
-		uid, _ := backup["uid"].(string)
-		bid, _ := backup["bid"].(string)
-		version, _ := backup["version"].(int)
-		numGuesses, _ := backup["num_guesses"].(int)
-		maxGuesses, _ := backup["max_guesses"].(int)
-		expiration, _ := backup["expiration"].(int)
-
 		standardBackups[i] = BackupInfo{
-			UID:        uid,
-			BID:        bid,
-			Version:    version,
-			NumGuesses: numGuesses,
-			MaxGuesses: maxGuesses,
-			Expiration: expiration,
+			UID:        backup.UID,
+			BID:        backup.BID,
+			Version:    backup.Version,
+			NumGuesses: backup.NumGuesses,
+			MaxGuesses: backup.MaxGuesses,
+			Expiration: backup.Expiration,
 		}
 	}
 
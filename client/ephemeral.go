@@ -0,0 +1,54 @@
+package client
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrReusedEphemeral is wrapped by errors from encrypted requests to a server that
+// answered two handshakes with the same ephemeral key while
+// NoiseConfig.RejectReusedEphemerals is set
+var ErrReusedEphemeral = errors.New("server reused its Noise ephemeral key")
+
+// ephemeralKeySize is the length of the X25519 ephemeral key that opens the
+// responder's handshake message; every supported suite uses X25519
+const ephemeralKeySize = 32
+
+// maxTrackedEphemerals bounds the ephemeral keys remembered per client, the
+// oldest being forgotten first
+const maxTrackedEphemerals = 1024
+
+// ephemeralLog remembers the ephemeral keys a server has sent. Copies of a
+// client made by WithContext share it.
+type ephemeralLog struct {
+	mu    sync.Mutex
+	seen  map[[ephemeralKeySize]byte]bool
+	order [][ephemeralKeySize]byte
+}
+
+// observe records the ephemeral key opening a responder handshake message and
+// reports whether the server had sent it before
+func (l *ephemeralLog) observe(message []byte) bool {
+	if l == nil || len(message) < ephemeralKeySize {
+		return false
+	}
+	var key [ephemeralKeySize]byte
+	copy(key[:], message)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.seen[key] {
+		return true
+	}
+	if l.seen == nil {
+		l.seen = make(map[[ephemeralKeySize]byte]bool)
+	}
+	if len(l.order) == maxTrackedEphemerals {
+		delete(l.seen, l.order[0])
+		l.order = l.order[1:]
+	}
+	l.seen[key] = true
+	l.order = append(l.order, key)
+	return false
+}
@@ -0,0 +1,96 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/openadp/ocrypt/testserver"
+)
+
+func TestRejectReusedEphemerals(t *testing.T) {
+	broken := testserver.New()
+	defer broken.Close()
+	broken.SetFault("noise_handshake", testserver.FaultReuseEphemeral)
+	healthy := testserver.New()
+	defer healthy.Close()
+
+	strict := &Options{Noise: &NoiseConfig{RejectReusedEphemerals: true}}
+
+	// The first handshake cannot be told apart from a healthy one; the second
+	// repeats its ephemeral key
+	client := strict.newClient(context.Background(), broken.URL, broken.PublicKey)
+	if _, err := client.Echo("first", true); err != nil {
+		t.Fatalf("Echo() error: %v", err)
+	}
+	_, err := client.Echo("second", true)
+	if !errors.Is(err, ErrReusedEphemeral) {
+		t.Fatalf("Echo() with a reused ephemeral error = %v, want ErrReusedEphemeral", err)
+	}
+	if isTransientError(err) {
+		t.Error("A reused ephemeral is retried")
+	}
+
+	// Copies made by WithContext share what the client has seen
+	if _, err := client.WithContext(context.Background()).Echo("copy", true); !errors.Is(err, ErrReusedEphemeral) {
+		t.Errorf("Echo() from a copy error = %v, want ErrReusedEphemeral", err)
+	}
+
+	// Without the option the reuse is only logged
+	logger := &recordingLogger{}
+	lenient := (&Options{Logger: logger}).newClient(context.Background(), broken.URL, broken.PublicKey)
+	for i := 0; i < 3; i++ {
+		if _, err := lenient.Echo("lenient", true); err != nil {
+			t.Fatalf("Echo() without the option error: %v", err)
+		}
+	}
+	if n := logger.count("WARN server reused its Noise ephemeral key"); n != 2 {
+		t.Errorf("Logged %d reuse warnings, want 2", n)
+	}
+
+	// Servers drawing fresh ephemerals pass every time
+	client = strict.newClient(context.Background(), healthy.URL, healthy.PublicKey)
+	for i := 0; i < 5; i++ {
+		if _, err := client.Echo("fresh", true); err != nil {
+			t.Fatalf("Echo() to a healthy server error: %v", err)
+		}
+	}
+}
+
+func TestPoolRejectsReusedEphemeralsAcrossOperations(t *testing.T) {
+	servers, serverInfos := newTestServers(t, 3)
+	identity := &Identity{UID: "user", DID: "app", BID: "even"}
+	generated := GenerateEncryptionKey(identity, "password", 10, 0, serverInfos)
+	if generated.Error != "" {
+		t.Fatalf("Key generation failed: %s", generated.Error)
+	}
+	for _, server := range servers {
+		server.SetFault("noise_handshake", testserver.FaultReuseEphemeral)
+	}
+
+	pool := NewPool(serverInfos, &Options{Noise: &NoiseConfig{RejectReusedEphemerals: true}}, nil)
+	defer pool.Close()
+
+	// Each recovery sends one handshake to each server it asks, so only the
+	// pool's memory of the first recovery reveals the reuse in the second
+	first := pool.Recover(identity, "password", generated.Threshold, generated.AuthCodes)
+	if first.Error != "" {
+		t.Fatalf("First recovery failed: %s", first.Error)
+	}
+	second := pool.Recover(identity, "password", generated.Threshold, generated.AuthCodes)
+	if second.Error == "" {
+		t.Fatal("Second recovery succeeded against servers reusing their ephemeral keys")
+	}
+	for i, result := range second.ServerResults {
+		if first.ServerResults[i].Success && !strings.Contains(result.Error, ErrReusedEphemeral.Error()) {
+			t.Errorf("Server %d, asked before: Error = %q, want ErrReusedEphemeral", i, result.Error)
+		}
+	}
+
+	// Without the pool each recovery starts with fresh clients and sees nothing
+	strict := &Options{Noise: &NoiseConfig{RejectReusedEphemerals: true}}
+	if recovered := RecoverEncryptionKeyWithOpts(identity, "password", serverInfos, generated.Threshold, generated.AuthCodes, strict); recovered.Error != "" {
+		t.Errorf("Recovery outside the pool failed: %s", recovered.Error)
+	}
+}
@@ -0,0 +1,97 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrCode classifies why a key generation or recovery operation failed, so that
+// callers can branch on the failure without parsing the human-readable Error string.
+// The zero value ErrNone means the operation succeeded. Localize turns a code into
+// a message for display.
+type ErrCode string
+
+// Error codes reported in GenerateEncryptionKeyResult.ErrCode and RecoverEncryptionKeyResult.ErrCode
+const (
+	ErrNone                ErrCode = ""
+	ErrInvalidIdentity     ErrCode = "INVALID_IDENTITY"     // Identity is nil or has an empty UID, DID or BID
+	ErrInvalidInput        ErrCode = "INVALID_INPUT"        // Another argument (max guesses, threshold, auth codes) is invalid
+	ErrInsufficientServers ErrCode = "INSUFFICIENT_SERVERS" // Too few servers were supplied for the operation
+	ErrNetwork             ErrCode = "NETWORK"              // No server could be reached
+	ErrThresholdNotMet     ErrCode = "THRESHOLD_NOT_MET"    // Fewer than threshold servers completed the operation
+	ErrGuessesExhausted    ErrCode = "GUESSES_EXHAUSTED"    // The backup is locked because all guesses were used
+	ErrInternal            ErrCode = "INTERNAL"             // A local cryptographic operation failed
+	ErrTimeout             ErrCode = "TIMEOUT"              // Options.Timeouts.Overall expired before threshold servers answered
+	ErrInconsistentServers ErrCode = "INCONSISTENT_SERVERS" // Servers disagree on backup parameters and Options.StrictConsistency is set, or returned shares that reconstruct different secrets
+	ErrGuessNotConfirmed   ErrCode = "GUESS_NOT_CONFIRMED"  // RecoverOptions.ConfirmGuessConsumption was not set, so no guess was spent
+	ErrReplayRejected      ErrCode = "REPLAY_REJECTED"      // Servers rejected requests as replayed or stale; see ErrReplayDetected
+	ErrAuthCodesRequired   ErrCode = "AUTH_CODES_REQUIRED"  // The auth codes of the backup cannot be re-derived; see RecoverWithoutAuthCodes
+	ErrBackupExpired       ErrCode = "BACKUP_EXPIRED"       // The backup expired on too many servers to reach the threshold; see Options.Clock
+	ErrAuthCodesRejected   ErrCode = "AUTH_CODES_REJECTED"  // The servers refused the auth codes derived from the password: it is wrong, or the codes were random; see RecoverWithoutAuthCodes
+
+	// ErrMixedProtocolVersions reports that too few servers speak, or returned
+	// shares of, ProtocolVersion, the only version this client reads; see
+	// RecoverEncryptionKeyResult.ProtocolVersion
+	ErrMixedProtocolVersions ErrCode = "MIXED_PROTOCOL_VERSIONS"
+
+	// ErrKeyCommitmentMismatch reports that the recovered key does not match
+	// Options.ExpectedKeyCommitment: the password is wrong, or a share is corrupted
+	ErrKeyCommitmentMismatch ErrCode = "KEY_COMMITMENT_MISMATCH"
+)
+
+// GuessesExhaustedError is returned by RecoverSecret when the backup has no
+// guesses left on the server. It is definitive: RetryPolicy never retries it.
+type GuessesExhaustedError struct {
+	Message string // The server's error message
+
+	// RetryAfter is when the server unlocks the backup again, if it enforces a
+	// cooldown and reports it. It is zero otherwise, and the lock may then be
+	// permanent.
+	RetryAfter time.Time
+}
+
+func (e *GuessesExhaustedError) Error() string {
+	if e.RetryAfter.IsZero() {
+		return e.Message
+	}
+	return fmt.Sprintf("%s (locked until %s)", e.Message, e.RetryAfter.UTC().Format(time.RFC3339))
+}
+
+// jsonRPCError converts a JSON-RPC error object to an error, describing it with
+// prefix. Exhausted guesses yield a wrapped *GuessesExhaustedError, with the
+// cooldown the server reports as {"retry_after": <Unix seconds>} in the error data,
+// and ReplayRejectedCode a wrapped ErrReplayDetected.
+func jsonRPCError(prefix string, e *JSONRPCError) error {
+	if e.Code == ReplayRejectedCode {
+		return fmt.Errorf("%s %d: %w: %s", prefix, e.Code, ErrReplayDetected, e.Message)
+	}
+	if !isGuessesExhaustedError(errors.New(e.Message)) {
+		return fmt.Errorf("%s %d: %s", prefix, e.Code, e.Message)
+	}
+
+	exhausted := &GuessesExhaustedError{Message: e.Message}
+	if data, ok := e.Data.(map[string]interface{}); ok {
+		if retryAfter, ok := data["retry_after"].(float64); ok && retryAfter > 0 {
+			exhausted.RetryAfter = time.Unix(int64(retryAfter), 0)
+		}
+	}
+	return fmt.Errorf("%s %d: %w", prefix, e.Code, exhausted)
+}
+
+// isGuessesExhaustedError reports whether a server error indicates that the
+// backup has no guesses left
+func isGuessesExhaustedError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var exhausted *GuessesExhaustedError
+	if errors.As(err, &exhausted) {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "too many guesses") ||
+		strings.Contains(msg, "guesses exhausted") ||
+		strings.Contains(msg, "no guesses remaining")
+}
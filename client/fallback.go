@@ -0,0 +1,111 @@
+package client
+
+import "fmt"
+
+// RecoverWithFallback recovers the key from the primary servers, contacting the
+// fallback servers only if fewer than threshold primary servers still hold a share.
+//
+// Before spending any guess, the primary servers are asked whether they hold the
+// backup, as QueryBackupStatus does. If threshold of them do, the key is recovered
+// from those alone and no fallback server is contacted. Otherwise fallback servers
+// are promoted in order, querying only as many as are still needed, until
+// threshold holders are known; recovery then spends one guess on at most each of
+// them and on no other server. If even the fallback servers cannot make up the threshold,
+// it fails with ErrThresholdNotMet without spending any guess, as BestEffortRecover
+// does.
+//
+// A fallback entry that reaches a server already selected, either through the same
+// URL or through another URL with the same pinned public key, is skipped: counting
+// it twice would spend two guesses on one server and contribute a duplicate share.
+// A fallback entry with the pinned key of an unreachable primary server is the
+// same server under another URL, so it uses that server's auth code if authCodes
+// has none for its own URL.
+func RecoverWithFallback(identity *Identity, password string, primary []ServerInfo, fallback []ServerInfo, threshold int, authCodes *AuthCodes, opts *Options) *RecoverEncryptionKeyResult {
+	if err := identity.Validate(); err != nil {
+		return &RecoverEncryptionKeyResult{Error: err.Error(), ErrCode: ErrInvalidIdentity}
+	}
+	if authCodes == nil || len(primary) == 0 {
+		return &RecoverEncryptionKeyResult{Error: "Auth codes and primary servers are required", ErrCode: ErrInvalidInput}
+	}
+	if threshold < 1 {
+		return &RecoverEncryptionKeyResult{Error: fmt.Sprintf("Invalid threshold %d", threshold), ErrCode: ErrInvalidInput}
+	}
+
+	selected := make([]ServerInfo, 0, threshold)
+	seenURLs := make(map[string]bool)
+	seenKeys := make(map[string]bool)
+	primaryAuthCodes := make(map[string]string) // Pinned key -> auth code of an unreachable primary
+	codes := authCodes.Clone()
+
+	// Primary servers whose status cannot be read count as not holding a share
+	status, _ := queryBackupStatus(identity, primary, authCodes, opts)
+	for i, server := range primary {
+		seenURLs[server.URL] = true
+		key := pinnedKey(server)
+		if status != nil && status.Servers[i].Found && !seenKeys[key] {
+			selected = append(selected, server)
+			if key != "" {
+				seenKeys[key] = true
+			}
+		} else if code, ok := authCodes.ForServer(server.URL); ok && key != "" {
+			primaryAuthCodes[key] = code
+		}
+	}
+
+	// Promote only as many fallback servers as are still needed, querying them in
+	// batches until the threshold is reached or they run out
+	var promoted []string
+	candidates := fallback
+	for len(selected) < threshold && len(candidates) > 0 {
+		var batch []ServerInfo
+		for len(batch) < threshold-len(selected) && len(candidates) > 0 {
+			server := candidates[0]
+			candidates = candidates[1:]
+			key := pinnedKey(server)
+			if seenURLs[server.URL] || (key != "" && seenKeys[key]) {
+				continue
+			}
+			seenURLs[server.URL] = true
+			if key != "" {
+				seenKeys[key] = true
+			}
+			if _, ok := codes.ForServer(server.URL); !ok && primaryAuthCodes[key] != "" {
+				codes.SetServer(server.URL, primaryAuthCodes[key])
+			}
+			batch = append(batch, server)
+		}
+		if len(batch) == 0 {
+			break
+		}
+
+		status, _ := queryBackupStatus(identity, batch, codes, opts)
+		for i, server := range batch {
+			if status != nil && status.Servers[i].Found {
+				selected = append(selected, server)
+				promoted = append(promoted, server.URL)
+			}
+		}
+	}
+
+	if len(selected) == 0 {
+		return &RecoverEncryptionKeyResult{
+			Error:        fmt.Sprintf("Found 0 of the %d shares needed", threshold),
+			ErrCode:      ErrThresholdNotMet,
+			SharesNeeded: threshold,
+		}
+	}
+
+	result := BestEffortRecover(identity, password, selected, threshold, codes, opts)
+	result.FallbackServers = promoted
+	return result
+}
+
+// pinnedKey returns the decoded public key pinned for server as a map key, or ""
+// if none is pinned or it cannot be decoded
+func pinnedKey(server ServerInfo) string {
+	publicKey, err := decodeServerPublicKey(server.PublicKey)
+	if err != nil {
+		return ""
+	}
+	return string(publicKey)
+}
@@ -0,0 +1,111 @@
+package client
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRecoverWithFallback(t *testing.T) {
+	tests := []struct {
+		name         string
+		decommission int // Primary servers closed after registration
+		wantCode     ErrCode
+		wantPromoted int
+	}{
+		{"primary suffices", 0, ErrNone, 0},
+		{"promote one", 1, ErrNone, 1},
+		{"promote two", 2, ErrNone, 2},
+		{"fallback exhausted", 3, ErrThresholdNotMet, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			servers, serverInfos := newTestServers(t, 5)
+			identity := &Identity{UID: "user", DID: "app", BID: "fallback"}
+
+			generated := GenerateEncryptionKeyWithExpiry(identity, "password", 10, time.Time{}, serverInfos, &Options{Threshold: 3})
+			if generated.Error != "" {
+				t.Fatalf("Key generation failed: %s", generated.Error)
+			}
+			for _, server := range servers[:tt.decommission] {
+				server.Close()
+			}
+
+			// A second URL for primary server 2 must not be contacted while that
+			// server is reachable
+			var mirrored atomic.Int32
+			target, _ := url.Parse(servers[2].URL)
+			proxy := httputil.NewSingleHostReverseProxy(target)
+			mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mirrored.Add(1)
+				proxy.ServeHTTP(w, r)
+			}))
+			defer mirror.Close()
+			fallback := append([]ServerInfo{{URL: mirror.URL, PublicKey: serverInfos[2].PublicKey}}, serverInfos[3:]...)
+
+			result := RecoverWithFallback(identity, "password", serverInfos[:3], fallback, generated.Threshold, generated.AuthCodes, nil)
+			if result.ErrCode != tt.wantCode {
+				t.Fatalf("ErrCode = %q, want %q (error: %s)", result.ErrCode, tt.wantCode, result.Error)
+			}
+			if tt.wantCode == ErrNone && !bytes.Equal(result.EncryptionKey, generated.EncryptionKey) {
+				t.Error("Recovered key does not match")
+			}
+			if len(result.FallbackServers) != tt.wantPromoted {
+				t.Errorf("FallbackServers = %v, want %d servers", result.FallbackServers, tt.wantPromoted)
+			}
+			if n := mirrored.Load(); n != 0 && tt.decommission < 3 {
+				t.Errorf("Second URL of a primary server received %d requests", n)
+			}
+
+			// Guesses are spent on exactly the servers used, and none if recovery
+			// cannot succeed
+			for i, server := range servers[tt.decommission:] {
+				want := 0
+				if tt.wantCode == ErrNone && tt.decommission+i < 3+tt.wantPromoted {
+					want = 1
+				}
+				if got := server.NumGuesses(identity.UID, identity.DID, identity.BID); got != want {
+					t.Errorf("Server %d spent %d guesses, want %d", tt.decommission+i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRecoverWithFallbackSameServerOtherURL(t *testing.T) {
+	servers, serverInfos := newTestServers(t, 3)
+	identity := &Identity{UID: "user", DID: "app", BID: "fallback-url"}
+
+	generated := GenerateEncryptionKeyWithExpiry(identity, "password", 10, time.Time{}, serverInfos, &Options{Threshold: 3})
+	if generated.Error != "" {
+		t.Fatalf("Key generation failed: %s", generated.Error)
+	}
+
+	// The primary URL of server 0 is unreachable, but the server answers elsewhere
+	target, _ := url.Parse(servers[0].URL)
+	mirror := httptest.NewServer(httputil.NewSingleHostReverseProxy(target))
+	defer mirror.Close()
+	primary := append([]ServerInfo{{URL: "http://127.0.0.1:1", PublicKey: serverInfos[0].PublicKey}}, serverInfos[1:]...)
+	codes := &AuthCodes{BaseAuthCode: generated.AuthCodes.BaseAuthCode, ServerAuthCodes: map[string]string{
+		primary[0].URL: generated.AuthCodes.ServerAuthCodes[serverInfos[0].URL],
+		primary[1].URL: generated.AuthCodes.ServerAuthCodes[serverInfos[1].URL],
+		primary[2].URL: generated.AuthCodes.ServerAuthCodes[serverInfos[2].URL],
+	}}
+
+	result := RecoverWithFallback(identity, "password", primary, []ServerInfo{{URL: mirror.URL, PublicKey: serverInfos[0].PublicKey}}, 3, codes, nil)
+	if result.Error != "" {
+		t.Fatalf("Recovery failed: %s", result.Error)
+	}
+	if !bytes.Equal(result.EncryptionKey, generated.EncryptionKey) {
+		t.Error("Recovered key does not match")
+	}
+	if got := servers[0].NumGuesses(identity.UID, identity.DID, identity.BID); got != 1 {
+		t.Errorf("Server 0 spent %d guesses, want 1", got)
+	}
+}
@@ -0,0 +1,199 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// File format (version 1):
+//
+//	magic "OCRYPT" || version (1 byte) || header length (4 bytes, big-endian) || header || stream
+//
+// The header is the JSON encoding of fileHeader and the stream is the output of
+// EncryptStream. The header is not encrypted: it holds only what recovery needs,
+// and altering it makes recovery fail or yield a key that does not decrypt the stream.
+const (
+	fileMagic         = "OCRYPT"
+	fileVersion       = 1
+	maxFileHeaderSize = 1 << 20
+)
+
+// FileExtension is appended to the name of files encrypted with EncryptFile
+const FileExtension = ".ocrypt"
+
+// ErrNotOcryptFile is returned by DecryptFile for files not produced by EncryptFile
+var ErrNotOcryptFile = errors.New("not an ocrypt file")
+
+// ErrBackupExists is returned by EncryptFile when a server already holds a backup
+// for the identity
+var ErrBackupExists = errors.New("backup already exists")
+
+// fileHeader is the recovery information stored at the start of an encrypted file
+type fileHeader struct {
+	UID      string    `json:"uid"`
+	Metadata *Metadata `json:"metadata"`
+}
+
+// EncryptFile generates a fresh key for identity protected by password on
+// serverInfos, and encrypts the file at path to path+FileExtension, with the
+// recovery metadata in a versioned header. The original file is left in place;
+// delete it once the encrypted copy is stored safely. EncryptFile fails if the
+// output file already exists.
+//
+// Each file needs an identity of its own, such as a BID derived from its path:
+// registering the key replaces any backup stored under the same (UID, DID, BID),
+// and with it the only way to decrypt the file that backup protects. EncryptFile
+// therefore fails with ErrBackupExists if any server already lists a backup for
+// identity.
+func EncryptFile(path string, identity *Identity, password string, serverInfos []ServerInfo, maxGuesses int) error {
+	outPath := path + FileExtension
+	if _, err := os.Lstat(outPath); err == nil {
+		return fmt.Errorf("%s already exists", outPath)
+	}
+	if status, _ := QueryBackupStatus(identity, serverInfos, nil); status != nil && status.Responding > 0 {
+		return fmt.Errorf("%w: %s is registered on %d servers", ErrBackupExists, identity.String(), status.Responding)
+	}
+
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	result := GenerateEncryptionKeyWithExpiry(identity, password, maxGuesses, time.Time{}, serverInfos, nil)
+	if result.Error != "" {
+		return fmt.Errorf("failed to generate key: %s", result.Error)
+	}
+	defer result.Zeroize()
+
+	metadata, err := MetadataFromResult(identity, result)
+	if err != nil {
+		return err
+	}
+	header, err := json.Marshal(&fileHeader{UID: identity.UID, Metadata: metadata})
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomically(outPath, func(w io.Writer) error {
+		prefix := make([]byte, len(fileMagic)+5)
+		copy(prefix, fileMagic)
+		prefix[len(fileMagic)] = fileVersion
+		binary.BigEndian.PutUint32(prefix[len(fileMagic)+1:], uint32(len(header)))
+		if _, err := w.Write(append(prefix, header...)); err != nil {
+			return err
+		}
+		return EncryptStream(result.EncryptionKey, in, w)
+	})
+}
+
+// DecryptFile decrypts a file produced by EncryptFile: it reads the recovery
+// metadata from the header, recovers the key with password and writes the
+// plaintext to path without FileExtension (or to path+".decrypted" if path does not
+// end in FileExtension). Nothing is written unless decryption succeeds. Files that
+// do not start with the ocrypt header fail with ErrNotOcryptFile.
+func DecryptFile(path, password string) error {
+	outPath := strings.TrimSuffix(path, FileExtension)
+	if outPath == path {
+		outPath = path + ".decrypted"
+	}
+	if _, err := os.Lstat(outPath); err == nil {
+		return fmt.Errorf("%s already exists", outPath)
+	}
+
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	reader := bufio.NewReader(in)
+	header, err := readFileHeader(reader)
+	if err != nil {
+		return err
+	}
+
+	metadata := header.Metadata
+	opts, err := metadata.RecoveryOptions(nil)
+	if err != nil {
+		return err
+	}
+	result := RecoverEncryptionKeyWithOpts(metadata.Identity(header.UID), password,
+		MetadataToServerInfo(metadata), metadata.Threshold, metadata.AuthCodes, opts)
+	if result.Error != "" {
+		return fmt.Errorf("failed to recover key: %s", result.Error)
+	}
+	defer result.Zeroize()
+
+	return writeFileAtomically(outPath, func(w io.Writer) error {
+		return DecryptStream(result.EncryptionKey, reader, w)
+	})
+}
+
+// readFileHeader reads and validates the header of an encrypted file
+func readFileHeader(r io.Reader) (*fileHeader, error) {
+	prefix := make([]byte, len(fileMagic)+5)
+	if _, err := io.ReadFull(r, prefix); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, ErrNotOcryptFile
+		}
+		return nil, err
+	}
+	if !bytes.Equal(prefix[:len(fileMagic)], []byte(fileMagic)) {
+		return nil, ErrNotOcryptFile
+	}
+	if version := prefix[len(fileMagic)]; version != fileVersion {
+		return nil, fmt.Errorf("unsupported ocrypt file version %d", version)
+	}
+
+	size := binary.BigEndian.Uint32(prefix[len(fileMagic)+1:])
+	if size > maxFileHeaderSize {
+		return nil, fmt.Errorf("ocrypt file header of %d bytes is too large", size)
+	}
+	raw := make([]byte, size)
+	if _, err := io.ReadFull(r, raw); err != nil {
+		return nil, fmt.Errorf("truncated ocrypt file header: %v", err)
+	}
+
+	var header fileHeader
+	if err := json.Unmarshal(raw, &header); err != nil {
+		return nil, fmt.Errorf("invalid ocrypt file header: %v", err)
+	}
+	if header.UID == "" || header.Metadata == nil {
+		return nil, errors.New("invalid ocrypt file header: missing uid or metadata")
+	}
+	return &header, nil
+}
+
+// writeFileAtomically writes path through a temporary file in the same
+// directory, which is renamed into place only if write succeeds
+func writeFileAtomically(path string, write func(io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	writer := bufio.NewWriter(tmp)
+	if err := write(writer); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,97 @@
+package client
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEncryptDecryptFile(t *testing.T) {
+	_, serverInfos := newTestServers(t, 3)
+	identity := &Identity{UID: "user", DID: "laptop", BID: "file://notes.txt"}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "notes.txt")
+	plaintext := make([]byte, 200000)
+	io.ReadFull(seededReader(5), plaintext)
+	if err := os.WriteFile(path, plaintext, 0o600); err != nil {
+		t.Fatalf("WriteFile() error: %v", err)
+	}
+
+	if err := EncryptFile(path, identity, "password", serverInfos, 10); err != nil {
+		t.Fatalf("EncryptFile() error: %v", err)
+	}
+	if err := EncryptFile(path, identity, "password", serverInfos, 10); err == nil {
+		t.Error("EncryptFile() overwrote an existing encrypted file")
+	}
+
+	// A second file under the same identity would replace the first file's backup
+	otherPath := filepath.Join(dir, "other.txt")
+	if err := os.WriteFile(otherPath, []byte("other"), 0o600); err != nil {
+		t.Fatalf("WriteFile() error: %v", err)
+	}
+	if err := EncryptFile(otherPath, identity, "password", serverInfos, 10); !errors.Is(err, ErrBackupExists) {
+		t.Errorf("EncryptFile() with a registered identity error = %v, want ErrBackupExists", err)
+	}
+	if _, err := os.Stat(otherPath + FileExtension); !os.IsNotExist(err) {
+		t.Error("Refused EncryptFile() left an output file")
+	}
+
+	// Decrypting next to the original requires moving it out of the way
+	if err := os.Rename(path, path+".orig"); err != nil {
+		t.Fatalf("Rename() error: %v", err)
+	}
+
+	if err := DecryptFile(path+FileExtension, "wrong password"); err == nil {
+		t.Error("DecryptFile() with the wrong password expected error")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("Failed DecryptFile() left an output file")
+	}
+
+	if err := DecryptFile(path+FileExtension, "password"); err != nil {
+		t.Fatalf("DecryptFile() error: %v", err)
+	}
+	decrypted, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile() error: %v", err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Error("Decrypted file does not match the original")
+	}
+}
+
+func TestDecryptFileRejectsOtherFiles(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		name     string
+		contents string
+		wantErr  error
+	}{
+		{"plain text", "just some text, not encrypted", ErrNotOcryptFile},
+		{"empty", "", ErrNotOcryptFile},
+		{"future version", "OCRYPT\x02\x00\x00\x00\x02{}", nil},
+		{"truncated header", "OCRYPT\x01\x00\x00\x01\x00{}", nil},
+		{"missing metadata", "OCRYPT\x01\x00\x00\x00\x02{}", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name+FileExtension)
+			if err := os.WriteFile(path, []byte(tt.contents), 0o600); err != nil {
+				t.Fatalf("WriteFile() error: %v", err)
+			}
+			err := DecryptFile(path, "password")
+			if err == nil {
+				t.Fatal("DecryptFile() expected error")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("DecryptFile() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package client
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RecoverFromMetadata recovers the key of the backup metadata describes, taking
+// the servers, threshold, auth codes and recovery options from the metadata
+// rather than from the caller, so that they cannot disagree with the backup. The
+// UID is not part of the metadata and must be supplied; see Metadata.
+func RecoverFromMetadata(metadata Metadata, uid, password string) *RecoverEncryptionKeyResult {
+	return RecoverFromMetadataWithOpts(metadata, uid, password, nil)
+}
+
+// RecoverFromMetadataWithOpts is like RecoverFromMetadata but applies opts on top
+// of the options recorded in the metadata; see Metadata.RecoveryOptions.
+//
+// The servers are probed first. If fewer of them are reachable than the stored
+// threshold, it fails with ErrInsufficientServers, naming the unreachable
+// servers, before any guess is spent.
+func RecoverFromMetadataWithOpts(metadata Metadata, uid, password string, opts *Options) *RecoverEncryptionKeyResult {
+	identity := metadata.Identity(uid)
+	if err := identity.Validate(); err != nil {
+		return &RecoverEncryptionKeyResult{Error: err.Error(), ErrCode: ErrInvalidIdentity}
+	}
+	if len(metadata.Servers) == 0 {
+		return &RecoverEncryptionKeyResult{Error: "Metadata lists no servers", ErrCode: ErrInvalidInput}
+	}
+	if metadata.Threshold < 1 || metadata.Threshold > len(metadata.Servers) {
+		return &RecoverEncryptionKeyResult{
+			Error:   fmt.Sprintf("Metadata threshold %d is not between 1 and its %d servers", metadata.Threshold, len(metadata.Servers)),
+			ErrCode: ErrInvalidInput,
+		}
+	}
+	if metadata.AuthCodes == nil {
+		return &RecoverEncryptionKeyResult{Error: "Metadata records no auth codes", ErrCode: ErrInvalidInput}
+	}
+
+	recovery, err := metadata.RecoveryOptions(opts)
+	if err != nil {
+		return &RecoverEncryptionKeyResult{Error: err.Error(), ErrCode: ErrInvalidInput}
+	}
+
+	ctx, cancel := recovery.overallContext()
+	probes := probeServers(ctx, MetadataToServerInfo(&metadata), recovery)
+	cancel()
+
+	var reachable []ServerInfo
+	var unreachable []string
+	for _, probe := range probes {
+		if probe.Reachable {
+			reachable = append(reachable, probe.Server)
+		} else {
+			unreachable = append(unreachable, probe.Server.URL)
+		}
+	}
+	if len(reachable) < metadata.Threshold {
+		return &RecoverEncryptionKeyResult{
+			Error: fmt.Sprintf("Only %d of the %d servers in the metadata are reachable, but the backup needs %d; unreachable: %s",
+				len(reachable), len(metadata.Servers), metadata.Threshold, strings.Join(unreachable, ", ")),
+			ErrCode:      ErrInsufficientServers,
+			SharesNeeded: metadata.Threshold,
+		}
+	}
+
+	return RecoverEncryptionKeyWithOpts(identity, password, reachable, metadata.Threshold, metadata.AuthCodes, recovery)
+}
@@ -0,0 +1,88 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestRecoverFromMetadata(t *testing.T) {
+	servers, serverInfos := newTestServers(t, 3)
+	identity := &Identity{UID: "user", DID: "app", BID: "from-metadata"}
+
+	generated := GenerateEncryptionKeyWithOpts(identity, "password", 10, 0, serverInfos, &Options{KeyLength: 16})
+	if generated.Error != "" {
+		t.Fatalf("Key generation failed: %s", generated.Error)
+	}
+	built, err := MetadataFromResult(identity, generated)
+	if err != nil {
+		t.Fatalf("MetadataFromResult() error: %v", err)
+	}
+	encoded, err := json.Marshal(built)
+	if err != nil {
+		t.Fatalf("Marshal() error: %v", err)
+	}
+	var metadata Metadata
+	if err := json.Unmarshal(encoded, &metadata); err != nil {
+		t.Fatalf("Unmarshal() error: %v", err)
+	}
+
+	// The key length, threshold and auth codes all come from the metadata
+	result := RecoverFromMetadata(metadata, identity.UID, "password")
+	if result.Error != "" {
+		t.Fatalf("Recovery failed: %s", result.Error)
+	}
+	if !bytes.Equal(result.EncryptionKey, generated.EncryptionKey) {
+		t.Error("Recovered key does not match")
+	}
+
+	// One server down still leaves the threshold of 2
+	servers[2].Close()
+	if result := RecoverFromMetadata(metadata, identity.UID, "password"); result.Error != "" {
+		t.Errorf("Recovery with one server down failed: %s", result.Error)
+	}
+
+	// Two servers down do not, and no guess is spent finding out
+	servers[1].Close()
+	guesses := servers[0].NumGuesses(identity.UID, identity.DID, identity.BID)
+	result = RecoverFromMetadata(metadata, identity.UID, "password")
+	if result.ErrCode != ErrInsufficientServers || !strings.Contains(result.Error, servers[1].URL) {
+		t.Errorf("ErrCode = %q (%s), want %q naming the unreachable servers", result.ErrCode, result.Error, ErrInsufficientServers)
+	}
+	if got := servers[0].NumGuesses(identity.UID, identity.DID, identity.BID); got != guesses {
+		t.Errorf("Guess counter moved from %d to %d", guesses, got)
+	}
+}
+
+func TestRecoverFromMetadataInvalid(t *testing.T) {
+	valid := Metadata{
+		Version:   MetadataVersion,
+		DID:       "app",
+		BID:       "invalid",
+		Servers:   []MetadataServer{{URL: "https://a.example.com"}, {URL: "https://b.example.com"}},
+		Threshold: 2,
+		AuthCodes: AuthCodesFromBase("base", []string{"https://a.example.com", "https://b.example.com"}),
+	}
+
+	tests := []struct {
+		name   string
+		modify func(m *Metadata)
+		uid    string
+		want   ErrCode
+	}{
+		{"missing uid", func(m *Metadata) {}, "", ErrInvalidIdentity},
+		{"no servers", func(m *Metadata) { m.Servers = nil }, "user", ErrInvalidInput},
+		{"threshold above servers", func(m *Metadata) { m.Threshold = 3 }, "user", ErrInvalidInput},
+		{"zero threshold", func(m *Metadata) { m.Threshold = 0 }, "user", ErrInvalidInput},
+		{"no auth codes", func(m *Metadata) { m.AuthCodes = nil }, "user", ErrInvalidInput},
+	}
+
+	for _, tt := range tests {
+		metadata := valid
+		tt.modify(&metadata)
+		if result := RecoverFromMetadata(metadata, tt.uid, "password"); result.ErrCode != tt.want {
+			t.Errorf("%s: ErrCode = %q (%s), want %q", tt.name, result.ErrCode, result.Error, tt.want)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package client
+
+import (
+	"crypto/tls"
+	"net/url"
+	"time"
+)
+
+// Option configures GenerateEncryptionKeyWithOptions and
+// RecoverEncryptionKeyWithOptions. Each Option sets one field of Options, so the
+// two styles can be mixed with WithOptions; later options override earlier ones.
+type Option func(*settings)
+
+// settings collects the effect of Options
+type settings struct {
+	opts       Options
+	maxGuesses int
+	expiresAt  time.Time
+}
+
+// newSettings applies options to the defaults
+func newSettings(options []Option) *settings {
+	s := &settings{maxGuesses: defaultMaxGuesses}
+	for _, option := range options {
+		option(s)
+	}
+	return s
+}
+
+// GenerateEncryptionKeyWithOptions is GenerateEncryptionKeyWithExpiry configured
+// with functional options. Without WithMaxGuesses the backup allows 10 guesses,
+// and without WithExpiry it never expires.
+func GenerateEncryptionKeyWithOptions(identity *Identity, password string, serverInfos []ServerInfo, options ...Option) *GenerateEncryptionKeyResult {
+	s := newSettings(options)
+	return GenerateEncryptionKeyWithExpiry(identity, password, s.maxGuesses, s.expiresAt, serverInfos, &s.opts)
+}
+
+// RecoverEncryptionKeyWithOptions is RecoverEncryptionKeyWithOpts configured with
+// functional options. WithMaxGuesses, WithExpiry and WithThreshold only apply to
+// key generation and are ignored.
+func RecoverEncryptionKeyWithOptions(identity *Identity, password string, serverInfos []ServerInfo, threshold int, authCodes *AuthCodes, options ...Option) *RecoverEncryptionKeyResult {
+	s := newSettings(options)
+	return RecoverEncryptionKeyWithOpts(identity, password, serverInfos, threshold, authCodes, &s.opts)
+}
+
+// WithOptions starts from a copy of opts; options after it override its fields
+func WithOptions(opts *Options) Option {
+	return func(s *settings) {
+		if opts != nil {
+			s.opts = *opts
+		}
+	}
+}
+
+// WithMaxGuesses sets the guess budget of a generated backup
+func WithMaxGuesses(maxGuesses int) Option {
+	return func(s *settings) { s.maxGuesses = maxGuesses }
+}
+
+// WithExpiry sets when a generated backup expires; see GenerateEncryptionKeyWithExpiry
+func WithExpiry(expiresAt time.Time) Option {
+	return func(s *settings) { s.expiresAt = expiresAt }
+}
+
+// WithThreshold sets Options.Threshold
+func WithThreshold(threshold int) Option {
+	return func(s *settings) { s.opts.Threshold = threshold }
+}
+
+// WithMinServers sets Options.MinServers
+func WithMinServers(minServers int) Option {
+	return func(s *settings) { s.opts.MinServers = minServers }
+}
+
+// WithTimeout sets Options.Timeouts.PerServer, the deadline of each server request
+func WithTimeout(perServer time.Duration) Option {
+	return func(s *settings) { s.opts.Timeouts.PerServer = perServer }
+}
+
+// WithOverallTimeout sets Options.Timeouts.Overall, the deadline of the whole operation
+func WithOverallTimeout(overall time.Duration) Option {
+	return func(s *settings) { s.opts.Timeouts.Overall = overall }
+}
+
+// WithRetry sets Options.Retry
+func WithRetry(policy RetryPolicy) Option {
+	return func(s *settings) { s.opts.Retry = &policy }
+}
+
+// WithLogger sets Options.Logger
+func WithLogger(logger Logger) Option {
+	return func(s *settings) { s.opts.Logger = logger }
+}
+
+// WithMetrics sets Options.Metrics
+func WithMetrics(metrics MetricsObserver) Option {
+	return func(s *settings) { s.opts.Metrics = metrics }
+}
+
+// WithProgress sets Options.Progress
+func WithProgress(progress ProgressFunc) Option {
+	return func(s *settings) { s.opts.Progress = progress }
+}
+
+// WithKeyLength sets Options.KeyLength
+func WithKeyLength(length int) Option {
+	return func(s *settings) { s.opts.KeyLength = length }
+}
+
+// WithPinParams sets Options.PinParams, the derivation of the PIN
+func WithPinParams(params PinParams) Option {
+	return func(s *settings) { s.opts.PinParams = &params }
+}
+
+// WithBindIdentity sets Options.BindIdentity
+func WithBindIdentity() Option {
+	return func(s *settings) { s.opts.BindIdentity = true }
+}
+
+// WithHashDST sets Options.HashDST
+func WithHashDST(dst string) Option {
+	return func(s *settings) { s.opts.HashDST = dst }
+}
+
+// WithKeyCommitment sets Options.KeyCommitment
+func WithKeyCommitment() Option {
+	return func(s *settings) { s.opts.KeyCommitment = true }
+}
+
+// WithClock sets Options.Clock
+func WithClock(clock Clock) Option {
+	return func(s *settings) { s.opts.Clock = clock }
+}
+
+// WithMaxWorkers sets Options.MaxWorkers
+func WithMaxWorkers(n int) Option {
+	return func(s *settings) { s.opts.MaxWorkers = n }
+}
+
+// WithMaxConcurrency sets Options.MaxConcurrency
+func WithMaxConcurrency(n int) Option {
+	return func(s *settings) { s.opts.MaxConcurrency = n }
+}
+
+// WithProxy sets Options.ProxyURL
+func WithProxy(proxy *url.URL) Option {
+	return func(s *settings) { s.opts.ProxyURL = proxy }
+}
+
+// WithTLSConfig sets Options.TLSConfig
+func WithTLSConfig(config *tls.Config) Option {
+	return func(s *settings) { s.opts.TLSConfig = config }
+}
+
+// WithTransport sets Options.Transport
+func WithTransport(transport Transport) Option {
+	return func(s *settings) { s.opts.Transport = transport }
+}
+
+// WithKeyCache sets Options.KeyCache
+func WithKeyCache(cache *KeyCache) Option {
+	return func(s *settings) { s.opts.KeyCache = cache }
+}
+
+// WithHealthStore sets Options.HealthStore
+func WithHealthStore(store ServerHealthStore) Option {
+	return func(s *settings) { s.opts.HealthStore = store }
+}
+
+// WithRateLimiter sets Options.RateLimiter
+func WithRateLimiter(limiter *RateLimiter) Option {
+	return func(s *settings) { s.opts.RateLimiter = limiter }
+}
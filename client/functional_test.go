@@ -0,0 +1,123 @@
+package client
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/openadp/ocrypt/testserver"
+)
+
+func TestFunctionalOptions(t *testing.T) {
+	servers, serverInfos := newTestServers(t, 3)
+	logger := &recordingLogger{}
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Second)
+
+	tests := []struct {
+		name    string
+		options []Option
+		setup   func()
+		check   func(t *testing.T, generated *GenerateEncryptionKeyResult, identity *Identity)
+	}{
+		{"defaults", nil, nil, func(t *testing.T, generated *GenerateEncryptionKeyResult, identity *Identity) {
+			if generated.Threshold != 2 || len(generated.EncryptionKey) != DefaultKeyLength {
+				t.Errorf("Threshold %d, key length %d, want 2 and %d", generated.Threshold, len(generated.EncryptionKey), DefaultKeyLength)
+			}
+			if got := backupMaxGuesses(t, identity, serverInfos); got != defaultMaxGuesses {
+				t.Errorf("Max guesses = %d, want %d", got, defaultMaxGuesses)
+			}
+		}},
+		{"WithThreshold", []Option{WithThreshold(3)}, nil, func(t *testing.T, generated *GenerateEncryptionKeyResult, _ *Identity) {
+			if generated.Threshold != 3 {
+				t.Errorf("Threshold = %d, want 3", generated.Threshold)
+			}
+		}},
+		{"WithKeyLength", []Option{WithKeyLength(16)}, nil, func(t *testing.T, generated *GenerateEncryptionKeyResult, _ *Identity) {
+			if len(generated.EncryptionKey) != 16 {
+				t.Errorf("Key length = %d, want 16", len(generated.EncryptionKey))
+			}
+		}},
+		{"WithMaxGuesses", []Option{WithMaxGuesses(3)}, nil, func(t *testing.T, _ *GenerateEncryptionKeyResult, identity *Identity) {
+			if got := backupMaxGuesses(t, identity, serverInfos); got != 3 {
+				t.Errorf("Max guesses = %d, want 3", got)
+			}
+		}},
+		{"WithExpiry", []Option{WithExpiry(expiresAt)}, nil, func(t *testing.T, generated *GenerateEncryptionKeyResult, identity *Identity) {
+			recovered := RecoverEncryptionKeyWithOptions(identity, "password", serverInfos, generated.Threshold, generated.AuthCodes)
+			for url, expiration := range recovered.Consistency.Expiration {
+				if int64(expiration) != expiresAt.Unix() {
+					t.Errorf("Server %s stored expiration %d, want %d", url, expiration, expiresAt.Unix())
+				}
+			}
+		}},
+		{"WithPinParams", []Option{WithPinParams(PinParams{KDF: PinKDFSHA256, Length: 8})}, nil, func(t *testing.T, generated *GenerateEncryptionKeyResult, _ *Identity) {
+			if generated.PinParams == nil || generated.PinParams.KDF != PinKDFSHA256 {
+				t.Errorf("PinParams = %+v, want SHA-256", generated.PinParams)
+			}
+		}},
+		{"WithLogger", []Option{WithLogger(logger)}, nil, func(t *testing.T, _ *GenerateEncryptionKeyResult, _ *Identity) {
+			if logger.count("INFO selected threshold") != 1 {
+				t.Error("Logger did not receive the threshold event")
+			}
+		}},
+		{"WithRetry", []Option{WithRetry(RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond})},
+			func() { servers[0].SetFaultOnce("RegisterSecret", testserver.FaultUnavailable) },
+			func(t *testing.T, generated *GenerateEncryptionKeyResult, _ *Identity) {
+				for _, result := range generated.ServerResults {
+					if !result.Success {
+						t.Errorf("Registration with %s failed despite the retry: %s", result.URL, result.Error)
+					}
+				}
+			}},
+		{"WithTimeout", []Option{WithTimeout(50 * time.Millisecond)},
+			func() { servers[0].SetLatency("RegisterSecret", 500*time.Millisecond) },
+			func(t *testing.T, generated *GenerateEncryptionKeyResult, _ *Identity) {
+				if generated.ServerResults[0].Success {
+					t.Error("Slow server did not time out")
+				}
+			}},
+		{"WithOptions overridden", []Option{WithOptions(&Options{Threshold: 1, KeyLength: 16}), WithThreshold(3)}, nil,
+			func(t *testing.T, generated *GenerateEncryptionKeyResult, _ *Identity) {
+				if generated.Threshold != 3 || len(generated.EncryptionKey) != 16 {
+					t.Errorf("Threshold %d, key length %d, want 3 and 16", generated.Threshold, len(generated.EncryptionKey))
+				}
+			}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.setup != nil {
+				tt.setup()
+			}
+			identity := &Identity{UID: "user", DID: "app", BID: tt.name}
+
+			generated := GenerateEncryptionKeyWithOptions(identity, "password", serverInfos, tt.options...)
+			if generated.Error != "" {
+				t.Fatalf("Key generation failed: %s", generated.Error)
+			}
+			for _, server := range servers {
+				server.SetLatency("RegisterSecret", 0)
+			}
+			tt.check(t, generated, identity)
+
+			// The same options recover the key
+			recovered := RecoverEncryptionKeyWithOptions(identity, "password", serverInfos, generated.Threshold, generated.AuthCodes, tt.options...)
+			if recovered.Error != "" {
+				t.Fatalf("Recovery failed: %s", recovered.Error)
+			}
+			if !bytes.Equal(recovered.EncryptionKey, generated.EncryptionKey) {
+				t.Error("Recovered key does not match")
+			}
+		})
+	}
+}
+
+// backupMaxGuesses returns the guess budget the servers report for a backup
+func backupMaxGuesses(t *testing.T, identity *Identity, serverInfos []ServerInfo) int {
+	t.Helper()
+	status, err := QueryBackupStatus(identity, serverInfos, nil)
+	if err != nil {
+		t.Fatalf("QueryBackupStatus() error: %v", err)
+	}
+	return status.Servers[0].MaxGuesses
+}
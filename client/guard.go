@@ -0,0 +1,34 @@
+package client
+
+// RecoverOptions are the options of RecoverEncryptionKeyGuarded
+type RecoverOptions struct {
+	Options
+
+	// ConfirmGuessConsumption must be set for a real recovery attempt, which
+	// spends one guess on each server it asks, whether or not the password is
+	// correct. Without it, recovery stops just before the first guess.
+	ConfirmGuessConsumption bool
+}
+
+// RecoverEncryptionKeyGuarded is like RecoverEncryptionKeyWithOpts, but only
+// spends guesses if opts.ConfirmGuessConsumption is set. It guards against user
+// interfaces that call recovery merely to validate a password field and so burn
+// through the guess budget.
+//
+// Without confirmation it runs every step that spends no guess: validating the
+// inputs, deriving the PIN, contacting the servers and reading their guess
+// counters with ListBackups. It then fails with ErrGuessNotConfirmed, reporting
+// in SharesCollected how many servers hold the backup and in GuessCounts their
+// counters. It cannot tell whether the password is correct: servers count a
+// guess when they evaluate the blinded point, in the same RecoverSecret call
+// that answers it, so no evaluation is possible without spending a guess.
+func RecoverEncryptionKeyGuarded(identity *Identity, password string, serverInfos []ServerInfo, threshold int, authCodes *AuthCodes, opts *RecoverOptions) *RecoverEncryptionKeyResult {
+	guarded := Options{}
+	confirmed := false
+	if opts != nil {
+		guarded = opts.Options
+		confirmed = opts.ConfirmGuessConsumption
+	}
+	guarded.unconfirmed = !confirmed
+	return RecoverEncryptionKeyWithOpts(identity, password, serverInfos, threshold, authCodes, &guarded)
+}
@@ -0,0 +1,60 @@
+package client
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRecoverEncryptionKeyGuarded(t *testing.T) {
+	servers, serverInfos := newTestServers(t, 3)
+	identity := &Identity{UID: "user", DID: "app", BID: "guarded"}
+
+	generated := GenerateEncryptionKey(identity, "password", 10, 0, serverInfos)
+	if generated.Error != "" {
+		t.Fatalf("Key generation failed: %s", generated.Error)
+	}
+
+	tests := []struct {
+		name      string
+		opts      *RecoverOptions
+		password  string
+		wantCode  ErrCode
+		wantSpent int // Guess counter afterwards of the threshold servers asked
+	}{
+		{"nil options", nil, "password", ErrGuessNotConfirmed, 0},
+		{"unconfirmed", &RecoverOptions{}, "password", ErrGuessNotConfirmed, 0},
+		{"unconfirmed wrong password", &RecoverOptions{}, "wrong", ErrGuessNotConfirmed, 0},
+		{"confirmed", &RecoverOptions{ConfirmGuessConsumption: true}, "password", ErrNone, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := RecoverEncryptionKeyGuarded(identity, tt.password, serverInfos, generated.Threshold, generated.AuthCodes, tt.opts)
+			if result.ErrCode != tt.wantCode {
+				t.Fatalf("ErrCode = %q, want %q (error: %s)", result.ErrCode, tt.wantCode, result.Error)
+			}
+			for i, server := range servers {
+				want := tt.wantSpent
+				if i >= generated.Threshold {
+					want = 0
+				}
+				if spent := server.NumGuesses(identity.UID, identity.DID, identity.BID); spent != want {
+					t.Errorf("Server %d spent %d guesses, want %d", i, spent, want)
+				}
+			}
+
+			if tt.wantCode == ErrNone {
+				if !bytes.Equal(result.EncryptionKey, generated.EncryptionKey) {
+					t.Error("Recovered key does not match")
+				}
+				return
+			}
+			if result.EncryptionKey != nil {
+				t.Error("Unconfirmed recovery returned a key")
+			}
+			if result.SharesCollected != 3 || result.SharesNeeded != generated.Threshold {
+				t.Errorf("Shares = %d of %d, want 3 of %d", result.SharesCollected, result.SharesNeeded, generated.Threshold)
+			}
+		})
+	}
+}
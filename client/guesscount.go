@@ -0,0 +1,62 @@
+package client
+
+import "sort"
+
+// GuessCounterAnomaly reports a server whose guess counter for a backup moved
+// backwards since it was last recorded. Counters only grow while a backup exists;
+// a smaller count means the server lost or reset the counter, which a malicious
+// server would do to allow unlimited password guesses.
+type GuessCounterAnomaly struct {
+	URL      string
+	Expected int // Counter recorded after the previous operation
+	Reported int // Counter the server reports now
+}
+
+// guessCounterAnomalies compares the counters reported by the servers with the
+// expected ones, ignoring servers without an expectation or a report
+func guessCounterAnomalies(expected, reported map[string]int) []GuessCounterAnomaly {
+	var anomalies []GuessCounterAnomaly
+	for url, count := range reported {
+		if want, ok := expected[url]; ok && count < want {
+			anomalies = append(anomalies, GuessCounterAnomaly{URL: url, Expected: want, Reported: count})
+		}
+	}
+	sort.Slice(anomalies, func(i, j int) bool { return anomalies[i].URL < anomalies[j].URL })
+	return anomalies
+}
+
+// RecordGuessCounts stores the guess counters observed by a recovery attempt in m,
+// successful or not, so that the next recovery through RecoveryOptions can detect
+// counters that moved backwards. Servers that did not report a counter keep their
+// previous value.
+func (m *Metadata) RecordGuessCounts(result *RecoverEncryptionKeyResult) {
+	if result == nil || len(result.GuessCounts) == 0 {
+		return
+	}
+	if m.GuessCounts == nil {
+		m.GuessCounts = make(map[string]int, len(result.GuessCounts))
+	}
+	for url, count := range result.GuessCounts {
+		m.GuessCounts[url] = count
+	}
+}
+
+// RecordRefresh stores the guess counters of status, as returned by
+// RefreshGuesses, in m. The counters were reset on purpose, so they replace the
+// recorded ones even where they are smaller, which recovery would otherwise
+// report as a GuessCounterAnomaly. Servers that did not report the backup keep
+// their previous value.
+func (m *Metadata) RecordRefresh(status *BackupStatus) {
+	if status == nil {
+		return
+	}
+	for _, server := range status.Servers {
+		if !server.Found {
+			continue
+		}
+		if m.GuessCounts == nil {
+			m.GuessCounts = make(map[string]int, len(status.Servers))
+		}
+		m.GuessCounts[server.URL] = server.NumGuesses
+	}
+}
@@ -0,0 +1,77 @@
+package client
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestGuessCounterAnomaly(t *testing.T) {
+	servers, serverInfos := newTestServers(t, 3)
+	identity := &Identity{UID: "user", DID: "laptop", BID: "counters"}
+
+	generated := GenerateEncryptionKey(identity, "password", 10, 0, serverInfos)
+	if generated.Error != "" {
+		t.Fatalf("Key generation failed: %s", generated.Error)
+	}
+	metadata, err := MetadataFromResult(identity, generated)
+	if err != nil {
+		t.Fatalf("MetadataFromResult() error: %v", err)
+	}
+
+	recover := func() *RecoverEncryptionKeyResult {
+		t.Helper()
+		opts, err := metadata.RecoveryOptions(nil)
+		if err != nil {
+			t.Fatalf("RecoveryOptions() error: %v", err)
+		}
+		result := RecoverEncryptionKeyWithOpts(identity, "password", MetadataToServerInfo(metadata), metadata.Threshold, metadata.AuthCodes, opts)
+		if result.Error != "" {
+			t.Fatalf("Recovery failed: %s", result.Error)
+		}
+		metadata.RecordGuessCounts(result)
+
+		// The counters survive a metadata round trip
+		encoded, _ := json.Marshal(metadata)
+		metadata = &Metadata{}
+		if err := json.Unmarshal(encoded, metadata); err != nil {
+			t.Fatalf("Unmarshal() error: %v", err)
+		}
+		return result
+	}
+
+	for i := 1; i <= 2; i++ {
+		result := recover()
+		if len(result.GuessCounterAnomalies) != 0 {
+			t.Fatalf("Recovery %d reported anomalies: %+v", i, result.GuessCounterAnomalies)
+		}
+		for j, server := range serverInfos {
+			want := i
+			if j >= generated.Threshold {
+				want = 0 // Not asked once the threshold was met
+			}
+			if metadata.GuessCounts[server.URL] != want {
+				t.Errorf("Recovery %d: recorded counter of %s = %d, want %d", i, server.URL, metadata.GuessCounts[server.URL], want)
+			}
+		}
+	}
+
+	// A malicious server resets its counter to allow unlimited guesses
+	if !servers[1].SetNumGuesses(identity.UID, identity.DID, identity.BID, 0) {
+		t.Fatal("Server does not hold the backup")
+	}
+
+	result := recover()
+	if len(result.GuessCounterAnomalies) != 1 {
+		t.Fatalf("GuessCounterAnomalies = %+v, want one anomaly", result.GuessCounterAnomalies)
+	}
+	want := GuessCounterAnomaly{URL: serverInfos[1].URL, Expected: 2, Reported: 0}
+	if got := result.GuessCounterAnomalies[0]; got != want {
+		t.Errorf("GuessCounterAnomalies[0] = %+v, want %+v", got, want)
+	}
+
+	// Without recorded counters there is nothing to compare against
+	plain := RecoverEncryptionKeyWithServerInfo(identity, "password", serverInfos, generated.Threshold, generated.AuthCodes)
+	if plain.Error != "" || len(plain.GuessCounterAnomalies) != 0 {
+		t.Errorf("Recovery without expected counters: error %q, anomalies %+v", plain.Error, plain.GuessCounterAnomalies)
+	}
+}
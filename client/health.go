@@ -0,0 +1,151 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// healthLatencyWeight is the weight of the newest request in the moving average
+// of ServerHealth.Latency
+const healthLatencyWeight = 0.2
+
+// ServerHealth summarizes the requests made to one server
+type ServerHealth struct {
+	Successes int // Requests the server answered, including with an error of its own
+	Failures  int // Requests that failed because of the server or the network
+
+	// ConsecutiveFailures counts the failures since the last success
+	ConsecutiveFailures int
+
+	// Latency is a moving average of the duration of answered requests
+	Latency time.Duration
+
+	// LastFailure is when the latest failure was recorded, zero if none was
+	LastFailure time.Time
+}
+
+// Score rates the health of the server between 0 and 1, higher being healthier:
+// the share of answered requests, smoothed so that a server without history
+// scores 0.5 and a single request does not decide, and halved for every failure
+// since the last success, so that a server that went down falls behind quickly
+// and recovers as quickly once it answers again.
+func (h ServerHealth) Score() float64 {
+	score := float64(h.Successes+1) / float64(h.Successes+h.Failures+2)
+	for i := 0; i < min(h.ConsecutiveFailures, 16); i++ {
+		score /= 2
+	}
+	return score
+}
+
+// ServerHealthStore remembers how servers have behaved, so that SelectServers
+// with SelectHealthiest can steer away from flaky ones. Set Options.HealthStore
+// to have every request recorded. Implementations must be safe for concurrent
+// use; persisting the records across runs is up to them, MemoryHealthStore keeps
+// them in memory only.
+type ServerHealthStore interface {
+	// Get returns the health recorded for a server, and false if nothing was
+	// recorded
+	Get(server string) (ServerHealth, bool)
+
+	// Record adds the outcome of one request to a server: whether the server
+	// answered, and how long the request took
+	Record(server string, answered bool, latency time.Duration)
+}
+
+// MemoryHealthStore is a ServerHealthStore that keeps its records in memory.
+// Servers are identified by their normalized URL. The zero value is ready to use.
+type MemoryHealthStore struct {
+	mu      sync.Mutex
+	servers map[string]ServerHealth
+}
+
+// NewMemoryHealthStore returns an empty MemoryHealthStore
+func NewMemoryHealthStore() *MemoryHealthStore {
+	return &MemoryHealthStore{}
+}
+
+// Get implements ServerHealthStore
+func (s *MemoryHealthStore) Get(server string) (ServerHealth, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	health, ok := s.servers[normalizeServerURL(server)]
+	return health, ok
+}
+
+// Record implements ServerHealthStore
+func (s *MemoryHealthStore) Record(server string, answered bool, latency time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.servers == nil {
+		s.servers = make(map[string]ServerHealth)
+	}
+	url := normalizeServerURL(server)
+	health := s.servers[url]
+	if answered {
+		health.Successes++
+		health.ConsecutiveFailures = 0
+		if health.Latency == 0 {
+			health.Latency = latency
+		} else {
+			health.Latency += time.Duration(healthLatencyWeight * float64(latency-health.Latency))
+		}
+	} else {
+		health.Failures++
+		health.ConsecutiveFailures++
+		health.LastFailure = time.Now()
+	}
+	s.servers[url] = health
+}
+
+// serverAnswered reports whether a request that returned err reached a working
+// server: it succeeded, or the server rejected it with an error of its own, such
+// as a wrong auth code. Network errors, HTTP error statuses and failed handshakes
+// count against the server; requests the caller cancelled count neither way.
+func serverAnswered(err error) (answered, counts bool) {
+	if err == nil {
+		return true, true
+	}
+	if errors.Is(err, context.Canceled) {
+		return false, false
+	}
+	var statusErr *statusError
+	if isTransientError(err) || errors.As(err, &statusErr) ||
+		errors.Is(err, ErrPublicKeyMismatch) || errors.Is(err, ErrReusedEphemeral) {
+		return false, true
+	}
+	return true, true
+}
+
+// selectHealthiest returns the count servers with the best health in store;
+// servers without a record score as ServerHealth{} does, and ties go to the lower
+// latency, then to the order of servers
+func selectHealthiest(servers []ServerInfo, count int, store ServerHealthStore) []ServerInfo {
+	if store == nil {
+		return servers[:count]
+	}
+	health := make([]ServerHealth, len(servers))
+	for i, server := range servers {
+		health[i], _ = store.Get(server.URL)
+	}
+
+	order := make([]int, len(servers))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		ha, hb := health[order[a]], health[order[b]]
+		if sa, sb := ha.Score(), hb.Score(); sa != sb {
+			return sa > sb
+		}
+		return ha.Latency < hb.Latency
+	})
+
+	selected := make([]ServerInfo, count)
+	for i, j := range order[:count] {
+		selected[i] = servers[j]
+	}
+	return selected
+}
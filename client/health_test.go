@@ -0,0 +1,110 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/openadp/ocrypt/testserver"
+)
+
+func TestServerHealthScore(t *testing.T) {
+	tests := []struct {
+		name   string
+		better ServerHealth
+		worse  ServerHealth
+	}{
+		{"answered beats unknown", ServerHealth{Successes: 3}, ServerHealth{}},
+		{"unknown beats failed", ServerHealth{}, ServerHealth{Failures: 1, ConsecutiveFailures: 1}},
+		{"recent failures weigh more", ServerHealth{Successes: 5, Failures: 3}, ServerHealth{Successes: 7, Failures: 1, ConsecutiveFailures: 1}},
+		{"more history", ServerHealth{Successes: 20, Failures: 1}, ServerHealth{Successes: 2, Failures: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.better.Score() <= tt.worse.Score() {
+				t.Errorf("Score() %v = %f, not above %v = %f", tt.better, tt.better.Score(), tt.worse, tt.worse.Score())
+			}
+		})
+	}
+	if score := (ServerHealth{Failures: 100, ConsecutiveFailures: 100}).Score(); score <= 0 {
+		t.Errorf("Score() of a failing server = %f, want above 0", score)
+	}
+}
+
+func TestHealthStoreDeprioritizesFailingServer(t *testing.T) {
+	servers, serverInfos := newTestServers(t, 4)
+	servers[1].SetFault("Echo", testserver.FaultUnavailable)
+
+	store := NewMemoryHealthStore()
+	opts := &Options{HealthStore: store}
+	for round := 0; round < 3; round++ {
+		for _, server := range servers {
+			opts.newClient(context.Background(), server.URL, nil).Ping()
+		}
+	}
+
+	failing, ok := store.Get(serverInfos[1].URL)
+	if !ok || failing.Failures != 3 || failing.ConsecutiveFailures != 3 || failing.Successes != 0 {
+		t.Errorf("Health of the failing server = %+v, want 3 consecutive failures", failing)
+	}
+	healthy, ok := store.Get(serverInfos[0].URL)
+	if !ok || healthy.Successes != 3 || healthy.Failures != 0 || healthy.Latency <= 0 {
+		t.Errorf("Health of a healthy server = %+v, want 3 successes with a latency", healthy)
+	}
+
+	// Whatever the random order, the failing server is left out
+	for seed := byte(0); seed < 20; seed++ {
+		selected, err := SelectServers(serverInfos, 3, SelectOptions{Strategy: SelectHealthiest, Health: store, Rand: seededReader(seed)})
+		if err != nil {
+			t.Fatalf("SelectServers() error: %v", err)
+		}
+		for _, server := range selected {
+			if server.URL == serverInfos[1].URL {
+				t.Fatalf("SelectServers() = %v, picked the failing server", selected)
+			}
+		}
+	}
+
+	// A server that answers again catches up
+	servers[1].SetFault("Echo", testserver.FaultNone)
+	for i := 0; i < 20; i++ {
+		opts.newClient(context.Background(), servers[1].URL, nil).Ping()
+	}
+	selected, err := SelectServers(serverInfos, 1, SelectOptions{Strategy: SelectHealthiest, Health: store, Rand: seededReader(1)})
+	if err != nil {
+		t.Fatalf("SelectServers() error: %v", err)
+	}
+	if selected[0].URL != serverInfos[1].URL {
+		t.Errorf("SelectServers() = %v, want the server with the longest record of answers", selected)
+	}
+}
+
+func TestHealthStoreServerErrorsCountAsAnswered(t *testing.T) {
+	server := testserver.New()
+	defer server.Close()
+
+	store := NewMemoryHealthStore()
+	client := (&Options{HealthStore: store}).newClient(context.Background(), server.URL, server.PublicKey)
+
+	// A rejected recovery is the server working as intended
+	if _, err := client.RecoverSecret("wrong", "uid", "did", "bid", "", 0, true, nil); err == nil {
+		t.Fatal("RecoverSecret() of a missing backup succeeded")
+	}
+	if health, _ := store.Get(server.URL); health.Successes != 1 || health.Failures != 0 {
+		t.Errorf("Health after a server error = %+v, want one success", health)
+	}
+
+	// A cancelled request counts neither way
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	client.WithContext(ctx).Ping()
+	if health, _ := store.Get(server.URL); health.Successes != 1 || health.Failures != 0 {
+		t.Errorf("Health after a cancelled request = %+v, want it unchanged", health)
+	}
+
+	var zero MemoryHealthStore
+	zero.Record("https://a.example/", false, time.Second)
+	if health, ok := zero.Get("HTTPS://a.example"); !ok || health.Failures != 1 {
+		t.Errorf("Get() = %+v, %v from the zero store, want the failure recorded under the normalized URL", health, ok)
+	}
+}
@@ -0,0 +1,63 @@
+package client
+
+import (
+	"encoding/hex"
+	"io"
+)
+
+// CapabilityIdempotentRegister is listed in the "capabilities" of GetServerInfo by
+// servers that deduplicate RegisterSecret requests carrying the same idempotency
+// key: a repeated request is acknowledged without storing the share again, so the
+// guess counter of the backup is not reset.
+const CapabilityIdempotentRegister = "idempotent_register"
+
+// serverCapabilities returns the "capabilities" list of a GetServerInfo response
+func serverCapabilities(info map[string]interface{}) []string {
+	list, _ := info["capabilities"].([]interface{})
+	var capabilities []string
+	for _, item := range list {
+		if capability, ok := item.(string); ok {
+			capabilities = append(capabilities, capability)
+		}
+	}
+	return capabilities
+}
+
+// hasCapability reports whether capabilities includes capability
+func hasCapability(capabilities []string, capability string) bool {
+	for _, c := range capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// newIdempotencyKey returns a random token identifying the registrations of one
+// key generation
+func newIdempotencyKey(random io.Reader) (string, error) {
+	token := make([]byte, 16)
+	if _, err := io.ReadFull(random, token); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(token), nil
+}
+
+// registerSecretIdempotent registers a share like RegisterSecret. If the server
+// advertises CapabilityIdempotentRegister, the request carries idempotencyKey and
+// transient failures, such as a response lost after the server stored the share,
+// are retried with the same key, using DefaultRetryPolicy if the client has no
+// RetryPolicy. Older servers receive the plain request and are retried only
+// according to the client's RetryPolicy.
+func (c *EncryptedOpenADPClient) registerSecretIdempotent(idempotencyKey, authCode, uid, did, bid string, version, x int, y string, maxGuesses, expiration int, encrypted bool) (bool, error) {
+	if idempotencyKey == "" || !c.Capabilities().Idempotent {
+		return c.RegisterSecret(authCode, uid, did, bid, version, x, y, maxGuesses, expiration, encrypted, nil)
+	}
+
+	retrying := *c
+	if retrying.RetryPolicy == nil {
+		retrying.RetryPolicy = &DefaultRetryPolicy
+	}
+	params := registerSecretParams{AuthCode: authCode, UID: uid, DID: did, BID: bid, Version: version, X: x, Y: y, MaxGuesses: maxGuesses, Expiration: expiration, IdempotencyKey: idempotencyKey}
+	return retrying.registerSecret(params, encrypted, nil)
+}
@@ -0,0 +1,94 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/openadp/ocrypt/testserver"
+)
+
+func TestIdempotentRegistrationSurvivesLostResponse(t *testing.T) {
+	servers, serverInfos := newTestServers(t, 3)
+	for _, server := range servers {
+		server.SetCapabilities(CapabilityIdempotentRegister)
+	}
+	// The first server stores the share but the response never arrives
+	servers[0].SetFaultOnce("RegisterSecret", testserver.FaultDropConnection)
+
+	identity := &Identity{UID: "user", DID: "laptop", BID: "idempotent"}
+	generated := GenerateEncryptionKeyWithOpts(identity, "password", 10, 0, serverInfos, &Options{Threshold: 3})
+	if generated.Error != "" {
+		t.Fatalf("Key generation failed: %s", generated.Error)
+	}
+	if generated.IdempotencyKey == "" {
+		t.Error("Result has no idempotency key")
+	}
+	metadata, _ := MetadataFromResult(identity, generated)
+	if metadata.IdempotencyKey != generated.IdempotencyKey {
+		t.Errorf("Metadata idempotency key = %q, want %q", metadata.IdempotencyKey, generated.IdempotencyKey)
+	}
+
+	recovered := RecoverEncryptionKeyWithServerInfo(identity, "password", serverInfos, generated.Threshold, generated.AuthCodes)
+	if recovered.Error != "" || !bytes.Equal(recovered.EncryptionKey, generated.EncryptionKey) {
+		t.Fatalf("Recovery failed: %s", recovered.Error)
+	}
+
+	for _, probe := range ProbeServers(context.Background(), serverInfos) {
+		if !probe.Idempotent {
+			t.Errorf("Probe of %s does not report idempotent registration", probe.Server.URL)
+		}
+	}
+}
+
+func TestIdempotentRegistrationKeepsGuessCounter(t *testing.T) {
+	servers, serverInfos := newTestServers(t, 2)
+	servers[0].SetCapabilities(CapabilityIdempotentRegister)
+	identity := &Identity{UID: "user", DID: "laptop", BID: "counter"}
+	y := "AQAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="
+
+	tests := []struct {
+		name      string
+		server    int
+		key       string
+		wantCount int
+	}{
+		{"same key", 0, "first", 3},
+		{"new key", 0, "second", 0},
+		{"server without the capability", 1, "first", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := (*Options)(nil).newClient(context.Background(), serverInfos[tt.server].URL, nil)
+			if _, err := client.registerSecretIdempotent("first", "auth", identity.UID, identity.DID, identity.BID, 1, 1, y, 10, 0, false); err != nil {
+				t.Fatalf("First registration failed: %v", err)
+			}
+			servers[tt.server].SetNumGuesses(identity.UID, identity.DID, identity.BID, 3)
+
+			if _, err := client.registerSecretIdempotent(tt.key, "auth", identity.UID, identity.DID, identity.BID, 1, 1, y, 10, 0, false); err != nil {
+				t.Fatalf("Second registration failed: %v", err)
+			}
+			if got := servers[tt.server].NumGuesses(identity.UID, identity.DID, identity.BID); got != tt.wantCount {
+				t.Errorf("Guess counter after the second registration = %d, want %d", got, tt.wantCount)
+			}
+		})
+	}
+}
+
+func TestRegistrationWithoutIdempotencyIsNotRetried(t *testing.T) {
+	servers, serverInfos := newTestServers(t, 3)
+	servers[0].SetFaultOnce("RegisterSecret", testserver.FaultDropConnection)
+
+	identity := &Identity{UID: "user", DID: "laptop", BID: "legacy"}
+	generated := GenerateEncryptionKeyWithOpts(identity, "password", 10, 0, serverInfos, nil)
+	if generated.Error != "" {
+		t.Fatalf("Key generation failed: %s", generated.Error)
+	}
+	if generated.ServerResults[0].Success {
+		t.Error("Registration with a lost response succeeded on a server without idempotency")
+	}
+	if !generated.ServerResults[1].Success || !generated.ServerResults[2].Success {
+		t.Errorf("Registration failed on healthy servers: %+v", generated.ServerResults)
+	}
+}
@@ -0,0 +1,154 @@
+package client
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// MaxIdentityFieldLength is the longest UID, DID or BID, in bytes, that Validate accepts
+const MaxIdentityFieldLength = 512
+
+// IdentityError reports which field of an Identity is invalid
+type IdentityError struct {
+	Field  string // "UID", "DID" or "BID", or "Identity" for a nil identity
+	Reason string // For example "cannot be empty"
+}
+
+func (e *IdentityError) Error() string {
+	return e.Field + " " + e.Reason
+}
+
+// Validate checks that every field of the identity is non-empty valid UTF-8 of at
+// most MaxIdentityFieldLength bytes without control characters, and returns an
+// *IdentityError for the first field that is not. It is called by key generation
+// and recovery, so invalid identities fail before any server is contacted.
+//
+// Validate does not reject surrounding whitespace, since backups may already
+// exist under such identities; call Normalize before registering new backups.
+func (id *Identity) Validate() error {
+	if id == nil {
+		return &IdentityError{Field: "Identity", Reason: "cannot be nil"}
+	}
+
+	fields := []struct {
+		name  string
+		value string
+	}{
+		{"UID", id.UID},
+		{"DID", id.DID},
+		{"BID", id.BID},
+	}
+	for _, field := range fields {
+		if reason := validateIdentityField(field.value); reason != "" {
+			return &IdentityError{Field: field.name, Reason: reason}
+		}
+	}
+	return nil
+}
+
+// validateIdentityField returns why value is not a valid identity field, or ""
+func validateIdentityField(value string) string {
+	switch {
+	case value == "":
+		return "cannot be empty"
+	case len(value) > MaxIdentityFieldLength:
+		return fmt.Sprintf("is %d bytes long, the maximum is %d", len(value), MaxIdentityFieldLength)
+	case !utf8.ValidString(value):
+		return "is not valid UTF-8"
+	case strings.IndexFunc(value, unicode.IsControl) >= 0:
+		return "contains control characters"
+	}
+	return ""
+}
+
+// Normalize trims surrounding whitespace from every field and, if lowercaseDID is
+// set, lowercases the DID, so that for example a hostname used as DID matches
+// however it was capitalized.
+//
+// Normalization changes the identity, and with it the key: apply it consistently
+// when registering and recovering, and not to identities of existing backups that
+// were registered without it.
+func (id *Identity) Normalize(lowercaseDID bool) {
+	id.UID = strings.TrimSpace(id.UID)
+	id.DID = strings.TrimSpace(id.DID)
+	id.BID = strings.TrimSpace(id.BID)
+	if lowercaseDID {
+		id.DID = strings.ToLower(id.DID)
+	}
+}
+
+// BID is the parity of a backup that rotates between two BIDs, so that a valid
+// backup exists at every point of a refresh; see AlternateBID
+type BID string
+
+// Backup parities
+const (
+	BIDEven BID = "even"
+	BIDOdd  BID = "odd"
+)
+
+// Flip returns the other parity, the BID the backup rotates to. Other values are
+// returned unchanged.
+func (b BID) Flip() BID {
+	if next, ok := AlternateBID(string(b)); ok {
+		return BID(next)
+	}
+	return b
+}
+
+// uidHashPrefix separates hashed UIDs from other uses of SHA-256
+const uidHashPrefix = "OpenADP-UID-v1:"
+
+// NewIdentity builds the identity of the backup with parity parity for a user of
+// an application. The user and application IDs are canonicalized, trimmed of
+// surrounding whitespace and normalized to NFC, and the application ID, used as
+// DID, is lowercased, so that the same inputs always yield the same identity and
+// so the same key. It returns an *IdentityError if an input is empty or invalid
+// after canonicalization, or if parity is neither BIDEven nor BIDOdd.
+//
+// Identities of existing backups must be built the way they were registered;
+// only use NewIdentity for backups it also created.
+func NewIdentity(userID, appID string, parity BID) (*Identity, error) {
+	return newIdentity(canonicalIdentityField(userID), appID, parity)
+}
+
+// NewIdentityHashed is like NewIdentity but uses a hash of the canonical user ID
+// as UID: the lowercase hex SHA-256 of "OpenADP-UID-v1:" followed by the user
+// ID. Servers then store no email address or other personal identifier, and
+// UIDs have a fixed length. The hashed and plain identities of a user differ, so
+// recovery must use the same choice as registration.
+func NewIdentityHashed(userID, appID string, parity BID) (*Identity, error) {
+	canonical := canonicalIdentityField(userID)
+	if reason := validateIdentityField(canonical); reason != "" {
+		return nil, &IdentityError{Field: "UID", Reason: reason}
+	}
+	sum := sha256.Sum256([]byte(uidHashPrefix + canonical))
+	return newIdentity(hex.EncodeToString(sum[:]), appID, parity)
+}
+
+// newIdentity implements NewIdentity for an already canonical UID
+func newIdentity(uid, appID string, parity BID) (*Identity, error) {
+	if parity != BIDEven && parity != BIDOdd {
+		return nil, &IdentityError{Field: "BID", Reason: fmt.Sprintf("must be %q or %q, got %q", BIDEven, BIDOdd, parity)}
+	}
+	identity := &Identity{
+		UID: uid,
+		DID: strings.ToLower(canonicalIdentityField(appID)),
+		BID: string(parity),
+	}
+	if err := identity.Validate(); err != nil {
+		return nil, err
+	}
+	return identity, nil
+}
+
+// canonicalIdentityField trims surrounding whitespace and normalizes to NFC
+func canonicalIdentityField(value string) string {
+	return norm.NFC.String(strings.TrimSpace(value))
+}
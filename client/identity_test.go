@@ -0,0 +1,168 @@
+package client
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestIdentityValidate(t *testing.T) {
+	long := strings.Repeat("x", MaxIdentityFieldLength+1)
+
+	tests := []struct {
+		name      string
+		identity  *Identity
+		wantField string
+	}{
+		{"valid", &Identity{UID: "user@example.com", DID: "laptop", BID: "file://notes.txt"}, ""},
+		{"non-ASCII", &Identity{UID: "jürgen", DID: "büro", BID: "日記"}, ""},
+		{"longest fields", &Identity{UID: long[1:], DID: long[1:], BID: long[1:]}, ""},
+		{"nil", nil, "Identity"},
+		{"empty UID", &Identity{DID: "laptop", BID: "even"}, "UID"},
+		{"empty DID", &Identity{UID: "user", BID: "even"}, "DID"},
+		{"empty BID", &Identity{UID: "user", DID: "laptop"}, "BID"},
+		{"long UID", &Identity{UID: long, DID: "laptop", BID: "even"}, "UID"},
+		{"control character in DID", &Identity{UID: "user", DID: "lap\ntop", BID: "even"}, "DID"},
+		{"NUL in BID", &Identity{UID: "user", DID: "laptop", BID: "even\x00"}, "BID"},
+		{"invalid UTF-8", &Identity{UID: "user\xff", DID: "laptop", BID: "even"}, "UID"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.identity.Validate()
+			if tt.wantField == "" {
+				if err != nil {
+					t.Errorf("Validate() error: %v", err)
+				}
+				return
+			}
+
+			var identityErr *IdentityError
+			if !errors.As(err, &identityErr) {
+				t.Fatalf("Validate() error = %v, want an *IdentityError", err)
+			}
+			if identityErr.Field != tt.wantField {
+				t.Errorf("Validate() reported field %s, want %s", identityErr.Field, tt.wantField)
+			}
+
+			// Generation and recovery reject the identity the same way
+			generated := GenerateEncryptionKey(tt.identity, "password", 10, 0, []ServerInfo{{URL: "https://unused.example.com"}})
+			recovered := RecoverEncryptionKeyWithServerInfo(tt.identity, "password", []ServerInfo{{URL: "https://unused.example.com"}}, 1, &AuthCodes{})
+			for _, result := range []struct {
+				msg  string
+				code ErrCode
+			}{{generated.Error, generated.ErrCode}, {recovered.Error, recovered.ErrCode}} {
+				if result.code != ErrInvalidIdentity || result.msg != err.Error() {
+					t.Errorf("Got %q (%s), want %q (%s)", result.code, result.msg, ErrInvalidIdentity, err.Error())
+				}
+			}
+		})
+	}
+}
+
+func TestIdentityNormalize(t *testing.T) {
+	tests := []struct {
+		name         string
+		identity     Identity
+		lowercaseDID bool
+		want         Identity
+	}{
+		{"unchanged", Identity{UID: "user", DID: "Laptop", BID: "even"}, false, Identity{UID: "user", DID: "Laptop", BID: "even"}},
+		{"trimmed", Identity{UID: " user\t", DID: "Laptop ", BID: "\neven"}, false, Identity{UID: "user", DID: "Laptop", BID: "even"}},
+		{"lowercase DID", Identity{UID: "User", DID: " Laptop.LOCAL ", BID: "Even"}, true, Identity{UID: "User", DID: "laptop.local", BID: "Even"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identity := tt.identity
+			identity.Normalize(tt.lowercaseDID)
+			if identity != tt.want {
+				t.Errorf("Normalize() = %+v, want %+v", identity, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewIdentity(t *testing.T) {
+	tests := []struct {
+		name      string
+		userID    string
+		appID     string
+		parity    BID
+		want      Identity
+		wantField string
+	}{
+		{"plain", "alice@example.com", "notes", BIDEven, Identity{UID: "alice@example.com", DID: "notes", BID: "even"}, ""},
+		{"trimmed", "  alice@example.com\t", " notes\n", BIDOdd, Identity{UID: "alice@example.com", DID: "notes", BID: "odd"}, ""},
+		{"lowercase DID only", "Alice", "Notes.Example.COM", BIDEven, Identity{UID: "Alice", DID: "notes.example.com", BID: "even"}, ""},
+		{"NFC", "jo\u0308rg", "bu\u0308ro", BIDEven, Identity{UID: "jörg", DID: "büro", BID: "even"}, ""},
+		{"blank user", "   ", "notes", BIDEven, Identity{}, "UID"},
+		{"blank app", "alice", "", BIDEven, Identity{}, "DID"},
+		{"control character", "alice", "no\x00tes", BIDEven, Identity{}, "DID"},
+		{"invalid parity", "alice", "notes", BID("file://notes.txt"), Identity{}, "BID"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identity, err := NewIdentity(tt.userID, tt.appID, tt.parity)
+			if tt.wantField != "" {
+				var identityErr *IdentityError
+				if !errors.As(err, &identityErr) || identityErr.Field != tt.wantField {
+					t.Fatalf("NewIdentity() error = %v, want an IdentityError for %s", err, tt.wantField)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewIdentity() error: %v", err)
+			}
+			if *identity != tt.want {
+				t.Errorf("NewIdentity() = %+v, want %+v", *identity, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewIdentityHashed(t *testing.T) {
+	identity, err := NewIdentityHashed("alice@example.com", "Notes", BIDEven)
+	if err != nil {
+		t.Fatalf("NewIdentityHashed() error: %v", err)
+	}
+	// hex(SHA-256("OpenADP-UID-v1:alice@example.com"))
+	want := Identity{UID: "ab083e000735be5ac5752129eab4e3fbcf6cee431a0fc0c6c258aa9738d3af4d", DID: "notes", BID: "even"}
+	if *identity != want {
+		t.Errorf("NewIdentityHashed() = %+v, want %+v", *identity, want)
+	}
+
+	// Canonically equal inputs hash to the same UID
+	again, _ := NewIdentityHashed("  alice@example.com ", "notes", BIDOdd)
+	if again.UID != identity.UID {
+		t.Error("Hashed UID depends on surrounding whitespace")
+	}
+	plain, _ := NewIdentity("alice@example.com", "notes", BIDEven)
+	if plain.UID == identity.UID {
+		t.Error("Hashed and plain UIDs are equal")
+	}
+	if _, err := NewIdentityHashed(" ", "notes", BIDEven); err == nil {
+		t.Error("NewIdentityHashed() accepted a blank user ID")
+	}
+}
+
+func TestBIDFlip(t *testing.T) {
+	tests := []struct {
+		bid  BID
+		want BID
+	}{
+		{BIDEven, BIDOdd},
+		{BIDOdd, BIDEven},
+		{BID("file://notes.txt"), BID("file://notes.txt")},
+	}
+
+	for _, tt := range tests {
+		if got := tt.bid.Flip(); got != tt.want {
+			t.Errorf("%q.Flip() = %q, want %q", tt.bid, got, tt.want)
+		}
+	}
+	if BIDEven.Flip().Flip() != BIDEven {
+		t.Error("Flipping twice does not restore the parity")
+	}
+}
@@ -183,7 +183,7 @@ func (c *OpenADPClient) makeRequest(method string, params interface{}) (*JSONRPC
 		if debug.IsDebugModeEnabled() {
 			debug.DebugLog(fmt.Sprintf("JSON-RPC error: %d - %s", response.Error.Code, response.Error.Message))
 		}
-		return nil, fmt.Errorf("JSON-RPC error %d: %s", response.Error.Code, response.Error.Message)
+		return nil, jsonRPCError("JSON-RPC error", response.Error)
 	}
 
 	if debug.IsDebugModeEnabled() {
@@ -194,40 +194,20 @@ func (c *OpenADPClient) makeRequest(method string, params interface{}) (*JSONRPC
 
 // ListBackups lists all backups for a user
 func (c *OpenADPClient) ListBackups(uid string) ([]ListBackupsResult, error) {
-	// Server expects: [uid] (1 parameter)
-	params := []interface{}{uid}
-
-	response, err := c.makeRequest("ListBackups", params)
-	if err != nil {
+	var backups []ListBackupsResult
+	if err := c.call("ListBackups", listBackupsParams{UID: uid}, &backups); err != nil {
 		return nil, err
 	}
-
-	// Parse the result
-	resultData, err := json.Marshal(response.Result)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal result: %v", err)
-	}
-
-	var result []ListBackupsResult
-	if err := json.Unmarshal(resultData, &result); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal result: %v", err)
-	}
-
-	return result, nil
+	return backups, nil
 }
 
 // Echo tests connectivity to the server
 func (c *OpenADPClient) Echo(message string) (string, error) {
-	response, err := c.makeRequest("Echo", []interface{}{message})
-	if err != nil {
+	var result string
+	if err := c.call("Echo", echoParams{Message: message}, &result); err != nil {
 		return "", err
 	}
 
-	result, ok := response.Result.(string)
-	if !ok {
-		return "", fmt.Errorf("unexpected response type: %T", response.Result)
-	}
-
 	if result != message {
 		return "", fmt.Errorf("unexpected echo response: got %q, want %q", result, message)
 	}
@@ -0,0 +1,144 @@
+package client
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"sort"
+	"sync"
+	"time"
+)
+
+// KeyCache keeps recovered keys in memory for a limited time, so that an app
+// decrypting many files of one backup in a session recovers the key once instead
+// of contacting the servers, and spending a guess, for every file. Set it in
+// Options.KeyCache; caching is off by default.
+//
+// Entries are keyed by an HMAC, under a random per-cache secret, of the backup's
+// metadata (identity, servers, threshold and auth codes) and the PIN, so a cached
+// key is only returned for the same backup and password. Keys are held in buffers
+// outside the Go heap that are locked into memory where the platform allows, and
+// are zeroized when they expire or are purged. A KeyCache is safe for concurrent
+// use.
+type KeyCache struct {
+	ttl    time.Duration
+	secret [sha256.Size]byte
+
+	mu      sync.Mutex
+	entries map[[sha256.Size]byte]*keyCacheEntry
+	now     func() time.Time // Replaced in tests
+}
+
+// keyCacheEntry is a cached key and its expiry
+type keyCacheEntry struct {
+	key     *lockedBuffer
+	expires time.Time
+}
+
+// NewKeyCache returns an empty cache whose entries expire ttl after they were
+// recovered
+func NewKeyCache(ttl time.Duration) *KeyCache {
+	c := &KeyCache{
+		ttl:     ttl,
+		entries: make(map[[sha256.Size]byte]*keyCacheEntry),
+		now:     time.Now,
+	}
+	if _, err := rand.Read(c.secret[:]); err != nil {
+		panic("crypto/rand failed: " + err.Error())
+	}
+	return c
+}
+
+// Purge zeroizes and removes every cached key
+func (c *KeyCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for fingerprint, entry := range c.entries {
+		entry.key.free()
+		delete(c.entries, fingerprint)
+	}
+}
+
+// Len returns the number of keys in the cache that have not expired
+func (c *KeyCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.expire()
+	return len(c.entries)
+}
+
+// get returns a copy of the key cached under fingerprint, or nil
+func (c *KeyCache) get(fingerprint [sha256.Size]byte) []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.expire()
+	entry, ok := c.entries[fingerprint]
+	if !ok {
+		return nil
+	}
+	return append([]byte(nil), entry.key.bytes()...)
+}
+
+// put caches a copy of key under fingerprint
+func (c *KeyCache) put(fingerprint [sha256.Size]byte, key []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if old, ok := c.entries[fingerprint]; ok {
+		old.key.free()
+	}
+	buffer := newLockedBuffer(len(key))
+	copy(buffer.bytes(), key)
+	c.entries[fingerprint] = &keyCacheEntry{key: buffer, expires: c.now().Add(c.ttl)}
+}
+
+// expire frees the entries whose TTL has passed; c.mu must be held
+func (c *KeyCache) expire() {
+	now := c.now()
+	for fingerprint, entry := range c.entries {
+		if !now.Before(entry.expires) {
+			entry.key.free()
+			delete(c.entries, fingerprint)
+		}
+	}
+}
+
+// fingerprint identifies a recovery of a keyLength-byte key for identity with pin
+// under the hash-to-curve tag dst from the given servers
+func (c *KeyCache) fingerprint(identity *Identity, pin []byte, serverInfos []ServerInfo, threshold int, authCodes *AuthCodes, keyLength int, dst string) [sha256.Size]byte {
+	mac := hmac.New(sha256.New, c.secret[:])
+	field := func(b []byte) {
+		var length [4]byte
+		binary.BigEndian.PutUint32(length[:], uint32(len(b)))
+		mac.Write(length[:])
+		mac.Write(b)
+	}
+
+	field([]byte(identity.UID))
+	field([]byte(identity.DID))
+	field([]byte(identity.BID))
+	field(pin)
+	field(binary.BigEndian.AppendUint32(nil, uint32(threshold)))
+	field(binary.BigEndian.AppendUint32(nil, uint32(keyLength)))
+	field([]byte(dst))
+	field([]byte(authCodes.BaseAuthCode))
+
+	urls := make([]string, len(serverInfos))
+	for i, serverInfo := range serverInfos {
+		urls[i] = serverInfo.URL
+	}
+	sort.Strings(urls)
+	for _, url := range urls {
+		field([]byte(url))
+		code, _ := authCodes.ForServer(url)
+		field([]byte(code))
+	}
+
+	var fingerprint [sha256.Size]byte
+	mac.Sum(fingerprint[:0])
+	return fingerprint
+}
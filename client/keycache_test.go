@@ -0,0 +1,106 @@
+package client
+
+import (
+	"bytes"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestKeyCache(t *testing.T) {
+	servers, serverInfos := newTestServers(t, 3)
+	identity := &Identity{UID: "user", DID: "laptop", BID: "cached"}
+
+	generated := GenerateEncryptionKey(identity, "password", 10, 0, serverInfos)
+	if generated.Error != "" {
+		t.Fatalf("Key generation failed: %s", generated.Error)
+	}
+
+	var requests atomic.Int32
+	cache := NewKeyCache(time.Minute)
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+	opts := &Options{
+		KeyCache: cache,
+		Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			requests.Add(1)
+			return http.DefaultTransport.RoundTrip(r)
+		}),
+	}
+
+	recover := func(password string) (*RecoverEncryptionKeyResult, int) {
+		t.Helper()
+		requests.Store(0)
+		result := RecoverEncryptionKeyWithOpts(identity, password, serverInfos, generated.Threshold, generated.AuthCodes, opts)
+		if result.Error != "" {
+			t.Fatalf("Recovery failed: %s", result.Error)
+		}
+		return result, int(requests.Load())
+	}
+	guesses := func() int {
+		return servers[0].NumGuesses(identity.UID, identity.DID, identity.BID)
+	}
+
+	first, calls := recover("password")
+	if first.Cached || calls == 0 {
+		t.Fatalf("First recovery: cached %v after %d requests, want a recovery from the servers", first.Cached, calls)
+	}
+
+	second, calls := recover("password")
+	if !second.Cached || calls != 0 {
+		t.Errorf("Second recovery: cached %v after %d requests, want a cached key and no requests", second.Cached, calls)
+	}
+	if !bytes.Equal(second.EncryptionKey, generated.EncryptionKey) {
+		t.Error("Cached key does not match")
+	}
+	second.Zeroize()
+	if third, _ := recover("password"); !bytes.Equal(third.EncryptionKey, generated.EncryptionKey) {
+		t.Error("Zeroizing a cached result damaged the cache")
+	}
+	if got := guesses(); got != 1 {
+		t.Errorf("Servers recorded %d guesses, want 1", got)
+	}
+
+	// Another password is not answered from the cache
+	if wrong, calls := recover("wrong"); wrong.Cached || calls == 0 {
+		t.Errorf("Recovery with another password: cached %v after %d requests", wrong.Cached, calls)
+	}
+
+	// Entries expire after the TTL
+	now = now.Add(time.Minute)
+	if cache.Len() != 0 {
+		t.Errorf("Len() = %d after the TTL, want 0", cache.Len())
+	}
+	if expired, calls := recover("password"); expired.Cached || calls == 0 {
+		t.Errorf("Recovery after the TTL: cached %v after %d requests", expired.Cached, calls)
+	}
+
+	// Purge empties the cache
+	if cache.Len() != 1 {
+		t.Fatalf("Len() = %d, want 1", cache.Len())
+	}
+	cache.Purge()
+	if purged, calls := recover("password"); purged.Cached || calls == 0 {
+		t.Errorf("Recovery after Purge: cached %v after %d requests", purged.Cached, calls)
+	}
+
+	// Caching is off by default
+	for i := 0; i < 2; i++ {
+		if result := RecoverEncryptionKeyWithServerInfo(identity, "password", serverInfos, generated.Threshold, generated.AuthCodes); result.Cached {
+			t.Error("Recovery without a KeyCache returned a cached key")
+		}
+	}
+}
+
+func TestLockedBuffer(t *testing.T) {
+	buffer := newLockedBuffer(32)
+	if len(buffer.bytes()) != 32 {
+		t.Fatalf("Buffer has %d bytes, want 32", len(buffer.bytes()))
+	}
+	copy(buffer.bytes(), bytes.Repeat([]byte{0xaa}, 32))
+	buffer.free()
+	if buffer.bytes() != nil {
+		t.Error("free() did not release the buffer")
+	}
+}
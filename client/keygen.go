@@ -12,18 +12,24 @@
 package client
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
 	"math"
 	"math/big"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/openadp/ocrypt/common"
 	"github.com/openadp/ocrypt/debug"
+	"golang.org/x/text/unicode/norm"
 )
 
 // SetDebugMode enables or disables debug mode for deterministic operations.
@@ -62,13 +68,58 @@ func DeriveIdentifiers(filename, userID, deviceID string) (uid, did, bid string)
 	return uid, did, bid
 }
 
+// ServerResult records the outcome of a request to a single server
+type ServerResult struct {
+	URL     string
+	Success bool
+	Latency time.Duration // Time spent on the request, zero if the server was never asked
+	Error   string
+
+	// Handshake and Exchange split Latency between the Noise-NK handshakes and
+	// the encrypted exchange; see LatencyBreakdown
+	Handshake time.Duration
+	Exchange  time.Duration
+}
+
 // GenerateEncryptionKeyResult represents the result of key generation
 type GenerateEncryptionKeyResult struct {
 	EncryptionKey []byte
 	Error         string
+	ErrCode       ErrCode    // Machine-readable classification of Error
+	Field         InputField // Argument at fault if ErrCode reports invalid input, for example FieldMaxGuesses
 	ServerURLs    []string
+	ServerInfos   []ServerInfo // The servers in ServerURLs, including their public keys
 	Threshold     int
 	AuthCodes     *AuthCodes
+	ServerResults []ServerResult // Per-server registration outcomes, in ServerURLs order
+	PinParams     *PinParams     // PIN derivation used, including any generated salt; nil for the default
+
+	// IdempotencyKey was sent with the registrations to servers that advertise
+	// CapabilityIdempotentRegister, so that retried registrations are deduplicated
+	IdempotencyKey string
+
+	// IdentityBound reports that EncryptionKey is bound to the identity; see
+	// Options.BindIdentity
+	IdentityBound bool
+
+	// HashDST is the hash-to-curve domain separation tag the key was derived
+	// under; see Options.HashDST
+	HashDST string
+
+	// Rollback reports the deletion of the shares that servers accepted when
+	// registration failed to reach the threshold; nil if none had been accepted
+	Rollback *RollbackReport
+
+	// KeyCommitment commits to EncryptionKey if Options.KeyCommitment is set; see
+	// VerifyRecoveredKey
+	KeyCommitment *KeyCommitment
+
+	// Capabilities are those shared by the servers in ServerURLs; see
+	// IntersectCapabilities
+	Capabilities Capabilities
+
+	// ProtocolVersion is the share format version the backup was registered in
+	ProtocolVersion int
 }
 
 // GenerateEncryptionKey generates an encryption key using OpenADP distributed secret sharing.
@@ -79,57 +130,245 @@ type GenerateEncryptionKeyResult struct {
 // 3. Distributes secret shares to OpenADP servers via JSON-RPC
 // 4. Uses authentication codes for secure server communication
 // 5. Uses threshold cryptography for recovery
+//
+// expiration is a Unix time in seconds, or 0 for a backup that never expires; see
+// GenerateEncryptionKeyWithExpiry.
+//
+// Deprecated: The int expiration is easily mistaken for a duration. Use
+// GenerateEncryptionKeyWithExpiry, which takes a time.Time.
 func GenerateEncryptionKey(identity *Identity, password string, maxGuesses, expiration int,
 	serverInfos []ServerInfo) *GenerateEncryptionKeyResult {
+	return GenerateEncryptionKeyWithOpts(identity, password, maxGuesses, expiration, serverInfos, nil)
+}
 
-	// Input validation
-	if identity == nil {
+// GenerateEncryptionKeyWithThreshold is like GenerateEncryptionKey but lets the caller
+// choose the recovery threshold t, with 1 <= t <= len(serverInfos).
+//
+// Every server must still be live for a share to be registered, so if fewer than t
+// servers respond or accept their share the result carries ErrThresholdNotMet.
+//
+// Deprecated: Use GenerateEncryptionKeyWithExpiry with Options.Threshold.
+func GenerateEncryptionKeyWithThreshold(identity *Identity, password string, maxGuesses, expiration, threshold int,
+	serverInfos []ServerInfo) *GenerateEncryptionKeyResult {
+	if threshold < 1 {
 		return &GenerateEncryptionKeyResult{
-			Error: "Identity cannot be nil",
+			Error:   fmt.Sprintf("Threshold must be at least 1, got %d", threshold),
+			ErrCode: ErrInvalidInput,
 		}
 	}
+	return GenerateEncryptionKeyWithOpts(identity, password, maxGuesses, expiration, serverInfos, &Options{Threshold: threshold})
+}
 
-	if identity.UID == "" {
-		return &GenerateEncryptionKeyResult{
-			Error: "UID cannot be empty",
-		}
+// GenerateEncryptionKeyWithOpts is like GenerateEncryptionKey but accepts explicit options.
+//
+// Servers are contacted concurrently using a worker pool bounded by opts.MaxWorkers.
+// A slow or unreachable server is recorded in ServerResults rather than aborting the
+// batch; the operation only fails if fewer than threshold servers accept their share.
+//
+// Deprecated: Use GenerateEncryptionKeyWithExpiry, which takes the expiration as a
+// time.Time.
+func GenerateEncryptionKeyWithOpts(identity *Identity, password string, maxGuesses, expiration int,
+	serverInfos []ServerInfo, opts *Options) *GenerateEncryptionKeyResult {
+
+	metrics := opts.metrics()
+	metrics.OperationStarted(OperationGenerate)
+	start := time.Now()
+
+	ctx, cancel := opts.overallContext()
+	defer cancel()
+	result := generateEncryptionKey(ctx, identity, password, maxGuesses, expiration, serverInfos, opts)
+	result.Error, result.ErrCode = timeoutError(ctx, result.Error, result.ErrCode)
+
+	metrics.OperationFinished(OperationGenerate, time.Since(start), result.ErrCode)
+	return result
+}
+
+// randomSecret draws the secret scalar of a new backup from random
+func randomSecret(random io.Reader) (*big.Int, error) {
+	secret, err := rand.Int(random, common.Q)
+	if err != nil {
+		return nil, err
 	}
 
-	if identity.DID == "" {
-		return &GenerateEncryptionKeyResult{
-			Error: "DID cannot be empty",
-		}
+	// Ensure secret is not zero
+	if secret.Sign() == 0 {
+		secret.SetInt64(1)
 	}
+	return secret, nil
+}
 
-	if identity.BID == "" {
-		return &GenerateEncryptionKeyResult{
-			Error: "BID cannot be empty",
+// prepareGeneration removes the duplicate entries of serverInfos and runs the
+// client-side checks of key generation on the servers that remain, noting the
+// removed duplicates in a failure about the servers or the threshold. It returns
+// the servers generation would go on to contact.
+func prepareGeneration(identity *Identity, maxGuesses, expiration int, serverInfos []ServerInfo, opts *Options) ([]ServerInfo, string, ErrCode, InputField) {
+	serverInfos, duplicates := opts.dedupeServers(serverInfos)
+	msg, code, field := validateGeneration(identity, maxGuesses, expiration, serverInfos, opts)
+	if code != ErrNone && (field == FieldServers || field == FieldThreshold) {
+		msg += duplicatesNote(duplicates)
+	}
+	return serverInfos, msg, code, field
+}
+
+// capableServers returns the indices of the live servers, with URLs urls and
+// capabilities caps, that can store a backup whose PIN is derived with kdf,
+// logging each server it leaves out. The quorum is formed from those servers,
+// so it fails if any were left out and too few remain to meet the threshold.
+func (o *Options) capableServers(urls []string, caps []Capabilities, kdf PinKDF) ([]int, string) {
+	needed := o.threshold(len(caps))
+	supported := make([]int, 0, len(caps))
+	var missing string
+	for i := range caps {
+		if reason := caps[i].unsupported(kdf, ProtocolVersion); reason != "" {
+			o.logger().Warn("server lacks capability", "server", urls[i], "capability", reason)
+			missing = reason
+			continue
 		}
+		supported = append(supported, i)
+	}
+	if len(supported) < len(caps) && len(supported) < needed {
+		return nil, fmt.Sprintf("Only %d of %d live servers support %s, need at least %d", len(supported), len(caps), missing, needed)
 	}
+	return supported, ""
+}
 
-	if maxGuesses < 0 {
+// validateGeneration runs the client-side checks of key generation that need no
+// server: the identity, maxGuesses, expiration, the server list, the key length,
+// the PIN parameters and the requested threshold. It returns ErrNone if generation
+// may proceed to contact the servers, and otherwise the argument at fault.
+func validateGeneration(identity *Identity, maxGuesses, expiration int, serverInfos []ServerInfo, opts *Options) (string, ErrCode, InputField) {
+	if err := identity.Validate(); err != nil {
+		return err.Error(), ErrInvalidIdentity, FieldIdentity
+	}
+
+	switch {
+	case opts != nil && opts.MaxGuessesLimit < 0:
+		return fmt.Sprintf("MaxGuessesLimit cannot be negative, got %d", opts.MaxGuessesLimit), ErrInvalidInput, FieldOptions
+	case opts != nil && opts.MinServers < 0:
+		return fmt.Sprintf("MinServers cannot be negative, got %d", opts.MinServers), ErrInvalidInput, FieldOptions
+	case maxGuesses < 1:
+		return fmt.Sprintf("Max guesses must be at least 1, got %d", maxGuesses), ErrInvalidInput, FieldMaxGuesses
+	case maxGuesses > opts.maxGuessesLimit():
+		return fmt.Sprintf("Max guesses must be at most %d, got %d", opts.maxGuessesLimit(), maxGuesses), ErrInvalidInput, FieldMaxGuesses
+	case expiration < 0:
+		return "Expiration is in the past", ErrInvalidInput, FieldExpiration
+	case expiration > 0 && int64(expiration) < opts.clock().Now().Unix():
+		return fmt.Sprintf("Expiration %s is in the past", time.Unix(int64(expiration), 0).UTC().Format(time.RFC3339)), ErrInvalidInput, FieldExpiration
+	case len(serverInfos) == 0:
+		return "No OpenADP servers available", ErrInsufficientServers, FieldServers
+	case len(serverInfos) < opts.minServers():
+		return fmt.Sprintf("Only %d servers provided, but MinServers requires at least %d", len(serverInfos), opts.minServers()), ErrInsufficientServers, FieldServers
+	case opts.validateKeyLength() != "":
+		return opts.validateKeyLength(), ErrInvalidInput, FieldOptions
+	case opts != nil && opts.PinParams.validate() != nil:
+		return fmt.Sprintf("Invalid PIN parameters: %v", opts.PinParams.validate()), ErrInvalidInput, FieldOptions
+	case opts != nil && opts.Threshold < 0:
+		return fmt.Sprintf("Threshold cannot be negative, got %d", opts.Threshold), ErrInvalidInput, FieldThreshold
+	case opts != nil && opts.Threshold > len(serverInfos):
+		return fmt.Sprintf("Threshold %d exceeds the number of servers (%d)", opts.Threshold, len(serverInfos)), ErrThresholdNotMet, FieldThreshold
+	}
+	return "", ErrNone, ""
+}
+
+// GenerateEncryptionKeyWithExpiry is like GenerateEncryptionKeyWithOpts but takes the
+// expiration of the backup as an absolute time; the zero time means it never expires.
+//
+// Each server stores the expiration with its share, truncated to whole seconds.
+// Once it has passed, servers refuse to recover the share, so the key can no longer
+// be recovered; servers may also delete expired shares. Expiration does not affect
+// the guess budget, and there is no call to extend it: registering the backup again
+// creates a new key. An expiration in the past is rejected with ErrInvalidInput
+// before any server is contacted.
+func GenerateEncryptionKeyWithExpiry(identity *Identity, password string, maxGuesses int, expiresAt time.Time,
+	serverInfos []ServerInfo, opts *Options) *GenerateEncryptionKeyResult {
+	return GenerateEncryptionKeyWithOpts(identity, password, maxGuesses, expirationUnix(expiresAt), serverInfos, opts)
+}
+
+// GenerateEncryptionKeyWithPin is like GenerateEncryptionKeyWithExpiry but takes
+// the PIN directly instead of the password, bypassing PasswordToPin, for
+// integrators that stretch the password elsewhere. Set opts.PinParams to the
+// derivation that produced the PIN, including its salt, to record it in Metadata
+// and allow recovery from the password; the PIN length is checked against them.
+func GenerateEncryptionKeyWithPin(identity *Identity, pin []byte, maxGuesses int, expiresAt time.Time,
+	serverInfos []ServerInfo, opts *Options) *GenerateEncryptionKeyResult {
+	explicit := withExplicitPin(opts, pin)
+	defer zeroize(explicit.explicitPin)
+	return GenerateEncryptionKeyWithExpiry(identity, "", maxGuesses, expiresAt, serverInfos, explicit)
+}
+
+// withExplicitPin returns a copy of opts that uses pin instead of a password.
+// A nil pin is kept as an empty one, so that it is never derived from "".
+func withExplicitPin(opts *Options, pin []byte) *Options {
+	explicit := Options{}
+	if opts != nil {
+		explicit = *opts
+	}
+	explicit.explicitPin = append([]byte{}, pin...)
+	return &explicit
+}
+
+// expirationUnix converts an expiration time to the wire format: Unix seconds, or 0 for never
+func expirationUnix(expiresAt time.Time) int {
+	if expiresAt.IsZero() {
+		return 0
+	}
+	if seconds := expiresAt.Unix(); seconds > 0 {
+		return int(seconds)
+	}
+	// Times at or before the epoch would read as "never" or be invalid; -1 is
+	// rejected as already expired
+	return -1
+}
+
+// generateEncryptionKey implements GenerateEncryptionKeyWithOpts, contacting the
+// servers under ctx
+func generateEncryptionKey(ctx context.Context, identity *Identity, password string, maxGuesses, expiration int,
+	serverInfos []ServerInfo, opts *Options) *GenerateEncryptionKeyResult {
+
+	// Input validation, counting each server only once
+	serverInfos, msg, code, field := prepareGeneration(identity, maxGuesses, expiration, serverInfos, opts)
+	if code != ErrNone {
 		return &GenerateEncryptionKeyResult{
-			Error: "Max guesses cannot be negative",
+			Error:   msg,
+			ErrCode: code,
+			Field:   field,
 		}
 	}
 
 	fmt.Printf("OpenADP: Identity=%s\n", identity.String())
 
-	// Step 1: Convert password to PIN
-	pin := []byte(password)
-
-	// Step 2: Check if we have servers
-	if len(serverInfos) == 0 {
+	// Step 1: Convert password to PIN, drawing a salt if the PIN KDF needs one. An
+	// explicit PIN was derived already, so its parameters are only recorded.
+	var pinParams *PinParams
+	if opts != nil && opts.explicitPin != nil {
+		pinParams = opts.PinParams
+	} else if opts != nil {
+		var err error
+		if pinParams, err = opts.PinParams.withSalt(opts.randReader()); err != nil {
+			return &GenerateEncryptionKeyResult{
+				Error:   fmt.Sprintf("Failed to generate PIN salt: %v", err),
+				ErrCode: ErrInternal,
+			}
+		}
+	}
+	pin, err := opts.pin(password, pinParams)
+	if err != nil {
 		return &GenerateEncryptionKeyResult{
-			Error: "No OpenADP servers available",
+			Error:   fmt.Sprintf("Failed to derive PIN: %v", err),
+			ErrCode: ErrInvalidInput,
 		}
 	}
+	defer zeroize(pin)
 
-	// Step 3: Initialize encrypted clients for each server using public keys from servers.json
-	clients := make([]*EncryptedOpenADPClient, 0, len(serverInfos))
-	liveServerURLs := make([]string, 0, len(serverInfos))
+	logger := opts.logger()
+
+	// Step 3: Initialize encrypted clients for each server using public keys from servers.json.
+	// Servers are pinged concurrently; the results keep the order of serverInfos.
+	candidates := make([]*EncryptedOpenADPClient, len(serverInfos))
+
+	forEachServer(len(serverInfos), opts.maxWorkers(len(serverInfos)), func(i int) {
+		serverInfo := serverInfos[i]
 
-	for _, serverInfo := range serverInfos {
 		var publicKey []byte
 		var err error
 
@@ -155,10 +394,10 @@ func GenerateEncryptionKey(identity *Identity, password string, maxGuesses, expi
 		}
 
 		// Create encrypted client with public key from servers.json (secure)
-		client := NewEncryptedOpenADPClient(serverInfo.URL, publicKey)
+		client := opts.newClient(ctx, serverInfo.URL, publicKey)
 		if err := client.Ping(); err == nil {
-			clients = append(clients, client)
-			liveServerURLs = append(liveServerURLs, serverInfo.URL)
+			candidates[i] = client
+			logger.Debug("server reachable", "server", serverInfo.URL, "encrypted", publicKey != nil)
 			if publicKey != nil {
 				fmt.Printf("OpenADP: Server %s - Using Noise-NK encryption (key from servers.json)\n", serverInfo.URL)
 			} else {
@@ -166,24 +405,87 @@ func GenerateEncryptionKey(identity *Identity, password string, maxGuesses, expi
 			}
 		} else {
 			fmt.Printf("Warning: Server %s is not accessible: %v\n", serverInfo.URL, err)
+			logger.Warn("server not accessible", "server", serverInfo.URL, "error", err.Error())
+		}
+	})
+
+	clients := make([]*EncryptedOpenADPClient, 0, len(serverInfos))
+	liveServerURLs := make([]string, 0, len(serverInfos))
+	liveServerInfos := make([]ServerInfo, 0, len(serverInfos))
+	for i, client := range candidates {
+		if client != nil {
+			clients = append(clients, client)
+			liveServerURLs = append(liveServerURLs, serverInfos[i].URL)
+			liveServerInfos = append(liveServerInfos, serverInfos[i])
 		}
 	}
 
 	if len(clients) == 0 {
 		return &GenerateEncryptionKeyResult{
-			Error: "No live servers available",
+			Error:   "No live servers available",
+			ErrCode: ErrNetwork,
+		}
+	}
+
+	// The quorum is formed from the live servers that can store the backup. If
+	// too few of them advertise the required capabilities, fail before
+	// registering anything.
+	caps := make([]Capabilities, len(clients))
+	forEachServer(len(clients), opts.maxWorkers(len(clients)), func(i int) {
+		caps[i] = clients[i].Capabilities()
+	})
+	var pinKDF PinKDF
+	if pinParams != nil {
+		pinKDF = pinParams.KDF
+	}
+	supported, msg := opts.capableServers(liveServerURLs, caps, pinKDF)
+	if msg != "" {
+		return &GenerateEncryptionKeyResult{
+			Error:   msg,
+			ErrCode: ErrInsufficientServers,
+		}
+	}
+	for n, i := range supported {
+		clients[n], liveServerURLs[n], liveServerInfos[n], caps[n] = clients[i], liveServerURLs[i], liveServerInfos[i], caps[i]
+	}
+	n := len(supported)
+	clients, liveServerURLs, liveServerInfos, caps = clients[:n], liveServerURLs[:n], liveServerInfos[:n], caps[:n]
+	if msg := checkExpiration(opts.clock().Now(), expiration, liveServerURLs, caps); msg != "" {
+		return &GenerateEncryptionKeyResult{
+			Error:   msg,
+			ErrCode: ErrInvalidInput,
+			Field:   FieldExpiration,
+		}
+	}
+	if len(clients) < opts.minServers() {
+		return &GenerateEncryptionKeyResult{
+			Error:   fmt.Sprintf("Only %d usable servers, but MinServers requires at least %d", len(clients), opts.minServers()),
+			ErrCode: ErrInsufficientServers,
 		}
 	}
 
 	fmt.Printf("OpenADP: Using %d live servers\n", len(clients))
 
 	// Step 4: Generate authentication codes for the live servers
-	authCodes := GenerateAuthCodes(liveServerURLs)
+	var authCodes *AuthCodes
+	if opts != nil && opts.DeriveAuthCodes {
+		logger.Warn("auth codes derived from the password, the backup has no guess limit")
+		derived, err := DeriveAuthCodes(identity, password, liveServerURLs)
+		if err != nil {
+			return &GenerateEncryptionKeyResult{Error: err.Error(), ErrCode: ErrInvalidIdentity}
+		}
+		authCodes = derived
+	} else {
+		generated, err := GenerateAuthCodesWithOpts(liveServerURLs, opts)
+		if err != nil {
+			return &GenerateEncryptionKeyResult{Error: fmt.Sprintf("Failed to generate auth codes: %v", err), ErrCode: ErrInternal}
+		}
+		authCodes = generated
+	}
 
 	// Step 5: Generate RANDOM secret and create point
 	// SECURITY FIX: Use random secret for Shamir secret sharing, not deterministic
 	var secret *big.Int
-	var err error
 
 	if debug.IsDebugModeEnabled() {
 		// In debug mode, use large deterministic secret
@@ -192,20 +494,17 @@ func GenerateEncryptionKey(identity *Identity, password string, maxGuesses, expi
 		_ = debug.GetDeterministicSecret()
 	} else {
 		// In normal mode, use cryptographically secure random
-		secret, err = rand.Int(rand.Reader, common.Q)
+		secret, err = randomSecret(opts.randReader())
 		if err != nil {
 			return &GenerateEncryptionKeyResult{
-				Error: fmt.Sprintf("Failed to generate random secret: %v", err),
+				Error:   fmt.Sprintf("Failed to generate random secret: %v", err),
+				ErrCode: ErrInternal,
 			}
 		}
-
-		// Ensure secret is not zero
-		if secret.Sign() == 0 {
-			secret.SetInt64(1)
-		}
 	}
+	defer zeroizeInt(secret)
 
-	U := common.H([]byte(identity.UID), []byte(identity.DID), []byte(identity.BID), pin)
+	U := common.HWithDST(opts.hashDST(), []byte(identity.UID), []byte(identity.DID), []byte(identity.BID), pin)
 
 	// Add debug logging to match other SDKs
 	if debug.IsDebugModeEnabled() {
@@ -220,94 +519,150 @@ func GenerateEncryptionKey(identity *Identity, password string, maxGuesses, expi
 	}
 
 	// Step 6: Create shares using secret sharing
-	threshold := len(clients)/2 + 1 // Standard majority threshold: floor(N/2) + 1
+	threshold := opts.threshold(len(clients))
 	numShares := len(clients)
 
 	if numShares < threshold {
+		logger.Warn("too few live servers for threshold", "live", numShares, "threshold", threshold)
+		opts.metrics().ThresholdEvaluated(OperationGenerate, numShares, threshold)
 		return &GenerateEncryptionKeyResult{
-			Error: fmt.Sprintf("Need at least %d servers, only %d available", threshold, numShares),
+			Error:   fmt.Sprintf("Need at least %d servers, only %d available", threshold, numShares),
+			ErrCode: ErrThresholdNotMet,
 		}
 	}
 
-	shares, err := MakeRandomShares(secret, threshold, numShares)
+	shares, err := makeRandomShares(opts.randReader(), secret, threshold, numShares)
 	if err != nil {
 		return &GenerateEncryptionKeyResult{
-			Error: fmt.Sprintf("Failed to create shares: %v", err),
+			Error:   fmt.Sprintf("Failed to create shares: %v", err),
+			ErrCode: ErrInternal,
 		}
 	}
+	defer func() {
+		for _, share := range shares {
+			zeroizeInt(share.Y)
+		}
+	}()
 
 	fmt.Printf("OpenADP: Created %d shares with threshold %d\n", len(shares), threshold)
+	logger.Info("selected threshold", "threshold", threshold, "servers", numShares)
+	if threshold == numShares {
+		logger.Warn("threshold equals server count, no server may fail", "threshold", threshold, "servers", numShares)
+	}
 
-	// Step 7: Register shares with servers using authentication codes and encryption
-	// Only use encrypted registration for sensitive operations
+	// Step 7: Register shares with servers using authentication codes and encryption.
+	// Registrations run concurrently and each server's outcome is recorded separately,
+	// so one slow or failing server cannot abort the whole batch.
 	version := 1
-	registrationErrors := []string{}
-	successfulRegistrations := 0
+	serverResults := make([]ServerResult, len(clients))
 
-	for i, share := range shares {
-		if i >= len(clients) {
-			break // More shares than servers
+	idempotencyKey, err := newIdempotencyKey(opts.randReader())
+	if err != nil {
+		return &GenerateEncryptionKeyResult{
+			Error:   fmt.Sprintf("Failed to generate idempotency key: %v", err),
+			ErrCode: ErrInternal,
 		}
+	}
 
+	forEachServer(len(clients), opts.maxWorkers(len(clients)), func(i int) {
+		share := shares[i]
 		client := clients[i]
 		serverURL := liveServerURLs[i]
-		authCode := authCodes.ServerAuthCodes[serverURL]
+		authCode, _ := authCodes.ForServer(serverURL)
+
+		serverResults[i] = ServerResult{URL: serverURL}
 
 		// Convert share Y to base64-encoded 32-byte little-endian format (per API spec)
-		yBytes := make([]byte, 32)
-		yBigInt := share.Y
-		yBigIntBytes := yBigInt.Bytes() // Big-endian format
-
-		// Convert to little-endian by copying and reversing
-		if len(yBigIntBytes) <= 32 {
-			copy(yBytes[32-len(yBigIntBytes):], yBigIntBytes) // Right-align in big-endian
-			// Convert to little-endian
-			for i, j := 0, len(yBytes)-1; i < j; i, j = i+1, j-1 {
-				yBytes[i], yBytes[j] = yBytes[j], yBytes[i]
-			}
-		} else {
-			return &GenerateEncryptionKeyResult{
-				Error: fmt.Sprintf("Y coordinate too large for 32-byte encoding: %d bytes", len(yBigIntBytes)),
-			}
+		yBytes, err := common.EncodeScalar(share.Y)
+		if err != nil {
+			serverResults[i].Error = fmt.Sprintf("Invalid share Y: %v", err)
+			return
 		}
 
 		yBase64 := base64.StdEncoding.EncodeToString(yBytes)
+		zeroize(yBytes)
 
 		// Use encrypted registration if server has public key, otherwise unencrypted for compatibility
 		encrypted := client.HasPublicKey()
 
-		success, err := client.RegisterSecret(
-			authCode, identity.UID, identity.DID, identity.BID, version, int(share.X.Int64()), yBase64, maxGuesses, expiration, encrypted, nil)
+		var timing LatencyBreakdown
+		start := time.Now()
+		success, err := client.withTiming(&timing).registerSecretIdempotent(idempotencyKey,
+			authCode, identity.UID, identity.DID, identity.BID, version, int(share.X.Int64()), yBase64, maxGuesses, expiration, encrypted)
+		serverResults[i].Latency = time.Since(start)
+		serverResults[i].recordTiming(OperationGenerate, timing, opts.metrics())
 
 		if err != nil {
-			registrationErrors = append(registrationErrors, fmt.Sprintf("Server %d (%s): %v", i+1, serverURL, err))
+			serverResults[i].Error = err.Error()
 		} else if !success {
-			registrationErrors = append(registrationErrors, fmt.Sprintf("Server %d (%s): Registration returned false", i+1, serverURL))
+			serverResults[i].Error = "Registration returned false"
+		}
+		if serverResults[i].Error != "" {
+			logger.Warn("registration failed", "server", serverURL, "latency", serverResults[i].Latency, "error", serverResults[i].Error)
 		} else {
+			logger.Debug("registration succeeded", "server", serverURL, "latency", serverResults[i].Latency, "encrypted", encrypted)
 			encStatus := "unencrypted"
 			if encrypted {
 				encStatus = "encrypted"
 			}
 			fmt.Printf("OpenADP: Registered share %s with server %d (%s) [%s]\n", share.X.String(), i+1, serverURL, encStatus)
+			serverResults[i].Success = true
+		}
+	})
+
+	registrationErrors := []string{}
+	successfulRegistrations := 0
+	for i, serverResult := range serverResults {
+		if serverResult.Success {
 			successfulRegistrations++
+		} else {
+			registrationErrors = append(registrationErrors, fmt.Sprintf("Server %d (%s): %s", i+1, serverResult.URL, serverResult.Error))
 		}
 	}
 
+	opts.metrics().ThresholdEvaluated(OperationGenerate, successfulRegistrations, threshold)
 	if successfulRegistrations < threshold {
+		logger.Warn("registration threshold not met", "registered", successfulRegistrations, "servers", len(clients), "threshold", threshold)
+		message := fmt.Sprintf("Failed to register enough shares: got %d/%d, need %d (threshold). Errors: %v", successfulRegistrations, len(clients), threshold, registrationErrors)
+		rollback := rollbackRegistration(ctx, clients, serverResults, identity, authCodes, logger)
+		if rollback != nil {
+			message += "; " + rollback.describe()
+		}
 		return &GenerateEncryptionKeyResult{
-			Error: fmt.Sprintf("Failed to register enough shares: got %d/%d, need %d (threshold). Errors: %v", successfulRegistrations, len(clients), threshold, registrationErrors),
+			Error:         message,
+			ErrCode:       ErrThresholdNotMet,
+			ServerResults: serverResults,
+			Rollback:      rollback,
 		}
 	}
 
+	logger.Info("registration threshold met", "registered", successfulRegistrations, "servers", len(clients), "threshold", threshold)
+
 	// Step 8: Derive encryption key
-	encKey := common.DeriveEncKey(S)
+	encKey := opts.boundKey(common.DeriveEncKeyWithLength(S, opts.keyLength()), identity)
+	var commitment *KeyCommitment
+	if opts != nil && opts.KeyCommitment {
+		var err error
+		if commitment, err = newKeyCommitment(opts.randReader(), encKey); err != nil {
+			return &GenerateEncryptionKeyResult{Error: fmt.Sprintf("Failed to commit to the key: %v", err), ErrCode: ErrInternal, ServerResults: serverResults}
+		}
+	}
 	fmt.Println("OpenADP: Successfully generated encryption key")
 
 	return &GenerateEncryptionKeyResult{
-		EncryptionKey: encKey,
-		ServerURLs:    liveServerURLs,
-		Threshold:     threshold,
-		AuthCodes:     authCodes, // Include auth codes for metadata
+		EncryptionKey:   encKey,
+		ServerURLs:      liveServerURLs,
+		ServerInfos:     liveServerInfos,
+		Threshold:       threshold,
+		AuthCodes:       authCodes, // Include auth codes for metadata
+		ServerResults:   serverResults,
+		PinParams:       pinParams,
+		IdempotencyKey:  idempotencyKey,
+		IdentityBound:   opts != nil && opts.BindIdentity,
+		HashDST:         opts.hashDST(),
+		KeyCommitment:   commitment,
+		Capabilities:    IntersectCapabilities(caps...),
+		ProtocolVersion: ProtocolVersion,
 	}
 }
 
@@ -315,6 +670,62 @@ func GenerateEncryptionKey(identity *Identity, password string, maxGuesses, expi
 type RecoverEncryptionKeyResult struct {
 	EncryptionKey []byte
 	Error         string
+	ErrCode       ErrCode        // Machine-readable classification of Error
+	ServerResults []ServerResult // Per-server recovery outcomes, in serverInfos order without duplicate servers
+
+	// Consistency reports whether the servers agree on the backup's registration
+	// parameters. Nil if recovery failed before the servers were queried.
+	Consistency *ConsistencyReport
+
+	// SharesCollected is the number of valid shares recovered from the servers, or,
+	// if BestEffortRecover stopped before spending guesses, the number of reachable
+	// servers that still hold a share. SharesNeeded is the threshold. Both are zero
+	// if recovery failed before the servers were queried.
+	SharesCollected int
+	SharesNeeded    int
+
+	// SharesUsed lists, sorted, the URLs of the SharesNeeded servers whose shares
+	// reconstructed the key: those with the smallest URLs among the servers that
+	// returned one. Any valid subset yields the same key; the fixed choice only
+	// makes recovery reproducible for debugging and test vectors.
+	SharesUsed []string
+
+	// GuessCounts maps the servers that reported the backup's guess counter to its
+	// value after this attempt; store it with Metadata.RecordGuessCounts.
+	// GuessCounterAnomalies lists the servers whose counter was lower than
+	// Options.ExpectedGuessCounts, a sign that the server reset it. Recovery
+	// proceeds regardless; callers should stop trusting such servers.
+	GuessCounts           map[string]int
+	GuessCounterAnomalies []GuessCounterAnomaly
+	// Cached reports that EncryptionKey came from Options.KeyCache without
+	// contacting any server; ServerResults and the fields above are then empty.
+	Cached bool
+
+	// RetryAfter is set with ErrGuessesExhausted if every locked server reported
+	// a cooldown: the latest time one of them unlocks the backup, after which a
+	// new attempt can reach them all. It is zero if a server did not report when
+	// it unlocks, and the backup may then be locked for good.
+	RetryAfter time.Time
+
+	// FallbackServers lists the URLs of the fallback servers RecoverWithFallback
+	// promoted because they hold a share, in order. It is empty if the primary
+	// servers sufficed.
+	FallbackServers []string
+
+	// BID is the backup ID the key was recovered from, and Version the backup
+	// version the servers whose shares were used report for it (the highest, if
+	// they disagree; zero if none reports one). Callers alternating between "even"
+	// and "odd" backups can use them to reconcile their state, for example after
+	// an interrupted RecoverAndRotate. Both are unset if recovery failed, and
+	// Version is also zero for a Cached key.
+	BID     string
+	Version int
+
+	// ProtocolVersion is the share format version of the shares the key was
+	// reconstructed from, always ProtocolVersion: shares of other versions are
+	// refused, and recovery fails with ErrMixedProtocolVersions if too few remain.
+	// Zero if recovery failed or the key was Cached.
+	ProtocolVersion int
 }
 
 // RecoverEncryptionKeyWithServerInfo recovers an encryption key using OpenADP distributed secret sharing.
@@ -326,60 +737,176 @@ type RecoverEncryptionKeyResult struct {
 // 4. Reconstructs the original secret using threshold cryptography
 // 5. Derives the same encryption key
 func RecoverEncryptionKeyWithServerInfo(identity *Identity, password string, serverInfos []ServerInfo, threshold int, authCodes *AuthCodes) *RecoverEncryptionKeyResult {
-	// Input validation
-	if identity == nil {
-		return &RecoverEncryptionKeyResult{
-			Error: "Identity cannot be nil",
-		}
+	return RecoverEncryptionKeyWithOpts(identity, password, serverInfos, threshold, authCodes, nil)
+}
+
+// RecoverEncryptionKeyWithOpts is like RecoverEncryptionKeyWithServerInfo but accepts explicit options.
+//
+// The servers cannot tell a wrong password from the right one, so a wrong
+// password recovers a wrong key without any error. Only with
+// opts.ExpectedKeyCommitment, which Metadata.RecoveryOptions and
+// RecoverFromMetadata fill in for backups registered with Options.KeyCommitment,
+// is the key checked before it is returned; otherwise it is unverified until it
+// fails to decrypt.
+func RecoverEncryptionKeyWithOpts(identity *Identity, password string, serverInfos []ServerInfo, threshold int, authCodes *AuthCodes, opts *Options) *RecoverEncryptionKeyResult {
+	metrics := opts.metrics()
+	metrics.OperationStarted(OperationRecover)
+	start := time.Now()
+
+	ctx, cancel := opts.overallContext()
+	defer cancel()
+	result := recoverEncryptionKey(ctx, identity, password, serverInfos, threshold, authCodes, opts)
+	result.Error, result.ErrCode = timeoutError(ctx, result.Error, result.ErrCode)
+
+	metrics.OperationFinished(OperationRecover, time.Since(start), result.ErrCode)
+	return result
+}
+
+// RecoverEncryptionKeyWithPin is like RecoverEncryptionKeyWithOpts but takes the
+// PIN directly instead of the password, for integrators that stretch the password
+// elsewhere, such as on a hardened device. The PIN must be the one the password
+// derives with opts.PinParams, and its length is checked against them: a backup
+// generated from a password recovers with the equivalent PIN, and vice versa.
+func RecoverEncryptionKeyWithPin(identity *Identity, pin []byte, serverInfos []ServerInfo, threshold int, authCodes *AuthCodes, opts *Options) *RecoverEncryptionKeyResult {
+	explicit := withExplicitPin(opts, pin)
+	defer zeroize(explicit.explicitPin)
+	return RecoverEncryptionKeyWithOpts(identity, "", serverInfos, threshold, authCodes, explicit)
+}
+
+// BestEffortRecover recovers the key from whichever servers in serverInfos are still
+// reachable, for backups whose servers have been decommissioned since registration.
+//
+// The threshold cannot be lowered: interpolating fewer than threshold shares yields a
+// different point, and so a wrong key that nothing would detect. Instead, before
+// spending any guess, BestEffortRecover counts the reachable servers that still hold
+// a share. If there are fewer than threshold it fails with ErrThresholdNotMet, an
+// Error such as "Found 4 of the 5 shares needed" and SharesCollected and
+// SharesNeeded set accordingly, leaving every guess counter untouched. Otherwise it
+// recovers like RecoverEncryptionKeyWithOpts, and SharesCollected reports how many
+// shares were actually recovered.
+func BestEffortRecover(identity *Identity, password string, serverInfos []ServerInfo, threshold int, authCodes *AuthCodes, opts *Options) *RecoverEncryptionKeyResult {
+	bestEffort := Options{}
+	if opts != nil {
+		bestEffort = *opts
 	}
+	bestEffort.bestEffort = true
+	return RecoverEncryptionKeyWithOpts(identity, password, serverInfos, threshold, authCodes, &bestEffort)
+}
 
-	if identity.UID == "" {
-		return &RecoverEncryptionKeyResult{
-			Error: "UID cannot be empty",
-		}
+// duplicatesNote returns a note on the duplicate servers removed from the list
+// for error messages, or "" if there were none
+func duplicatesNote(duplicates []string) string {
+	if len(duplicates) == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" after removing %d duplicate servers (%s)", len(duplicates), strings.Join(duplicates, ", "))
+}
+
+// timeoutError reclassifies a failure to reach enough servers as ErrTimeout if
+// the overall deadline in ctx expired
+func timeoutError(ctx context.Context, msg string, code ErrCode) (string, ErrCode) {
+	if (code == ErrNetwork || code == ErrThresholdNotMet) && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return msg + " (overall timeout expired)", ErrTimeout
 	}
+	return msg, code
+}
 
-	if identity.DID == "" {
+// recoverEncryptionKey implements RecoverEncryptionKeyWithOpts, contacting the
+// servers under ctx
+func recoverEncryptionKey(ctx context.Context, identity *Identity, password string, serverInfos []ServerInfo, threshold int, authCodes *AuthCodes, opts *Options) *RecoverEncryptionKeyResult {
+	// Input validation
+	if err := identity.Validate(); err != nil {
 		return &RecoverEncryptionKeyResult{
-			Error: "DID cannot be empty",
+			Error:   err.Error(),
+			ErrCode: ErrInvalidIdentity,
 		}
 	}
 
-	if identity.BID == "" {
+	if threshold <= 0 {
 		return &RecoverEncryptionKeyResult{
-			Error: "BID cannot be empty",
+			Error:   "Threshold must be positive",
+			ErrCode: ErrInvalidInput,
 		}
 	}
 
-	if threshold <= 0 {
+	if msg := opts.validateKeyLength(); msg != "" {
 		return &RecoverEncryptionKeyResult{
-			Error: "Threshold must be positive",
+			Error:   msg,
+			ErrCode: ErrInvalidInput,
 		}
 	}
 
 	fmt.Printf("OpenADP: Identity=%s\n", identity.String())
 
 	// Step 1: Convert password to same PIN
-	pin := []byte(password)
+	var pinParams *PinParams
+	if opts != nil {
+		pinParams = opts.PinParams
+	}
+	pin, err := opts.pin(password, pinParams)
+	if err != nil {
+		return &RecoverEncryptionKeyResult{
+			Error:   fmt.Sprintf("Failed to derive PIN: %v", err),
+			ErrCode: ErrInvalidInput,
+		}
+	}
+	defer zeroize(pin)
 
 	// Step 2: Check if we have servers and auth codes
 	if len(serverInfos) == 0 {
 		return &RecoverEncryptionKeyResult{
-			Error: "No OpenADP servers available",
+			Error:   "No OpenADP servers available",
+			ErrCode: ErrInsufficientServers,
 		}
 	}
 
 	if authCodes == nil {
 		return &RecoverEncryptionKeyResult{
-			Error: "No authentication codes provided",
+			Error:   "No authentication codes provided",
+			ErrCode: ErrInvalidInput,
 		}
 	}
 
+	serverInfos, duplicates := opts.dedupeServers(serverInfos)
+	if len(duplicates) > 0 && len(serverInfos) < threshold {
+		return &RecoverEncryptionKeyResult{
+			Error:        fmt.Sprintf("Threshold %d exceeds the number of servers (%d)", threshold, len(serverInfos)) + duplicatesNote(duplicates),
+			ErrCode:      ErrThresholdNotMet,
+			SharesNeeded: threshold,
+		}
+	}
+
+	logger := opts.logger()
+
+	cache := opts.keyCache()
+	var fingerprint [sha256.Size]byte
+	if cache != nil {
+		fingerprint = cache.fingerprint(identity, pin, serverInfos, threshold, authCodes, opts.keyLength(), opts.hashDST())
+		if key := cache.get(fingerprint); key != nil {
+			logger.Debug("recovered key from cache")
+			if key = opts.boundKey(key, identity); !opts.commitmentMatches(key) {
+				zeroize(key)
+				return &RecoverEncryptionKeyResult{Error: keyCommitmentMismatchMessage, ErrCode: ErrKeyCommitmentMismatch, BID: identity.BID}
+			}
+			return &RecoverEncryptionKeyResult{EncryptionKey: key, Cached: true, BID: identity.BID}
+		}
+	}
+
+	var session *RecoverySession
+	if opts != nil && opts.session != nil {
+		session = opts.session
+		serverInfos = session.pending(serverInfos)
+	}
+
 	// Step 3: Initialize clients for the specific servers, using encryption when public keys are available
+	serverResults := make([]ServerResult, len(serverInfos))
 	clients := make([]*EncryptedOpenADPClient, 0, len(serverInfos))
 	liveServerURLs := make([]string, 0, len(serverInfos))
+	liveIndexes := make([]int, 0, len(serverInfos)) // Index into serverInfos of each live client
+
+	for i, serverInfo := range serverInfos {
+		serverResults[i] = ServerResult{URL: serverInfo.URL}
 
-	for _, serverInfo := range serverInfos {
 		var publicKey []byte
 		if serverInfo.PublicKey != "" {
 			// Parse public key (handles "ed25519:" prefix)
@@ -398,31 +925,48 @@ func RecoverEncryptionKeyWithServerInfo(identity *Identity, password string, ser
 			}
 		}
 
-		client := NewEncryptedOpenADPClient(serverInfo.URL, publicKey)
+		client := opts.newClient(ctx, serverInfo.URL, publicKey)
+		start := time.Now()
 		if err := client.Ping(); err == nil {
 			clients = append(clients, client)
 			liveServerURLs = append(liveServerURLs, serverInfo.URL)
+			liveIndexes = append(liveIndexes, i)
 		} else {
 			fmt.Printf("Warning: Server %s is not accessible: %v\n", serverInfo.URL, err)
+			logger.Warn("server not accessible", "server", serverInfo.URL, "error", err.Error())
+			serverResults[i].Latency = time.Since(start)
+			serverResults[i].Error = fmt.Sprintf("Server not accessible: %v", err)
 		}
 	}
 
-	if len(clients) == 0 {
+	if len(clients) == 0 && session.Collected() < threshold {
 		return &RecoverEncryptionKeyResult{
-			Error: "No servers are accessible",
+			Error:         "No servers are accessible",
+			ErrCode:       ErrNetwork,
+			ServerResults: serverResults,
 		}
 	}
 
 	fmt.Printf("OpenADP: Using %d live servers\n", len(clients))
 
 	// Step 4: Create cryptographic context (same as encryption)
-	U := common.H([]byte(identity.UID), []byte(identity.DID), []byte(identity.BID), pin)
+	U := common.HWithDST(opts.hashDST(), []byte(identity.UID), []byte(identity.DID), []byte(identity.BID), pin)
 
-	// Generate random r and compute B for recovery protocol
-	r, err := rand.Int(rand.Reader, common.Q)
+	// Generate a fresh random r for every recovery and compute B for recovery protocol
+	r, err := rand.Int(opts.randReader(), common.Q)
 	if err != nil {
 		return &RecoverEncryptionKeyResult{
-			Error: fmt.Sprintf("Failed to generate random r: %v", err),
+			Error:         fmt.Sprintf("Failed to generate random r: %v", err),
+			ErrCode:       ErrInternal,
+			ServerResults: serverResults,
+		}
+	}
+	defer zeroizeInt(r)
+	if err := activeBlindingGuard.check(r); err != nil {
+		return &RecoverEncryptionKeyResult{
+			Error:         err.Error(),
+			ErrCode:       ErrInternal,
+			ServerResults: serverResults,
 		}
 	}
 
@@ -430,9 +974,12 @@ func RecoverEncryptionKeyWithServerInfo(identity *Identity, password string, ser
 	rInv := new(big.Int).ModInverse(r, common.Q)
 	if rInv == nil {
 		return &RecoverEncryptionKeyResult{
-			Error: "Failed to compute modular inverse",
+			Error:         "Failed to compute modular inverse",
+			ErrCode:       ErrInternal,
+			ServerResults: serverResults,
 		}
 	}
+	defer zeroizeInt(rInv)
 
 	B := common.PointMul(r, U)
 
@@ -440,38 +987,177 @@ func RecoverEncryptionKeyWithServerInfo(identity *Identity, password string, ser
 	bCompressed := common.PointCompress(B)
 	bBase64Format := base64.StdEncoding.EncodeToString(bCompressed)
 
-	// Step 5: Recover shares from servers using authentication codes
-	fmt.Println("OpenADP: Recovering shares from servers...")
-	recoveredPointShares := make([]*PointShare, 0, len(clients))
+	// Step 5: Read the guess counter and registration parameters of the backup from
+	// every server before any guess is spent
+	guessNums := make([]int, len(clients)) // Default to 0 for first guess (0-based indexing)
+	consistency := &ConsistencyReport{MaxGuesses: map[string]int{}, Expiration: map[string]int{}}
+	holders := session.Collected() // Servers that list the backup or could not be asked, or gave a share before
+	guessCounts := make(map[string]int, len(clients))
+	protocolVersions := make([]int, len(clients)) // Share format spoken by each server, 0 if none this client knows
 
 	for i, client := range clients {
 		serverURL := liveServerURLs[i]
-		authCode := authCodes.ServerAuthCodes[serverURL]
 
-		// Get current guess number for this backup from the server
-		backups, err := client.ListBackups(identity.UID, false, nil)
-		guessNum := 0 // Default to 0 for first guess (0-based indexing)
+		protocolVersions[i] = negotiateProtocolVersion(client.Capabilities())
+		if protocolVersions[i] == 0 {
+			logger.Warn("server speaks no supported protocol version", "server", serverURL, "versions", client.Capabilities().ProtocolVersions)
+		}
+
+		backups, err := client.listBackups(identity.UID, false, nil)
 		if err != nil {
 			fmt.Printf("Warning: Could not list backups from server %d: %v\n", i+1, err)
-		} else {
-			// Find our backup in the list using the complete primary key (UID, DID, BID)
-			for _, backupMap := range backups {
-				if backupUID, ok := backupMap["uid"].(string); ok && backupUID == identity.UID {
-					if backupDID, ok := backupMap["did"].(string); ok && backupDID == identity.DID {
-						if backupBID, ok := backupMap["bid"].(string); ok && backupBID == identity.BID {
-							if numGuesses, ok := backupMap["num_guesses"].(float64); ok {
-								// Use current num_guesses as the next guess number (0-based)
-								guessNum = int(numGuesses)
-							}
-							break
-						}
-					}
-				}
+			holders++
+			continue
+		}
+
+		// Find our backup in the list using the complete primary key (UID, DID, BID)
+		for _, backup := range backups {
+			if backup.UID == identity.UID && backup.DID == identity.DID && backup.BID == identity.BID {
+				holders++
+				// Use current num_guesses as the next guess number (0-based)
+				guessNums[i] = backup.NumGuesses
+				guessCounts[serverURL] = backup.NumGuesses
+				consistency.MaxGuesses[serverURL] = backup.MaxGuesses
+				opts.metrics().GuessesRemaining(serverURL, backup.MaxGuesses-guessNums[i])
+				consistency.Expiration[serverURL] = backup.Expiration
+				break
 			}
 		}
+	}
+
+	var anomalies []GuessCounterAnomaly
+	if opts != nil {
+		anomalies = guessCounterAnomalies(opts.ExpectedGuessCounts, guessCounts)
+	}
+	for _, anomaly := range anomalies {
+		logger.Warn("guess counter moved backwards", "server", anomaly.URL, "expected", anomaly.Expected, "reported", anomaly.Reported)
+	}
+
+	consistency.evaluate(opts.expirationTolerance())
+	if !consistency.Consistent {
+		logger.Warn("servers disagree on backup parameters", "max_guesses", consistency.MaxGuesses, "expiration", consistency.Expiration)
+		if opts != nil && opts.StrictConsistency {
+			return &RecoverEncryptionKeyResult{
+				Error:                 fmt.Sprintf("Servers disagree on backup parameters: max guesses %v, expiration %v", consistency.MaxGuesses, consistency.Expiration),
+				ErrCode:               ErrInconsistentServers,
+				ServerResults:         serverResults,
+				Consistency:           consistency,
+				GuessCounts:           guessCounts,
+				GuessCounterAnomalies: anomalies,
+			}
+		}
+	}
+
+	// Servers refuse to recover backups past their expiration, so fail before
+	// spending a guess if too few unexpired shares remain
+	now := opts.clock().Now().Unix()
+	expired := 0
+	for _, expiration := range consistency.Expiration {
+		if expiration > 0 && now > int64(expiration) {
+			expired++
+		}
+	}
+	if expired > 0 && holders-expired < threshold {
+		logger.Warn("backup expired", "expired", expired, "holders", holders, "threshold", threshold)
+		opts.metrics().ThresholdEvaluated(OperationRecover, holders-expired, threshold)
+		return &RecoverEncryptionKeyResult{
+			Error:                 fmt.Sprintf("Backup expired on %d of %d servers, leaving %d of the %d shares needed", expired, holders, holders-expired, threshold),
+			ErrCode:               ErrBackupExpired,
+			ServerResults:         serverResults,
+			Consistency:           consistency,
+			SharesCollected:       holders - expired,
+			SharesNeeded:          threshold,
+			GuessCounts:           guessCounts,
+			GuessCounterAnomalies: anomalies,
+		}
+	}
+
+	if opts != nil && opts.bestEffort && holders < threshold {
+		logger.Warn("too few shares left for threshold", "available", holders, "threshold", threshold)
+		opts.metrics().ThresholdEvaluated(OperationRecover, holders, threshold)
+		return &RecoverEncryptionKeyResult{
+			Error:                 fmt.Sprintf("Found %d of the %d shares needed", holders, threshold),
+			ErrCode:               ErrThresholdNotMet,
+			ServerResults:         serverResults,
+			Consistency:           consistency,
+			SharesCollected:       holders,
+			SharesNeeded:          threshold,
+			GuessCounts:           guessCounts,
+			GuessCounterAnomalies: anomalies,
+		}
+	}
+
+	if opts != nil && opts.unconfirmed {
+		return &RecoverEncryptionKeyResult{
+			Error:                 fmt.Sprintf("Stopped before spending a guess: %d of %d servers hold the backup, %d needed. Set ConfirmGuessConsumption to attempt recovery, which spends one guess on each server it asks.", holders, len(clients), threshold),
+			ErrCode:               ErrGuessNotConfirmed,
+			ServerResults:         serverResults,
+			Consistency:           consistency,
+			SharesCollected:       holders,
+			SharesNeeded:          threshold,
+			GuessCounts:           guessCounts,
+			GuessCounterAnomalies: anomalies,
+		}
+	}
+
+	// Step 6: Recover shares from servers using authentication codes
+	fmt.Println("OpenADP: Recovering shares from servers...")
+	recoveredPointShares := make([]*PointShare, 0, len(clients))
+	recoveredURLs := make([]string, 0, len(clients)) // Server of each recovered share
+	if session != nil {
+		recoveredPointShares, recoveredURLs = session.blinded(r)
+	}
+	versions := make(map[string]int) // Backup version reported by each server
+	guessesExhausted := false
+	versionRefused := false  // A server speaks or returned a share of an unsupported protocol version
+	var retryAfter time.Time // Latest cooldown reported by a locked server
+	lockedIndefinitely := false
+	replayRejected := false // A server rejected a request as replayed or stale
+	progress := opts.progress()
+
+	// Ask the servers concurrently, in order, but no more at once than shares are
+	// still needed or MaxConcurrency allows, so that no more guesses are spent
+	// than needed. Requests still outstanding once the threshold is met are
+	// cancelled. The state below is shared by the requests and guarded by mu.
+	fanOut, stopFanOut := context.WithCancel(ctx)
+	defer stopFanOut()
+	var mu sync.Mutex
+
+	// VerifyShares asks for one share beyond the threshold, when a server is left
+	// to give it, for verifyReconstruction to check the others against
+	wanted := threshold
+	if opts != nil && opts.VerifyShares && len(recoveredPointShares)+len(clients) > threshold {
+		wanted++
+	}
+
+	recoverShare := func(i int) {
+		if fanOut.Err() != nil {
+			return // Never asked
+		}
+		client := clients[i]
+		serverURL := liveServerURLs[i]
+		authCode, _ := authCodes.ForServer(serverURL)
+		serverResult := &serverResults[liveIndexes[i]]
+		if protocolVersions[i] == 0 {
+			// Its share could not be combined with any other, so spend no guess on it
+			serverResult.Error = fmt.Sprintf("Server speaks protocol versions %v, this client only %d", client.Capabilities().ProtocolVersions, ProtocolVersion)
+			mu.Lock()
+			versionRefused = true
+			mu.Unlock()
+			return
+		}
+		guessNum := guessNums[i]
+		mu.Lock()
+		event := ProgressEvent{Type: ServerStarted, URL: serverURL, Server: i + 1, Servers: len(clients), Shares: len(recoveredPointShares), Threshold: threshold}
+		mu.Unlock()
+		progress.report(event)
+		var timing LatencyBreakdown
+		client = client.WithContext(fanOut).withTiming(&timing)
+		start := time.Now()
 
 		// Try recovery with current guess number, retry once if guess number is wrong
-		resultMap, err := client.RecoverSecret(authCode, identity.UID, identity.DID, identity.BID, bBase64Format, guessNum, true, nil)
+		params := recoverSecretParams{AuthCode: authCode, UID: identity.UID, DID: identity.DID, BID: identity.BID, B: bBase64Format, GuessNum: guessNum}
+		recovered, err := client.recoverSecret(params, true, nil)
 
 		// If we get a guess number error, try to parse the expected number and retry
 		if err != nil && strings.Contains(err.Error(), "expecting guess_num =") {
@@ -484,40 +1170,91 @@ func RecoverEncryptionKeyWithServerInfo(identity *Identity, password string, ser
 				}
 				if expectedGuess, parseErr := strconv.Atoi(expectedStr); parseErr == nil {
 					fmt.Printf("Server %d (%s): Retrying with expected guess_num = %d\n", i+1, serverURL, expectedGuess)
-					resultMap, err = client.RecoverSecret(authCode, identity.UID, identity.DID, identity.BID, bBase64Format, expectedGuess, true, nil)
+					params.GuessNum = expectedGuess
+					recovered, err = client.recoverSecret(params, true, nil)
+					guessNum = expectedGuess
 				}
 			}
 		}
 
+		serverResult.Latency = time.Since(start)
+		serverResult.recordTiming(OperationRecover, timing, opts.metrics())
+
+		if err != nil && fanOut.Err() != nil && ctx.Err() == nil {
+			return // Cancelled once the threshold was met
+		}
 		if err != nil {
 			fmt.Printf("Server %d (%s) recovery failed: %v\n", i+1, serverURL, err)
-			continue
+			serverResult.Error = err.Error()
+			mu.Lock()
+			defer mu.Unlock()
+			if isGuessesExhaustedError(err) {
+				guessesExhausted = true
+				var exhausted *GuessesExhaustedError
+				if !errors.As(err, &exhausted) || exhausted.RetryAfter.IsZero() {
+					lockedIndefinitely = true
+				} else if exhausted.RetryAfter.After(retryAfter) {
+					retryAfter = exhausted.RetryAfter
+				}
+			}
+			if errors.Is(err, ErrReplayDetected) {
+				replayRejected = true
+			}
+			event.Type, event.Error = ServerFailed, serverResult.Error
+			progress.report(event)
+			return
 		}
 
-		x, ok := resultMap["x"].(float64)
-		if !ok {
+		mu.Lock()
+		if recovered.Version != 0 {
+			versions[serverURL] = recovered.Version
+		}
+
+		// The guess was spent; record the counter the server reports after it,
+		// which is at least one
+		if recovered.NumGuesses > 0 {
+			guessCounts[serverURL] = recovered.NumGuesses
+		} else {
+			guessCounts[serverURL] = guessNum + 1
+		}
+		mu.Unlock()
+
+		x := recovered.X
+		if x <= 0 {
 			fmt.Printf("Server %d (%s): Invalid x field\n", i+1, serverURL)
-			continue
+			serverResult.Error = "Invalid x field"
+			event.Type, event.Error = ServerFailed, serverResult.Error
+			progress.report(event)
+			return
 		}
 
-		siBBase64, ok := resultMap["si_b"].(string)
-		if !ok {
+		siBBase64 := recovered.SiB
+		if siBBase64 == "" {
 			fmt.Printf("Server %d (%s): Invalid si_b field\n", i+1, serverURL)
-			continue
+			serverResult.Error = "Invalid si_b field"
+			event.Type, event.Error = ServerFailed, serverResult.Error
+			progress.report(event)
+			return
 		}
 
 		// Decode si_b from base64
 		siBBytes, err := base64.StdEncoding.DecodeString(siBBase64)
 		if err != nil {
 			fmt.Printf("Server %d (%s): Failed to decode si_b: %v\n", i+1, serverURL, err)
-			continue
+			serverResult.Error = fmt.Sprintf("Failed to decode si_b: %v", err)
+			event.Type, event.Error = ServerFailed, serverResult.Error
+			progress.report(event)
+			return
 		}
 
 		// Decompress si_b from the result
 		siB4D, err := common.PointDecompress(siBBytes)
 		if err != nil {
 			fmt.Printf("Server %d (%s): Failed to decompress si_b: %v\n", i+1, serverURL, err)
-			continue
+			serverResult.Error = fmt.Sprintf("Failed to decompress si_b: %v", err)
+			event.Type, event.Error = ServerFailed, serverResult.Error
+			progress.report(event)
+			return
 		}
 
 		siB := common.Unexpand(siB4D)
@@ -529,24 +1266,111 @@ func RecoverEncryptionKeyWithServerInfo(identity *Identity, password string, ser
 			Point: siB, // This is si*B point returned by server
 		}
 
+		// A server may report the format the share was registered in; otherwise it
+		// is the version the server speaks
+		shareVersion := protocolVersions[i]
+		if recovered.ProtocolVersion != 0 {
+			shareVersion = recovered.ProtocolVersion
+		}
+		if !supportsProtocolVersion(shareVersion) {
+			serverResult.Error = fmt.Sprintf("Share has protocol version %d, this client only supports %d", shareVersion, ProtocolVersion)
+			event.Type, event.Error = ServerFailed, serverResult.Error
+			progress.report(event)
+			mu.Lock()
+			versionRefused = true
+			mu.Unlock()
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
 		recoveredPointShares = append(recoveredPointShares, pointShare)
+		recoveredURLs = append(recoveredURLs, serverURL)
+		if session != nil {
+			session.add(serverURL, pointShare, rInv)
+		}
+		serverResult.Success = true
+		event.Type, event.Shares = ServerSucceeded, len(recoveredPointShares)
+		progress.report(event)
+		if len(recoveredPointShares) == threshold {
+			progress.report(ProgressEvent{Type: ThresholdMet, Shares: threshold, Threshold: threshold})
+		}
+		if len(recoveredPointShares) >= wanted {
+			stopFanOut()
+		}
+		logger.Debug("recovery succeeded", "server", serverURL, "latency", serverResult.Latency)
 		fmt.Printf("OpenADP: Recovered share %d from server %d (%s)\n", int(x), i+1, serverURL)
 	}
+	forEachNeeded(fanOut, len(clients), opts.maxConcurrency(len(clients)), &mu, func() int {
+		return max(wanted-len(recoveredPointShares), 0)
+	}, recoverShare)
+	stopFanOut()
+
+	for _, i := range liveIndexes {
+		if serverResult := serverResults[i]; !serverResult.Success && serverResult.Error != "" {
+			logger.Warn("recovery failed", "server", serverResult.URL, "latency", serverResult.Latency, "error", serverResult.Error)
+		}
+	}
 
+	opts.metrics().ThresholdEvaluated(OperationRecover, len(recoveredPointShares), threshold)
 	if len(recoveredPointShares) < threshold {
+		logger.Warn("recovery threshold not met", "recovered", len(recoveredPointShares), "threshold", threshold, "guesses_exhausted", guessesExhausted)
+		errCode := ErrThresholdNotMet
+		errMsg := fmt.Sprintf("Could not recover enough shares (got %d, need at least %d)", len(recoveredPointShares), threshold)
+		if guessesExhausted {
+			errCode = ErrGuessesExhausted
+			errMsg = "Too many attempts: the backup is locked. " + errMsg
+			if lockedIndefinitely {
+				retryAfter = time.Time{}
+			} else {
+				errMsg += fmt.Sprintf("; try again after %s", retryAfter.UTC().Format(time.RFC3339))
+			}
+		} else if replayRejected {
+			errCode = ErrReplayRejected
+			errMsg = "Servers rejected requests as replayed or stale; check the system clock. " + errMsg
+		} else if versionRefused {
+			errCode = ErrMixedProtocolVersions
+			errMsg = fmt.Sprintf("Servers speak or returned shares of protocol versions other than %d, the only one this client supports. ", ProtocolVersion) + errMsg
+		}
 		return &RecoverEncryptionKeyResult{
-			Error: fmt.Sprintf("Could not recover enough shares (got %d, need at least %d)", len(recoveredPointShares), threshold),
+			Error:                 errMsg,
+			ErrCode:               errCode,
+			RetryAfter:            retryAfter,
+			ServerResults:         serverResults,
+			Consistency:           consistency,
+			SharesCollected:       len(recoveredPointShares),
+			SharesNeeded:          threshold,
+			GuessCounts:           guessCounts,
+			GuessCounterAnomalies: anomalies,
 		}
 	}
 
-	// Step 6: Reconstruct secret using point-based recovery (like Python recover_sb)
-	fmt.Printf("OpenADP: Reconstructing secret from %d point shares...\n", len(recoveredPointShares))
+	logger.Info("recovery threshold met", "recovered", len(recoveredPointShares), "threshold", threshold)
+
+	// Step 7: Reconstruct secret using point-based recovery (like Python recover_sb)
+	usedShares, sharesUsed := selectShares(recoveredPointShares, recoveredURLs, threshold)
+	fmt.Printf("OpenADP: Reconstructing secret from %d point shares...\n", len(usedShares))
 
 	// Use point-based Lagrange interpolation to recover s*B (like Python recover_sb)
-	recoveredSB, err := RecoverPointSecret(recoveredPointShares)
+	recoveredSB, err := RecoverPointSecret(usedShares)
 	if err != nil {
 		return &RecoverEncryptionKeyResult{
-			Error: fmt.Sprintf("Failed to reconstruct point secret: %v", err),
+			Error:         fmt.Sprintf("Failed to reconstruct point secret: %v", err),
+			ErrCode:       ErrInternal,
+			ServerResults: serverResults,
+		}
+	}
+	if err := verifyReconstruction(recoveredSB, recoveredPointShares, recoveredURLs, sharesUsed); err != nil {
+		logger.Warn("reconstruction failed verification", "error", err.Error())
+		return &RecoverEncryptionKeyResult{
+			Error:                 fmt.Sprintf("Reconstructed secret failed verification: %v", err),
+			ErrCode:               ErrInconsistentServers,
+			ServerResults:         serverResults,
+			Consistency:           consistency,
+			SharesCollected:       len(recoveredPointShares),
+			SharesNeeded:          threshold,
+			GuessCounts:           guessCounts,
+			GuessCounterAnomalies: anomalies,
 		}
 	}
 
@@ -555,13 +1379,117 @@ func RecoverEncryptionKeyWithServerInfo(identity *Identity, password string, ser
 	recoveredSB4D := common.Expand(recoveredSB)
 	originalSU := common.PointMul(rInv, recoveredSB4D)
 
-	// Step 7: Derive same encryption key
-	encKey := common.DeriveEncKey(originalSU)
+	// Step 8: Derive same encryption key
+	masterKey := common.DeriveEncKeyWithLength(originalSU, opts.keyLength())
+	encKey := opts.boundKey(masterKey, identity)
+	if !opts.commitmentMatches(encKey) {
+		zeroize(masterKey)
+		zeroize(encKey)
+		logger.Warn("recovered key does not match the key commitment")
+		return &RecoverEncryptionKeyResult{
+			Error:                 keyCommitmentMismatchMessage,
+			ErrCode:               ErrKeyCommitmentMismatch,
+			ServerResults:         serverResults,
+			Consistency:           consistency,
+			SharesCollected:       len(recoveredPointShares),
+			SharesNeeded:          threshold,
+			GuessCounts:           guessCounts,
+			GuessCounterAnomalies: anomalies,
+		}
+	}
+	if cache != nil {
+		cache.put(fingerprint, masterKey)
+	}
 	fmt.Println("OpenADP: Successfully recovered encryption key")
 
+	version := 0
+	for _, url := range sharesUsed {
+		version = max(version, versions[url])
+	}
+
 	return &RecoverEncryptionKeyResult{
-		EncryptionKey: encKey,
+		EncryptionKey:         encKey,
+		ServerResults:         serverResults,
+		Consistency:           consistency,
+		SharesCollected:       len(recoveredPointShares),
+		SharesNeeded:          threshold,
+		SharesUsed:            sharesUsed,
+		GuessCounts:           guessCounts,
+		GuessCounterAnomalies: anomalies,
+		BID:                   identity.BID,
+		Version:               version,
+		ProtocolVersion:       ProtocolVersion,
+	}
+}
+
+// selectShares returns the threshold shares to reconstruct from, taken from the
+// servers with the smallest URLs, and those URLs in order. Any threshold valid
+// shares reconstruct the same secret; choosing them independently of which
+// servers answered first makes the computation reproducible across runs and
+// implementations.
+func selectShares(shares []*PointShare, urls []string, threshold int) ([]*PointShare, []string) {
+	order := make([]int, len(shares))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return urls[order[a]] < urls[order[b]] })
+
+	selected := make([]*PointShare, threshold)
+	selectedURLs := make([]string, threshold)
+	for i, j := range order[:threshold] {
+		selected[i], selectedURLs[i] = shares[j], urls[j]
+	}
+	return selected, selectedURLs
+}
+
+// verifyReconstruction checks secret, reconstructed from the shares of the
+// servers in used, before a key is derived from it. It must be a valid point,
+// and each share beyond the threshold must reconstruct the same point in place
+// of one of the shares used: a share that does not lies off the polynomial of
+// the others, so some share was corrupted and the key cannot be trusted.
+func verifyReconstruction(secret *common.Point2D, shares []*PointShare, urls, used []string) error {
+	if !common.IsValidPoint(common.Expand(secret)) {
+		return errors.New("not a valid point")
+	}
+
+	inUse := make(map[string]bool, len(used))
+	for _, url := range used {
+		inUse[url] = true
+	}
+	var others []*PointShare // The shares used, but one
+	for i, share := range shares {
+		if inUse[urls[i]] && len(others) < len(used)-1 {
+			others = append(others, share)
+		}
+	}
+	for i, share := range shares {
+		if inUse[urls[i]] {
+			continue
+		}
+		check, err := RecoverPointSecret(append(append([]*PointShare(nil), others...), share))
+		if err != nil {
+			return fmt.Errorf("share of %s: %v", urls[i], err)
+		}
+		if check.X.Cmp(secret.X) != 0 || check.Y.Cmp(secret.Y) != 0 {
+			return fmt.Errorf("share of %s disagrees with the shares of %s", urls[i], strings.Join(used, ", "))
+		}
 	}
+	return nil
+}
+
+// PasswordToPin converts a password into the PIN bytes that are hashed together with
+// the identity into the OpenADP point U = H(UID, DID, BID, pin).
+//
+// The password is normalized to Unicode NFC first, so the same password typed with a
+// precomposed character ("\u00e9") or a combining sequence ("e\u0301") yields the same
+// PIN on every platform. Passwords that are already NFC, including all ASCII
+// passwords, produce exactly the raw UTF-8 bytes used by earlier versions.
+//
+// Migration: backups registered before normalization was introduced used the raw
+// UTF-8 bytes of the password. If such a password contained non-NFC sequences, it can
+// only be recovered with Options.RawPassword set, which bypasses this function.
+func PasswordToPin(password string) []byte {
+	return []byte(norm.NFC.String(password))
 }
 
 // Helper functions
@@ -579,17 +1507,40 @@ func min(a, b int) int {
 	return b
 }
 
-// AuthCodes represents authentication codes for OpenADP servers
+// AuthCodes represents authentication codes for OpenADP servers.
+//
+// An AuthCodes may be shared by goroutines, for example by parallel recoveries,
+// as long as ServerAuthCodes is not modified directly while it is in use: the
+// package only reads the codes, through ForServer, and never modifies an
+// AuthCodes it was given. Code that updates the codes of a shared AuthCodes must
+// use SetServer, or update a Clone and swap it in.
 type AuthCodes struct {
 	BaseAuthCode    string            `json:"base_auth_code"`
 	ServerAuthCodes map[string]string `json:"server_auth_codes"`
+
+	mu sync.RWMutex // Guards ServerAuthCodes in ForServer, SetServer and Clone
 }
 
 // GenerateAuthCodes generates authentication codes for OpenADP servers.
 //
 // This creates a base authentication code (256-bit SHA256 hash) and derives server-specific codes
 // for each server URL, matching the expected 64-character hex format.
+//
+// It panics if crypto/rand fails, since it has no way to report the error; use
+// GenerateAuthCodesWithOpts to handle it.
 func GenerateAuthCodes(serverURLs []string) *AuthCodes {
+	authCodes, err := GenerateAuthCodesWithOpts(serverURLs, nil)
+	if err != nil {
+		// SECURITY: Never use deterministic fallback for cryptographic operations
+		panic(fmt.Sprintf("CRITICAL: %v. Cannot continue with insecure operations.", err))
+	}
+	return authCodes
+}
+
+// GenerateAuthCodesWithOpts is like GenerateAuthCodes but draws the base
+// authentication code from opts.Rand when set, and returns an error if the
+// randomness source fails instead of panicking.
+func GenerateAuthCodesWithOpts(serverURLs []string, opts *Options) (*AuthCodes, error) {
 	// Generate base authentication code (256 bits = 32 bytes as hex = 64 chars)
 	var baseAuthCode string
 
@@ -599,13 +1550,20 @@ func GenerateAuthCodes(serverURLs []string) *AuthCodes {
 	} else {
 		// In normal mode, use cryptographically secure random
 		baseBytes := make([]byte, 32)
-		if _, err := rand.Read(baseBytes); err != nil {
+		if _, err := io.ReadFull(opts.randReader(), baseBytes); err != nil {
 			// SECURITY: Never use deterministic fallback for cryptographic operations
-			panic(fmt.Sprintf("CRITICAL: Cryptographic random number generation failed: %v. Cannot continue with insecure operations.", err))
+			return nil, fmt.Errorf("random number generation failed: %w", err)
 		}
 		baseAuthCode = fmt.Sprintf("%x", baseBytes)
 	}
 
+	return AuthCodesFromBase(baseAuthCode, serverURLs), nil
+}
+
+// AuthCodesFromBase derives the auth code of each server from the base auth code
+// as GenerateAuthCodes does: the hex SHA-256 of "<base>:<server URL>". It
+// restores the full AuthCodes of a backup from its BaseAuthCode alone.
+func AuthCodesFromBase(baseAuthCode string, serverURLs []string) *AuthCodes {
 	// Generate server-specific authentication codes using SHA256
 	serverAuthCodes := make(map[string]string)
 	for _, serverURL := range serverURLs {
@@ -653,17 +1611,13 @@ func FetchRemainingGuessesForServers(identity *Identity, serverInfos []ServerInf
 
 		if err := client.Ping(); err == nil {
 			// List backups to get remaining guesses
-			backups, err := client.ListBackups(identity.UID, false, nil)
+			backups, err := client.listBackups(identity.UID, false, nil)
 			if err == nil {
 				// Find our specific backup
 				remainingGuesses := -1 // Default to unknown
 				for _, backup := range backups {
-					if backup["uid"] == identity.UID &&
-						backup["did"] == identity.DID &&
-						backup["bid"] == identity.BID {
-						numGuesses, _ := backup["num_guesses"].(float64)
-						maxGuesses, _ := backup["max_guesses"].(float64)
-						remainingGuesses = int(math.Max(0, maxGuesses-numGuesses))
+					if backup.UID == identity.UID && backup.DID == identity.DID && backup.BID == identity.BID {
+						remainingGuesses = max(0, backup.MaxGuesses-backup.NumGuesses)
 						break
 					}
 				}
@@ -1,9 +1,33 @@
 package client
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/openadp/ocrypt/common"
+	"github.com/openadp/ocrypt/testserver"
 )
 
+// seededReader returns a deterministic, non-cryptographic byte stream for tests
+func seededReader(seed byte) *rand.ChaCha8 {
+	var s [32]byte
+	s[0] = seed
+	return rand.NewChaCha8(s)
+}
+
 // Test individual keygen functions without server dependencies
 
 func TestIdentity(t *testing.T) {
@@ -103,6 +127,43 @@ func TestGenerateAuthCodes(t *testing.T) {
 	}
 }
 
+func TestGenerateAuthCodesRand(t *testing.T) {
+	serverURLs := []string{"https://server1.com", "https://server2.com"}
+
+	a, _ := GenerateAuthCodesWithOpts(serverURLs, &Options{Rand: seededReader(1)})
+	b, _ := GenerateAuthCodesWithOpts(serverURLs, &Options{Rand: seededReader(1)})
+	if a.BaseAuthCode != b.BaseAuthCode {
+		t.Errorf("Same seed produced different base auth codes: %s vs %s", a.BaseAuthCode, b.BaseAuthCode)
+	}
+	for _, url := range serverURLs {
+		if a.ServerAuthCodes[url] != b.ServerAuthCodes[url] {
+			t.Errorf("Same seed produced different auth codes for %s", url)
+		}
+	}
+
+	c, _ := GenerateAuthCodesWithOpts(serverURLs, &Options{Rand: seededReader(2)})
+	if a.BaseAuthCode == c.BaseAuthCode {
+		t.Error("Different seeds produced the same base auth code")
+	}
+
+	// The default path must still use crypto/rand
+	if GenerateAuthCodes(serverURLs).BaseAuthCode == GenerateAuthCodes(serverURLs).BaseAuthCode {
+		t.Error("GenerateAuthCodes() produced the same base auth code twice")
+	}
+
+	// A failing source is reported rather than panicking
+	short := &Options{Rand: io.LimitReader(seededReader(1), 16)}
+	if codes, err := GenerateAuthCodesWithOpts(serverURLs, short); err == nil || codes != nil {
+		t.Errorf("GenerateAuthCodesWithOpts() with an exhausted source = %v, %v, want an error", codes, err)
+	}
+	_, serverInfos := newTestServers(t, 2)
+	identity := &Identity{UID: "user", DID: "app", BID: "even"}
+	result := GenerateEncryptionKeyWithOpts(identity, "password", 10, 0, serverInfos, &Options{Rand: io.LimitReader(seededReader(1), 16)})
+	if result.ErrCode != ErrInternal {
+		t.Errorf("Key generation with an exhausted source: ErrCode = %q (%s), want %q", result.ErrCode, result.Error, ErrInternal)
+	}
+}
+
 func TestGenerateEncryptionKeyInputValidation(t *testing.T) {
 	tests := []struct {
 		name       string
@@ -112,6 +173,7 @@ func TestGenerateEncryptionKeyInputValidation(t *testing.T) {
 		expiration int
 		serverURLs []string
 		wantError  bool
+		wantCode   ErrCode
 	}{
 		{
 			name:       "nil identity",
@@ -121,6 +183,7 @@ func TestGenerateEncryptionKeyInputValidation(t *testing.T) {
 			expiration: 0,
 			serverURLs: []string{"http://server1.com"},
 			wantError:  true,
+			wantCode:   ErrInvalidIdentity,
 		},
 		{
 			name:       "empty UID",
@@ -130,6 +193,7 @@ func TestGenerateEncryptionKeyInputValidation(t *testing.T) {
 			expiration: 0,
 			serverURLs: []string{"http://server1.com"},
 			wantError:  true,
+			wantCode:   ErrInvalidIdentity,
 		},
 		{
 			name:       "empty DID",
@@ -139,6 +203,7 @@ func TestGenerateEncryptionKeyInputValidation(t *testing.T) {
 			expiration: 0,
 			serverURLs: []string{"http://server1.com"},
 			wantError:  true,
+			wantCode:   ErrInvalidIdentity,
 		},
 		{
 			name:       "empty BID",
@@ -148,6 +213,7 @@ func TestGenerateEncryptionKeyInputValidation(t *testing.T) {
 			expiration: 0,
 			serverURLs: []string{"http://server1.com"},
 			wantError:  true,
+			wantCode:   ErrInvalidIdentity,
 		},
 		{
 			name:       "negative max guesses",
@@ -157,6 +223,7 @@ func TestGenerateEncryptionKeyInputValidation(t *testing.T) {
 			expiration: 0,
 			serverURLs: []string{"http://server1.com"},
 			wantError:  true,
+			wantCode:   ErrInvalidInput,
 		},
 		{
 			name:       "no servers",
@@ -166,6 +233,7 @@ func TestGenerateEncryptionKeyInputValidation(t *testing.T) {
 			expiration: 0,
 			serverURLs: []string{},
 			wantError:  true,
+			wantCode:   ErrInsufficientServers,
 		},
 		{
 			name:       "valid inputs (will fail at server connection)",
@@ -175,13 +243,14 @@ func TestGenerateEncryptionKeyInputValidation(t *testing.T) {
 			expiration: 0,
 			serverURLs: []string{"http://localhost:9999"}, // non-existent server
 			wantError:  true,                              // Should fail at connectivity test
+			wantCode:   ErrNetwork,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			result := GenerateEncryptionKey(tt.identity, tt.password,
-				tt.maxGuesses, tt.expiration, ConvertURLsToServerInfo(tt.serverURLs))
+				tt.maxGuesses, tt.expiration, insecureServerInfos(t, tt.serverURLs))
 
 			if tt.wantError && result.Error == "" {
 				t.Errorf("GenerateEncryptionKey() expected error but got none")
@@ -189,6 +258,51 @@ func TestGenerateEncryptionKeyInputValidation(t *testing.T) {
 			if !tt.wantError && result.Error != "" {
 				t.Errorf("GenerateEncryptionKey() unexpected error: %s", result.Error)
 			}
+			if result.ErrCode != tt.wantCode {
+				t.Errorf("GenerateEncryptionKey() ErrCode = %q, want %q", result.ErrCode, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestGenerateEncryptionKeyLimits(t *testing.T) {
+	servers, serverInfos := newTestServers(t, 3)
+	servers[1].SetServerInfo("max_expiration", 3600)
+	identity := &Identity{UID: "user", DID: "app", BID: "even"}
+	inHalfHour := int(time.Now().Add(30 * time.Minute).Unix())
+	inTwoHours := int(time.Now().Add(2 * time.Hour).Unix())
+
+	tests := []struct {
+		name       string
+		maxGuesses int
+		expiration int
+		opts       *Options
+		wantCode   ErrCode
+		wantField  InputField
+	}{
+		{"zero guesses", 0, inHalfHour, nil, ErrInvalidInput, FieldMaxGuesses},
+		{"one guess", 1, inHalfHour, nil, ErrNone, ""},
+		{"default limit", DefaultMaxGuessesLimit, inHalfHour, nil, ErrNone, ""},
+		{"over default limit", DefaultMaxGuessesLimit + 1, inHalfHour, nil, ErrInvalidInput, FieldMaxGuesses},
+		{"custom limit", 5, inHalfHour, &Options{MaxGuessesLimit: 5}, ErrNone, ""},
+		{"over custom limit", 6, inHalfHour, &Options{MaxGuessesLimit: 5}, ErrInvalidInput, FieldMaxGuesses},
+		{"negative limit", 5, inHalfHour, &Options{MaxGuessesLimit: -1}, ErrInvalidInput, FieldOptions},
+		{"past expiration", 10, 1, nil, ErrInvalidInput, FieldExpiration},
+		{"beyond server maximum", 10, inTwoHours, nil, ErrInvalidInput, FieldExpiration},
+		{"never expires", 10, 0, nil, ErrInvalidInput, FieldExpiration},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := ValidateGenerationWithOpts(identity, tt.maxGuesses, tt.expiration, serverInfos, tt.opts)
+			if report.ErrCode != tt.wantCode || report.Field != tt.wantField {
+				t.Errorf("ValidateGenerationWithOpts() = %q on %q, want %q on %q (error: %s)", report.ErrCode, report.Field, tt.wantCode, tt.wantField, report.Error)
+			}
+
+			result := GenerateEncryptionKeyWithOpts(identity, "password", tt.maxGuesses, tt.expiration, serverInfos, tt.opts)
+			if result.ErrCode != tt.wantCode || result.Field != tt.wantField {
+				t.Errorf("GenerateEncryptionKeyWithOpts() = %q on %q, want %q on %q (error: %s)", result.ErrCode, result.Field, tt.wantCode, tt.wantField, result.Error)
+			}
 		})
 	}
 }
@@ -202,6 +316,7 @@ func TestRecoverEncryptionKeyInputValidation(t *testing.T) {
 		threshold   int
 		authCodes   *AuthCodes
 		wantError   bool
+		wantCode    ErrCode
 	}{
 		{
 			name:        "nil identity",
@@ -211,6 +326,7 @@ func TestRecoverEncryptionKeyInputValidation(t *testing.T) {
 			threshold:   1,
 			authCodes:   &AuthCodes{},
 			wantError:   true,
+			wantCode:    ErrInvalidIdentity,
 		},
 		{
 			name:        "empty UID",
@@ -220,6 +336,7 @@ func TestRecoverEncryptionKeyInputValidation(t *testing.T) {
 			threshold:   1,
 			authCodes:   &AuthCodes{},
 			wantError:   true,
+			wantCode:    ErrInvalidIdentity,
 		},
 		{
 			name:        "empty DID",
@@ -229,6 +346,7 @@ func TestRecoverEncryptionKeyInputValidation(t *testing.T) {
 			threshold:   1,
 			authCodes:   &AuthCodes{},
 			wantError:   true,
+			wantCode:    ErrInvalidIdentity,
 		},
 		{
 			name:        "empty BID",
@@ -238,6 +356,7 @@ func TestRecoverEncryptionKeyInputValidation(t *testing.T) {
 			threshold:   1,
 			authCodes:   &AuthCodes{},
 			wantError:   true,
+			wantCode:    ErrInvalidIdentity,
 		},
 		{
 			name:        "zero threshold",
@@ -247,6 +366,7 @@ func TestRecoverEncryptionKeyInputValidation(t *testing.T) {
 			threshold:   0,
 			authCodes:   &AuthCodes{},
 			wantError:   true,
+			wantCode:    ErrInvalidInput,
 		},
 		{
 			name:        "negative threshold",
@@ -256,6 +376,7 @@ func TestRecoverEncryptionKeyInputValidation(t *testing.T) {
 			threshold:   -1,
 			authCodes:   &AuthCodes{},
 			wantError:   true,
+			wantCode:    ErrInvalidInput,
 		},
 		{
 			name:        "no servers",
@@ -265,6 +386,7 @@ func TestRecoverEncryptionKeyInputValidation(t *testing.T) {
 			threshold:   1,
 			authCodes:   &AuthCodes{},
 			wantError:   true,
+			wantCode:    ErrInsufficientServers,
 		},
 		{
 			name:        "nil auth codes",
@@ -274,6 +396,7 @@ func TestRecoverEncryptionKeyInputValidation(t *testing.T) {
 			threshold:   1,
 			authCodes:   nil,
 			wantError:   true,
+			wantCode:    ErrInvalidInput,
 		},
 	}
 
@@ -288,6 +411,9 @@ func TestRecoverEncryptionKeyInputValidation(t *testing.T) {
 			if !tt.wantError && result.Error != "" {
 				t.Errorf("RecoverEncryptionKeyWithServerInfo() unexpected error: %s", result.Error)
 			}
+			if result.ErrCode != tt.wantCode {
+				t.Errorf("RecoverEncryptionKeyWithServerInfo() ErrCode = %q, want %q", result.ErrCode, tt.wantCode)
+			}
 		})
 	}
 }
@@ -341,14 +467,31 @@ func TestMaxMin(t *testing.T) {
 	}
 }
 
-// Integration test that requires real servers - keep as a separate test that can be skipped
-func TestKeygenRoundTrip(t *testing.T) {
-	// Skip if running in CI or if servers not available
-	if testing.Short() {
-		t.Skip("Skipping integration test in short mode")
+// newTestServers starts n in-memory OpenADP servers with Noise-NK keys
+func newTestServers(t testing.TB, n int) ([]*testserver.Server, []ServerInfo) {
+	servers := make([]*testserver.Server, n)
+	serverInfos := make([]ServerInfo, n)
+	for i := range servers {
+		servers[i] = testserver.New()
+		t.Cleanup(servers[i].Close)
+		serverInfos[i] = ServerInfo{URL: servers[i].URL, PublicKey: servers[i].PublicKeyString()}
 	}
+	return servers, serverInfos
+}
+
+// insecureServerInfos converts the plaintext http:// URLs of local mock servers
+func insecureServerInfos(t *testing.T, urls []string) []ServerInfo {
+	t.Helper()
+	serverInfos, err := ConvertURLsToServerInfoStrict(urls, &Options{AllowInsecure: true})
+	if err != nil {
+		t.Fatalf("ConvertURLsToServerInfoStrict() error: %v", err)
+	}
+	return serverInfos
+}
+
+func TestKeygenRoundTrip(t *testing.T) {
+	_, serverInfos := newTestServers(t, 3)
 
-	// Test the complete keygen round trip using actual functions
 	identity := &Identity{
 		UID: "test-user-id-fixed",
 		DID: "test-app",
@@ -357,45 +500,1162 @@ func TestKeygenRoundTrip(t *testing.T) {
 	password := "test-password-123"
 	maxGuesses := 10
 	expiration := 0
-	serverURLs := []string{
-		"http://localhost:9200",
-		"http://localhost:9201",
-		"http://localhost:9202",
-	}
 
 	// Step 1: Generate encryption key
-	t.Log("🔐 Generating encryption key...")
-	result := GenerateEncryptionKey(identity, password, maxGuesses, expiration, ConvertURLsToServerInfo(serverURLs))
+	result := GenerateEncryptionKey(identity, password, maxGuesses, expiration, serverInfos)
 	if result.Error != "" {
-		t.Skipf("Key generation failed (servers not available): %s", result.Error)
+		t.Fatalf("Key generation failed: %s", result.Error)
+	}
+	if len(result.ServerURLs) != 3 || result.Threshold != 2 {
+		t.Fatalf("Used %d servers with threshold %d, want 3 servers with threshold 2", len(result.ServerURLs), result.Threshold)
 	}
-
-	t.Logf("✅ Generated key: %x", result.EncryptionKey[:16])
-	t.Logf("✅ Used %d servers with threshold %d", len(result.ServerURLs), result.Threshold)
 
 	// Step 2: Recover encryption key
-	t.Log("🔓 Recovering encryption key...")
-	serverInfos := ConvertURLsToServerInfo(result.ServerURLs)
 	recoveryResult := RecoverEncryptionKeyWithServerInfo(identity, password, serverInfos, result.Threshold, result.AuthCodes)
 	if recoveryResult.Error != "" {
 		t.Fatalf("Key recovery failed: %s", recoveryResult.Error)
 	}
 
-	t.Logf("✅ Recovered key: %x", recoveryResult.EncryptionKey[:16])
-
 	// Step 3: Verify keys match
-	if len(result.EncryptionKey) != len(recoveryResult.EncryptionKey) {
-		t.Errorf("Key lengths don't match: original=%d, recovered=%d", len(result.EncryptionKey), len(recoveryResult.EncryptionKey))
+	if !bytes.Equal(result.EncryptionKey, recoveryResult.EncryptionKey) {
+		t.Errorf("Keys don't match: original=%x, recovered=%x", result.EncryptionKey, recoveryResult.EncryptionKey)
+	}
+	if recoveryResult.BID != identity.BID || recoveryResult.Version != 1 {
+		t.Errorf("Recovered BID %q version %d, want %q version 1", recoveryResult.BID, recoveryResult.Version, identity.BID)
+	}
+
+	// A wrong password recovers a different key
+	wrongResult := RecoverEncryptionKeyWithServerInfo(identity, "wrong-password", serverInfos, result.Threshold, result.AuthCodes)
+	if wrongResult.Error != "" {
+		t.Fatalf("Recovery with wrong password failed: %s", wrongResult.Error)
+	}
+	if bytes.Equal(result.EncryptionKey, wrongResult.EncryptionKey) {
+		t.Error("Wrong password recovered the original key")
+	}
+}
+
+// TestKeygenRoundTripConcurrent shares one AuthCodes between parallel recoveries
+// while another goroutine updates it; run it with -race
+func TestKeygenRoundTripConcurrent(t *testing.T) {
+	_, serverInfos := newTestServers(t, 3)
+
+	// Generating from the same seed gives every backup the same auth codes
+	const backups = 4
+	var authCodes *AuthCodes
+	keys := make([][]byte, backups)
+	for i := range keys {
+		identity := &Identity{UID: "user", DID: "app", BID: fmt.Sprintf("concurrent-%d", i)}
+		seed := [32]byte{byte(backups)}
+		generated := GenerateEncryptionKeyWithOpts(identity, "password", 10, 0, serverInfos, &Options{Rand: rand.NewChaCha8(seed)})
+		if generated.Error != "" {
+			t.Fatalf("Key generation failed: %s", generated.Error)
+		}
+		if authCodes == nil {
+			authCodes = generated.AuthCodes
+		} else if generated.AuthCodes.BaseAuthCode != authCodes.BaseAuthCode {
+			t.Fatal("Seeded generations produced different auth codes")
+		}
+		keys[i] = generated.EncryptionKey
+	}
+
+	done := make(chan struct{})
+	writer := make(chan struct{})
+	go func() {
+		defer close(writer)
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			authCodes.SetServer("https://spare.example.com", fmt.Sprint(i))
+			authCodes.Clone()
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := range keys {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			identity := &Identity{UID: "user", DID: "app", BID: fmt.Sprintf("concurrent-%d", i)}
+			recovered := RecoverEncryptionKeyWithServerInfo(identity, "password", serverInfos, 2, authCodes)
+			if recovered.Error != "" {
+				t.Errorf("Recovery %d failed: %s", i, recovered.Error)
+			} else if !bytes.Equal(recovered.EncryptionKey, keys[i]) {
+				t.Errorf("Recovery %d: keys don't match", i)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(done)
+	<-writer
+}
+
+func TestKeygenRoundTripFaults(t *testing.T) {
+	identity := &Identity{UID: "user", DID: "app", BID: "even"}
+	password := "password"
+
+	tests := []struct {
+		name     string
+		faults   map[int]testserver.Fault // server index -> fault on RecoverSecret or ListBackups
+		method   string
+		wantCode ErrCode
+	}{
+		{"stale guess counter on all servers", map[int]testserver.Fault{0: testserver.FaultStaleGuessCounter, 1: testserver.FaultStaleGuessCounter, 2: testserver.FaultStaleGuessCounter}, "ListBackups", ErrNone},
+		{"one server drops connection", map[int]testserver.Fault{0: testserver.FaultDropConnection}, "RecoverSecret", ErrNone},
+		{"two servers drop connection", map[int]testserver.Fault{0: testserver.FaultDropConnection, 2: testserver.FaultDropConnection}, "RecoverSecret", ErrThresholdNotMet},
+		{"two servers unavailable", map[int]testserver.Fault{1: testserver.FaultUnavailable, 2: testserver.FaultUnavailable}, "RecoverSecret", ErrThresholdNotMet},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			servers, serverInfos := newTestServers(t, 3)
+
+			result := GenerateEncryptionKey(identity, password, 10, 0, serverInfos)
+			if result.Error != "" {
+				t.Fatalf("Key generation failed: %s", result.Error)
+			}
+
+			// Consume one guess so that a stale counter differs from the true one
+			first := RecoverEncryptionKeyWithServerInfo(identity, password, serverInfos, result.Threshold, result.AuthCodes)
+			if first.Error != "" {
+				t.Fatalf("First recovery failed: %s", first.Error)
+			}
+
+			for i, fault := range tt.faults {
+				servers[i].SetFault(tt.method, fault)
+			}
+
+			recovered := RecoverEncryptionKeyWithServerInfo(identity, password, serverInfos, result.Threshold, result.AuthCodes)
+			if recovered.ErrCode != tt.wantCode {
+				t.Fatalf("ErrCode = %q, want %q (error: %s)", recovered.ErrCode, tt.wantCode, recovered.Error)
+			}
+			if tt.wantCode == ErrNone && !bytes.Equal(result.EncryptionKey, recovered.EncryptionKey) {
+				t.Error("Recovered key does not match the generated key")
+			}
+		})
+	}
+}
+
+func TestRecoverEncryptionKeyGuessesExhausted(t *testing.T) {
+	tests := []struct {
+		name          string
+		cooldowns     []time.Duration // Per server
+		wantCooldown  time.Duration   // Zero for no RetryAfter
+		wantRetryHint bool
+	}{
+		{"no cooldown", []time.Duration{0, 0, 0}, 0, false},
+		{"cooldown", []time.Duration{time.Hour, time.Hour, time.Hour}, time.Hour, true},
+		{"latest cooldown", []time.Duration{time.Hour, 2 * time.Hour, time.Hour}, 2 * time.Hour, true},
+		{"one server without cooldown", []time.Duration{time.Hour, 0, time.Hour}, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			servers, serverInfos := newTestServers(t, 3)
+			identity := &Identity{UID: "user", DID: "app", BID: "even"}
+			for i, server := range servers {
+				server.SetGuessCooldown(tt.cooldowns[i])
+			}
+
+			result := GenerateEncryptionKey(identity, "password", 2, 0, serverInfos)
+			if result.Error != "" {
+				t.Fatalf("Key generation failed: %s", result.Error)
+			}
+
+			for i := 0; i < 2; i++ {
+				RecoverEncryptionKeyWithServerInfo(identity, "wrong", serverInfos, result.Threshold, result.AuthCodes)
+			}
+			if n := servers[0].NumGuesses(identity.UID, identity.DID, identity.BID); n != 2 {
+				t.Fatalf("NumGuesses = %d after two attempts, want 2", n)
+			}
+			locked := time.Now()
+
+			// Exhaustion is never retried
+			opts := &Options{Retry: &RetryPolicy{MaxAttempts: 5, BaseDelay: time.Second}}
+			start := time.Now()
+			recovered := RecoverEncryptionKeyWithOpts(identity, "password", serverInfos, result.Threshold, result.AuthCodes, opts)
+			if elapsed := time.Since(start); elapsed >= time.Second {
+				t.Errorf("Recovery took %v, want no retry delay", elapsed)
+			}
+			if recovered.ErrCode != ErrGuessesExhausted {
+				t.Fatalf("ErrCode = %q, want %q (error: %s)", recovered.ErrCode, ErrGuessesExhausted, recovered.Error)
+			}
+			if n := servers[0].NumGuesses(identity.UID, identity.DID, identity.BID); n != 2 {
+				t.Errorf("NumGuesses = %d after the locked attempt, want 2", n)
+			}
+
+			if tt.wantCooldown == 0 {
+				if !recovered.RetryAfter.IsZero() {
+					t.Errorf("RetryAfter = %v, want zero", recovered.RetryAfter)
+				}
+			} else if want := locked.Add(tt.wantCooldown); recovered.RetryAfter.Before(want.Add(-5*time.Second)) || recovered.RetryAfter.After(want) {
+				t.Errorf("RetryAfter = %v, want about %v", recovered.RetryAfter, want)
+			}
+			if hint := strings.Contains(recovered.Error, "try again after"); hint != tt.wantRetryHint {
+				t.Errorf("Error = %q, want a retry hint: %v", recovered.Error, tt.wantRetryHint)
+			}
+		})
+	}
+}
+
+func TestRecoverEncryptionKeyServerResults(t *testing.T) {
+	servers, serverInfos := newTestServers(t, 5)
+	identity := &Identity{UID: "user", DID: "app", BID: "even"}
+
+	result := GenerateEncryptionKeyWithThreshold(identity, "password", 10, 0, 3, serverInfos)
+	if result.Error != "" {
+		t.Fatalf("Key generation failed: %s", result.Error)
+	}
+	for i, serverResult := range result.ServerResults {
+		if serverResult.Latency <= 0 {
+			t.Errorf("Registration ServerResults[%d].Latency = %v, want positive", i, serverResult.Latency)
+		}
+	}
+
+	// Server 1 goes away and server 3 is asked with the wrong auth code
+	servers[1].Close()
+	authCodes := &AuthCodes{BaseAuthCode: result.AuthCodes.BaseAuthCode, ServerAuthCodes: map[string]string{}}
+	for url, code := range result.AuthCodes.ServerAuthCodes {
+		authCodes.ServerAuthCodes[url] = code
 	}
+	authCodes.ServerAuthCodes[servers[3].URL] = "wrong"
 
-	for i := 0; i < len(result.EncryptionKey) && i < len(recoveryResult.EncryptionKey); i++ {
-		if result.EncryptionKey[i] != recoveryResult.EncryptionKey[i] {
-			t.Errorf("Keys don't match at byte %d: original=0x%02x, recovered=0x%02x", i, result.EncryptionKey[i], recoveryResult.EncryptionKey[i])
-			t.Errorf("Original key:  %x", result.EncryptionKey)
-			t.Errorf("Recovered key: %x", recoveryResult.EncryptionKey)
+	wantSuccess := []bool{true, false, true, false, true}
+
+	for _, threshold := range []int{3, 4} {
+		recovered := RecoverEncryptionKeyWithServerInfo(identity, "password", serverInfos, threshold, authCodes)
+		if threshold == 3 && recovered.Error != "" {
+			t.Fatalf("Recovery with threshold 3 failed: %s", recovered.Error)
+		}
+		if threshold == 4 && recovered.ErrCode != ErrThresholdNotMet {
+			t.Fatalf("Recovery with threshold 4 ErrCode = %q, want %q", recovered.ErrCode, ErrThresholdNotMet)
+		}
+
+		if len(recovered.ServerResults) != len(serverInfos) {
+			t.Fatalf("Got %d ServerResults, want %d", len(recovered.ServerResults), len(serverInfos))
+		}
+		for i, serverResult := range recovered.ServerResults {
+			if serverResult.URL != serverInfos[i].URL {
+				t.Errorf("ServerResults[%d].URL = %s, want %s", i, serverResult.URL, serverInfos[i].URL)
+			}
+			if serverResult.Success != wantSuccess[i] {
+				t.Errorf("ServerResults[%d].Success = %v, want %v (error: %s)", i, serverResult.Success, wantSuccess[i], serverResult.Error)
+			}
+			if !serverResult.Success && serverResult.Error == "" {
+				t.Errorf("ServerResults[%d] failed without an error reason", i)
+			}
+			if serverResult.Latency <= 0 {
+				t.Errorf("ServerResults[%d].Latency = %v, want positive", i, serverResult.Latency)
+			}
+		}
+		if !strings.Contains(recovered.ServerResults[3].Error, "invalid auth code") {
+			t.Errorf("ServerResults[3].Error = %q, want an auth code rejection", recovered.ServerResults[3].Error)
+		}
+	}
+}
+
+// mockRPCHandler answers a single JSON-RPC method call for a mock server. Returning
+// an error sends a JSON-RPC error response; returning an *httpStatusError sends a bare
+// HTTP status instead.
+type mockRPCHandler func(method string, params []interface{}) (interface{}, error)
+
+// httpStatusError makes a mock server reply with a non-200 HTTP status
+type httpStatusError int
+
+func (e httpStatusError) Error() string { return http.StatusText(int(e)) }
+
+// newMockRPCServer starts an unencrypted JSON-RPC server backed by handler
+func newMockRPCServer(t *testing.T, handler mockRPCHandler) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req JSONRPCRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
+
+		params, _ := req.Params.([]interface{})
+		result, err := handler(req.Method, params)
+
+		var statusErr httpStatusError
+		if errors.As(err, &statusErr) {
+			w.WriteHeader(int(statusErr))
+			return
+		}
+
+		response := map[string]interface{}{"jsonrpc": "2.0", "id": req.ID}
+		if err != nil {
+			response["error"] = map[string]interface{}{"code": -32000, "message": err.Error()}
+		} else {
+			response["result"] = result
+		}
+		json.NewEncoder(w).Encode(response)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// newMockRegistrationServer starts a JSON-RPC server that answers Echo and accepts
+// RegisterSecret after the given delay. If fail is set, registration returns HTTP 504
+// once the delay has elapsed, simulating a server that misses its deadline.
+func newMockRegistrationServer(t *testing.T, delay time.Duration, fail bool, inFlight, peak *int32) *httptest.Server {
+	return newMockRPCServer(t, func(method string, params []interface{}) (interface{}, error) {
+		switch method {
+		case "Echo":
+			return params[0], nil
+		case "RegisterSecret":
+			if inFlight != nil {
+				n := atomic.AddInt32(inFlight, 1)
+				defer atomic.AddInt32(inFlight, -1)
+				for {
+					p := atomic.LoadInt32(peak)
+					if n <= p || atomic.CompareAndSwapInt32(peak, p, n) {
+						break
+					}
+				}
+			}
+			time.Sleep(delay)
+			if fail {
+				return nil, httpStatusError(http.StatusGatewayTimeout)
+			}
+			return true, nil
+		}
+		return nil, httpStatusError(http.StatusNotFound)
+	})
+}
+
+func TestGenerateEncryptionKeyParallelRegistration(t *testing.T) {
+	const fastDelay = 150 * time.Millisecond
+	const slowDelay = 400 * time.Millisecond
+
+	var urls []string
+	for i := 0; i < 3; i++ {
+		urls = append(urls, newMockRegistrationServer(t, fastDelay, false, nil, nil).URL)
+	}
+	slow := newMockRegistrationServer(t, slowDelay, true, nil, nil)
+	urls = append(urls, slow.URL)
+
+	identity := &Identity{UID: "user", DID: "app", BID: "even"}
+
+	start := time.Now()
+	result := GenerateEncryptionKey(identity, "password", 10, 0, insecureServerInfos(t, urls))
+	elapsed := time.Since(start)
+
+	if result.Error != "" {
+		t.Fatalf("GenerateEncryptionKey() unexpected error: %s", result.Error)
+	}
+	if result.Threshold != 3 {
+		t.Errorf("Threshold = %d, want 3", result.Threshold)
+	}
+	if len(result.ServerResults) != len(urls) {
+		t.Fatalf("ServerResults count = %d, want %d", len(result.ServerResults), len(urls))
+	}
+
+	for i, serverResult := range result.ServerResults {
+		if serverResult.URL != urls[i] {
+			t.Errorf("ServerResults[%d].URL = %s, want %s", i, serverResult.URL, urls[i])
+		}
+		wantSuccess := serverResult.URL != slow.URL
+		if serverResult.Success != wantSuccess {
+			t.Errorf("ServerResults[%d].Success = %v, want %v (error: %s)", i, serverResult.Success, wantSuccess, serverResult.Error)
+		}
+		if !wantSuccess && serverResult.Error == "" {
+			t.Errorf("ServerResults[%d].Error is empty for failed server", i)
+		}
+	}
+
+	// Sequential registration would take at least the sum of all delays
+	if sequential := 3*fastDelay + slowDelay; elapsed >= sequential {
+		t.Errorf("GenerateEncryptionKey() took %v, want less than sequential %v", elapsed, sequential)
+	}
+}
+
+func TestGenerateEncryptionKeyMaxWorkers(t *testing.T) {
+	var inFlight, peak int32
+
+	var urls []string
+	for i := 0; i < 5; i++ {
+		urls = append(urls, newMockRegistrationServer(t, 50*time.Millisecond, false, &inFlight, &peak).URL)
+	}
+
+	identity := &Identity{UID: "user", DID: "app", BID: "even"}
+	result := GenerateEncryptionKeyWithOpts(identity, "password", 10, 0, insecureServerInfos(t, urls), &Options{MaxWorkers: 2})
+	if result.Error != "" {
+		t.Fatalf("GenerateEncryptionKeyWithOpts() unexpected error: %s", result.Error)
+	}
+
+	if peak > 2 {
+		t.Errorf("Peak concurrent registrations = %d, want at most 2", peak)
+	}
+	for i, serverResult := range result.ServerResults {
+		if !serverResult.Success {
+			t.Errorf("ServerResults[%d] failed: %s", i, serverResult.Error)
+		}
+	}
+}
+
+func TestGenerateEncryptionKeyThresholdNotMetCode(t *testing.T) {
+	urls := []string{
+		newMockRegistrationServer(t, 0, false, nil, nil).URL,
+		newMockRegistrationServer(t, 0, true, nil, nil).URL,
+		newMockRegistrationServer(t, 0, true, nil, nil).URL,
+	}
+
+	identity := &Identity{UID: "user", DID: "app", BID: "even"}
+	result := GenerateEncryptionKey(identity, "password", 10, 0, insecureServerInfos(t, urls))
+
+	if result.Error == "" {
+		t.Fatal("GenerateEncryptionKey() expected error but got none")
+	}
+	if result.ErrCode != ErrThresholdNotMet {
+		t.Errorf("GenerateEncryptionKey() ErrCode = %q, want %q", result.ErrCode, ErrThresholdNotMet)
+	}
+}
+
+func TestGenerateEncryptionKeyWithThreshold(t *testing.T) {
+	var urls []string
+	for i := 0; i < 3; i++ {
+		urls = append(urls, newMockRegistrationServer(t, 0, false, nil, nil).URL)
+	}
+	serverInfos := insecureServerInfos(t, urls)
+	identity := &Identity{UID: "user", DID: "app", BID: "even"}
+
+	tests := []struct {
+		name      string
+		threshold int
+		wantCode  ErrCode
+	}{
+		{"t=1", 1, ErrNone},
+		{"t=n", 3, ErrNone},
+		{"t>n", 4, ErrThresholdNotMet},
+		{"t=0", 0, ErrInvalidInput},
+		{"negative t", -1, ErrInvalidInput},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := GenerateEncryptionKeyWithThreshold(identity, "password", 10, 0, tt.threshold, serverInfos)
+
+			if result.ErrCode != tt.wantCode {
+				t.Fatalf("GenerateEncryptionKeyWithThreshold() ErrCode = %q, want %q (error: %s)", result.ErrCode, tt.wantCode, result.Error)
+			}
+			if tt.wantCode != ErrNone {
+				return
+			}
+			if result.Threshold != tt.threshold {
+				t.Errorf("Threshold = %d, want %d", result.Threshold, tt.threshold)
+			}
+			if len(result.ServerURLs) != len(urls) {
+				t.Errorf("Registered with %d servers, want %d", len(result.ServerURLs), len(urls))
+			}
+		})
+	}
+
+	// Only one of three servers accepts its share, so t=2 cannot be met
+	failing := []string{
+		newMockRegistrationServer(t, 0, false, nil, nil).URL,
+		newMockRegistrationServer(t, 0, true, nil, nil).URL,
+		newMockRegistrationServer(t, 0, true, nil, nil).URL,
+	}
+	result := GenerateEncryptionKeyWithThreshold(identity, "password", 10, 0, 2, insecureServerInfos(t, failing))
+	if result.ErrCode != ErrThresholdNotMet {
+		t.Errorf("GenerateEncryptionKeyWithThreshold() ErrCode = %q, want %q", result.ErrCode, ErrThresholdNotMet)
+	}
+}
+
+func TestGenerateEncryptionKeyMinServers(t *testing.T) {
+	servers, serverInfos := newTestServers(t, 3)
+	identity := &Identity{UID: "user", DID: "app", BID: "even"}
+
+	tests := []struct {
+		name       string
+		servers    int
+		down       int // Servers that are unreachable
+		minServers int
+		threshold  int
+		wantCode   ErrCode
+		wantWarn   int // "threshold equals server count" warnings
+	}{
+		{"default allows one server", 1, 0, 0, 0, ErrNone, 1},
+		{"below minimum", 1, 0, 2, 0, ErrInsufficientServers, 0},
+		{"at minimum", 2, 0, 2, 0, ErrNone, 1},
+		{"majority of three", 3, 0, 3, 0, ErrNone, 0},
+		{"threshold equals n", 3, 0, 3, 3, ErrNone, 1},
+		{"too few live servers", 3, 1, 3, 0, ErrInsufficientServers, 0},
+		{"negative minimum", 3, 0, -1, 0, ErrInvalidInput, 0},
+		{"negative minimum without servers", 0, 0, -1, 0, ErrInvalidInput, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i, server := range servers {
+				if i < tt.down {
+					server.SetFault("", testserver.FaultUnavailable)
+				} else {
+					server.SetFault("", testserver.FaultNone)
+				}
+			}
+			logger := &recordingLogger{}
+			opts := &Options{MinServers: tt.minServers, Threshold: tt.threshold, Logger: logger}
+
+			result := GenerateEncryptionKeyWithOpts(identity, "password", 10, 0, serverInfos[:tt.servers], opts)
+			if result.ErrCode != tt.wantCode {
+				t.Fatalf("ErrCode = %q, want %q (error: %s)", result.ErrCode, tt.wantCode, result.Error)
+			}
+			if tt.wantCode == ErrInsufficientServers && !strings.Contains(result.Error, "MinServers") {
+				t.Errorf("Error %q does not mention MinServers", result.Error)
+			}
+			if got := logger.count("WARN threshold equals server count"); got != tt.wantWarn {
+				t.Errorf("Logged %d threshold warnings, want %d", got, tt.wantWarn)
+			}
+		})
+	}
+}
+
+func TestRecoverSharesUsed(t *testing.T) {
+	servers, serverInfos := newTestServers(t, 5)
+	identity := &Identity{UID: "user", DID: "app", BID: "subset"}
+
+	generated := GenerateEncryptionKey(identity, "password", 20, 0, serverInfos)
+	if generated.Error != "" {
+		t.Fatalf("Key generation failed: %s", generated.Error)
+	}
+
+	// sortedURLs returns the URLs of servers in sorted order
+	sortedURLs := func(servers []ServerInfo) []string {
+		urls := make([]string, len(servers))
+		for i, serverInfo := range servers {
+			urls[i] = serverInfo.URL
+		}
+		sort.Strings(urls)
+		return urls
+	}
+
+	// Recovery asks the servers in the order they are listed and stops at the
+	// threshold; SharesUsed lists the servers it used in sorted order
+	for run := 0; run < 5; run++ {
+		shuffled := append([]ServerInfo(nil), serverInfos...)
+		rand.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+
+		result := RecoverEncryptionKeyWithServerInfo(identity, "password", shuffled, generated.Threshold, generated.AuthCodes)
+		if result.Error != "" {
+			t.Fatalf("Run %d: recovery failed: %s", run, result.Error)
+		}
+		if want := sortedURLs(shuffled[:generated.Threshold]); !reflect.DeepEqual(result.SharesUsed, want) {
+			t.Errorf("Run %d: SharesUsed = %v, want %v", run, result.SharesUsed, want)
+		}
+		if !bytes.Equal(result.EncryptionKey, generated.EncryptionKey) {
+			t.Errorf("Run %d: recovered key does not match", run)
+		}
 	}
 
-	t.Log("✅ Keygen round trip test passed - keys match!")
+	// Without the first server, the next one takes its place in the subset
+	servers[0].SetFault("RecoverSecret", testserver.FaultUnavailable)
+	result := RecoverEncryptionKeyWithServerInfo(identity, "password", serverInfos, generated.Threshold, generated.AuthCodes)
+	if result.Error != "" {
+		t.Fatalf("Recovery failed: %s", result.Error)
+	}
+	if want := sortedURLs(serverInfos[1 : generated.Threshold+1]); !reflect.DeepEqual(result.SharesUsed, want) {
+		t.Errorf("SharesUsed = %v, want %v", result.SharesUsed, want)
+	}
+	if !bytes.Equal(result.EncryptionKey, generated.EncryptionKey) {
+		t.Error("Key recovered from another subset does not match")
+	}
+}
+
+func TestGenerateEncryptionKeyRand(t *testing.T) {
+	var urls []string
+	for i := 0; i < 3; i++ {
+		urls = append(urls, newMockRegistrationServer(t, 0, false, nil, nil).URL)
+	}
+	serverInfos := insecureServerInfos(t, urls)
+	identity := &Identity{UID: "user", DID: "app", BID: "even"}
+
+	generate := func(opts *Options) *GenerateEncryptionKeyResult {
+		result := GenerateEncryptionKeyWithOpts(identity, "password", 10, 0, serverInfos, opts)
+		if result.Error != "" {
+			t.Fatalf("GenerateEncryptionKeyWithOpts() unexpected error: %s", result.Error)
+		}
+		return result
+	}
+
+	a := generate(&Options{Rand: seededReader(1)})
+	b := generate(&Options{Rand: seededReader(1)})
+	if string(a.EncryptionKey) != string(b.EncryptionKey) || a.AuthCodes.BaseAuthCode != b.AuthCodes.BaseAuthCode {
+		t.Error("Same seed produced different keys or auth codes")
+	}
+
+	if string(generate(nil).EncryptionKey) == string(generate(nil).EncryptionKey) {
+		t.Error("Default randomness produced the same key twice")
+	}
+}
+
+func TestPasswordToPinNormalization(t *testing.T) {
+	tests := []struct {
+		name        string
+		precomposed string
+		decomposed  string
+	}{
+		{"acute accent", "caf\u00e9", "cafe\u0301"},
+		{"umlaut", "\u00fcber-Passw\u00f6rt", "u\u0308ber-Passwo\u0308rt"},
+		{"hangul", "\ud55c\uae00", "\u1112\u1161\u11ab\u1100\u1173\u11af"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.precomposed == tt.decomposed {
+				t.Fatal("test strings must differ before normalization")
+			}
+
+			pinA := PasswordToPin(tt.precomposed)
+			pinB := PasswordToPin(tt.decomposed)
+			if string(pinA) != string(pinB) {
+				t.Errorf("PasswordToPin(%q) = %x, PasswordToPin(%q) = %x, want equal", tt.precomposed, pinA, tt.decomposed, pinB)
+			}
+
+			// Both forms must map to the same point H(UID, DID, BID, pin)
+			uA := common.H([]byte("user"), []byte("app"), []byte("even"), pinA)
+			uB := common.H([]byte("user"), []byte("app"), []byte("even"), pinB)
+			if !common.PointEqual(uA, uB) {
+				t.Error("normalized passwords produced different points")
+			}
+		})
+	}
+}
+
+func TestPasswordToPinBackwardCompatible(t *testing.T) {
+	// NFC and ASCII passwords must keep their pre-normalization PIN
+	for _, password := range []string{"test-password-123", "caf\u00e9", ""} {
+		if got := PasswordToPin(password); string(got) != password {
+			t.Errorf("PasswordToPin(%q) = %q, want raw bytes", password, got)
+		}
+	}
+
+	// RawPassword opts out of normalization for legacy backups
+	decomposed := "cafe\u0301"
+	if got := (&Options{RawPassword: true}).passwordToPin(decomposed); string(got) != decomposed {
+		t.Errorf("passwordToPin() with RawPassword = %q, want %q", got, decomposed)
+	}
+	if got := (*Options)(nil).passwordToPin(decomposed); string(got) != "caf\u00e9" {
+		t.Errorf("passwordToPin() with nil options = %q, want NFC form", got)
+	}
+}
+
+func TestTimeouts(t *testing.T) {
+	servers, serverInfos := newTestServers(t, 3)
+	identity := &Identity{UID: "user", DID: "app", BID: "even"}
+
+	// A server slower than PerServer is excluded; the others carry the backup
+	servers[2].SetLatency("Echo", 500*time.Millisecond)
+	generated := GenerateEncryptionKeyWithOpts(identity, "password", 10, 0, serverInfos,
+		&Options{Threshold: 2, Timeouts: Timeouts{PerServer: 100 * time.Millisecond}})
+	if generated.Error != "" {
+		t.Fatalf("Key generation failed: %s", generated.Error)
+	}
+	if len(generated.ServerURLs) != 2 {
+		t.Errorf("Generation used %d servers, want 2", len(generated.ServerURLs))
+	}
+	servers[2].SetLatency("Echo", 0)
+	allServerInfos, serverInfos := serverInfos, serverInfos[:2]
+
+	tests := []struct {
+		name      string
+		slow      []int
+		wantCode  ErrCode
+		wantShare []bool
+	}{
+		{"no slow servers", nil, ErrNone, []bool{true, true}},
+		{"last server slow", []int{1}, ErrTimeout, []bool{true, false}},
+		{"first server slow", []int{0}, ErrTimeout, []bool{false, true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, i := range tt.slow {
+				servers[i].SetLatency("RecoverSecret", 2*time.Second)
+				defer servers[i].SetLatency("RecoverSecret", 0)
+			}
+
+			start := time.Now()
+			result := RecoverEncryptionKeyWithOpts(identity, "password", serverInfos, 2, generated.AuthCodes,
+				&Options{Timeouts: Timeouts{Overall: 300 * time.Millisecond}})
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("Recovery took %v despite a 300ms overall timeout", elapsed)
+			}
+			if result.ErrCode != tt.wantCode {
+				t.Errorf("ErrCode = %q, want %q (error: %s)", result.ErrCode, tt.wantCode, result.Error)
+			}
+			for i, want := range tt.wantShare {
+				if result.ServerResults[i].Success != want {
+					t.Errorf("ServerResults[%d].Success = %v, want %v (%s)", i, result.ServerResults[i].Success, want, result.ServerResults[i].Error)
+				}
+			}
+		})
+	}
+
+	// The overall timeout returns partial results when the threshold is still met
+	servers[2].SetLatency("RegisterSecret", 2*time.Second)
+	partial := GenerateEncryptionKeyWithOpts(&Identity{UID: "user", DID: "app", BID: "odd"}, "password", 10, 0,
+		allServerInfos, &Options{Threshold: 2, Timeouts: Timeouts{Overall: 300 * time.Millisecond}})
+	if partial.Error != "" {
+		t.Fatalf("Key generation with a slow server failed: %s", partial.Error)
+	}
+	if partial.ServerResults[2].Success {
+		t.Error("Slow server reported success despite the overall timeout")
+	}
+}
+
+func TestRecoverConsistency(t *testing.T) {
+	servers, serverInfos := newTestServers(t, 3)
+	identity := &Identity{UID: "user", DID: "app", BID: "even"}
+	expiresAt := int(time.Now().Add(time.Hour).Unix())
+
+	generated := GenerateEncryptionKey(identity, "password", 10, expiresAt, serverInfos)
+	if generated.Error != "" {
+		t.Fatalf("Key generation failed: %s", generated.Error)
+	}
+
+	tests := []struct {
+		name           string
+		maxGuesses     int
+		expiration     int
+		opts           *Options
+		wantConsistent bool
+		wantCode       ErrCode
+	}{
+		{"agreement", 10, expiresAt, nil, true, ErrNone},
+		{"lenient max guesses", 12, expiresAt, nil, false, ErrNone},
+		{"strict max guesses", 12, expiresAt, &Options{StrictConsistency: true}, false, ErrInconsistentServers},
+		{"strict expiration", 10, expiresAt + 300, &Options{StrictConsistency: true}, false, ErrInconsistentServers},
+		{"within tolerance", 10, expiresAt + 30, &Options{StrictConsistency: true, ExpirationTolerance: time.Minute}, true, ErrNone},
+		{"never expires on one server", 10, 0, &Options{StrictConsistency: true, ExpirationTolerance: time.Minute}, false, ErrInconsistentServers},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			servers[2].SetBackupLimits(identity.UID, identity.DID, identity.BID, tt.maxGuesses, tt.expiration)
+			before := servers[0].NumGuesses(identity.UID, identity.DID, identity.BID)
+
+			result := RecoverEncryptionKeyWithOpts(identity, "password", serverInfos, generated.Threshold, generated.AuthCodes, tt.opts)
+			if result.ErrCode != tt.wantCode {
+				t.Fatalf("ErrCode = %q, want %q (error: %s)", result.ErrCode, tt.wantCode, result.Error)
+			}
+			if result.Consistency == nil || result.Consistency.Consistent != tt.wantConsistent {
+				t.Fatalf("Consistency = %+v, want Consistent %v", result.Consistency, tt.wantConsistent)
+			}
+			if got := result.Consistency.MaxGuesses[servers[2].URL]; got != tt.maxGuesses {
+				t.Errorf("Consistency.MaxGuesses[server 2] = %d, want %d", got, tt.maxGuesses)
+			}
+
+			// Strict failures happen before any guess is spent
+			spent := servers[0].NumGuesses(identity.UID, identity.DID, identity.BID) - before
+			if wantSpent := map[bool]int{true: 0, false: 1}[tt.wantCode != ErrNone]; spent != wantSpent {
+				t.Errorf("Recovery spent %d guesses, want %d", spent, wantSpent)
+			}
+		})
+	}
+}
+
+func TestGenerateEncryptionKeyWithExpiry(t *testing.T) {
+	servers, serverInfos := newTestServers(t, 3)
+	inAnHour := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name           string
+		expiresAt      time.Time
+		wantCode       ErrCode
+		wantExpiration int
+	}{
+		{"never", time.Time{}, ErrNone, 0},
+		{"one hour from now", inAnHour, ErrNone, int(inAnHour.Unix())},
+		{"already expired", time.Now().Add(-time.Hour), ErrInvalidInput, 0},
+		{"before the epoch", time.Unix(-10, 0), ErrInvalidInput, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identity := &Identity{UID: "user", DID: "app", BID: tt.name}
+			generated := GenerateEncryptionKeyWithExpiry(identity, "password", 10, tt.expiresAt, serverInfos, nil)
+			if generated.ErrCode != tt.wantCode {
+				t.Fatalf("ErrCode = %q, want %q (error: %s)", generated.ErrCode, tt.wantCode, generated.Error)
+			}
+
+			if tt.wantCode != ErrNone {
+				// Rejected before any server was contacted
+				for i, server := range servers {
+					if server.NumGuesses(identity.UID, identity.DID, identity.BID) != -1 {
+						t.Errorf("Server %d holds a backup with an expired expiration", i)
+					}
+				}
+				return
+			}
+
+			recovered := RecoverEncryptionKeyWithServerInfo(identity, "password", serverInfos, generated.Threshold, generated.AuthCodes)
+			if recovered.Error != "" {
+				t.Fatalf("Recovery failed: %s", recovered.Error)
+			}
+			for url, expiration := range recovered.Consistency.Expiration {
+				if expiration != tt.wantExpiration {
+					t.Errorf("Server %s stored expiration %d, want %d", url, expiration, tt.wantExpiration)
+				}
+			}
+		})
+	}
+}
+
+func TestRecoverMaxConcurrency(t *testing.T) {
+	tests := []struct {
+		name           string
+		maxConcurrency int
+		verifyShares   bool
+		wantInFlight   int
+		wantCollected  int
+	}{
+		{"limited", 2, false, 2, 6},
+		{"default", 0, false, 6, 6}, // No more than the shares needed
+		{"verify shares", 0, true, 7, 7},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			servers, serverInfos := newTestServers(t, 10)
+			identity := &Identity{UID: "user", DID: "app", BID: "fan-out"}
+			generated := GenerateEncryptionKeyWithOpts(identity, "password", 10, 0, serverInfos, &Options{Threshold: 6})
+			if generated.Error != "" {
+				t.Fatalf("Key generation failed: %s", generated.Error)
+			}
+			for _, server := range servers {
+				server.SetLatency("RecoverSecret", 20*time.Millisecond)
+			}
+
+			// Before the shares are requested, servers are contacted one at a time
+			var inFlight, peak atomic.Int32
+			opts := &Options{
+				MaxConcurrency: tt.maxConcurrency,
+				VerifyShares:   tt.verifyShares,
+				Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+					n := inFlight.Add(1)
+					defer inFlight.Add(-1)
+					for {
+						if p := peak.Load(); n <= p || peak.CompareAndSwap(p, n) {
+							break
+						}
+					}
+					return http.DefaultTransport.RoundTrip(r)
+				}),
+			}
+			result := RecoverEncryptionKeyWithOpts(identity, "password", serverInfos, 6, generated.AuthCodes, opts)
+			if result.Error != "" {
+				t.Fatalf("Recovery failed: %s", result.Error)
+			}
+			if !bytes.Equal(result.EncryptionKey, generated.EncryptionKey) {
+				t.Error("Recovered key does not match")
+			}
+			if got := int(peak.Load()); got != tt.wantInFlight {
+				t.Errorf("%d requests in flight at once, want %d", got, tt.wantInFlight)
+			}
+
+			// Recovery stops once it holds the threshold, or one more share
+			for i, server := range servers {
+				want := 0
+				if i < tt.wantCollected {
+					want = 1
+				}
+				if got := server.NumGuesses(identity.UID, identity.DID, identity.BID); got != want {
+					t.Errorf("Server %d spent %d guesses, want %d", i, got, want)
+				}
+			}
+			if result.SharesCollected != tt.wantCollected {
+				t.Errorf("SharesCollected = %d, want %d", result.SharesCollected, tt.wantCollected)
+			}
+		})
+	}
+}
+
+func TestRecoverStopsAtThreshold(t *testing.T) {
+	servers, serverInfos := newTestServers(t, 3)
+	identity := &Identity{UID: "user", DID: "app", BID: "early"}
+	generated := GenerateEncryptionKeyWithOpts(identity, "password", 10, 0, serverInfos, &Options{Threshold: 2})
+	if generated.Error != "" {
+		t.Fatalf("Key generation failed: %s", generated.Error)
+	}
+
+	const slow = 2 * time.Second
+	servers[2].SetLatency("RecoverSecret", slow)
+	start := time.Now()
+	result := RecoverEncryptionKeyWithOpts(identity, "password", serverInfos, 2, generated.AuthCodes, nil)
+	if elapsed := time.Since(start); elapsed >= slow {
+		t.Errorf("Recovery took %v, waiting for the slow server", elapsed)
+	}
+	if result.Error != "" {
+		t.Fatalf("Recovery failed: %s", result.Error)
+	}
+	if !bytes.Equal(result.EncryptionKey, generated.EncryptionKey) {
+		t.Error("Recovered key does not match")
+	}
+	if got := servers[2].NumGuesses(identity.UID, identity.DID, identity.BID); got != 0 {
+		t.Errorf("Slow server spent %d guesses, want 0", got)
+	}
+}
+
+func TestRecoverCorruptedShare(t *testing.T) {
+	tests := []struct {
+		name     string
+		corrupt  int // Server whose share is corrupted
+		verify   bool
+		wantCode ErrCode
+	}{
+		{"share used", 0, true, ErrInconsistentServers},
+		{"share beyond the threshold", 2, true, ErrInconsistentServers},
+		{"server not asked", 3, true, ErrNone},
+		{"without VerifyShares", 2, false, ErrNone},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			servers, serverInfos := newTestServers(t, 4)
+			identity := &Identity{UID: "user", DID: "app", BID: "corrupted"}
+			generated := GenerateEncryptionKeyWithOpts(identity, "password", 10, 0, serverInfos, &Options{Threshold: 2})
+			if generated.Error != "" {
+				t.Fatalf("Key generation failed: %s", generated.Error)
+			}
+			servers[tt.corrupt].SetFault("RecoverSecret", testserver.FaultCorruptShare)
+
+			result := RecoverEncryptionKeyWithOpts(identity, "password", serverInfos, 2, generated.AuthCodes, &Options{VerifyShares: tt.verify})
+			if result.ErrCode != tt.wantCode {
+				t.Fatalf("ErrCode = %q (%s), want %q", result.ErrCode, result.Error, tt.wantCode)
+			}
+			if tt.wantCode == ErrNone && !bytes.Equal(result.EncryptionKey, generated.EncryptionKey) {
+				t.Error("Recovered key does not match")
+			}
+			if tt.wantCode != ErrNone && result.EncryptionKey != nil {
+				t.Error("Recovery returned a key derived from a corrupted share")
+			}
+
+			// Only VerifyShares spends a guess beyond the threshold
+			want := 0
+			if tt.verify {
+				want = 1
+			}
+			if got := servers[2].NumGuesses(identity.UID, identity.DID, identity.BID); got != want {
+				t.Errorf("Third server spent %d guesses, want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestVerifyReconstruction(t *testing.T) {
+	_, serverInfos := newTestServers(t, 3)
+	identity := &Identity{UID: "user", DID: "app", BID: "verified"}
+	generated := GenerateEncryptionKeyWithOpts(identity, "password", 10, 0, serverInfos, &Options{Threshold: 2})
+	if generated.Error != "" {
+		t.Fatalf("Key generation failed: %s", generated.Error)
+	}
+
+	// Collect a share from every server into one session, one more than needed
+	collected := NewRecoverySession(identity, 2)
+	for _, serverInfo := range serverInfos {
+		session := NewRecoverySession(identity, 3) // Never met, so the share is kept
+		ResumeRecovery(session, []ServerInfo{serverInfo}, "password", generated.AuthCodes, nil)
+		if session.Collected() != 1 {
+			t.Fatalf("Collected %d shares from %s, want 1", session.Collected(), serverInfo.URL)
+		}
+		collected.Shares = append(collected.Shares, session.Shares...)
+	}
+
+	tests := []struct {
+		name     string
+		corrupt  int // Share replaced by another server's share, or -1
+		wantCode ErrCode
+	}{
+		{"consistent", -1, ErrNone},
+		{"corrupted extra share", 2, ErrInconsistentServers},
+		{"corrupted share used", 0, ErrInconsistentServers},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session := &RecoverySession{Identity: collected.Identity, Threshold: 2, Shares: append([]SessionShare(nil), collected.Shares...)}
+			if tt.corrupt >= 0 {
+				session.Shares[tt.corrupt].Point = session.Shares[(tt.corrupt+1)%3].Point
+			}
+
+			result := ResumeRecovery(session, serverInfos, "password", generated.AuthCodes, nil)
+			if result.ErrCode != tt.wantCode {
+				t.Fatalf("ErrCode = %q (%s), want %q", result.ErrCode, result.Error, tt.wantCode)
+			}
+			if tt.wantCode == ErrNone && !bytes.Equal(result.EncryptionKey, generated.EncryptionKey) {
+				t.Error("Recovered key does not match")
+			}
+		})
+	}
+}
+
+func TestBestEffortRecover(t *testing.T) {
+	tests := []struct {
+		name          string
+		decommission  int
+		wantCode      ErrCode
+		wantCollected int
+		wantSpent     int
+	}{
+		{"all servers", 0, ErrNone, 3, 1},
+		{"two decommissioned", 2, ErrNone, 3, 1},
+		{"three decommissioned", 3, ErrThresholdNotMet, 2, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			servers, serverInfos := newTestServers(t, 5)
+			identity := &Identity{UID: "user", DID: "app", BID: "even"}
+
+			generated := GenerateEncryptionKeyWithExpiry(identity, "password", 10, time.Time{}, serverInfos, &Options{Threshold: 3})
+			if generated.Error != "" {
+				t.Fatalf("Key generation failed: %s", generated.Error)
+			}
+			for _, server := range servers[:tt.decommission] {
+				server.Close()
+			}
+
+			result := BestEffortRecover(identity, "password", serverInfos, generated.Threshold, generated.AuthCodes, nil)
+			if result.ErrCode != tt.wantCode {
+				t.Fatalf("ErrCode = %q, want %q (error: %s)", result.ErrCode, tt.wantCode, result.Error)
+			}
+			if result.SharesCollected != tt.wantCollected || result.SharesNeeded != 3 {
+				t.Errorf("Shares = %d of %d, want %d of 3", result.SharesCollected, result.SharesNeeded, tt.wantCollected)
+			}
+			if tt.wantCode == ErrNone && !bytes.Equal(result.EncryptionKey, generated.EncryptionKey) {
+				t.Error("Recovered key does not match")
+			}
+
+			remaining := servers[tt.decommission]
+			if spent := remaining.NumGuesses(identity.UID, identity.DID, identity.BID); spent != tt.wantSpent {
+				t.Errorf("Recovery spent %d guesses, want %d", spent, tt.wantSpent)
+			}
+		})
+	}
+}
+
+func TestKeyLength(t *testing.T) {
+	_, serverInfos := newTestServers(t, 3)
+
+	tests := []struct {
+		name      string
+		keyLength int
+		wantLen   int
+	}{
+		{"default", 0, 32},
+		{"AES-128", 16, 16},
+		{"XChaCha20", 32, 32},
+		{"64 bytes", 64, 64},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identity := &Identity{UID: "user", DID: "laptop", BID: "key-length-" + tt.name}
+			generated := GenerateEncryptionKeyWithOpts(identity, "password", 10, 0, serverInfos, &Options{KeyLength: tt.keyLength})
+			if generated.Error != "" {
+				t.Fatalf("Key generation failed: %s", generated.Error)
+			}
+			if len(generated.EncryptionKey) != tt.wantLen {
+				t.Fatalf("Key is %d bytes, want %d", len(generated.EncryptionKey), tt.wantLen)
+			}
+
+			metadata, err := MetadataFromResult(identity, generated)
+			if err != nil {
+				t.Fatalf("MetadataFromResult() error: %v", err)
+			}
+			opts, err := metadata.RecoveryOptions(nil)
+			if err != nil {
+				t.Fatalf("RecoveryOptions() error: %v", err)
+			}
+			recovered := RecoverEncryptionKeyWithOpts(identity, "password", serverInfos, metadata.Threshold, metadata.AuthCodes, opts)
+			if recovered.Error != "" {
+				t.Fatalf("Recovery failed: %s", recovered.Error)
+			}
+			if !bytes.Equal(recovered.EncryptionKey, generated.EncryptionKey) {
+				t.Errorf("Recovered key %x, want %x", recovered.EncryptionKey, generated.EncryptionKey)
+			}
+
+			// Requesting another length is rejected before any server is contacted
+			other := 16
+			if tt.wantLen == 16 {
+				other = 32
+			}
+			if _, err := metadata.RecoveryOptions(&Options{KeyLength: other}); !errors.Is(err, ErrKeyLengthMismatch) {
+				t.Errorf("RecoveryOptions(KeyLength: %d) error = %v, want ErrKeyLengthMismatch", other, err)
+			}
+		})
+	}
+
+	for _, length := range []int{-1, 8, 15, 65} {
+		identity := &Identity{UID: "user", DID: "laptop", BID: "invalid"}
+		generated := GenerateEncryptionKeyWithOpts(identity, "password", 10, 0, serverInfos, &Options{KeyLength: length})
+		if generated.ErrCode != ErrInvalidInput {
+			t.Errorf("KeyLength %d: generation ErrCode = %q, want %q", length, generated.ErrCode, ErrInvalidInput)
+		}
+		recovered := RecoverEncryptionKeyWithOpts(identity, "password", serverInfos, 1, &AuthCodes{}, &Options{KeyLength: length})
+		if recovered.ErrCode != ErrInvalidInput {
+			t.Errorf("KeyLength %d: recovery ErrCode = %q, want %q", length, recovered.ErrCode, ErrInvalidInput)
+		}
+	}
+}
+
+func TestHashDST(t *testing.T) {
+	_, serverInfos := newTestServers(t, 3)
+
+	for _, dst := range []string{"", "OpenADP-experimental"} {
+		identity := &Identity{UID: "user", DID: "laptop", BID: "dst"}
+		if dst != "" {
+			identity.BID = "dst-experimental"
+		}
+		generated := GenerateEncryptionKeyWithOpts(identity, "password", 10, 0, serverInfos, &Options{HashDST: dst})
+		if generated.Error != "" {
+			t.Fatalf("DST %q: key generation failed: %s", dst, generated.Error)
+		}
+		if generated.HashDST != dst {
+			t.Errorf("DST %q: result HashDST = %q", dst, generated.HashDST)
+		}
+
+		metadata, err := MetadataFromResult(identity, generated)
+		if err != nil {
+			t.Fatalf("MetadataFromResult() error: %v", err)
+		}
+		encoded, err := json.Marshal(metadata)
+		if err != nil {
+			t.Fatalf("Marshal() error: %v", err)
+		}
+		if recorded := strings.Contains(string(encoded), `"hash_dst"`); recorded != (dst != "") {
+			t.Errorf("DST %q: metadata %s records the tag = %v", dst, encoded, recorded)
+		}
+		var decoded Metadata
+		if err := json.Unmarshal(encoded, &decoded); err != nil {
+			t.Fatalf("Unmarshal() error: %v", err)
+		}
+
+		opts, err := decoded.RecoveryOptions(nil)
+		if err != nil {
+			t.Fatalf("RecoveryOptions() error: %v", err)
+		}
+		recovered := RecoverEncryptionKeyWithOpts(identity, "password", serverInfos, decoded.Threshold, decoded.AuthCodes, opts)
+		if recovered.Error != "" {
+			t.Fatalf("DST %q: recovery failed: %s", dst, recovered.Error)
+		}
+		if !bytes.Equal(recovered.EncryptionKey, generated.EncryptionKey) {
+			t.Errorf("DST %q: recovered key %x, want %x", dst, recovered.EncryptionKey, generated.EncryptionKey)
+		}
+
+		if dst == "" {
+			continue
+		}
+
+		// Recovering under another tag derives a different key
+		wrong := RecoverEncryptionKeyWithOpts(identity, "password", serverInfos, decoded.Threshold, decoded.AuthCodes, nil)
+		if wrong.Error == "" && bytes.Equal(wrong.EncryptionKey, generated.EncryptionKey) {
+			t.Errorf("DST %q: recovery with the production tag derived the same key", dst)
+		}
+		if _, err := decoded.RecoveryOptions(&Options{HashDST: "OpenADP-other"}); !errors.Is(err, ErrHashDSTMismatch) {
+			t.Errorf("RecoveryOptions(HashDST: other) error = %v, want ErrHashDSTMismatch", err)
+		}
+	}
 }
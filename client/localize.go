@@ -0,0 +1,93 @@
+package client
+
+import (
+	"strings"
+	"sync"
+)
+
+// MessageTable maps error codes to user-facing messages in one language
+type MessageTable map[ErrCode]string
+
+// englishMessages is the default table, used for languages without a
+// registered table and for codes a registered table leaves out
+var englishMessages = MessageTable{
+	ErrInvalidIdentity:     "The backup identity is invalid.",
+	ErrInvalidInput:        "The request is invalid.",
+	ErrInsufficientServers: "Not enough servers are configured.",
+	ErrNetwork:             "The servers could not be reached. Check your connection and try again.",
+	ErrThresholdNotMet:     "Not enough servers responded. Try again later.",
+	ErrGuessesExhausted:    "Too many incorrect attempts. The backup is locked.",
+	ErrInternal:            "An internal error occurred.",
+	ErrTimeout:             "The servers took too long to respond. Try again later.",
+	ErrInconsistentServers: "The servers disagree about the backup.",
+	ErrGuessNotConfirmed:   "Recovery needs your confirmation to use one attempt.",
+	ErrReplayRejected:      "The servers rejected the request. Check that your device clock is correct.",
+	ErrAuthCodesRequired:   "The backup's authentication codes are required.",
+	ErrBackupExpired:       "The backup has expired.",
+	ErrAuthCodesRejected:   "The password is incorrect, or the backup's authentication codes are required.",
+
+	ErrMixedProtocolVersions: "The servers use incompatible protocol versions.",
+	ErrKeyCommitmentMismatch: "The password is incorrect, or the backup is damaged.",
+}
+
+var (
+	localesMu sync.RWMutex
+	locales   = map[string]MessageTable{}
+)
+
+// RegisterLocale registers the messages Localize returns for lang, a BCP 47
+// language tag such as "de" or "pt-BR", replacing any table registered for it
+// before. Codes the table leaves out fall back to English. The package ships no
+// translations; applications register their own. Registering a nil table removes
+// the language. It is safe to call concurrently with Localize.
+func RegisterLocale(lang string, table MessageTable) {
+	lang = normalizeLang(lang)
+	localesMu.Lock()
+	defer localesMu.Unlock()
+	if table == nil {
+		delete(locales, lang)
+		return
+	}
+	copied := make(MessageTable, len(table))
+	for code, message := range table {
+		copied[code] = message
+	}
+	locales[lang] = copied
+}
+
+// Localize returns a user-facing message for code in lang, for display instead
+// of the Error string of a result, which is English and meant for logs. It looks
+// up the table registered for lang, then for its base language ("pt" for
+// "pt-BR"), then the English default. It returns "" for ErrNone, and a generic
+// message naming the code for codes no table knows.
+func Localize(code ErrCode, lang string) string {
+	if code == ErrNone {
+		return ""
+	}
+	lang = normalizeLang(lang)
+	localesMu.RLock()
+	for _, candidate := range []string{lang, baseLang(lang)} {
+		if message, ok := locales[candidate][code]; ok {
+			localesMu.RUnlock()
+			return message
+		}
+	}
+	localesMu.RUnlock()
+
+	if message, ok := englishMessages[code]; ok {
+		return message
+	}
+	return "An error occurred (" + string(code) + ")."
+}
+
+// normalizeLang folds the case and separators of a language tag, so that
+// "pt_BR" and "PT-br" name the same table
+func normalizeLang(lang string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(lang), "_", "-"))
+}
+
+// baseLang returns the primary language subtag of a normalized tag
+func baseLang(lang string) string {
+	base, _, _ := strings.Cut(lang, "-")
+	return base
+}
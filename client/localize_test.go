@@ -0,0 +1,67 @@
+package client
+
+import "testing"
+
+func TestLocalize(t *testing.T) {
+	RegisterLocale("xx", MessageTable{
+		ErrNetwork:          "xx-network",
+		ErrGuessesExhausted: "xx-locked",
+	})
+	t.Cleanup(func() { RegisterLocale("xx", nil) })
+
+	tests := []struct {
+		name string
+		code ErrCode
+		lang string
+		want string
+	}{
+		{"registered", ErrNetwork, "xx", "xx-network"},
+		{"registered other code", ErrGuessesExhausted, "xx", "xx-locked"},
+		{"region falls back to base", ErrNetwork, "xx-YY", "xx-network"},
+		{"case and separator folded", ErrNetwork, "XX_yy", "xx-network"},
+		{"missing code falls back to English", ErrTimeout, "xx", englishMessages[ErrTimeout]},
+		{"unregistered language", ErrNetwork, "zz", englishMessages[ErrNetwork]},
+		{"empty language", ErrNetwork, "", englishMessages[ErrNetwork]},
+		{"no error", ErrNone, "xx", ""},
+		{"unknown code", ErrCode("SOMETHING_NEW"), "xx", "An error occurred (SOMETHING_NEW)."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Localize(tt.code, tt.lang); got != tt.want {
+				t.Errorf("Localize(%q, %q) = %q, want %q", tt.code, tt.lang, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLocalizeRegistration(t *testing.T) {
+	table := MessageTable{ErrNetwork: "before"}
+	RegisterLocale("xx", table)
+	t.Cleanup(func() { RegisterLocale("xx", nil) })
+
+	// The registered table is a copy
+	table[ErrNetwork] = "after"
+	if got := Localize(ErrNetwork, "xx"); got != "before" {
+		t.Errorf("Localize() after modifying the table = %q, want %q", got, "before")
+	}
+
+	RegisterLocale("xx", nil)
+	if got := Localize(ErrNetwork, "xx"); got != englishMessages[ErrNetwork] {
+		t.Errorf("Localize() after removing the locale = %q, want English", got)
+	}
+}
+
+func TestEnglishMessagesComplete(t *testing.T) {
+	codes := []ErrCode{
+		ErrInvalidIdentity, ErrInvalidInput, ErrInsufficientServers, ErrNetwork,
+		ErrThresholdNotMet, ErrGuessesExhausted, ErrInternal, ErrTimeout,
+		ErrInconsistentServers, ErrGuessNotConfirmed, ErrReplayRejected,
+		ErrAuthCodesRequired, ErrBackupExpired, ErrAuthCodesRejected, ErrMixedProtocolVersions,
+		ErrKeyCommitmentMismatch,
+	}
+	for _, code := range codes {
+		if englishMessages[code] == "" {
+			t.Errorf("No English message for %s", code)
+		}
+	}
+}
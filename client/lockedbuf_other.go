@@ -0,0 +1,25 @@
+//go:build !(linux || darwin)
+
+package client
+
+// lockedBuffer holds a secret. On this platform it is an ordinary heap slice that
+// is zeroized when freed, with the caveats described in zeroize.go.
+type lockedBuffer struct {
+	data []byte
+}
+
+// newLockedBuffer returns a zeroed buffer of size bytes
+func newLockedBuffer(size int) *lockedBuffer {
+	return &lockedBuffer{data: make([]byte, size)}
+}
+
+// bytes returns the contents of the buffer
+func (b *lockedBuffer) bytes() []byte {
+	return b.data
+}
+
+// free zeroizes the buffer
+func (b *lockedBuffer) free() {
+	zeroize(b.data)
+	b.data = nil
+}
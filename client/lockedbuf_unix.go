@@ -0,0 +1,44 @@
+//go:build linux || darwin
+
+package client
+
+import "syscall"
+
+// lockedBuffer holds a secret in memory mapped outside the Go heap, so the garbage
+// collector never copies it, and locked so it is not swapped to disk. Locking is
+// best-effort: it fails silently if RLIMIT_MEMLOCK is exhausted.
+type lockedBuffer struct {
+	data   []byte
+	mapped bool
+	locked bool
+}
+
+// newLockedBuffer returns a zeroed buffer of size bytes
+func newLockedBuffer(size int) *lockedBuffer {
+	data, err := syscall.Mmap(-1, 0, max(size, 1), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_ANON|syscall.MAP_PRIVATE)
+	if err != nil {
+		return &lockedBuffer{data: make([]byte, size)}
+	}
+	b := &lockedBuffer{data: data[:size], mapped: true}
+	b.locked = syscall.Mlock(data) == nil
+	return b
+}
+
+// bytes returns the contents of the buffer
+func (b *lockedBuffer) bytes() []byte {
+	return b.data
+}
+
+// free zeroizes the buffer and releases its memory
+func (b *lockedBuffer) free() {
+	zeroize(b.data)
+	if b.mapped {
+		data := b.data[:cap(b.data)]
+		if b.locked {
+			syscall.Munlock(data)
+		}
+		syscall.Munmap(data)
+	}
+	b.data = nil
+	b.mapped, b.locked = false, false
+}
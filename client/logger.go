@@ -0,0 +1,45 @@
+package client
+
+import "sync/atomic"
+
+// Logger receives structured log events from key generation and recovery: the
+// outcome of every server request, threshold decisions and retry attempts. Its
+// methods match those of *slog.Logger, so a *slog.Logger can be used directly.
+//
+// args are alternating keys and values. Events never carry secret material: no
+// password, PIN, key, share or auth code is ever passed to a Logger, at any level.
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+}
+
+// nopLogger discards all events
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, ...any) {}
+func (nopLogger) Info(string, ...any)  {}
+func (nopLogger) Warn(string, ...any)  {}
+
+// loggerBox wraps a Logger so that atomic.Value always stores the same concrete type
+type loggerBox struct{ Logger }
+
+var defaultLogger atomic.Value
+
+// SetLogger sets the package-wide logger used when Options.Logger is nil. Passing
+// nil restores the default, which discards all events. It is safe to call
+// concurrently with running operations.
+func SetLogger(l Logger) {
+	if l == nil {
+		l = nopLogger{}
+	}
+	defaultLogger.Store(loggerBox{l})
+}
+
+// packageLogger returns the logger set with SetLogger
+func packageLogger() Logger {
+	if box, ok := defaultLogger.Load().(loggerBox); ok {
+		return box.Logger
+	}
+	return nopLogger{}
+}
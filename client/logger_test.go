@@ -0,0 +1,128 @@
+package client
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/openadp/ocrypt/testserver"
+)
+
+// *slog.Logger must satisfy Logger without an adapter
+var _ Logger = slog.Default()
+
+// recordingLogger records every event as "level msg key=value ..."
+type recordingLogger struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (l *recordingLogger) record(level, msg string, args []any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, fmt.Sprintf("%s %s %v", level, msg, args))
+}
+
+func (l *recordingLogger) Debug(msg string, args ...any) { l.record("DEBUG", msg, args) }
+func (l *recordingLogger) Info(msg string, args ...any)  { l.record("INFO", msg, args) }
+func (l *recordingLogger) Warn(msg string, args ...any)  { l.record("WARN", msg, args) }
+
+// count returns the number of events starting with prefix
+func (l *recordingLogger) count(prefix string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	n := 0
+	for _, event := range l.events {
+		if strings.HasPrefix(event, prefix) {
+			n++
+		}
+	}
+	return n
+}
+
+func TestLogger(t *testing.T) {
+	servers, serverInfos := newTestServers(t, 3)
+	identity := &Identity{UID: "user", DID: "app", BID: "even"}
+	const password = "correct horse battery staple"
+
+	logger := &recordingLogger{}
+	opts := &Options{
+		Logger: logger,
+		Retry:  &RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond},
+	}
+
+	generated := GenerateEncryptionKeyWithOpts(identity, password, 10, 0, serverInfos, opts)
+	if generated.Error != "" {
+		t.Fatalf("Key generation failed: %s", generated.Error)
+	}
+
+	servers[0].SetFault("RecoverSecret", testserver.FaultUnavailable)
+	recovered := RecoverEncryptionKeyWithOpts(identity, password, serverInfos, generated.Threshold, generated.AuthCodes, opts)
+	if recovered.Error != "" {
+		t.Fatalf("Recovery failed: %s", recovered.Error)
+	}
+
+	tests := []struct {
+		prefix string
+		want   int
+	}{
+		{"DEBUG server reachable", 3},
+		{"INFO selected threshold", 1},
+		{"DEBUG registration succeeded", 3},
+		{"INFO registration threshold met", 1},
+		{"DEBUG recovery succeeded", 2},
+		{"WARN retrying request", 1},
+		{"WARN recovery failed", 1},
+		{"INFO recovery threshold met", 1},
+	}
+	for _, tt := range tests {
+		if got := logger.count(tt.prefix); got != tt.want {
+			t.Errorf("%d events %q, want %d", got, tt.prefix, tt.want)
+		}
+	}
+
+	// No secret material appears in any event
+	secrets := []string{
+		password,
+		hex.EncodeToString(generated.EncryptionKey),
+		base64.StdEncoding.EncodeToString(generated.EncryptionKey),
+		generated.AuthCodes.BaseAuthCode,
+	}
+	for _, code := range generated.AuthCodes.ServerAuthCodes {
+		secrets = append(secrets, code)
+	}
+	for _, event := range logger.events {
+		for _, secret := range secrets {
+			if strings.Contains(event, secret) {
+				t.Errorf("Event %q contains secret material", event)
+			}
+		}
+	}
+}
+
+func TestSetLogger(t *testing.T) {
+	logger := &recordingLogger{}
+	SetLogger(logger)
+	defer SetLogger(nil)
+
+	_, serverInfos := newTestServers(t, 1)
+	result := GenerateEncryptionKey(&Identity{UID: "user", DID: "app", BID: "even"}, "password", 10, 0, serverInfos)
+	if result.Error != "" {
+		t.Fatalf("Key generation failed: %s", result.Error)
+	}
+	if logger.count("INFO registration threshold met") != 1 {
+		t.Errorf("Package logger did not receive events: %v", logger.events)
+	}
+
+	// A per-call logger takes precedence
+	perCall := &recordingLogger{}
+	GenerateEncryptionKeyWithOpts(&Identity{UID: "user", DID: "app", BID: "odd"}, "password", 10, 0, serverInfos, &Options{Logger: perCall})
+	if perCall.count("INFO registration threshold met") != 1 || logger.count("INFO registration threshold met") != 1 {
+		t.Error("Per-call logger did not take precedence over the package logger")
+	}
+}
@@ -0,0 +1,332 @@
+package client
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/openadp/ocrypt/common"
+)
+
+// MetadataVersion is the schema version written by Metadata.MarshalJSON.
+//
+// New optional fields are added without changing the version; readers ignore
+// fields they do not know. The version only changes for incompatible changes,
+// and UnmarshalJSON rejects versions newer than this one.
+const MetadataVersion = 1
+
+// MetadataServer is a server holding a share of a backup
+type MetadataServer struct {
+	URL       string `json:"url"`
+	PublicKey string `json:"public_key,omitempty"`
+}
+
+// ErrKeyLengthMismatch is returned when the key length requested for recovery
+// differs from the length recorded in a backup's Metadata
+var ErrKeyLengthMismatch = errors.New("key length differs from the one used at registration")
+
+// ErrHashDSTMismatch is returned when the hash-to-curve domain separation tag
+// requested for recovery differs from the tag recorded in a backup's Metadata
+var ErrHashDSTMismatch = errors.New("hash-to-curve tag differs from the one used at registration")
+
+// Metadata bundles everything besides the user ID and password that is needed to
+// recover a key created with GenerateEncryptionKey, in a versioned JSON container.
+//
+// The auth codes authorize recovery attempts, so the metadata should be stored
+// with the same care as any other credential. The UID is deliberately not part of
+// the metadata; supply it again when building the Identity for recovery.
+type Metadata struct {
+	Version   int              `json:"version"`
+	DID       string           `json:"did"`
+	BID       string           `json:"bid"`
+	Servers   []MetadataServer `json:"servers"`
+	Threshold int              `json:"threshold"`
+	AuthCodes *AuthCodes       `json:"auth_codes"`
+	PinParams *PinParams       `json:"pin_params,omitempty"` // Nil for the default PIN derivation
+	KeyLength int              `json:"key_length,omitempty"` // Zero for DefaultKeyLength
+
+	// IdentityBound records that the key is bound to the identity; see
+	// Options.BindIdentity
+	IdentityBound bool `json:"identity_bound,omitempty"`
+
+	// HashDST is the hash-to-curve domain separation tag the key was derived
+	// under, empty for common.ProductionDST; see Options.HashDST
+	HashDST string `json:"hash_dst,omitempty"`
+
+	// KeyCommitment commits to the key, nil unless Options.KeyCommitment was
+	// set; see VerifyRecoveredKey
+	KeyCommitment *KeyCommitment `json:"key_commitment,omitempty"`
+
+	// IdempotencyKey identifies the registrations of the backup to servers that
+	// deduplicate them; see CapabilityIdempotentRegister
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+
+	// GuessCounts maps server URLs to the backup's guess counter as last observed,
+	// zero at registration; see RecordGuessCounts
+	GuessCounts map[string]int `json:"guess_counts,omitempty"`
+
+	// ProtocolVersion is the share format version of the backup. MigrateBackup
+	// records it; zero means version 1.
+	ProtocolVersion int `json:"protocol_version,omitempty"`
+
+	// unknown holds fields written by newer versions so that they survive a
+	// decode/encode round trip
+	unknown map[string]json.RawMessage
+}
+
+// metadataFields aliases Metadata without its methods, for default JSON handling
+type metadataFields Metadata
+
+// MetadataFromResult builds the metadata for a successful key generation of identity
+func MetadataFromResult(identity *Identity, result *GenerateEncryptionKeyResult) (*Metadata, error) {
+	if identity == nil {
+		return nil, errors.New("identity cannot be nil")
+	}
+	if result == nil || result.Error != "" {
+		return nil, errors.New("cannot build metadata from a failed key generation")
+	}
+
+	servers := make([]MetadataServer, len(result.ServerURLs))
+	for i, url := range result.ServerURLs {
+		servers[i].URL = url
+		if i < len(result.ServerInfos) && result.ServerInfos[i].URL == url {
+			servers[i].PublicKey = result.ServerInfos[i].PublicKey
+		}
+	}
+
+	keyLength := len(result.EncryptionKey)
+	if keyLength == DefaultKeyLength {
+		keyLength = 0
+	}
+
+	guessCounts := make(map[string]int, len(servers))
+	for _, server := range servers {
+		guessCounts[server.URL] = 0
+	}
+
+	protocolVersion := result.ProtocolVersion
+	if protocolVersion == ProtocolVersion {
+		protocolVersion = 0
+	}
+
+	return &Metadata{
+		Version:         MetadataVersion,
+		DID:             identity.DID,
+		BID:             identity.BID,
+		Servers:         servers,
+		Threshold:       result.Threshold,
+		AuthCodes:       result.AuthCodes,
+		PinParams:       result.PinParams,
+		KeyLength:       keyLength,
+		IdentityBound:   result.IdentityBound,
+		HashDST:         result.HashDST,
+		KeyCommitment:   result.KeyCommitment,
+		IdempotencyKey:  result.IdempotencyKey,
+		GuessCounts:     guessCounts,
+		ProtocolVersion: protocolVersion,
+	}, nil
+}
+
+// RecoveryOptions returns opts with the PIN parameters, key length, identity
+// binding, hash-to-curve tag and guess counters recorded in m, for recovering the
+// backup m describes. It fails with ErrPinParamsMismatch, ErrKeyLengthMismatch or
+// ErrHashDSTMismatch if opts already selects different PIN parameters, a
+// different key length or a different tag, rather than letting recovery spend
+// guesses on a key that cannot match.
+func (m *Metadata) RecoveryOptions(opts *Options) (*Options, error) {
+	var recovery Options
+	if opts != nil {
+		recovery = *opts
+		if opts.PinParams != nil && !opts.PinParams.equal(m.PinParams) {
+			return nil, fmt.Errorf("%w: registered with %s, recovering with %s", ErrPinParamsMismatch, m.PinParams.describe(), opts.PinParams.describe())
+		}
+		if opts.KeyLength != 0 && opts.keyLength() != m.keyLength() {
+			return nil, fmt.Errorf("%w: the key is %d bytes, %d requested", ErrKeyLengthMismatch, m.keyLength(), opts.keyLength())
+		}
+		if opts.HashDST != "" && opts.hashDST() != m.hashDST() {
+			return nil, fmt.Errorf("%w: registered with %q, recovering with %q", ErrHashDSTMismatch, m.hashDST(), opts.hashDST())
+		}
+	}
+	recovery.PinParams = m.PinParams
+	recovery.KeyLength = m.KeyLength
+	recovery.BindIdentity = m.IdentityBound
+	recovery.HashDST = m.HashDST
+	recovery.ExpectedGuessCounts = m.GuessCounts
+	recovery.ExpectedKeyCommitment = m.KeyCommitment
+	return &recovery, nil
+}
+
+// validate checks the fields of metadata read from an untrusted source for
+// values no key generation writes: a threshold beyond the servers, servers
+// without a URL, an unsupported key length or PIN derivation, negative guess
+// counters or a malformed key commitment
+func (m *Metadata) validate() error {
+	if m.Threshold < 0 || m.Threshold > len(m.Servers) {
+		return fmt.Errorf("threshold %d is not between 0 and the %d servers", m.Threshold, len(m.Servers))
+	}
+	for i, server := range m.Servers {
+		if server.URL == "" {
+			return fmt.Errorf("server %d has no URL", i)
+		}
+	}
+	if m.KeyLength != 0 && (m.KeyLength < MinKeyLength || m.KeyLength > MaxKeyLength) {
+		return fmt.Errorf("key length must be between %d and %d bytes, got %d", MinKeyLength, MaxKeyLength, m.KeyLength)
+	}
+	if err := m.PinParams.validate(); err != nil {
+		return err
+	}
+	for url, count := range m.GuessCounts {
+		if count < 0 {
+			return fmt.Errorf("negative guess count %d for %s", count, url)
+		}
+	}
+	if c := m.KeyCommitment; c != nil && (len(c.Salt) == 0 || len(c.MAC) != sha256.Size) {
+		return errors.New("malformed key commitment")
+	}
+	return nil
+}
+
+// keyLength returns the length of the backup's key
+func (m *Metadata) keyLength() int {
+	if m.KeyLength == 0 {
+		return DefaultKeyLength
+	}
+	return m.KeyLength
+}
+
+// hashDST returns the hash-to-curve tag of the backup's key
+func (m *Metadata) hashDST() string {
+	if m.HashDST == "" {
+		return common.ProductionDST
+	}
+	return m.HashDST
+}
+
+// MetadataToServerInfo returns the servers of m in the form expected by
+// RecoverEncryptionKeyWithServerInfo
+func MetadataToServerInfo(m *Metadata) []ServerInfo {
+	serverInfos := make([]ServerInfo, len(m.Servers))
+	for i, server := range m.Servers {
+		serverInfos[i] = ServerInfo{URL: server.URL, PublicKey: server.PublicKey}
+	}
+	return serverInfos
+}
+
+// Identity returns the identity of the backup described by m for the given user
+func (m *Metadata) Identity(uid string) *Identity {
+	return &Identity{UID: uid, DID: m.DID, BID: m.BID}
+}
+
+// metadataFingerprintLabel separates metadata fingerprints from other hashes
+const metadataFingerprintLabel = "OpenADP-MetadataFingerprint-v1"
+
+// Fingerprint returns a hex SHA-256 hash of the configuration of the backup m
+// describes: its DID and BID, threshold, servers and their public keys, PIN
+// derivation, key length, identity binding and hash-to-curve tag. It suits cache
+// keys and detecting that a backup was reconfigured, for example by
+// RelocateServers.
+//
+// Equal configurations have equal fingerprints however they are written: the
+// servers are compared as a set of normalized URLs, and defaults equal their
+// explicit values. Auth codes, the key commitment, the idempotency key and the
+// guess counters are left out, so the fingerprint reveals no secret and does not
+// change as recoveries spend guesses.
+func (m *Metadata) Fingerprint() string {
+	servers := make([]string, len(m.Servers))
+	for i, server := range m.Servers {
+		servers[i] = normalizeServerURL(server.URL) + "\x00" + strings.TrimSpace(server.PublicKey)
+	}
+	sort.Strings(servers)
+
+	pinParams := m.PinParams
+	if pinParams == nil {
+		pinParams = &PinParams{}
+	}
+
+	var data []byte
+	appendField := func(field string) {
+		data = binary.BigEndian.AppendUint32(data, uint32(len(field)))
+		data = append(data, field...)
+	}
+	appendField(metadataFingerprintLabel)
+	appendField(m.DID)
+	appendField(m.BID)
+	appendField(strconv.Itoa(m.Threshold))
+	appendField(strconv.Itoa(len(servers)))
+	for _, server := range servers {
+		appendField(server)
+	}
+	appendField(string(pinParams.KDF))
+	appendField(strconv.Itoa(pinParams.length()))
+	appendField(string(pinParams.Salt))
+	appendField(fmt.Sprintf("%d/%d/%d", pinParams.time(), pinParams.memoryKiB(), pinParams.threads()))
+	appendField(strconv.Itoa(m.keyLength()))
+	appendField(strconv.FormatBool(m.IdentityBound))
+	appendField(m.hashDST())
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// MarshalJSON encodes the metadata, including any unknown fields read earlier.
+// Keys are sorted, so encoding the same metadata always yields the same bytes.
+func (m *Metadata) MarshalJSON() ([]byte, error) {
+	known, err := json.Marshal((*metadataFields)(m))
+	if err != nil {
+		return nil, err
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(known, &fields); err != nil {
+		return nil, err
+	}
+	for key, value := range m.unknown {
+		if _, ok := fields[key]; !ok {
+			fields[key] = value
+		}
+	}
+
+	return json.Marshal(fields)
+}
+
+// UnmarshalJSON decodes metadata, keeping unknown fields for re-encoding. It fails
+// if the version is missing or newer than MetadataVersion, or if the fields are
+// inconsistent; see validate.
+func (m *Metadata) UnmarshalJSON(data []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return fmt.Errorf("invalid metadata: %v", err)
+	}
+
+	var decoded metadataFields
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return fmt.Errorf("invalid metadata: %v", err)
+	}
+
+	if decoded.Version < 1 {
+		return errors.New("invalid metadata: missing version")
+	}
+	if decoded.Version > MetadataVersion {
+		return fmt.Errorf("unsupported metadata version %d (this library supports up to %d)", decoded.Version, MetadataVersion)
+	}
+	if err := (*Metadata)(&decoded).validate(); err != nil {
+		return fmt.Errorf("invalid metadata: %v", err)
+	}
+
+	for _, key := range []string{"version", "did", "bid", "servers", "threshold", "auth_codes", "pin_params", "key_length", "identity_bound", "hash_dst", "key_commitment", "idempotency_key", "guess_counts", "protocol_version"} {
+		delete(fields, key)
+	}
+	if len(fields) == 0 {
+		fields = nil
+	}
+
+	*m = Metadata(decoded)
+	m.unknown = fields
+	return nil
+}
@@ -0,0 +1,49 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// FuzzUnmarshalMetadata decodes arbitrary bytes as Metadata. Decoding must never
+// panic, and any metadata it accepts must re-encode to bytes that decode to the
+// same metadata.
+func FuzzUnmarshalMetadata(f *testing.F) {
+	valid := `{"auth_codes":{"base_auth_code":"abc","server_auth_codes":{"https://a":"def"}},` +
+		`"bid":"b","did":"d","guess_counts":{"https://a":0},"key_length":16,` +
+		`"servers":[{"url":"https://a","public_key":"ed25519:AAAA"}],"threshold":1,"version":1}`
+	f.Add([]byte(valid))
+	f.Add([]byte(valid[:len(valid)/2]))                                                // Truncated
+	f.Add([]byte(strings.Replace(valid, `"d"`, `"`+strings.Repeat("d", 4096)+`"`, 1))) // Oversized field
+	f.Add([]byte(`{"version":1,"servers":[],"threshold":5}`))
+	f.Add([]byte(`{"version":1,"pin_params":{"kdf":"argon2id","length":1000}}`))
+	f.Add([]byte(`{"version":1,"key_commitment":{"salt":"","mac":null}}`))
+	f.Add([]byte(`{"version":99999999999999999999}`))
+	f.Add([]byte(`{"version":1,"future":[[[[[]]]]]}`))
+	f.Add([]byte(`null`))
+	f.Add([]byte{})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var metadata Metadata
+		if err := json.Unmarshal(data, &metadata); err != nil {
+			return
+		}
+		encoded, err := json.Marshal(&metadata)
+		if err != nil {
+			t.Fatalf("Marshal() of accepted metadata error: %v", err)
+		}
+		var again Metadata
+		if err := json.Unmarshal(encoded, &again); err != nil {
+			t.Fatalf("Unmarshal() of re-encoded metadata %s error: %v", encoded, err)
+		}
+		reencoded, err := json.Marshal(&again)
+		if err != nil {
+			t.Fatalf("Marshal() error: %v", err)
+		}
+		if !bytes.Equal(encoded, reencoded) {
+			t.Fatalf("Metadata changed in a round trip:\n%s\n%s", encoded, reencoded)
+		}
+	})
+}
@@ -0,0 +1,186 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestMetadataRoundTrip(t *testing.T) {
+	_, serverInfos := newTestServers(t, 3)
+	identity := &Identity{UID: "user", DID: "laptop", BID: "file://notes.txt"}
+
+	result := GenerateEncryptionKey(identity, "password", 10, 0, serverInfos)
+	if result.Error != "" {
+		t.Fatalf("Key generation failed: %s", result.Error)
+	}
+
+	metadata, err := MetadataFromResult(identity, result)
+	if err != nil {
+		t.Fatalf("MetadataFromResult() error: %v", err)
+	}
+
+	encoded, err := json.Marshal(metadata)
+	if err != nil {
+		t.Fatalf("json.Marshal() error: %v", err)
+	}
+
+	var decoded Metadata
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatalf("json.Unmarshal() error: %v", err)
+	}
+
+	// Encoding is stable across a round trip
+	reencoded, err := json.Marshal(&decoded)
+	if err != nil {
+		t.Fatalf("json.Marshal() error: %v", err)
+	}
+	if !bytes.Equal(encoded, reencoded) {
+		t.Errorf("Round trip changed the encoding:\n%s\n%s", encoded, reencoded)
+	}
+
+	// The decoded metadata is enough to recover the key
+	recovered := RecoverEncryptionKeyWithServerInfo(decoded.Identity(identity.UID), "password",
+		MetadataToServerInfo(&decoded), decoded.Threshold, decoded.AuthCodes)
+	if recovered.Error != "" {
+		t.Fatalf("Recovery from metadata failed: %s", recovered.Error)
+	}
+	if !bytes.Equal(recovered.EncryptionKey, result.EncryptionKey) {
+		t.Error("Key recovered from metadata does not match")
+	}
+}
+
+func TestMetadataUnknownFields(t *testing.T) {
+	input := `{"auth_codes":{"base_auth_code":"abc","server_auth_codes":{"https://a":"def"}},` +
+		`"bid":"b","did":"d","future":{"nested":[1,2]},"servers":[{"url":"https://a","public_key":"ed25519:AAAA"}],` +
+		`"threshold":1,"version":1}`
+
+	var metadata Metadata
+	if err := json.Unmarshal([]byte(input), &metadata); err != nil {
+		t.Fatalf("json.Unmarshal() error: %v", err)
+	}
+	if metadata.DID != "d" || metadata.BID != "b" || metadata.Threshold != 1 || len(metadata.Servers) != 1 {
+		t.Errorf("Unexpected decoded metadata: %+v", metadata)
+	}
+
+	encoded, err := json.Marshal(&metadata)
+	if err != nil {
+		t.Fatalf("json.Marshal() error: %v", err)
+	}
+	if string(encoded) != input {
+		t.Errorf("Unknown field not preserved:\ngot  %s\nwant %s", encoded, input)
+	}
+}
+
+func TestMetadataUnmarshalErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantErr string
+	}{
+		{"missing version", `{"did":"d","bid":"b"}`, "missing version"},
+		{"future version", `{"version":2,"did":"d","bid":"b"}`, "unsupported metadata version 2"},
+		{"not an object", `[1,2,3]`, "invalid metadata"},
+		{"wrong type", `{"version":1,"threshold":"two"}`, "invalid metadata"},
+		{"threshold beyond servers", `{"version":1,"servers":[{"url":"https://a"}],"threshold":2}`, "threshold 2"},
+		{"negative threshold", `{"version":1,"threshold":-1}`, "threshold -1"},
+		{"server without url", `{"version":1,"servers":[{"public_key":"ed25519:AAAA"}]}`, "no URL"},
+		{"key length", `{"version":1,"key_length":4096}`, "key length"},
+		{"pin kdf", `{"version":1,"pin_params":{"kdf":"md5"}}`, "unsupported PIN KDF"},
+		{"pin memory", `{"version":1,"pin_params":{"kdf":"argon2id","salt":"AAAA","memory_kib":4294967295}}`, "Argon2id memory"},
+		{"pin time", `{"version":1,"pin_params":{"kdf":"argon2id","salt":"AAAA","time":4294967295}}`, "Argon2id time"},
+		{"negative guess count", `{"version":1,"guess_counts":{"https://a":-1}}`, "negative guess count"},
+		{"key commitment", `{"version":1,"key_commitment":{"salt":"AAAA","mac":"AAAA"}}`, "key commitment"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var metadata Metadata
+			err := json.Unmarshal([]byte(tt.input), &metadata)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("json.Unmarshal() error = %v, want containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMetadataFromResultErrors(t *testing.T) {
+	identity := &Identity{UID: "user", DID: "d", BID: "b"}
+
+	if _, err := MetadataFromResult(nil, &GenerateEncryptionKeyResult{}); err == nil {
+		t.Error("MetadataFromResult(nil identity) expected error")
+	}
+	if _, err := MetadataFromResult(identity, &GenerateEncryptionKeyResult{Error: "failed"}); err == nil {
+		t.Error("MetadataFromResult(failed result) expected error")
+	}
+}
+
+func TestMetadataFingerprint(t *testing.T) {
+	base := func() *Metadata {
+		return &Metadata{
+			Version:   MetadataVersion,
+			DID:       "laptop",
+			BID:       "file://notes.txt",
+			Threshold: 2,
+			Servers: []MetadataServer{
+				{URL: "https://a.example", PublicKey: "ed25519:AAAA"},
+				{URL: "https://b.example"},
+				{URL: "https://c.example"},
+			},
+			AuthCodes:   &AuthCodes{BaseAuthCode: "secret"},
+			GuessCounts: map[string]int{"https://a.example": 0},
+		}
+	}
+	fingerprint := base().Fingerprint()
+
+	same := []struct {
+		name   string
+		modify func(m *Metadata)
+	}{
+		{"servers reordered", func(m *Metadata) {
+			m.Servers[0], m.Servers[2] = m.Servers[2], m.Servers[0]
+		}},
+		{"URL not normalized", func(m *Metadata) { m.Servers[1].URL = "HTTPS://B.example:443/" }},
+		{"other auth codes", func(m *Metadata) { m.AuthCodes = &AuthCodes{BaseAuthCode: "other"} }},
+		{"guesses spent", func(m *Metadata) { m.GuessCounts["https://a.example"] = 3 }},
+		{"explicit defaults", func(m *Metadata) {
+			m.KeyLength = DefaultKeyLength
+			m.PinParams = &PinParams{}
+		}},
+	}
+	for _, tt := range same {
+		t.Run(tt.name, func(t *testing.T) {
+			m := base()
+			tt.modify(m)
+			if got := m.Fingerprint(); got != fingerprint {
+				t.Errorf("Fingerprint() = %s, want %s", got, fingerprint)
+			}
+		})
+	}
+
+	different := []struct {
+		name   string
+		modify func(m *Metadata)
+	}{
+		{"threshold", func(m *Metadata) { m.Threshold = 3 }},
+		{"server replaced", func(m *Metadata) { m.Servers[1].URL = "https://d.example" }},
+		{"server removed", func(m *Metadata) { m.Servers = m.Servers[:2] }},
+		{"public key", func(m *Metadata) { m.Servers[0].PublicKey = "ed25519:BBBB" }},
+		{"DID", func(m *Metadata) { m.DID = "phone" }},
+		{"BID", func(m *Metadata) { m.BID = "file://other.txt" }},
+		{"PIN derivation", func(m *Metadata) { m.PinParams = &PinParams{KDF: PinKDFSHA256} }},
+		{"key length", func(m *Metadata) { m.KeyLength = 16 }},
+		{"identity bound", func(m *Metadata) { m.IdentityBound = true }},
+		{"hash tag", func(m *Metadata) { m.HashDST = "OTHER-DST" }},
+	}
+	for _, tt := range different {
+		t.Run(tt.name, func(t *testing.T) {
+			m := base()
+			tt.modify(m)
+			if m.Fingerprint() == fingerprint {
+				t.Error("Fingerprint() did not change")
+			}
+		})
+	}
+}
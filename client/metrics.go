@@ -0,0 +1,83 @@
+package client
+
+import "time"
+
+// Operation names a top-level operation reported to a MetricsObserver
+type Operation string
+
+// Operations reported to MetricsObserver
+const (
+	OperationGenerate Operation = "generate"
+	OperationRecover  Operation = "recover"
+)
+
+// MetricsObserver is called at key points of key generation and recovery, so that
+// callers can maintain counters and histograms (for example with Prometheus)
+// without this package depending on a metrics library. Methods may be called
+// concurrently from the per-server workers and must not block.
+//
+// Embed NopMetricsObserver to implement only the methods of interest; methods added
+// to this interface in the future will then default to doing nothing.
+type MetricsObserver interface {
+	// OperationStarted is called when a generation or recovery begins
+	OperationStarted(op Operation)
+	// OperationFinished is called when it ends; code is ErrNone on success
+	OperationFinished(op Operation, duration time.Duration, code ErrCode)
+
+	// RequestStarted is called before every JSON-RPC request to a server,
+	// including each retry attempt
+	RequestStarted(server, method string)
+	// RequestFinished is called after the request; err is nil on success
+	RequestFinished(server, method string, duration time.Duration, err error)
+
+	// ThresholdEvaluated is called once the number of servers that accepted a
+	// share (generation) or returned one (recovery) is known. The threshold is
+	// met if succeeded >= threshold.
+	ThresholdEvaluated(op Operation, succeeded, threshold int)
+
+	// GuessesRemaining reports the remaining guesses of the backup on a server,
+	// as seen before a recovery attempt
+	GuessesRemaining(server string, remaining int)
+
+	// ServerLatency reports where the time of a server's registration
+	// (generation) or share recovery (recovery) went
+	ServerLatency(op Operation, server string, latency LatencyBreakdown)
+}
+
+// LatencyBreakdown splits the time spent on one server's request into the Noise-NK
+// handshake and the exchange of the request itself: for recovery, sending the
+// blinded point and receiving its evaluation. Both sum over retry attempts.
+type LatencyBreakdown struct {
+	Handshake time.Duration // Handshakes, including their HTTP round trips
+	Exchange  time.Duration // Encrypted request and response, including encryption and decryption
+	Total     time.Duration // Wall time, at least Handshake + Exchange; includes retry backoff
+}
+
+// NopMetricsObserver is a MetricsObserver that does nothing. It is the default,
+// and can be embedded by partial implementations.
+type NopMetricsObserver struct{}
+
+func (NopMetricsObserver) OperationStarted(Operation)                           {}
+func (NopMetricsObserver) OperationFinished(Operation, time.Duration, ErrCode)  {}
+func (NopMetricsObserver) RequestStarted(string, string)                        {}
+func (NopMetricsObserver) RequestFinished(string, string, time.Duration, error) {}
+func (NopMetricsObserver) ThresholdEvaluated(Operation, int, int)               {}
+func (NopMetricsObserver) GuessesRemaining(string, int)                         {}
+func (NopMetricsObserver) ServerLatency(Operation, string, LatencyBreakdown)    {}
+
+// withTiming returns a shallow copy of the client that adds the handshake and
+// exchange time of its requests to timing. Each call on a shared client takes
+// its own copy, so that concurrent calls neither race nor mix their timings.
+func (c *EncryptedOpenADPClient) withTiming(timing *LatencyBreakdown) *EncryptedOpenADPClient {
+	c2 := *c
+	c2.timing = timing
+	return &c2
+}
+
+// recordTiming fills the latency breakdown of r from timing, and reports it to
+// metrics
+func (r *ServerResult) recordTiming(op Operation, timing LatencyBreakdown, metrics MetricsObserver) {
+	timing.Total = r.Latency
+	r.Handshake, r.Exchange = timing.Handshake, timing.Exchange
+	metrics.ServerLatency(op, r.URL, timing)
+}
@@ -0,0 +1,147 @@
+package client
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/openadp/ocrypt/testserver"
+)
+
+// recordingObserver counts MetricsObserver callbacks by a short description
+type recordingObserver struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func (o *recordingObserver) add(event string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.counts == nil {
+		o.counts = make(map[string]int)
+	}
+	o.counts[event]++
+}
+
+func (o *recordingObserver) OperationStarted(op Operation) { o.add(fmt.Sprintf("start %s", op)) }
+func (o *recordingObserver) OperationFinished(op Operation, _ time.Duration, code ErrCode) {
+	o.add(fmt.Sprintf("finish %s %q", op, code))
+}
+func (o *recordingObserver) RequestStarted(_, method string) { o.add("request " + method) }
+func (o *recordingObserver) RequestFinished(_, method string, _ time.Duration, err error) {
+	o.add(fmt.Sprintf("response %s ok=%v", method, err == nil))
+}
+func (o *recordingObserver) ThresholdEvaluated(op Operation, succeeded, threshold int) {
+	o.add(fmt.Sprintf("threshold %s %d/%d", op, succeeded, threshold))
+}
+func (o *recordingObserver) GuessesRemaining(_ string, remaining int) {
+	o.add(fmt.Sprintf("remaining %d", remaining))
+}
+
+func TestMetricsObserver(t *testing.T) {
+	servers, serverInfos := newTestServers(t, 3)
+	identity := &Identity{UID: "user", DID: "app", BID: "even"}
+
+	observer := &recordingObserver{}
+	opts := &Options{Metrics: observer}
+
+	generated := GenerateEncryptionKeyWithOpts(identity, "password", 10, 0, serverInfos, opts)
+	if generated.Error != "" {
+		t.Fatalf("Key generation failed: %s", generated.Error)
+	}
+
+	servers[0].SetFault("RecoverSecret", testserver.FaultUnavailable)
+	recovered := RecoverEncryptionKeyWithOpts(identity, "password", serverInfos, generated.Threshold, generated.AuthCodes, opts)
+	if recovered.Error != "" {
+		t.Fatalf("Recovery failed: %s", recovered.Error)
+	}
+
+	// A recovery from too few servers reports the missed threshold and its error code
+	wrong := RecoverEncryptionKeyWithOpts(identity, "wrong", serverInfos[1:2], 2, generated.AuthCodes, opts)
+	if wrong.ErrCode != ErrThresholdNotMet {
+		t.Fatalf("ErrCode = %q, want %q", wrong.ErrCode, ErrThresholdNotMet)
+	}
+
+	want := map[string]int{
+		"start generate":                     1,
+		`finish generate ""`:                 1,
+		"threshold generate 3/2":             1,
+		"request RegisterSecret":             3,
+		"response RegisterSecret ok=true":    3,
+		"start recover":                      2,
+		`finish recover ""`:                  1,
+		`finish recover "THRESHOLD_NOT_MET"`: 1,
+		"threshold recover 2/2":              1,
+		"threshold recover 1/2":              1,
+		"request RecoverSecret":              4,
+		"response RecoverSecret ok=true":     3,
+		"response RecoverSecret ok=false":    1,
+		"remaining 10":                       3,
+		"remaining 9":                        1,
+		"latency generate":                   3,
+		"latency recover":                    4,
+	}
+	for event, count := range want {
+		if got := observer.counts[event]; got != count {
+			t.Errorf("%d callbacks %q, want %d", got, event, count)
+		}
+	}
+}
+func (o *recordingObserver) ServerLatency(op Operation, _ string, latency LatencyBreakdown) {
+	o.add(fmt.Sprintf("latency %s", op))
+}
+
+// latencyObserver records the latency breakdowns reported for each server
+type latencyObserver struct {
+	NopMetricsObserver
+	mu        sync.Mutex
+	latencies map[string]LatencyBreakdown
+}
+
+func (o *latencyObserver) ServerLatency(op Operation, server string, latency LatencyBreakdown) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.latencies[server] = latency
+}
+
+func TestServerLatencyBreakdown(t *testing.T) {
+	servers, serverInfos := newTestServers(t, 3)
+	identity := &Identity{UID: "user", DID: "app", BID: "latency"}
+
+	generated := GenerateEncryptionKeyWithOpts(identity, "password", 10, 0, serverInfos, nil)
+	if generated.Error != "" {
+		t.Fatalf("Key generation failed: %s", generated.Error)
+	}
+
+	const handshakeDelay, exchangeDelay = 30 * time.Millisecond, 60 * time.Millisecond
+	servers[0].SetLatency("noise_handshake", handshakeDelay)
+	servers[1].SetLatency("RecoverSecret", exchangeDelay)
+
+	observer := &latencyObserver{latencies: make(map[string]LatencyBreakdown)}
+	recovered := RecoverEncryptionKeyWithOpts(identity, "password", serverInfos, generated.Threshold, generated.AuthCodes, &Options{Metrics: observer})
+	if recovered.Error != "" {
+		t.Fatalf("Recovery failed: %s", recovered.Error)
+	}
+
+	for i, result := range recovered.ServerResults[:generated.Threshold] {
+		if result.Handshake <= 0 || result.Exchange <= 0 {
+			t.Errorf("Server %d: breakdown %v + %v is not populated", i, result.Handshake, result.Exchange)
+		}
+		if result.Handshake+result.Exchange > result.Latency {
+			t.Errorf("Server %d: handshake %v + exchange %v exceed the total %v", i, result.Handshake, result.Exchange, result.Latency)
+		}
+		want := LatencyBreakdown{Handshake: result.Handshake, Exchange: result.Exchange, Total: result.Latency}
+		if got := observer.latencies[result.URL]; got != want {
+			t.Errorf("Server %d: observer saw %+v, want %+v", i, got, want)
+		}
+	}
+
+	// The delays show up in the phase where they happen
+	if slow := recovered.ServerResults[0]; slow.Handshake < handshakeDelay || slow.Exchange >= handshakeDelay {
+		t.Errorf("Slow handshake: breakdown %v + %v, want the handshake to take at least %v", slow.Handshake, slow.Exchange, handshakeDelay)
+	}
+	if slow := recovered.ServerResults[1]; slow.Exchange < exchangeDelay || slow.Handshake >= exchangeDelay {
+		t.Errorf("Slow evaluation: breakdown %v + %v, want the exchange to take at least %v", slow.Handshake, slow.Exchange, exchangeDelay)
+	}
+}
@@ -0,0 +1,131 @@
+package client
+
+import (
+	"context"
+	"fmt"
+)
+
+// MigrateBackupResult is the result of MigrateBackup
+type MigrateBackupResult struct {
+	Error   string  // Why migration failed
+	ErrCode ErrCode // Machine-readable classification of Error
+
+	FromVersion int // Share format version of the backup before migration
+	ToVersion   int // Share format version of the migrated backup
+
+	// Metadata describes the migrated backup, including its ProtocolVersion
+	Metadata *Metadata
+}
+
+// MigrateBackup checks that the backup identified by identity can be served at
+// share format version targetVersion, for servers upgrading to a new protocol
+// version, and returns its Metadata with that version recorded.
+//
+// Only the version check is supported. This client speaks only ProtocolVersion,
+// which is therefore the version of every backup it registered, and the shares
+// the servers hold stay valid under it: migrating to it recovers and registers
+// nothing, spends no guess, and succeeds if at least threshold servers accept
+// the version, failing with ErrInsufficientServers otherwise. Any other target
+// fails with ErrInvalidInput, as there is no migration path to or from a
+// version this client cannot register.
+//
+// MigrateBackup uses default options; backups registered with others migrate
+// through MigrateBackupWithOpts or Metadata.Migrate.
+func MigrateBackup(identity *Identity, serverInfos []ServerInfo, authCodes *AuthCodes, threshold int, targetVersion int) *MigrateBackupResult {
+	return MigrateBackupWithOpts(identity, serverInfos, authCodes, threshold, targetVersion, nil)
+}
+
+// MigrateBackupWithOpts is like MigrateBackup, with the options the backup was
+// registered with. PinParams, KeyLength, BindIdentity and HashDST describe the
+// backup and are recorded in Metadata, and the connection settings apply to
+// every server contacted.
+func MigrateBackupWithOpts(identity *Identity, serverInfos []ServerInfo, authCodes *AuthCodes, threshold int, targetVersion int, opts *Options) *MigrateBackupResult {
+	if err := identity.Validate(); err != nil {
+		return &MigrateBackupResult{ToVersion: targetVersion, Error: err.Error(), ErrCode: ErrInvalidInput}
+	}
+	servers := make([]MetadataServer, len(serverInfos))
+	for i, server := range serverInfos {
+		servers[i] = MetadataServer{URL: server.URL, PublicKey: server.PublicKey}
+	}
+	metadata := &Metadata{
+		Version:   MetadataVersion,
+		DID:       identity.DID,
+		BID:       identity.BID,
+		Servers:   servers,
+		Threshold: threshold,
+		AuthCodes: authCodes,
+		HashDST:   opts.hashDST(),
+	}
+	if opts != nil {
+		metadata.PinParams = opts.PinParams
+		metadata.IdentityBound = opts.BindIdentity
+		if opts.KeyLength != DefaultKeyLength {
+			metadata.KeyLength = opts.KeyLength
+		}
+	}
+	return migrateBackup(serverInfos, targetVersion, metadata, opts)
+}
+
+// Migrate migrates the backup m describes, as MigrateBackupWithOpts does with
+// the options returned by RecoveryOptions. The migrated Metadata is a copy of m
+// that records the new version and keeps everything else, such as the key
+// commitment and the idempotency key.
+func (m *Metadata) Migrate(targetVersion int, opts *Options) *MigrateBackupResult {
+	recoveryOpts, err := m.RecoveryOptions(opts)
+	if err != nil {
+		return &MigrateBackupResult{ToVersion: targetVersion, Error: err.Error(), ErrCode: ErrInvalidInput}
+	}
+	migrated := *m
+	migrated.GuessCounts = make(map[string]int, len(m.GuessCounts))
+	for url, count := range m.GuessCounts {
+		migrated.GuessCounts[url] = count
+	}
+	return migrateBackup(MetadataToServerInfo(m), targetVersion, &migrated, recoveryOpts)
+}
+
+// migrateBackup migrates the backup metadata describes with opts, and on success
+// returns metadata, updated with the new version, as the Metadata of the
+// migrated backup
+func migrateBackup(serverInfos []ServerInfo, targetVersion int, metadata *Metadata, opts *Options) *MigrateBackupResult {
+	fromVersion := metadata.ProtocolVersion
+	if fromVersion == 0 {
+		fromVersion = ProtocolVersion
+	}
+	result := &MigrateBackupResult{FromVersion: fromVersion, ToVersion: targetVersion}
+	if !supportsProtocolVersion(targetVersion) {
+		result.Error = fmt.Sprintf("This client cannot register protocol version %d, only %d", targetVersion, ProtocolVersion)
+		result.ErrCode = ErrInvalidInput
+		return result
+	}
+	if fromVersion != targetVersion {
+		result.Error = fmt.Sprintf("No migration path from protocol version %d to %d", fromVersion, targetVersion)
+		result.ErrCode = ErrInvalidInput
+		return result
+	}
+
+	caps := make([]Capabilities, len(serverInfos))
+	forEachServer(len(serverInfos), opts.maxWorkers(len(serverInfos)), func(i int) {
+		publicKey, err := decodeServerPublicKey(serverInfos[i].PublicKey)
+		if err != nil {
+			caps[i] = unknownCapabilities()
+			return
+		}
+		caps[i] = opts.newClient(context.Background(), serverInfos[i].URL, publicKey).Capabilities()
+	})
+	supported := 0
+	for _, c := range caps {
+		if c.SupportsProtocolVersion(targetVersion) {
+			supported++
+		}
+	}
+	if supported < metadata.Threshold {
+		result.Error = fmt.Sprintf("Only %d of %d servers support protocol version %d, need at least %d", supported, len(serverInfos), targetVersion, metadata.Threshold)
+		result.ErrCode = ErrInsufficientServers
+		return result
+	}
+
+	// The shares the servers hold are already in targetVersion
+	metadata.ProtocolVersion = targetVersion
+	result.Metadata = metadata
+	return result
+}
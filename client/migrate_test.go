@@ -0,0 +1,141 @@
+package client
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestMigrateBackup(t *testing.T) {
+	servers, serverInfos := newTestServers(t, 3)
+	for _, server := range servers {
+		server.SetShareVersion(ProtocolVersion)
+	}
+	identity := &Identity{UID: "user", DID: "phone", BID: "even"}
+	generated := GenerateEncryptionKeyWithOpts(identity, "password", 10, 0, serverInfos, &Options{Threshold: 2})
+	if generated.Error != "" {
+		t.Fatalf("Key generation failed: %s", generated.Error)
+	}
+
+	result := MigrateBackup(identity, serverInfos, generated.AuthCodes, 2, ProtocolVersion)
+	if result.Error != "" {
+		t.Fatalf("Migration failed: %s", result.Error)
+	}
+	if result.FromVersion != ProtocolVersion || result.ToVersion != ProtocolVersion {
+		t.Errorf("Migrated from version %d to %d, want %d to %d", result.FromVersion, result.ToVersion, ProtocolVersion, ProtocolVersion)
+	}
+	if result.Metadata.BID != identity.BID {
+		t.Errorf("Metadata has BID %q, want %q", result.Metadata.BID, identity.BID)
+	}
+	if result.Metadata.ProtocolVersion != ProtocolVersion {
+		t.Errorf("Metadata has ProtocolVersion %d, want %d", result.Metadata.ProtocolVersion, ProtocolVersion)
+	}
+
+	// Migrating to the version the backup is already in spends no guess
+	for i, server := range servers {
+		if got := server.NumGuesses(identity.UID, identity.DID, identity.BID); got != 0 {
+			t.Errorf("Server %d spent %d guesses on a no-op migration, want 0", i, got)
+		}
+	}
+
+	// The migrated backup is the original one and still recovers to its key
+	metadata := result.Metadata
+	recovered := RecoverEncryptionKeyWithOpts(metadata.Identity(identity.UID), "password", MetadataToServerInfo(metadata), metadata.Threshold, metadata.AuthCodes, nil)
+	if recovered.Error != "" {
+		t.Fatalf("Recovery of the migrated backup failed: %s", recovered.Error)
+	}
+	if !bytes.Equal(recovered.EncryptionKey, generated.EncryptionKey) {
+		t.Error("Migrated backup recovered a different key")
+	}
+}
+
+func TestMigrateBackupKeepsOptions(t *testing.T) {
+	opts := &Options{Threshold: 2, PinParams: &PinParams{KDF: PinKDFSHA256, Length: 8}, KeyLength: 48, KeyCommitment: true}
+	tests := []struct {
+		name    string
+		migrate func(identity *Identity, serverInfos []ServerInfo, generated *GenerateEncryptionKeyResult) *MigrateBackupResult
+	}{
+		{"options", func(identity *Identity, serverInfos []ServerInfo, generated *GenerateEncryptionKeyResult) *MigrateBackupResult {
+			return MigrateBackupWithOpts(identity, serverInfos, generated.AuthCodes, 2, ProtocolVersion, opts)
+		}},
+		{"metadata", func(identity *Identity, _ []ServerInfo, generated *GenerateEncryptionKeyResult) *MigrateBackupResult {
+			metadata, err := MetadataFromResult(identity, generated)
+			if err != nil {
+				t.Fatalf("MetadataFromResult: %v", err)
+			}
+			return metadata.Migrate(ProtocolVersion, nil)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, serverInfos := newTestServers(t, 3)
+			identity := &Identity{UID: "user", DID: "phone", BID: "even"}
+			generated := GenerateEncryptionKeyWithOpts(identity, "password", 10, 0, serverInfos, opts)
+			if generated.Error != "" {
+				t.Fatalf("Key generation failed: %s", generated.Error)
+			}
+
+			result := tt.migrate(identity, serverInfos, generated)
+			if result.Error != "" {
+				t.Fatalf("Migration failed: %s", result.Error)
+			}
+			metadata := result.Metadata
+			if !metadata.PinParams.equal(opts.PinParams) || metadata.KeyLength != opts.KeyLength {
+				t.Errorf("Metadata has PinParams %s and key length %d, want %s and %d", metadata.PinParams.describe(), metadata.KeyLength, opts.PinParams.describe(), opts.KeyLength)
+			}
+
+			// The migrated metadata alone recovers the key
+			recoveryOpts, err := metadata.RecoveryOptions(nil)
+			if err != nil {
+				t.Fatalf("RecoveryOptions: %v", err)
+			}
+			recovered := RecoverEncryptionKeyWithOpts(metadata.Identity(identity.UID), "password", MetadataToServerInfo(metadata), metadata.Threshold, metadata.AuthCodes, recoveryOpts)
+			if recovered.Error != "" {
+				t.Fatalf("Recovery of the migrated backup failed: %s", recovered.Error)
+			}
+			if !bytes.Equal(recovered.EncryptionKey, generated.EncryptionKey) {
+				t.Error("Migrated backup recovered a different key")
+			}
+		})
+	}
+}
+
+func TestMigrateBackupUnsupported(t *testing.T) {
+	servers, serverInfos := newTestServers(t, 3)
+	identity := &Identity{UID: "user", DID: "phone", BID: "even"}
+	generated := GenerateEncryptionKeyWithOpts(identity, "password", 10, 0, serverInfos, &Options{Threshold: 2})
+	if generated.Error != "" {
+		t.Fatalf("Key generation failed: %s", generated.Error)
+	}
+
+	// Servers that upgraded to version 2 do not give this client a way to register it
+	for _, server := range servers {
+		server.SetServerInfo("protocol_versions", []int{ProtocolVersion, ProtocolVersion + 1})
+	}
+	result := MigrateBackup(identity, serverInfos, generated.AuthCodes, 2, ProtocolVersion+1)
+	if result.ErrCode != ErrInvalidInput {
+		t.Errorf("Migration to a version this client lacks: ErrCode = %q (%s), want %q", result.ErrCode, result.Error, ErrInvalidInput)
+	}
+
+	metadata, err := MetadataFromResult(identity, generated)
+	if err != nil {
+		t.Fatalf("MetadataFromResult: %v", err)
+	}
+	metadata.ProtocolVersion = ProtocolVersion + 1
+	result = metadata.Migrate(ProtocolVersion, nil)
+	if result.ErrCode != ErrInvalidInput || !strings.Contains(result.Error, "No migration path") {
+		t.Errorf("Migration from version %d: ErrCode = %q (%s), want %q with no migration path", ProtocolVersion+1, result.ErrCode, result.Error, ErrInvalidInput)
+	}
+
+	servers[0].SetServerInfo("protocol_versions", []int{ProtocolVersion + 1})
+	servers[1].SetServerInfo("protocol_versions", []int{ProtocolVersion + 1})
+	result = MigrateBackup(identity, serverInfos, generated.AuthCodes, 2, ProtocolVersion)
+	if result.ErrCode != ErrInsufficientServers {
+		t.Errorf("Migration to a version too few servers accept: ErrCode = %q (%s), want %q", result.ErrCode, result.Error, ErrInsufficientServers)
+	}
+	for i, server := range servers {
+		if got := server.NumGuesses(identity.UID, identity.DID, identity.BID); got != 0 {
+			t.Errorf("Server %d spent %d guesses on a failed migration, want 0", i, got)
+		}
+	}
+}
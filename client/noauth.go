@@ -0,0 +1,105 @@
+package client
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/openadp/ocrypt/debug"
+)
+
+// authCodesRequiredMessage explains why recovery needs the stored auth codes
+const authCodesRequiredMessage = "Auth codes are required: the base auth code is 256 random bits chosen when the backup " +
+	"was registered, and cannot be re-derived from the identity or password. Servers refuse recovery attempts " +
+	"without the code registered with them, so restore the auth codes, or at least the base auth code " +
+	"(see AuthCodesFromBase), from the backup metadata."
+
+// authCodesRejectedMessage explains why the servers refused every reproducible
+// candidate, which they do alike for a wrong password and for random codes
+const authCodesRejectedMessage = "The servers refused the auth codes derived from this password. Either the password " +
+	"is wrong, or the backup was registered with random auth codes, which cannot be re-derived: restore the auth " +
+	"codes, or at least the base auth code (see AuthCodesFromBase), from the backup metadata."
+
+// RecoverWithoutAuthCodes recovers a key when only the servers and threshold of
+// the backup are known, not its auth codes.
+//
+// Auth codes cannot in general be recovered: GenerateAuthCodes draws the base
+// auth code at random, and servers check the code before counting a guess. So
+// this succeeds only for backups whose base auth code is reproducible: codes
+// derived with DeriveAuthCodes (see Options.DeriveAuthCodes) and the fixed code
+// used in debug mode. If the base auth code was kept, derive the codes with
+// AuthCodesFromBase and recover as usual instead.
+//
+// The auth codes derived from a wrong password are wrong too, and servers refuse
+// them exactly as they refuse the candidates for a backup with random codes, so
+// both fail with ErrAuthCodesRejected once the servers have refused every
+// candidate: the password is wrong, or the codes must be restored. Candidate codes
+// that the servers reject cost no guess: a server checks the auth code before it
+// counts the attempt. ErrAuthCodesRequired is returned only if no candidate could
+// be derived at all.
+//
+// RecoverWithoutAuthCodes uses default options; backups registered with others
+// recover through RecoverWithoutAuthCodesWithOpts.
+func RecoverWithoutAuthCodes(identity *Identity, password string, serverInfos []ServerInfo, threshold int) *RecoverEncryptionKeyResult {
+	return RecoverWithoutAuthCodesWithOpts(identity, password, serverInfos, threshold, nil)
+}
+
+// RecoverWithoutAuthCodesWithOpts is like RecoverWithoutAuthCodes, with the
+// options the backup was registered with, as for RecoverEncryptionKeyWithOpts.
+// Each candidate is tried with opts.
+func RecoverWithoutAuthCodesWithOpts(identity *Identity, password string, serverInfos []ServerInfo, threshold int, opts *Options) *RecoverEncryptionKeyResult {
+	if err := identity.Validate(); err != nil {
+		return &RecoverEncryptionKeyResult{Error: err.Error(), ErrCode: ErrInvalidIdentity}
+	}
+	if len(serverInfos) == 0 || threshold < 1 {
+		return &RecoverEncryptionKeyResult{Error: fmt.Sprintf("Servers and a threshold of at least 1 are required, got %d servers and threshold %d", len(serverInfos), threshold), ErrCode: ErrInvalidInput}
+	}
+
+	urls := make([]string, len(serverInfos))
+	for i, serverInfo := range serverInfos {
+		urls[i] = serverInfo.URL
+	}
+
+	var result *RecoverEncryptionKeyResult
+	bases := reproducibleBaseAuthCodes(identity, password)
+	for _, base := range bases {
+		attempt := RecoverEncryptionKeyWithOpts(identity, password, serverInfos, threshold, AuthCodesFromBase(base, urls), opts)
+		if attempt.Error == "" {
+			return attempt
+		}
+		if !authCodesRejected(attempt) {
+			result = attempt
+		}
+	}
+	if result != nil {
+		return result
+	}
+	if len(bases) > 0 {
+		return &RecoverEncryptionKeyResult{Error: authCodesRejectedMessage, ErrCode: ErrAuthCodesRejected, SharesNeeded: threshold}
+	}
+	return &RecoverEncryptionKeyResult{Error: authCodesRequiredMessage, ErrCode: ErrAuthCodesRequired, SharesNeeded: threshold}
+}
+
+// authCodesRejected reports whether every server refused the auth codes of a
+// failed recovery, so that they were not the codes of the backup
+func authCodesRejected(result *RecoverEncryptionKeyResult) bool {
+	for _, serverResult := range result.ServerResults {
+		if !strings.Contains(serverResult.Error, "invalid auth code") {
+			return false
+		}
+	}
+	return len(result.ServerResults) > 0
+}
+
+// reproducibleBaseAuthCodes returns the base auth codes that GenerateAuthCodes
+// could have produced for identity and password without randomness, in the
+// order to try them
+func reproducibleBaseAuthCodes(identity *Identity, password string) []string {
+	var bases []string
+	if debug.IsDebugModeEnabled() {
+		bases = append(bases, debug.GetDeterministicBaseAuthCode())
+	}
+	if derived, err := DeriveAuthCodes(identity, password, nil); err == nil {
+		bases = append(bases, derived.BaseAuthCode)
+	}
+	return bases
+}
@@ -0,0 +1,176 @@
+package client
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/openadp/ocrypt/debug"
+)
+
+func TestRecoverWithoutAuthCodes(t *testing.T) {
+	servers, serverInfos := newTestServers(t, 3)
+
+	// Random auth codes cannot be re-derived, and servers refuse the candidates
+	// before counting a guess
+	identity := &Identity{UID: "user", DID: "app", BID: "random-codes"}
+	generated := GenerateEncryptionKey(identity, "password", 10, 0, serverInfos)
+	if generated.Error != "" {
+		t.Fatalf("Key generation failed: %s", generated.Error)
+	}
+	result := RecoverWithoutAuthCodes(identity, "password", serverInfos, generated.Threshold)
+	if result.ErrCode != ErrAuthCodesRejected {
+		t.Fatalf("ErrCode = %q, want %q (%s)", result.ErrCode, ErrAuthCodesRejected, result.Error)
+	}
+	for i, server := range servers {
+		if got := server.NumGuesses(identity.UID, identity.DID, identity.BID); got != 0 {
+			t.Errorf("Server %d spent %d guesses, want 0", i, got)
+		}
+	}
+
+	// The base auth code alone restores the others
+	if restored := AuthCodesFromBase(generated.AuthCodes.BaseAuthCode, generated.ServerURLs); !reflect.DeepEqual(restored.ServerAuthCodes, generated.AuthCodes.ServerAuthCodes) {
+		t.Errorf("AuthCodesFromBase() = %v, want %v", restored.ServerAuthCodes, generated.AuthCodes.ServerAuthCodes)
+	}
+
+	// In debug mode the base auth code is fixed, so the codes are reproducible
+	debug.SetDebugMode(true)
+	defer debug.SetDebugMode(false)
+	identity = &Identity{UID: "user", DID: "app", BID: "debug-codes"}
+	generated = GenerateEncryptionKey(identity, "password", 10, 0, serverInfos)
+	if generated.Error != "" {
+		t.Fatalf("Key generation failed: %s", generated.Error)
+	}
+	result = RecoverWithoutAuthCodes(identity, "password", serverInfos, generated.Threshold)
+	if result.Error != "" {
+		t.Fatalf("Recovery failed: %s", result.Error)
+	}
+	if !bytes.Equal(result.EncryptionKey, generated.EncryptionKey) {
+		t.Error("Recovered key does not match")
+	}
+}
+
+func TestDeriveAuthCodes(t *testing.T) {
+	urls := []string{"https://a.example.com", "https://b.example.com"}
+	identity := &Identity{UID: "user", DID: "app", BID: "derived"}
+
+	first, err := DeriveAuthCodes(identity, "password", urls)
+	if err != nil {
+		t.Fatalf("DeriveAuthCodes() error: %v", err)
+	}
+	second, err := DeriveAuthCodes(&Identity{UID: "user", DID: "app", BID: "derived"}, "password", urls)
+	if err != nil {
+		t.Fatalf("DeriveAuthCodes() error: %v", err)
+	}
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("DeriveAuthCodes() is not deterministic: %+v, then %+v", first, second)
+	}
+	if len(first.BaseAuthCode) != 64 {
+		t.Errorf("BaseAuthCode has %d hex digits, want 64", len(first.BaseAuthCode))
+	}
+	if first.ServerAuthCodes[urls[0]] == first.ServerAuthCodes[urls[1]] {
+		t.Error("Both servers share an auth code")
+	}
+	if restored := AuthCodesFromBase(first.BaseAuthCode, urls); !reflect.DeepEqual(restored.ServerAuthCodes, first.ServerAuthCodes) {
+		t.Errorf("AuthCodesFromBase() = %v, want %v", restored.ServerAuthCodes, first.ServerAuthCodes)
+	}
+
+	others := []struct {
+		name     string
+		identity *Identity
+		password string
+	}{
+		{"UID", &Identity{UID: "other", DID: "app", BID: "derived"}, "password"},
+		{"DID", &Identity{UID: "user", DID: "other", BID: "derived"}, "password"},
+		{"BID", &Identity{UID: "user", DID: "app", BID: "other"}, "password"},
+		{"field boundary", &Identity{UID: "use", DID: "rapp", BID: "derived"}, "password"},
+		{"password", identity, "other password"},
+	}
+	for _, tt := range others {
+		other, err := DeriveAuthCodes(tt.identity, tt.password, urls)
+		if err != nil {
+			t.Fatalf("DeriveAuthCodes() with another %s error: %v", tt.name, err)
+		}
+		if other.BaseAuthCode == first.BaseAuthCode {
+			t.Errorf("Another %s derives the same base auth code", tt.name)
+		}
+	}
+
+	if _, err := DeriveAuthCodes(&Identity{UID: "user"}, "password", urls); err == nil {
+		t.Error("DeriveAuthCodes() accepted an incomplete identity")
+	}
+}
+
+func TestRecoverWithDerivedAuthCodes(t *testing.T) {
+	_, serverInfos := newTestServers(t, 3)
+	identity := &Identity{UID: "user", DID: "app", BID: "derived-codes"}
+
+	logger := &recordingLogger{}
+	generated := GenerateEncryptionKeyWithOpts(identity, "password", 10, 0, serverInfos, &Options{DeriveAuthCodes: true, Logger: logger})
+	if generated.Error != "" {
+		t.Fatalf("Key generation failed: %s", generated.Error)
+	}
+	if n := logger.count("WARN auth codes derived from the password"); n != 1 {
+		t.Errorf("Logged %d warnings about the guess limit, want 1", n)
+	}
+	derived, err := DeriveAuthCodes(identity, "password", generated.ServerURLs)
+	if err != nil {
+		t.Fatalf("DeriveAuthCodes() error: %v", err)
+	}
+	if !reflect.DeepEqual(generated.AuthCodes.ServerAuthCodes, derived.ServerAuthCodes) {
+		t.Errorf("Generated auth codes %v, want the derived %v", generated.AuthCodes.ServerAuthCodes, derived.ServerAuthCodes)
+	}
+
+	result := RecoverWithoutAuthCodes(identity, "password", serverInfos, generated.Threshold)
+	if result.Error != "" {
+		t.Fatalf("Recovery failed: %s", result.Error)
+	}
+	if !bytes.Equal(result.EncryptionKey, generated.EncryptionKey) {
+		t.Error("Recovered key does not match")
+	}
+}
+
+func TestRecoverWithDerivedAuthCodesOptions(t *testing.T) {
+	_, serverInfos := newTestServers(t, 3)
+	identity := &Identity{UID: "user", DID: "app", BID: "derived-key-length"}
+
+	opts := &Options{KeyLength: 48}
+	generated := GenerateEncryptionKeyWithOpts(identity, "password", 10, 0, serverInfos, &Options{KeyLength: 48, DeriveAuthCodes: true})
+	if generated.Error != "" {
+		t.Fatalf("Key generation failed: %s", generated.Error)
+	}
+
+	result := RecoverWithoutAuthCodesWithOpts(identity, "password", serverInfos, generated.Threshold, opts)
+	if result.Error != "" {
+		t.Fatalf("Recovery failed: %s", result.Error)
+	}
+	if len(result.EncryptionKey) != 48 || !bytes.Equal(result.EncryptionKey, generated.EncryptionKey) {
+		t.Errorf("Recovered a %d-byte key that does not match the %d-byte key generated", len(result.EncryptionKey), len(generated.EncryptionKey))
+	}
+}
+
+func TestRecoverWithDerivedAuthCodesWrongPassword(t *testing.T) {
+	servers, serverInfos := newTestServers(t, 3)
+	identity := &Identity{UID: "user", DID: "app", BID: "derived-wrong-password"}
+
+	generated := GenerateEncryptionKeyWithOpts(identity, "password", 10, 0, serverInfos, &Options{DeriveAuthCodes: true})
+	if generated.Error != "" {
+		t.Fatalf("Key generation failed: %s", generated.Error)
+	}
+
+	// A wrong password derives wrong auth codes, which is not a backup needing
+	// stored codes
+	result := RecoverWithoutAuthCodes(identity, "wrong password", serverInfos, generated.Threshold)
+	if result.ErrCode != ErrAuthCodesRejected {
+		t.Fatalf("ErrCode = %q, want %q (%s)", result.ErrCode, ErrAuthCodesRejected, result.Error)
+	}
+	if !strings.Contains(result.Error, "password is wrong") {
+		t.Errorf("Error = %q, want it to name the password", result.Error)
+	}
+	for i, server := range servers {
+		if got := server.NumGuesses(identity.UID, identity.DID, identity.BID); got != 0 {
+			t.Errorf("Server %d spent %d guesses, want 0", i, got)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/openadp/ocrypt/common"
+)
+
+// ErrNoCommonNoiseSuite is wrapped by errors from encrypted requests to a server
+// that supports none of the cipher suites allowed by NoiseConfig
+var ErrNoCommonNoiseSuite = errors.New("no common Noise cipher suite")
+
+// NoiseConfig selects the Noise handshake used for encrypted requests.
+// A nil *NoiseConfig is valid and selects Noise-NK with DefaultNoiseSuite.
+type NoiseConfig struct {
+	// Pattern is the Noise handshake pattern. Only "NK" is supported; empty
+	// selects it.
+	Pattern string
+
+	// CipherSuites lists the cipher suites the client may use, most preferred
+	// first, by name such as "25519_ChaChaPoly_BLAKE2s" (see common.NoiseSuites).
+	// Empty (the default) allows only common.DefaultNoiseSuite.
+	//
+	// The client uses the first suite that the server advertises in
+	// GetServerInfo. Servers that do not advertise any suite are assumed to
+	// support only common.DefaultNoiseSuite.
+	CipherSuites []string
+
+	// RejectReusedEphemerals fails encrypted requests with ErrReusedEphemeral
+	// when the server answers a handshake with an ephemeral key it has sent
+	// before. Without it such reuse is only logged.
+	//
+	// Noise-NK is forward secret only if both sides draw a fresh ephemeral key
+	// for every handshake; a server that reuses one lets anyone who later learns
+	// that key decrypt every session made with it. The client can only see the
+	// ephemeral keys a server sends to it, so the check is limited to the
+	// handshakes of one EncryptedOpenADPClient and its WithContext copies, which
+	// remember the last 1024 keys. Operations such as GenerateEncryptionKey
+	// create clients of their own; run them through a Pool, which keeps each
+	// server's keys across operations, to detect reuse between them. Servers in
+	// debug mode fix their ephemeral key on purpose, and are rejected too.
+	RejectReusedEphemerals bool
+}
+
+// rejectReusedEphemerals reports whether reused server ephemerals fail requests
+func (c *NoiseConfig) rejectReusedEphemerals() bool {
+	return c != nil && c.RejectReusedEphemerals
+}
+
+// DefaultNoiseSuite is the cipher suite of the original Noise-NK protocol
+const DefaultNoiseSuite = common.DefaultNoiseSuite
+
+// cipherSuites returns the allowed suites, most preferred first
+func (c *NoiseConfig) cipherSuites() []string {
+	if c == nil || len(c.CipherSuites) == 0 {
+		return []string{DefaultNoiseSuite}
+	}
+	return c.CipherSuites
+}
+
+// validate checks that the pattern and every allowed suite are supported
+func (c *NoiseConfig) validate() error {
+	if c == nil {
+		return nil
+	}
+	if c.Pattern != "" && c.Pattern != "NK" {
+		return fmt.Errorf("unsupported Noise pattern %q, only NK is supported", c.Pattern)
+	}
+	for _, suite := range c.CipherSuites {
+		if _, err := common.ParseNoiseSuite(suite); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// onlyDefault reports whether the configuration allows only DefaultNoiseSuite,
+// in which case no negotiation is needed
+func (c *NoiseConfig) onlyDefault() bool {
+	suites := c.cipherSuites()
+	return len(suites) == 1 && suites[0] == DefaultNoiseSuite
+}
+
+// negotiate picks the first allowed suite that the server supports
+func (c *NoiseConfig) negotiate(serverSuites []string) (string, error) {
+	if len(serverSuites) == 0 {
+		serverSuites = []string{DefaultNoiseSuite}
+	}
+	for _, suite := range c.cipherSuites() {
+		for _, supported := range serverSuites {
+			if suite == supported {
+				return suite, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("%w: client allows %v, server supports %v", ErrNoCommonNoiseSuite, c.cipherSuites(), serverSuites)
+}
+
+// serverNoiseSuites extracts the cipher suites advertised in a GetServerInfo result
+func serverNoiseSuites(info map[string]interface{}) []string {
+	list, _ := info["noise_suites"].([]interface{})
+	var suites []string
+	for _, item := range list {
+		if suite, ok := item.(string); ok {
+			suites = append(suites, suite)
+		}
+	}
+	return suites
+}
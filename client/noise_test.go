@@ -0,0 +1,102 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/openadp/ocrypt/testserver"
+)
+
+func TestNoiseConfigNegotiation(t *testing.T) {
+	const chacha = "25519_ChaChaPoly_BLAKE2s"
+
+	tests := []struct {
+		name         string
+		serverSuites []string
+		config       *NoiseConfig
+		wantSuite    string
+		wantErr      error
+	}{
+		{"defaults", nil, nil, DefaultNoiseSuite, nil},
+		{"preferred suite", []string{DefaultNoiseSuite, chacha}, &NoiseConfig{CipherSuites: []string{chacha, DefaultNoiseSuite}}, chacha, nil},
+		{"fallback to default", []string{DefaultNoiseSuite}, &NoiseConfig{CipherSuites: []string{chacha, DefaultNoiseSuite}}, DefaultNoiseSuite, nil},
+		{"server does not advertise", nil, &NoiseConfig{CipherSuites: []string{chacha}}, "", ErrNoCommonNoiseSuite},
+		{"disjoint suites", []string{"25519_AESGCM_SHA512"}, &NoiseConfig{Pattern: "NK", CipherSuites: []string{chacha}}, "", ErrNoCommonNoiseSuite},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := testserver.New()
+			defer server.Close()
+			if tt.serverSuites != nil {
+				server.SetNoiseSuites(tt.serverSuites...)
+			}
+
+			opts := &Options{Noise: tt.config}
+			client := opts.newClient(context.Background(), server.URL, server.PublicKey)
+			_, err := client.Echo("hello", true)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Echo() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Echo() error: %v", err)
+			}
+			if suite, _ := client.negotiateNoiseSuite(); suite != tt.wantSuite {
+				t.Errorf("Negotiated suite %s, want %s", suite, tt.wantSuite)
+			}
+		})
+	}
+}
+
+func TestNoiseConfigRoundTrip(t *testing.T) {
+	servers, serverInfos := newTestServers(t, 3)
+	for _, server := range servers {
+		server.SetNoiseSuites("25519_ChaChaPoly_SHA256")
+	}
+	identity := &Identity{UID: "user", DID: "app", BID: "even"}
+	opts := &Options{Noise: &NoiseConfig{CipherSuites: []string{"25519_ChaChaPoly_SHA256"}}}
+
+	generated := GenerateEncryptionKeyWithExpiry(identity, "password", 10, time.Time{}, serverInfos, opts)
+	if generated.Error != "" {
+		t.Fatalf("Key generation failed: %s", generated.Error)
+	}
+	recovered := RecoverEncryptionKeyWithOpts(identity, "password", serverInfos, generated.Threshold, generated.AuthCodes, opts)
+	if recovered.Error != "" {
+		t.Fatalf("Recovery failed: %s", recovered.Error)
+	}
+	if !bytes.Equal(recovered.EncryptionKey, generated.EncryptionKey) {
+		t.Error("Recovered key does not match")
+	}
+
+	probes := ProbeServers(context.Background(), serverInfos[:1])
+	if got := probes[0].NoiseSuites; len(got) != 1 || got[0] != "25519_ChaChaPoly_SHA256" {
+		t.Errorf("Probe reported suites %v", got)
+	}
+}
+
+func TestNoiseConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  *NoiseConfig
+		wantErr bool
+	}{
+		{"nil", nil, false},
+		{"NK", &NoiseConfig{Pattern: "NK", CipherSuites: []string{"25519_ChaChaPoly_BLAKE2b"}}, false},
+		{"XX pattern", &NoiseConfig{Pattern: "XX"}, true},
+		{"unknown suite", &NoiseConfig{CipherSuites: []string{"448_AESGCM_SHA256"}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.config.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,507 @@
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/openadp/ocrypt/common"
+)
+
+// Options holds optional settings for key generation and recovery.
+// A nil *Options is valid and selects the default for every setting.
+type Options struct {
+	// MaxWorkers bounds how many servers are contacted concurrently.
+	// Zero (the default) uses one worker per server.
+	MaxWorkers int
+
+	// MaxConcurrency bounds how many recovery requests are in flight at once, so
+	// that recovering from a large server set does not open a connection to
+	// every server at the same time. Recovery asks further servers as requests
+	// complete, and cancels the outstanding ones once it holds threshold shares,
+	// or one more with VerifyShares. Zero (the default) selects
+	// DefaultMaxConcurrency.
+	MaxConcurrency int
+
+	// VerifyShares makes recovery ask for one share beyond the threshold, when
+	// another server holds one, so that a corrupted share is detected before the
+	// key is derived. It spends a guess on one more server and waits for its
+	// answer. By default recovery stops at the threshold and checks the key only
+	// against shares that arrived before the outstanding requests were cancelled.
+	VerifyShares bool
+
+	// RawPassword uses the raw UTF-8 bytes of the password as the PIN instead of
+	// normalizing it with PasswordToPin. Only set this to recover backups created
+	// before NFC normalization was introduced.
+	RawPassword bool
+
+	// PinParams selects the derivation of the PIN from the password. Nil (the
+	// default) uses PasswordToPin. Recovery must use the parameters returned in
+	// GenerateEncryptionKeyResult.PinParams, which include any generated salt;
+	// Metadata records them.
+	PinParams *PinParams
+
+	// KeyLength is the length in bytes of the derived encryption key, between
+	// MinKeyLength and MaxKeyLength, for example 16 for AES-128. Zero (the default)
+	// selects DefaultKeyLength. Recovery must use the length of the generated key,
+	// which Metadata records.
+	KeyLength int
+
+	// BindIdentity binds the encryption key to the Identity with DeriveKeyBound,
+	// so that keys of different identities are independent even if the master
+	// keys collide. Recovery must use the same setting as key generation, which
+	// Metadata records.
+	BindIdentity bool
+
+	// KeyCommitment records a KeyCommitment to the generated key in the result,
+	// and from there in Metadata, so that VerifyRecoveredKey can check a
+	// recovered key
+	KeyCommitment bool
+
+	// HashDST is the hash-to-curve domain separation tag under which the identity
+	// and PIN are mapped to a point; see common.HWithDST. Empty (the default)
+	// selects common.ProductionDST, the tag of ProtocolVersion. Other tags are
+	// for experimentation only: the tag changes the derived key, so it must stay
+	// fixed for a protocol version, and recovery must use the tag of key
+	// generation, which Metadata records.
+	HashDST string
+
+	// Threshold is the number of shares required to recover the key. Zero (the
+	// default) selects a majority of the live servers: floor(N/2) + 1.
+	Threshold int
+
+	// MinServers is the fewest servers key generation may register the backup
+	// with. Fewer servers are rejected with ErrInsufficientServers, both in the
+	// provided list and among the live servers. Zero (the default) allows a single
+	// server for compatibility, but a single server holds enough shares to mount an
+	// offline attack on the password, so production deployments should require at
+	// least 2, and preferably 3 so that one server may fail.
+	MinServers int
+
+	// MaxGuessesLimit is the largest guess budget key generation accepts; larger
+	// values are rejected with FieldMaxGuesses. Zero (the default) selects
+	// DefaultMaxGuessesLimit.
+	MaxGuessesLimit int
+
+	// Rand is the source of randomness for the secret, the share polynomial, the
+	// auth codes and the recovery blinding factor. Nil (the default) uses
+	// crypto/rand. Only inject a different reader in tests.
+	Rand io.Reader
+
+	// Retry retries transient server errors with exponential backoff. Nil (the
+	// default) makes a single attempt per request. See RetryPolicy.
+	Retry *RetryPolicy
+
+	// AllowInsecure lets ConvertURLsToServerInfoStrict accept plaintext http://
+	// server URLs. Only set this for local test servers.
+	AllowInsecure bool
+
+	// Logger receives structured events for this call. Nil (the default) uses the
+	// logger set with SetLogger, which discards events unless configured.
+	Logger Logger
+
+	// Metrics is notified of requests, threshold decisions and operation
+	// latencies. Nil (the default) discards them.
+	Metrics MetricsObserver
+
+	// Transport carries the HTTP requests to every server, for example a custom
+	// dialer or, under WebAssembly, a fetch wrapper. Nil (the default) uses a
+	// transport shared by all servers.
+	Transport Transport
+
+	// ProxyURL routes the requests to every server through this proxy, for
+	// example http://proxy.corp:3128 or socks5://127.0.0.1:9050 for Tor. It is
+	// ignored if Transport is set. Nil (the default) honors HTTP_PROXY,
+	// HTTPS_PROXY, ALL_PROXY and NO_PROXY from the environment.
+	ProxyURL *url.URL
+
+	// TLSConfig configures TLS for https:// server URLs, for example RootCAs from
+	// LoadCABundle to trust a private CA, or Certificates for mutual TLS. It
+	// combines with ProxyURL and Timeouts, and is ignored if Transport is set. The
+	// config must not be modified after its first use. Connections are pooled per
+	// config, so reuse one config rather than building one per call. Nil (the
+	// default) verifies servers against the system roots.
+	TLSConfig *tls.Config
+
+	// Timeouts bounds how long each server request and the whole operation may
+	// take. The zero value keeps the defaults: 30 seconds per request and no
+	// overall limit.
+	Timeouts Timeouts
+
+	// StrictConsistency fails recovery with ErrInconsistentServers, before any
+	// guess is spent, if the servers disagree on the backup's max guesses or on
+	// its expiration by more than ExpirationTolerance. By default disagreement is
+	// only reported in RecoverEncryptionKeyResult.Consistency.
+	StrictConsistency bool
+
+	// ExpirationTolerance is the largest difference between the expirations
+	// reported by different servers that still counts as agreement.
+	ExpirationTolerance time.Duration
+
+	// ExpectedGuessCounts maps server URLs to the guess counter recorded for the
+	// backup after the previous recovery; Metadata.RecoveryOptions fills it in.
+	// Recovery reports servers whose counter is now lower in
+	// RecoverEncryptionKeyResult.GuessCounterAnomalies.
+	ExpectedGuessCounts map[string]int
+
+	// ExpectedKeyCommitment is the commitment recorded for the backup's key;
+	// Metadata.RecoveryOptions fills it in. Recovery checks the key against it and
+	// fails with ErrKeyCommitmentMismatch, returning no key, if they differ. Nil
+	// (the default) returns the key unverified.
+	ExpectedKeyCommitment *KeyCommitment
+
+	// Progress receives an event as recovery contacts each server and when the
+	// threshold is met. Nil (the default) reports nothing. See ProgressFunc.
+	Progress ProgressFunc
+
+	// KeyCache returns keys recovered earlier in the session for the same backup
+	// and password without contacting the servers or spending a guess. Nil (the
+	// default) disables caching. See KeyCache.
+	KeyCache *KeyCache
+
+	// BatchConcurrency bounds how many requests of GenerateEncryptionKeyBatch are
+	// processed at once. Zero (the default) uses 8.
+	BatchConcurrency int
+
+	// Noise selects the Noise handshake pattern and the cipher suites allowed for
+	// encrypted requests. Nil (the default) uses Noise-NK with DefaultNoiseSuite,
+	// which every server supports.
+	Noise *NoiseConfig
+
+	// MaxClockSkew tightens how far the timestamp of an encrypted request may be
+	// from the server's clock before servers advertising CapabilityReplayProtection
+	// reject it as stale with ErrReplayDetected. Zero (the default) leaves the
+	// server's own limit; servers never accept a larger skew than their own.
+	MaxClockSkew time.Duration
+
+	// Clock tells the time against which expirations are checked and with which
+	// encrypted requests to servers advertising CapabilityReplayProtection are
+	// stamped. Nil (the default) uses the system clock. Latencies are always
+	// measured with the system clock.
+	Clock Clock
+
+	// DeriveAuthCodes derives the auth codes from the identity and password with
+	// DeriveAuthCodes instead of drawing them at random, so that
+	// RecoverWithoutAuthCodes can recover the key. It removes the guess limit:
+	// anyone who knows the identity can test passwords online without limit, and
+	// a single server can test them offline. Read the warning on DeriveAuthCodes
+	// before enabling it.
+	DeriveAuthCodes bool
+
+	// HealthStore records whether each server answered every request, and how
+	// fast, for SelectServers with SelectHealthiest to consult. Nil (the default)
+	// records nothing.
+	HealthStore ServerHealthStore
+
+	// RateLimiter bounds how fast requests are sent, per server and in total,
+	// delaying or rejecting requests over the limit. Nil (the default) sends
+	// requests as fast as the operation makes them. See RateLimiter.
+	RateLimiter *RateLimiter
+
+	// transportFor returns the transport for one server, overriding transport();
+	// set by Pool
+	transportFor func(url string, publicKey []byte) http.RoundTripper
+
+	// ephemeralsFor returns the log of the ephemeral keys a server has sent,
+	// which outlives the clients of one operation; set by Pool
+	ephemeralsFor func(url string) *ephemeralLog
+
+	// bestEffort makes recovery count the shares still held by reachable servers
+	// before spending guesses; set by BestEffortRecover
+	bestEffort bool
+
+	// explicitPin is the PIN to use instead of deriving one from the password;
+	// set by GenerateEncryptionKeyWithPin and RecoverEncryptionKeyWithPin
+	explicitPin []byte
+
+	// unconfirmed stops recovery before the first guess is spent; set by
+	// RecoverEncryptionKeyGuarded
+	unconfirmed bool
+
+	// session holds the shares collected by earlier attempts, which recovery
+	// combines with the new ones instead of contacting their servers again; set by
+	// ResumeRecovery
+	session *RecoverySession
+}
+
+// Timeouts configures request deadlines
+type Timeouts struct {
+	// PerServer bounds each HTTP request to a server, including connecting.
+	// Zero uses 30 seconds.
+	PerServer time.Duration
+
+	// Overall caps the whole fan-out of a key generation or recovery. When it
+	// expires, outstanding requests are aborted and the operation completes with
+	// the servers that answered in time: it still succeeds if enough of them did,
+	// and fails with ErrTimeout otherwise. Zero means no limit.
+	Overall time.Duration
+}
+
+// allowInsecure reports whether plaintext http:// server URLs are accepted
+func (o *Options) allowInsecure() bool {
+	return o != nil && o.AllowInsecure
+}
+
+// DefaultMaxConcurrency is the number of recovery requests in flight at once
+// when Options.MaxConcurrency is zero
+const DefaultMaxConcurrency = 8
+
+// maxConcurrency returns how many of n servers recovery asks at once
+func (o *Options) maxConcurrency(n int) int {
+	if o == nil || o.MaxConcurrency <= 0 {
+		return min(n, DefaultMaxConcurrency)
+	}
+	return min(n, o.MaxConcurrency)
+}
+
+// maxWorkers returns the worker pool size to use for n servers
+func (o *Options) maxWorkers(n int) int {
+	if o == nil || o.MaxWorkers <= 0 || o.MaxWorkers > n {
+		return n
+	}
+	return o.MaxWorkers
+}
+
+// batchConcurrency returns how many of n batch requests to process at once
+func (o *Options) batchConcurrency(n int) int {
+	if o == nil || o.BatchConcurrency <= 0 {
+		return min(n, 8)
+	}
+	return min(n, o.BatchConcurrency)
+}
+
+// threshold returns the recovery threshold to use for n live servers
+func (o *Options) threshold(n int) int {
+	if o == nil || o.Threshold == 0 {
+		return n/2 + 1 // Standard majority threshold: floor(N/2) + 1
+	}
+	return o.Threshold
+}
+
+// minServers returns the fewest servers key generation may use
+func (o *Options) minServers() int {
+	if o == nil || o.MinServers < 1 {
+		return 1
+	}
+	return o.MinServers
+}
+
+// maxGuessesLimit returns the largest accepted guess budget
+func (o *Options) maxGuessesLimit() int {
+	if o == nil || o.MaxGuessesLimit == 0 {
+		return DefaultMaxGuessesLimit
+	}
+	return o.MaxGuessesLimit
+}
+
+// randReader returns the source of randomness to use
+func (o *Options) randReader() io.Reader {
+	if o == nil || o.Rand == nil {
+		return rand.Reader
+	}
+	return o.Rand
+}
+
+// Encryption key lengths accepted in Options.KeyLength
+const (
+	DefaultKeyLength = 32
+	MinKeyLength     = 16
+	MaxKeyLength     = 64
+)
+
+// keyLength returns the length of the encryption key to derive
+func (o *Options) keyLength() int {
+	if o == nil || o.KeyLength == 0 {
+		return DefaultKeyLength
+	}
+	return o.KeyLength
+}
+
+// hashDST returns the hash-to-curve domain separation tag selected by o
+func (o *Options) hashDST() string {
+	if o == nil || o.HashDST == "" {
+		return common.ProductionDST
+	}
+	return o.HashDST
+}
+
+// validateKeyLength returns why the requested key length is unusable, or ""
+func (o *Options) validateKeyLength() string {
+	if length := o.keyLength(); length < MinKeyLength || length > MaxKeyLength {
+		return fmt.Sprintf("Key length must be between %d and %d bytes, got %d", MinKeyLength, MaxKeyLength, length)
+	}
+	return ""
+}
+
+// keyCache returns the key cache to use, or nil
+func (o *Options) keyCache() *KeyCache {
+	if o == nil {
+		return nil
+	}
+	return o.KeyCache
+}
+
+// logger returns the logger to use for this call
+func (o *Options) logger() Logger {
+	if o == nil || o.Logger == nil {
+		return packageLogger()
+	}
+	return o.Logger
+}
+
+// metrics returns the metrics observer to use for this call
+func (o *Options) metrics() MetricsObserver {
+	if o == nil || o.Metrics == nil {
+		return NopMetricsObserver{}
+	}
+	return o.Metrics
+}
+
+// transport returns the HTTP transport to use for server requests
+func (o *Options) transport() http.RoundTripper {
+	if o.Transport != nil {
+		return o.Transport
+	}
+	if o.TLSConfig != nil {
+		return tlsTransport(o.TLSConfig, o.ProxyURL)
+	}
+	if o.ProxyURL == nil {
+		return defaultTransport
+	}
+	return proxyTransport(o.ProxyURL)
+}
+
+// overallContext returns the context bounding a whole operation
+func (o *Options) overallContext() (context.Context, context.CancelFunc) {
+	if o == nil || o.Timeouts.Overall <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), o.Timeouts.Overall)
+}
+
+// expirationTolerance returns the tolerated expiration difference
+func (o *Options) expirationTolerance() time.Duration {
+	if o == nil {
+		return 0
+	}
+	return o.ExpirationTolerance
+}
+
+// newClient creates a client for the server at url configured according to the
+// options, whose requests are aborted when ctx is done
+func (o *Options) newClient(ctx context.Context, url string, publicKey []byte) *EncryptedOpenADPClient {
+	client := NewEncryptedOpenADPClient(url, publicKey).WithContext(ctx)
+	client.logger = o.logger()
+	client.metrics = o.metrics()
+	if o != nil {
+		client.health = o.HealthStore
+		client.limiter = o.RateLimiter
+		client.RetryPolicy = o.Retry
+		client.noiseConfig = o.Noise
+		client.maxClockSkew = o.MaxClockSkew
+		client.clock = o.Clock
+		client.HTTPClient.Transport = o.transport()
+		if o.transportFor != nil {
+			client.HTTPClient.Transport = o.transportFor(url, publicKey)
+		}
+		if o.ephemeralsFor != nil {
+			client.ephemerals = o.ephemeralsFor(url)
+		}
+		if o.Timeouts.PerServer > 0 {
+			client.HTTPClient.Timeout = o.Timeouts.PerServer
+		}
+	}
+	return client
+}
+
+// passwordToPin derives the PIN for password according to the options
+func (o *Options) passwordToPin(password string) []byte {
+	if o != nil && o.RawPassword {
+		return []byte(password)
+	}
+	return PasswordToPin(password)
+}
+
+// pin derives the PIN for password with params, which may be nil, or returns a
+// copy of the explicit PIN after checking it against params
+func (o *Options) pin(password string, params *PinParams) ([]byte, error) {
+	if o != nil && o.explicitPin != nil {
+		if err := params.checkPin(o.explicitPin); err != nil {
+			return nil, err
+		}
+		return append([]byte(nil), o.explicitPin...), nil
+	}
+	normalized := o.passwordToPin(password)
+	if params == nil {
+		return normalized, nil
+	}
+	defer zeroize(normalized)
+	return derivePin(normalized, params)
+}
+
+// forEachServer calls fn(i) for every i in [0, n) using at most workers
+// concurrent goroutines, and returns once all calls have completed.
+func forEachServer(n, workers int, fn func(i int)) {
+	var wg sync.WaitGroup
+
+	// Limit concurrent workers
+	semaphore := make(chan struct{}, max(workers, 1))
+
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			// Acquire semaphore
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			fn(i)
+		}(i)
+	}
+
+	wg.Wait()
+}
+
+// forEachNeeded calls fn(i) for every i in [0, n) in order, running at most
+// workers calls, and no more than needed() returns, at once. It starts no more
+// calls once needed returns zero or ctx ends, and returns once all calls it
+// started have completed. needed is called with mu held; fn is called without
+// it, and may take it to change what needed returns.
+func forEachNeeded(ctx context.Context, n, workers int, mu *sync.Mutex, needed func() int, fn func(i int)) {
+	var wg sync.WaitGroup
+	finished := sync.NewCond(mu)
+	running := 0
+
+	mu.Lock()
+	for i := 0; i < n && ctx.Err() == nil; {
+		limit := min(needed(), workers)
+		if limit <= 0 {
+			break
+		}
+		if running >= limit {
+			finished.Wait()
+			continue
+		}
+		running++
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			fn(i)
+			mu.Lock()
+			running--
+			finished.Signal()
+			mu.Unlock()
+		}(i)
+		i++
+	}
+	mu.Unlock()
+
+	wg.Wait()
+}
@@ -0,0 +1,216 @@
+package client
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// PinKDF selects how a password is turned into the PIN hashed into the backup's point
+type PinKDF string
+
+const (
+	// PinKDFNone uses the NFC-normalized password bytes as the PIN, as
+	// PasswordToPin does. It is the default.
+	PinKDFNone PinKDF = ""
+
+	// PinKDFSHA256 uses the first Length bytes of SHA-256 of the password
+	PinKDFSHA256 PinKDF = "sha256"
+
+	// PinKDFArgon2id uses Length bytes of Argon2id of the password, which makes
+	// each guess of an offline attack on the registration data costly
+	PinKDFArgon2id PinKDF = "argon2id"
+)
+
+// Argon2id defaults, following the second recommended option of RFC 9106
+const (
+	defaultArgon2Time      = 3
+	defaultArgon2MemoryKiB = 64 * 1024
+	defaultArgon2Threads   = 4
+	argon2SaltSize         = 16
+)
+
+// Upper bounds on the Argon2id parameters. They are read from metadata, which may
+// come from an untrusted file, so they are capped to keep a crafted file from
+// making the client allocate unbounded memory or run for hours. The memory bound
+// admits the first recommended option of RFC 9106, 2 GiB.
+const (
+	maxArgon2Time      = 16
+	maxArgon2MemoryKiB = 2 * 1024 * 1024
+	maxArgon2Threads   = 16
+	maxArgon2SaltSize  = 64
+)
+
+// ErrPinParamsMismatch is returned when the PIN parameters supplied for recovery
+// differ from those recorded in a backup's Metadata. Recovering with different
+// parameters would spend a guess on threshold servers and yield a wrong key.
+var ErrPinParamsMismatch = errors.New("PIN parameters differ from those used at registration")
+
+// PinParams selects the derivation of the PIN from the password. The zero value
+// selects PinKDFNone, the derivation of PasswordToPin. Backups must be recovered
+// with the parameters they were registered with, so they are recorded in Metadata.
+type PinParams struct {
+	KDF PinKDF `json:"kdf"`
+
+	// Length is the PIN length in bytes, between 1 and 64. Zero selects 2 bytes for
+	// PinKDFSHA256 and 32 bytes for PinKDFArgon2id. It must be zero for PinKDFNone.
+	Length int `json:"length,omitempty"`
+
+	// Salt, Time, MemoryKiB and Threads are the Argon2id parameters. Key
+	// generation draws a random salt if none is set; zero costs select 3 passes
+	// over 64 MiB with 4 threads. They are limited to 64 bytes of salt, 16 passes
+	// over 2 GiB and 16 threads.
+	Salt      []byte `json:"salt,omitempty"`
+	Time      uint32 `json:"time,omitempty"`
+	MemoryKiB uint32 `json:"memory_kib,omitempty"`
+	Threads   uint8  `json:"threads,omitempty"`
+}
+
+// PasswordToPinWithParams derives the PIN for password with params. The password is
+// normalized to NFC first, as by PasswordToPin.
+func PasswordToPinWithParams(password string, params PinParams) ([]byte, error) {
+	normalized := PasswordToPin(password)
+	defer zeroize(normalized)
+	return derivePin(normalized, &params)
+}
+
+// derivePin applies the KDF of params, which may be nil, to the normalized password
+func derivePin(password []byte, params *PinParams) ([]byte, error) {
+	if err := params.validate(); err != nil {
+		return nil, err
+	}
+	if params == nil || params.KDF == PinKDFNone {
+		return append([]byte(nil), password...), nil
+	}
+
+	switch params.KDF {
+	case PinKDFSHA256:
+		sum := sha256.Sum256(password)
+		defer zeroize(sum[:])
+		return append([]byte(nil), sum[:params.length()]...), nil
+	case PinKDFArgon2id:
+		if len(params.Salt) == 0 {
+			return nil, errors.New("Argon2id PIN derivation needs the salt recorded at registration")
+		}
+		return argon2.IDKey(password, params.Salt, params.time(), params.memoryKiB(), params.threads(), uint32(params.length())), nil
+	}
+	return nil, fmt.Errorf("unsupported PIN KDF %q", params.KDF)
+}
+
+// validate checks that params describes a supported derivation
+func (p *PinParams) validate() error {
+	if p == nil {
+		return nil
+	}
+	switch p.KDF {
+	case PinKDFNone:
+		if p.Length != 0 || len(p.Salt) != 0 {
+			return errors.New("PIN length and salt require a PIN KDF")
+		}
+	case PinKDFSHA256:
+		if p.Length < 0 || p.Length > sha256.Size {
+			return fmt.Errorf("SHA-256 PIN length must be between 1 and %d bytes, got %d", sha256.Size, p.Length)
+		}
+	case PinKDFArgon2id:
+		if p.Length < 0 || p.Length > 64 {
+			return fmt.Errorf("Argon2id PIN length must be between 1 and 64 bytes, got %d", p.Length)
+		}
+		if len(p.Salt) > maxArgon2SaltSize {
+			return fmt.Errorf("Argon2id salt must be at most %d bytes, got %d", maxArgon2SaltSize, len(p.Salt))
+		}
+		if p.Time > maxArgon2Time {
+			return fmt.Errorf("Argon2id time must be at most %d passes, got %d", maxArgon2Time, p.Time)
+		}
+		if p.MemoryKiB > maxArgon2MemoryKiB {
+			return fmt.Errorf("Argon2id memory must be at most %d KiB, got %d", maxArgon2MemoryKiB, p.MemoryKiB)
+		}
+		if p.Threads > maxArgon2Threads {
+			return fmt.Errorf("Argon2id threads must be at most %d, got %d", maxArgon2Threads, p.Threads)
+		}
+	default:
+		return fmt.Errorf("unsupported PIN KDF %q", p.KDF)
+	}
+	return nil
+}
+
+// checkPin checks that pin, supplied directly instead of a password, has the
+// length params derives
+func (p *PinParams) checkPin(pin []byte) error {
+	if err := p.validate(); err != nil {
+		return err
+	}
+	if p != nil && p.KDF != PinKDFNone && len(pin) != p.length() {
+		return fmt.Errorf("PIN is %d bytes, but %s derives %d bytes", len(pin), p.KDF, p.length())
+	}
+	return nil
+}
+
+// withSalt returns params with a random salt from random if the KDF needs one and
+// none is set
+func (p *PinParams) withSalt(random io.Reader) (*PinParams, error) {
+	if p == nil || p.KDF != PinKDFArgon2id || len(p.Salt) != 0 {
+		return p, nil
+	}
+	salted := *p
+	salted.Salt = make([]byte, argon2SaltSize)
+	if _, err := io.ReadFull(random, salted.Salt); err != nil {
+		return nil, err
+	}
+	return &salted, nil
+}
+
+// equal reports whether p and q select the same derivation; nil equals the zero value
+func (p *PinParams) equal(q *PinParams) bool {
+	var zero PinParams
+	if p == nil {
+		p = &zero
+	}
+	if q == nil {
+		q = &zero
+	}
+	return p.KDF == q.KDF && p.length() == q.length() && bytes.Equal(p.Salt, q.Salt) &&
+		p.time() == q.time() && p.memoryKiB() == q.memoryKiB() && p.threads() == q.threads()
+}
+
+// describe returns a short description of the derivation for error messages
+func (p *PinParams) describe() string {
+	if p == nil || p.KDF == PinKDFNone {
+		return "the default PIN derivation"
+	}
+	return fmt.Sprintf("%s with a %d-byte PIN", p.KDF, p.length())
+}
+
+func (p *PinParams) length() int {
+	switch {
+	case p.Length != 0 || p.KDF == PinKDFNone:
+		return p.Length
+	case p.KDF == PinKDFSHA256:
+		return 2
+	}
+	return 32
+}
+
+func (p *PinParams) time() uint32 {
+	if p.Time == 0 {
+		return defaultArgon2Time
+	}
+	return p.Time
+}
+
+func (p *PinParams) memoryKiB() uint32 {
+	if p.MemoryKiB == 0 {
+		return defaultArgon2MemoryKiB
+	}
+	return p.MemoryKiB
+}
+
+func (p *PinParams) threads() uint8 {
+	if p.Threads == 0 {
+		return defaultArgon2Threads
+	}
+	return p.Threads
+}
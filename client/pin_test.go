@@ -0,0 +1,191 @@
+package client
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPasswordToPinWithParams(t *testing.T) {
+	sum := sha256.Sum256([]byte("password"))
+	salt := []byte("0123456789abcdef")
+
+	tests := []struct {
+		name    string
+		params  PinParams
+		want    []byte
+		wantLen int
+		wantErr bool
+	}{
+		{"default", PinParams{}, PasswordToPin("password"), 8, false},
+		{"sha256 default length", PinParams{KDF: PinKDFSHA256}, sum[:2], 2, false},
+		{"sha256 8 bytes", PinParams{KDF: PinKDFSHA256, Length: 8}, sum[:8], 8, false},
+		{"argon2id", PinParams{KDF: PinKDFArgon2id, Salt: salt, MemoryKiB: 1024}, nil, 32, false},
+		{"argon2id 16 bytes", PinParams{KDF: PinKDFArgon2id, Length: 16, Salt: salt, MemoryKiB: 1024}, nil, 16, false},
+		{"argon2id without salt", PinParams{KDF: PinKDFArgon2id}, nil, 0, true},
+		{"sha256 too long", PinParams{KDF: PinKDFSHA256, Length: 33}, nil, 0, true},
+		{"argon2id too many passes", PinParams{KDF: PinKDFArgon2id, Salt: salt, Time: 1 << 20}, nil, 0, true},
+		{"argon2id too much memory", PinParams{KDF: PinKDFArgon2id, Salt: salt, MemoryKiB: 1 << 30}, nil, 0, true},
+		{"argon2id too many threads", PinParams{KDF: PinKDFArgon2id, Salt: salt, Threads: 255}, nil, 0, true},
+		{"argon2id salt too long", PinParams{KDF: PinKDFArgon2id, Salt: make([]byte, 65)}, nil, 0, true},
+		{"length without KDF", PinParams{Length: 4}, nil, 0, true},
+		{"unknown KDF", PinParams{KDF: "scrypt"}, nil, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pin, err := PasswordToPinWithParams("password", tt.params)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("PasswordToPinWithParams() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(pin) != tt.wantLen {
+				t.Errorf("PIN is %d bytes, want %d", len(pin), tt.wantLen)
+			}
+			if tt.want != nil && !bytes.Equal(pin, tt.want) {
+				t.Errorf("PIN = %x, want %x", pin, tt.want)
+			}
+			again, _ := PasswordToPinWithParams("password", tt.params)
+			if !bytes.Equal(pin, again) {
+				t.Error("PasswordToPinWithParams() is not deterministic")
+			}
+		})
+	}
+
+	first, _ := PasswordToPinWithParams("password", PinParams{KDF: PinKDFArgon2id, Salt: salt, MemoryKiB: 1024})
+	other, _ := PasswordToPinWithParams("password", PinParams{KDF: PinKDFArgon2id, Salt: []byte("fedcba9876543210"), MemoryKiB: 1024})
+	if bytes.Equal(first, other) {
+		t.Error("Argon2id PIN does not depend on the salt")
+	}
+}
+
+func TestPinParamsRecordedInMetadata(t *testing.T) {
+	_, serverInfos := newTestServers(t, 3)
+	identity := &Identity{UID: "user", DID: "app", BID: "argon2"}
+	opts := &Options{PinParams: &PinParams{KDF: PinKDFArgon2id, MemoryKiB: 1024}}
+
+	generated := GenerateEncryptionKeyWithOpts(identity, "password", 10, 0, serverInfos, opts)
+	if generated.Error != "" {
+		t.Fatalf("Key generation failed: %s", generated.Error)
+	}
+	if generated.PinParams == nil || len(generated.PinParams.Salt) != argon2SaltSize {
+		t.Fatalf("Result PinParams = %+v, want a generated salt", generated.PinParams)
+	}
+	if len(opts.PinParams.Salt) != 0 {
+		t.Error("Key generation modified the caller's PinParams")
+	}
+
+	metadata, err := MetadataFromResult(identity, generated)
+	if err != nil {
+		t.Fatalf("MetadataFromResult() error: %v", err)
+	}
+	encoded, _ := json.Marshal(metadata)
+	var decoded Metadata
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatalf("Unmarshal() error: %v", err)
+	}
+
+	recoveryOpts, err := decoded.RecoveryOptions(nil)
+	if err != nil {
+		t.Fatalf("RecoveryOptions() error: %v", err)
+	}
+	recovered := RecoverEncryptionKeyWithOpts(identity, "password", MetadataToServerInfo(&decoded), decoded.Threshold, decoded.AuthCodes, recoveryOpts)
+	if recovered.Error != "" {
+		t.Fatalf("Recovery failed: %s", recovered.Error)
+	}
+	if !bytes.Equal(recovered.EncryptionKey, generated.EncryptionKey) {
+		t.Error("Recovered key does not match")
+	}
+
+	// Mismatched parameters are rejected before any server is contacted
+	mismatches := []*PinParams{
+		{KDF: PinKDFSHA256},
+		{KDF: PinKDFArgon2id, MemoryKiB: 1024},
+		{KDF: PinKDFArgon2id, MemoryKiB: 2048, Salt: generated.PinParams.Salt},
+	}
+	for _, params := range mismatches {
+		if _, err := decoded.RecoveryOptions(&Options{PinParams: params}); !errors.Is(err, ErrPinParamsMismatch) {
+			t.Errorf("RecoveryOptions(%+v) error = %v, want ErrPinParamsMismatch", params, err)
+		}
+	}
+
+	// Argon2id parameters without the recorded salt cannot derive the PIN
+	result := RecoverEncryptionKeyWithOpts(identity, "password", serverInfos, generated.Threshold, generated.AuthCodes, opts)
+	if result.ErrCode != ErrInvalidInput {
+		t.Errorf("Recovery without the salt: ErrCode = %q, want %q", result.ErrCode, ErrInvalidInput)
+	}
+}
+
+func TestExplicitPin(t *testing.T) {
+	_, serverInfos := newTestServers(t, 3)
+	salt := []byte("0123456789abcdef")
+
+	tests := []struct {
+		name   string
+		params *PinParams
+	}{
+		{"default", nil},
+		{"sha256", &PinParams{KDF: PinKDFSHA256, Length: 8}},
+		{"argon2id", &PinParams{KDF: PinKDFArgon2id, Salt: salt, MemoryKiB: 1024}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var params PinParams
+			if tt.params != nil {
+				params = *tt.params
+			}
+			pin, err := PasswordToPinWithParams("password", params)
+			if err != nil {
+				t.Fatalf("PasswordToPinWithParams() error: %v", err)
+			}
+
+			// Generated from the password, recovered from the PIN
+			fromPassword := &Identity{UID: "user", DID: "app", BID: "password-" + tt.name}
+			generated := GenerateEncryptionKeyWithExpiry(fromPassword, "password", 10, time.Time{}, serverInfos, &Options{PinParams: tt.params})
+			if generated.Error != "" {
+				t.Fatalf("Key generation failed: %s", generated.Error)
+			}
+			metadata, _ := MetadataFromResult(fromPassword, generated)
+			opts, _ := metadata.RecoveryOptions(nil)
+			recovered := RecoverEncryptionKeyWithPin(fromPassword, pin, serverInfos, generated.Threshold, generated.AuthCodes, opts)
+			if recovered.Error != "" {
+				t.Fatalf("Recovery with the PIN failed: %s", recovered.Error)
+			}
+			if !bytes.Equal(recovered.EncryptionKey, generated.EncryptionKey) {
+				t.Error("Key recovered with the PIN does not match")
+			}
+
+			// Generated from the PIN, recovered from the password
+			fromPin := &Identity{UID: "user", DID: "app", BID: "pin-" + tt.name}
+			generated = GenerateEncryptionKeyWithPin(fromPin, pin, 10, time.Time{}, serverInfos, &Options{PinParams: tt.params})
+			if generated.Error != "" {
+				t.Fatalf("Key generation with the PIN failed: %s", generated.Error)
+			}
+			metadata, _ = MetadataFromResult(fromPin, generated)
+			opts, _ = metadata.RecoveryOptions(nil)
+			recovered = RecoverEncryptionKeyWithOpts(fromPin, "password", serverInfos, generated.Threshold, generated.AuthCodes, opts)
+			if recovered.Error != "" {
+				t.Fatalf("Recovery with the password failed: %s", recovered.Error)
+			}
+			if !bytes.Equal(recovered.EncryptionKey, generated.EncryptionKey) {
+				t.Error("Key recovered with the password does not match")
+			}
+		})
+	}
+
+	// A PIN of the wrong length is rejected before any server is contacted
+	identity := &Identity{UID: "user", DID: "app", BID: "wrong-length"}
+	opts := &Options{PinParams: &PinParams{KDF: PinKDFArgon2id, Salt: salt}}
+	if result := GenerateEncryptionKeyWithPin(identity, make([]byte, 16), 10, time.Time{}, serverInfos, opts); result.ErrCode != ErrInvalidInput {
+		t.Errorf("Generation with a 16-byte PIN: ErrCode = %q, want %q", result.ErrCode, ErrInvalidInput)
+	}
+	if result := RecoverEncryptionKeyWithPin(identity, make([]byte, 16), serverInfos, 2, &AuthCodes{}, opts); result.ErrCode != ErrInvalidInput {
+		t.Errorf("Recovery with a 16-byte PIN: ErrCode = %q, want %q", result.ErrCode, ErrInvalidInput)
+	}
+}
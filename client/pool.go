@@ -0,0 +1,165 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// PoolConfig bounds the connections a Pool keeps to each server
+type PoolConfig struct {
+	// IdleTimeout closes connections that have been idle this long. Zero (the
+	// default) uses 90 seconds.
+	IdleTimeout time.Duration
+
+	// MaxConnsPerServer limits the connections open to a server at once, idle or
+	// in use; further requests wait for a connection. Zero (the default) means no
+	// limit.
+	MaxConnsPerServer int
+}
+
+// Pool runs generation, recovery and probing against a fixed set of servers over
+// long-lived connections, for applications that register or recover many backups.
+//
+// Each server gets its own HTTP connection pool, keyed by URL and public key, which
+// stays open across operations so that TCP and TLS setup is paid once per
+// connection rather than once per request. Noise-NK sessions cannot be reused: the
+// protocol binds each session to a single encrypted call, so every encrypted
+// request still runs its own handshake. The pool remembers the ephemeral keys
+// each server answers those handshakes with, so that NoiseConfig detects a
+// server reusing one across operations, not just within one.
+//
+// A Pool is safe for concurrent use. Close it when finished.
+type Pool struct {
+	serverInfos []ServerInfo
+	opts        Options
+	config      PoolConfig
+
+	mu         sync.Mutex
+	transports map[poolKey]*http.Transport
+	ephemerals map[string]*ephemeralLog // By server URL
+	closed     bool
+}
+
+// poolKey identifies the connections of one server
+type poolKey struct {
+	url       string
+	publicKey string
+}
+
+// NewPool returns a pool for serverInfos. opts applies to every operation as for
+// GenerateEncryptionKeyWithOpts; a nil config selects the PoolConfig defaults.
+//
+// If opts.Transport is set, or under js/wasm where requests go through fetch, the
+// pool uses that transport for every server unchanged, and config has no effect.
+func NewPool(serverInfos []ServerInfo, opts *Options, config *PoolConfig) *Pool {
+	p := &Pool{
+		serverInfos: append([]ServerInfo(nil), serverInfos...),
+		transports:  make(map[poolKey]*http.Transport),
+		ephemerals:  make(map[string]*ephemeralLog),
+	}
+	if opts != nil {
+		p.opts = *opts
+	}
+	if config != nil {
+		p.config = *config
+	}
+	if _, ok := p.opts.transport().(*http.Transport); ok && p.opts.Transport == nil {
+		p.opts.transportFor = p.transport
+	}
+	p.opts.ephemeralsFor = p.ephemeralLog
+	return p
+}
+
+// Generate is GenerateEncryptionKeyWithExpiry on the pool's servers
+func (p *Pool) Generate(identity *Identity, password string, maxGuesses int, expiresAt time.Time) *GenerateEncryptionKeyResult {
+	if p.isClosed() {
+		return &GenerateEncryptionKeyResult{Error: "Pool is closed", ErrCode: ErrInvalidInput}
+	}
+	return GenerateEncryptionKeyWithExpiry(identity, password, maxGuesses, expiresAt, p.serverInfos, &p.opts)
+}
+
+// Recover is RecoverEncryptionKeyWithOpts on the pool's servers that authCodes
+// holds a code for, which are the servers the backup was registered with
+func (p *Pool) Recover(identity *Identity, password string, threshold int, authCodes *AuthCodes) *RecoverEncryptionKeyResult {
+	if p.isClosed() {
+		return &RecoverEncryptionKeyResult{Error: "Pool is closed", ErrCode: ErrInvalidInput}
+	}
+
+	serverInfos := p.serverInfos
+	if authCodes != nil {
+		serverInfos = nil
+		for _, serverInfo := range p.serverInfos {
+			if _, ok := authCodes.ForServer(serverInfo.URL); ok {
+				serverInfos = append(serverInfos, serverInfo)
+			}
+		}
+	}
+	return RecoverEncryptionKeyWithOpts(identity, password, serverInfos, threshold, authCodes, &p.opts)
+}
+
+// Probe is ProbeServers on the pool's servers, using the pool's connections
+func (p *Pool) Probe(ctx context.Context) []ServerProbe {
+	return probeServers(ctx, p.serverInfos, &p.opts)
+}
+
+// Close closes every idle connection and makes later operations fail.
+// Requests in flight are not interrupted.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.closed = true
+	for key, transport := range p.transports {
+		transport.CloseIdleConnections()
+		delete(p.transports, key)
+	}
+	return nil
+}
+
+// isClosed reports whether Close was called
+func (p *Pool) isClosed() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.closed
+}
+
+// ephemeralLog returns the log of the ephemeral keys the server at url has sent,
+// creating it on first use
+func (p *Pool) ephemeralLog(url string) *ephemeralLog {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	log, ok := p.ephemerals[url]
+	if !ok {
+		log = &ephemeralLog{}
+		p.ephemerals[url] = log
+	}
+	return log
+}
+
+// transport returns the connection pool of a server, creating it on first use
+func (p *Pool) transport(url string, publicKey []byte) http.RoundTripper {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key := poolKey{url: url, publicKey: string(publicKey)}
+	if transport, ok := p.transports[key]; ok {
+		return transport
+	}
+
+	// Inherit the proxy settings of the shared transport
+	transport := p.opts.transport().(*http.Transport).Clone()
+	if p.config.MaxConnsPerServer > 0 {
+		transport.MaxConnsPerHost = p.config.MaxConnsPerServer
+		transport.MaxIdleConnsPerHost = p.config.MaxConnsPerServer
+	}
+	if p.config.IdleTimeout > 0 {
+		transport.IdleConnTimeout = p.config.IdleTimeout
+	}
+	if !p.closed {
+		p.transports[key] = transport
+	}
+	return transport
+}
@@ -0,0 +1,87 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestPool(t *testing.T) {
+	_, serverInfos := newTestServers(t, 3)
+	pool := NewPool(serverInfos, nil, &PoolConfig{IdleTimeout: time.Minute, MaxConnsPerServer: 2})
+	identity := &Identity{UID: "user", DID: "app", BID: "even"}
+
+	generated := pool.Generate(identity, "password", 10, time.Time{})
+	if generated.Error != "" {
+		t.Fatalf("Key generation failed: %s", generated.Error)
+	}
+	recovered := pool.Recover(identity, "password", generated.Threshold, generated.AuthCodes)
+	if recovered.Error != "" {
+		t.Fatalf("Recovery failed: %s", recovered.Error)
+	}
+	if !bytes.Equal(recovered.EncryptionKey, generated.EncryptionKey) {
+		t.Error("Recovered key does not match")
+	}
+	for _, probe := range pool.Probe(context.Background()) {
+		if !probe.Healthy() {
+			t.Errorf("Probe of %s failed: %s", probe.Server.URL, probe.Error)
+		}
+	}
+
+	// One connection pool per server, configured from PoolConfig
+	if len(pool.transports) != len(serverInfos) {
+		t.Errorf("Pool holds %d transports, want %d", len(pool.transports), len(serverInfos))
+	}
+	for key, transport := range pool.transports {
+		if transport.MaxConnsPerHost != 2 || transport.IdleConnTimeout != time.Minute {
+			t.Errorf("Transport for %s: MaxConnsPerHost %d, IdleConnTimeout %v", key.url, transport.MaxConnsPerHost, transport.IdleConnTimeout)
+		}
+	}
+
+	pool.Close()
+	if len(pool.transports) != 0 {
+		t.Error("Close() kept transports")
+	}
+	if result := pool.Generate(identity, "password", 10, time.Time{}); result.Error == "" {
+		t.Error("Generate() after Close() succeeded")
+	}
+	if result := pool.Recover(identity, "password", generated.Threshold, generated.AuthCodes); result.Error == "" {
+		t.Error("Recover() after Close() succeeded")
+	}
+}
+
+func BenchmarkPoolGenerate(b *testing.B) {
+	_, serverInfos := newTestServers(b, 3)
+
+	const batch = 100
+	run := func(b *testing.B, generate func(identity *Identity) *GenerateEncryptionKeyResult) {
+		for i := 0; i < b.N; i++ {
+			for j := 0; j < batch; j++ {
+				identity := &Identity{UID: "user", DID: "app", BID: fmt.Sprintf("bench-%d-%d", i, j)}
+				if result := generate(identity); result.Error != "" {
+					b.Fatalf("Key generation failed: %s", result.Error)
+				}
+			}
+		}
+	}
+
+	b.Run("pooled", func(b *testing.B) {
+		pool := NewPool(serverInfos, nil, nil)
+		defer pool.Close()
+		run(b, func(identity *Identity) *GenerateEncryptionKeyResult {
+			return pool.Generate(identity, "password", 10, time.Time{})
+		})
+	})
+
+	b.Run("unpooled", func(b *testing.B) {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.DisableKeepAlives = true
+		opts := &Options{Transport: transport}
+		run(b, func(identity *Identity) *GenerateEncryptionKeyResult {
+			return GenerateEncryptionKeyWithExpiry(identity, "password", 10, time.Time{}, serverInfos, opts)
+		})
+	})
+}
@@ -0,0 +1,110 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// ServerProbe is the result of probing a single server with ProbeServers
+type ServerProbe struct {
+	Server         ServerInfo    // The server that was probed
+	Reachable      bool          // The server answered the liveness check
+	Latency        time.Duration // Round-trip time of the liveness check
+	Version        string        // Software version reported by GetServerInfo, if any
+	PublicKey      []byte        // Noise-NK public key reported by GetServerInfo, if any; pin it with ServerInfo.WithPublicKey
+	KeyMismatch    bool          // The reported key differs from the configured one
+	NoiseSuites    []string      // Noise cipher suites advertised by GetServerInfo; nil means only DefaultNoiseSuite
+	Idempotent     bool          // The server advertises CapabilityIdempotentRegister
+	Capabilities   Capabilities  // Capabilities advertised by GetServerInfo; see Capabilities.Known
+	HandshakeError string        // Noise-NK handshake failure, empty on success or when no key is configured
+	Error          string        // Liveness failure, empty if Reachable
+}
+
+// Healthy reports whether the server is reachable, reports the configured public key
+// and, if a key is configured, completed a Noise-NK handshake.
+func (p *ServerProbe) Healthy() bool {
+	return p.Reachable && !p.KeyMismatch && p.HandshakeError == ""
+}
+
+// ProbeServers checks every server in parallel and reports, per server, whether it is
+// reachable, how long the liveness check took, which software version it runs and
+// whether a Noise-NK handshake against its configured public key succeeds.
+//
+// Probing does not touch any backup state, so it can be used to pre-filter a large
+// server list (see HealthyServers) before GenerateEncryptionKey. Cancelling ctx aborts
+// outstanding probes; servers that were not probed in time report the context error.
+// Results are returned in the same order as serverInfos.
+func ProbeServers(ctx context.Context, serverInfos []ServerInfo) []ServerProbe {
+	return probeServers(ctx, serverInfos, nil)
+}
+
+// probeServers implements ProbeServers, creating clients according to opts
+func probeServers(ctx context.Context, serverInfos []ServerInfo, opts *Options) []ServerProbe {
+	probes := make([]ServerProbe, len(serverInfos))
+
+	forEachServer(len(serverInfos), len(serverInfos), func(i int) {
+		probes[i] = probeServer(ctx, serverInfos[i], opts)
+	})
+
+	return probes
+}
+
+// probeServer runs the liveness, version and handshake checks against one server
+func probeServer(ctx context.Context, serverInfo ServerInfo, opts *Options) ServerProbe {
+	probe := ServerProbe{Server: serverInfo, Capabilities: unknownCapabilities()}
+
+	publicKey, err := decodeServerPublicKey(serverInfo.PublicKey)
+	if err != nil {
+		probe.Error = fmt.Sprintf("invalid public key: %v", err)
+		return probe
+	}
+
+	client := opts.newClient(ctx, serverInfo.URL, publicKey)
+
+	// Liveness
+	start := time.Now()
+	if err := client.Ping(); err != nil {
+		probe.Error = err.Error()
+		return probe
+	}
+	probe.Latency = time.Since(start)
+	probe.Reachable = true
+
+	// Version and advertised key; older servers may not implement GetServerInfo,
+	// which does not make them unhealthy.
+	if info, err := client.GetServerInfo(); err == nil {
+		probe.Version, _ = info["version"].(string)
+		probe.NoiseSuites = serverNoiseSuites(info)
+		probe.Capabilities = capabilitiesFromInfo(info)
+		probe.Idempotent = probe.Capabilities.Idempotent
+		if reported, _ := info["noise_nk_public_key"].(string); reported != "" {
+			if key, err := decodeServerPublicKey(reported); err == nil {
+				probe.PublicKey = key
+			}
+		}
+	}
+	if publicKey != nil && probe.PublicKey != nil && !SecureEqual(publicKey, probe.PublicKey) {
+		probe.KeyMismatch = true
+	}
+
+	// Handshake against the configured key
+	if publicKey != nil {
+		if _, err := client.Echo("probe", true); err != nil {
+			probe.HandshakeError = err.Error()
+		}
+	}
+
+	return probe
+}
+
+// HealthyServers returns the servers of the probes that are Healthy, in probe order
+func HealthyServers(probes []ServerProbe) []ServerInfo {
+	var healthy []ServerInfo
+	for i := range probes {
+		if probes[i].Healthy() {
+			healthy = append(healthy, probes[i].Server)
+		}
+	}
+	return healthy
+}
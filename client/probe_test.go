@@ -0,0 +1,211 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newMockProbeServer starts a server that answers Echo and GetServerInfo, reporting
+// the given version and base64 Noise-NK key. It does not implement the handshake.
+func newMockProbeServer(t *testing.T, version, noiseKey string, delay time.Duration) string {
+	server := newMockRPCServer(t, func(method string, params []interface{}) (interface{}, error) {
+		time.Sleep(delay)
+		switch method {
+		case "Echo":
+			return params[0], nil
+		case "GetServerInfo":
+			return map[string]interface{}{"version": version, "noise_nk_public_key": noiseKey}, nil
+		}
+		return nil, errors.New("method not found")
+	})
+	return server.URL
+}
+
+func TestProbeServers(t *testing.T) {
+	key := make([]byte, 32)
+	key[0] = 1
+	otherKey := make([]byte, 32)
+	otherKey[0] = 2
+	keyB64 := base64.StdEncoding.EncodeToString(key)
+
+	healthyURL := newMockProbeServer(t, "1.2.3", "", 0)
+	keyedURL := newMockProbeServer(t, "1.2.4", keyB64, 0)
+	mismatchURL := newMockProbeServer(t, "1.2.5", base64.StdEncoding.EncodeToString(otherKey), 0)
+	down := newMockRPCServer(t, nil)
+	down.Close()
+	downURL := down.URL
+
+	servers := []ServerInfo{
+		{URL: healthyURL},
+		{URL: keyedURL, PublicKey: "ed25519:" + keyB64},
+		{URL: mismatchURL, PublicKey: keyB64},
+		{URL: downURL},
+		{URL: healthyURL, PublicKey: "not base64!"},
+	}
+
+	probes := ProbeServers(context.Background(), servers)
+	if len(probes) != len(servers) {
+		t.Fatalf("Expected %d probes, got %d", len(servers), len(probes))
+	}
+
+	tests := []struct {
+		name         string
+		reachable    bool
+		version      string
+		mismatch     bool
+		handshakeErr bool
+		healthy      bool
+	}{
+		{"healthy", true, "1.2.3", false, false, true},
+		{"handshake fails", true, "1.2.4", false, true, false},
+		{"key mismatch", true, "1.2.5", true, true, false},
+		{"unreachable", false, "", false, false, false},
+		{"invalid key", false, "", false, false, false},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			probe := probes[i]
+			if probe.Server.URL != servers[i].URL {
+				t.Errorf("Probe %d is for %s, expected %s", i, probe.Server.URL, servers[i].URL)
+			}
+			if probe.Reachable != tt.reachable {
+				t.Errorf("Expected Reachable=%v, got %v (error: %s)", tt.reachable, probe.Reachable, probe.Error)
+			}
+			if tt.reachable && probe.Latency <= 0 {
+				t.Error("Expected a positive latency for a reachable server")
+			}
+			if !tt.reachable && probe.Error == "" {
+				t.Error("Expected an error for an unreachable server")
+			}
+			if probe.Version != tt.version {
+				t.Errorf("Expected version %q, got %q", tt.version, probe.Version)
+			}
+			if probe.KeyMismatch != tt.mismatch {
+				t.Errorf("Expected KeyMismatch=%v, got %v", tt.mismatch, probe.KeyMismatch)
+			}
+			if (probe.HandshakeError != "") != tt.handshakeErr {
+				t.Errorf("Expected handshake error=%v, got %q", tt.handshakeErr, probe.HandshakeError)
+			}
+			if probe.Healthy() != tt.healthy {
+				t.Errorf("Expected Healthy=%v, got %v", tt.healthy, probe.Healthy())
+			}
+		})
+	}
+
+	healthy := HealthyServers(probes)
+	if len(healthy) != 1 || healthy[0].URL != healthyURL {
+		t.Errorf("Expected only %s to be healthy, got %v", healthyURL, healthy)
+	}
+}
+
+func TestProbeServersContextCancel(t *testing.T) {
+	slowURL := newMockProbeServer(t, "1.0.0", "", 2*time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	probes := ProbeServers(ctx, []ServerInfo{{URL: slowURL}})
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("ProbeServers did not honor context cancellation, took %v", elapsed)
+	}
+	if probes[0].Reachable || probes[0].Error == "" {
+		t.Errorf("Expected cancelled probe to be unreachable with an error, got %+v", probes[0])
+	}
+}
+
+func TestPinnedPublicKey(t *testing.T) {
+	servers, unpinned := newTestServers(t, 3)
+	for i := range unpinned {
+		unpinned[i].PublicKey = ""
+	}
+
+	// Capture the observed keys and pin them
+	serverInfos := make([]ServerInfo, len(unpinned))
+	for i, probe := range ProbeServers(context.Background(), unpinned) {
+		if !probe.Reachable || probe.PublicKey == nil {
+			t.Fatalf("Probe of %s failed: %s", probe.Server.URL, probe.Error)
+		}
+		serverInfos[i] = probe.Server.WithPublicKey(probe.PublicKey)
+		if serverInfos[i].PublicKey != servers[i].PublicKeyString() {
+			t.Errorf("Pinned key = %s, want %s", serverInfos[i].PublicKey, servers[i].PublicKeyString())
+		}
+	}
+
+	identity := &Identity{UID: "user", DID: "app", BID: "even"}
+	generated := GenerateEncryptionKeyWithThreshold(identity, "password", 10, 0, 2, serverInfos)
+	if generated.Error != "" {
+		t.Fatalf("Key generation failed: %s", generated.Error)
+	}
+
+	// Pin the first server to the key of another one
+	serverInfos[0] = serverInfos[0].WithPublicKey(servers[1].PublicKey)
+
+	client := NewEncryptedOpenADPClient(servers[0].URL, servers[1].PublicKey)
+	if _, err := client.Echo("ping", true); !errors.Is(err, ErrPublicKeyMismatch) {
+		t.Errorf("Echo() error = %v, want ErrPublicKeyMismatch", err)
+	}
+
+	// The mismatched server is excluded and the other two still meet the threshold
+	recovered := RecoverEncryptionKeyWithServerInfo(identity, "password", serverInfos, 2, generated.AuthCodes)
+	if recovered.Error != "" {
+		t.Fatalf("Recovery failed: %s", recovered.Error)
+	}
+	if !bytes.Equal(recovered.EncryptionKey, generated.EncryptionKey) {
+		t.Error("Recovered key does not match")
+	}
+	if result := recovered.ServerResults[0]; result.Success || !strings.Contains(result.Error, ErrPublicKeyMismatch.Error()) {
+		t.Errorf("ServerResults[0] = %+v, want public key mismatch", result)
+	}
+	if n := servers[0].NumGuesses(identity.UID, identity.DID, identity.BID); n != 0 {
+		t.Errorf("Mismatched server recorded %d guesses, want 0", n)
+	}
+}
+
+func TestHandshakeErrorClassification(t *testing.T) {
+	servers, _ := newTestServers(t, 1)
+	publicKey := servers[0].PublicKey
+
+	tests := []struct {
+		name         string
+		message      string
+		wantMismatch bool
+	}{
+		{"undecryptable", "handshake failed: failed to read handshake message: cipher: message authentication failed", true},
+		{"rate limited", "rate limit exceeded", false},
+		{"server fault", "internal error", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var request JSONRPCRequest
+				json.NewDecoder(r.Body).Decode(&request)
+				if request.Method != "noise_handshake" {
+					w.Write([]byte(`{"jsonrpc":"2.0","result":{},"id":1}`))
+					return
+				}
+				response, _ := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "error": map[string]interface{}{"code": -32000, "message": tt.message}, "id": request.ID})
+				w.Write(response)
+			}))
+			defer server.Close()
+
+			_, err := NewEncryptedOpenADPClient(server.URL, publicKey).Echo("ping", true)
+			if err == nil {
+				t.Fatal("Echo() succeeded")
+			}
+			if got := errors.Is(err, ErrPublicKeyMismatch); got != tt.wantMismatch {
+				t.Errorf("Echo() error = %v, ErrPublicKeyMismatch %v, want %v", err, got, tt.wantMismatch)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package client
+
+import "fmt"
+
+// Profile describes the security and availability of a t-of-n backup; see
+// SecurityProfile
+type Profile struct {
+	Threshold   int
+	ServerCount int
+
+	// ServersToCompromise is how many servers an attacker must compromise to mount
+	// an offline attack on the password: the threshold
+	ServersToCompromise int
+
+	// ServersToLockout is how many servers must be lost, whether down, shut down or
+	// locked by exhausted guesses, before the key cannot be recovered
+	ServersToLockout int
+
+	// FaultTolerance is how many servers may be lost while the key can still be
+	// recovered: one less than ServersToLockout
+	FaultTolerance int
+
+	// Summary explains the profile in a sentence suitable for a user interface
+	Summary string
+}
+
+// SecurityProfile computes how a backup registered with serverCount servers, any
+// threshold of which recover the key, trades security against availability. A
+// threshold outside 1..serverCount yields a Profile with only Summary set,
+// explaining why the parameters are invalid.
+func SecurityProfile(threshold, serverCount int) Profile {
+	profile := Profile{Threshold: threshold, ServerCount: serverCount}
+	if serverCount < 1 || threshold < 1 || threshold > serverCount {
+		profile.Summary = fmt.Sprintf("%d-of-%d is not a valid configuration: the threshold must be between 1 and the number of servers", threshold, serverCount)
+		return profile
+	}
+
+	profile.ServersToCompromise = threshold
+	profile.FaultTolerance = serverCount - threshold
+	profile.ServersToLockout = profile.FaultTolerance + 1
+
+	profile.Summary = fmt.Sprintf("With %d-of-%d, an attacker must compromise %s to attack your password offline; %s can fail and you lose access once %s unavailable.",
+		threshold, serverCount, countServers(profile.ServersToCompromise),
+		describeTolerance(profile.FaultTolerance), describeLockout(profile.ServersToLockout))
+	return profile
+}
+
+// countServers formats a count of servers
+func countServers(n int) string {
+	if n == 1 {
+		return "1 server"
+	}
+	return fmt.Sprintf("%d servers", n)
+}
+
+// describeTolerance describes how many servers can fail
+func describeTolerance(n int) string {
+	switch n {
+	case 0:
+		return "no server"
+	case 1:
+		return "1 server"
+	}
+	return fmt.Sprintf("up to %d servers", n)
+}
+
+// describeLockout describes how many unavailable servers lock the backup
+func describeLockout(n int) string {
+	if n == 1 {
+		return "any server is"
+	}
+	return fmt.Sprintf("%d servers are", n)
+}
@@ -0,0 +1,36 @@
+package client
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSecurityProfile(t *testing.T) {
+	tests := []struct {
+		threshold, servers                       int
+		wantCompromise, wantLockout, wantFailing int
+		wantSummary                              string
+	}{
+		{1, 1, 1, 1, 0, "With 1-of-1, an attacker must compromise 1 server to attack your password offline; no server can fail and you lose access once any server is unavailable."},
+		{3, 5, 3, 3, 2, "With 3-of-5, an attacker must compromise 3 servers to attack your password offline; up to 2 servers can fail and you lose access once 3 servers are unavailable."},
+		{9, 15, 9, 7, 6, "With 9-of-15, an attacker must compromise 9 servers to attack your password offline; up to 6 servers can fail and you lose access once 7 servers are unavailable."},
+	}
+
+	for _, tt := range tests {
+		profile := SecurityProfile(tt.threshold, tt.servers)
+		if profile.ServersToCompromise != tt.wantCompromise || profile.ServersToLockout != tt.wantLockout || profile.FaultTolerance != tt.wantFailing {
+			t.Errorf("SecurityProfile(%d, %d) = %+v, want %d to compromise, %d to lock out, %d may fail",
+				tt.threshold, tt.servers, profile, tt.wantCompromise, tt.wantLockout, tt.wantFailing)
+		}
+		if profile.Summary != tt.wantSummary {
+			t.Errorf("SecurityProfile(%d, %d).Summary = %q, want %q", tt.threshold, tt.servers, profile.Summary, tt.wantSummary)
+		}
+	}
+
+	for _, invalid := range [][2]int{{0, 3}, {4, 3}, {1, 0}} {
+		profile := SecurityProfile(invalid[0], invalid[1])
+		if profile.ServersToCompromise != 0 || !strings.Contains(profile.Summary, "not a valid configuration") {
+			t.Errorf("SecurityProfile(%d, %d) = %+v, want an invalid profile", invalid[0], invalid[1], profile)
+		}
+	}
+}
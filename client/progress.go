@@ -0,0 +1,81 @@
+package client
+
+import "sync"
+
+// ProgressEventType is the kind of a ProgressEvent
+type ProgressEventType int
+
+// Progress events of recovery
+const (
+	// ServerStarted is reported before a server is asked for its share
+	ServerStarted ProgressEventType = iota
+	// ServerSucceeded is reported when a server returned its share
+	ServerSucceeded
+	// ServerFailed is reported when a server did not return a share
+	ServerFailed
+	// ThresholdMet is reported once, when enough shares have been recovered to
+	// reconstruct the key
+	ThresholdMet
+)
+
+func (t ProgressEventType) String() string {
+	switch t {
+	case ServerStarted:
+		return "ServerStarted"
+	case ServerSucceeded:
+		return "ServerSucceeded"
+	case ServerFailed:
+		return "ServerFailed"
+	case ThresholdMet:
+		return "ThresholdMet"
+	}
+	return "Unknown"
+}
+
+// ProgressEvent describes a step of recovery, for example to show "contacting
+// server 2 of 5" in a user interface
+type ProgressEvent struct {
+	Type ProgressEventType
+
+	// URL, Server and Servers identify the server as the Server-th of Servers
+	// contacted, counting from 1. They are unset for ThresholdMet.
+	URL     string
+	Server  int
+	Servers int
+
+	// Shares is the number of shares recovered so far, of the Threshold needed
+	Shares    int
+	Threshold int
+
+	// Error is why the server failed, for ServerFailed
+	Error string
+}
+
+// ProgressFunc receives the progress events of recovery. Calls never overlap,
+// but may come from different goroutines; the function should return quickly,
+// since recovery waits for it.
+type ProgressFunc func(ProgressEvent)
+
+// progressReporter serializes the calls of a ProgressFunc
+type progressReporter struct {
+	mu sync.Mutex
+	fn ProgressFunc
+}
+
+// progress returns the reporter for the options' ProgressFunc
+func (o *Options) progress() *progressReporter {
+	if o == nil {
+		return &progressReporter{}
+	}
+	return &progressReporter{fn: o.Progress}
+}
+
+// report calls the ProgressFunc, if any, with event
+func (p *progressReporter) report(event ProgressEvent) {
+	if p.fn == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.fn(event)
+}
@@ -0,0 +1,72 @@
+package client
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/openadp/ocrypt/testserver"
+)
+
+func TestRecoverProgress(t *testing.T) {
+	servers, serverInfos := newTestServers(t, 3)
+	identity := &Identity{UID: "user", DID: "app", BID: "progress"}
+
+	generated := GenerateEncryptionKey(identity, "password", 10, 0, serverInfos)
+	if generated.Error != "" {
+		t.Fatalf("Key generation failed: %s", generated.Error)
+	}
+	if generated.Threshold != 2 {
+		t.Fatalf("Threshold = %d, want 2", generated.Threshold)
+	}
+
+	tests := []struct {
+		name   string
+		failed int // Server that refuses RecoverSecret, or -1
+		want   []string
+	}{
+		{"all succeed", -1, []string{
+			"ServerStarted 1/3 0", "ServerSucceeded 1/3 1",
+			"ServerStarted 2/3 1", "ServerSucceeded 2/3 2", "ThresholdMet 0/0 2",
+		}},
+		{"first fails", 0, []string{
+			"ServerStarted 1/3 0", "ServerFailed 1/3 0",
+			"ServerStarted 2/3 0", "ServerSucceeded 2/3 1",
+			"ServerStarted 3/3 1", "ServerSucceeded 3/3 2", "ThresholdMet 0/0 2",
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i, server := range servers {
+				fault := testserver.FaultNone
+				if i == tt.failed {
+					fault = testserver.FaultUnavailable
+				}
+				server.SetFault("RecoverSecret", fault)
+			}
+
+			var events []ProgressEvent
+			// One request at a time, so that the events come in a fixed order
+			opts := &Options{MaxConcurrency: 1, Progress: func(event ProgressEvent) { events = append(events, event) }}
+			result := RecoverEncryptionKeyWithOpts(identity, "password", serverInfos, generated.Threshold, generated.AuthCodes, opts)
+			if result.Error != "" {
+				t.Fatalf("Recovery failed: %s", result.Error)
+			}
+
+			var got []string
+			for _, event := range events {
+				got = append(got, fmt.Sprintf("%s %d/%d %d", event.Type, event.Server, event.Servers, event.Shares))
+				if event.Threshold != 2 {
+					t.Errorf("%s event has Threshold %d, want 2", event.Type, event.Threshold)
+				}
+				if event.Type == ServerFailed && event.Error == "" {
+					t.Error("ServerFailed event has no Error")
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Events = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
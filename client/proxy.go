@@ -0,0 +1,86 @@
+package client
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+// proxyTransports caches one transport per proxy URL, so that clients using the
+// same proxy share a connection pool instead of leaking one each
+var proxyTransports sync.Map // string -> *http.Transport
+
+// proxyTransport returns the shared transport that routes requests through proxy
+func proxyTransport(proxy *url.URL) *http.Transport {
+	if transport, ok := proxyTransports.Load(proxy.String()); ok {
+		return transport.(*http.Transport)
+	}
+	transport, _ := proxyTransports.LoadOrStore(proxy.String(), newProxyTransport(http.ProxyURL(proxy)))
+	return transport.(*http.Transport)
+}
+
+// newProxyTransport returns a copy of http.DefaultTransport using proxy
+func newProxyTransport(proxy func(*http.Request) (*url.URL, error)) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = proxy
+	return transport
+}
+
+// proxyFromEnvironment extends http.ProxyFromEnvironment (HTTP_PROXY, HTTPS_PROXY
+// and NO_PROXY) with ALL_PROXY, which is used for requests those variables do not
+// cover, for example socks5://127.0.0.1:9050 to route through Tor. ALL_PROXY
+// honors the host names and domain suffixes in NO_PROXY and, like
+// http.ProxyFromEnvironment, never proxies requests to localhost.
+func proxyFromEnvironment(req *http.Request) (*url.URL, error) {
+	if proxy, err := http.ProxyFromEnvironment(req); proxy != nil || err != nil {
+		return proxy, err
+	}
+
+	allProxy := os.Getenv("ALL_PROXY")
+	if allProxy == "" {
+		allProxy = os.Getenv("all_proxy")
+	}
+	host := req.URL.Hostname()
+	if allProxy == "" || isLocalHost(host) || matchesNoProxy(host) {
+		return nil, nil
+	}
+	return url.Parse(allProxy)
+}
+
+// matchesNoProxy reports whether host is excluded by NO_PROXY: "*", an exact host
+// name or a domain suffix such as ".example.com". Ports and CIDR ranges in NO_PROXY
+// are not supported here.
+func matchesNoProxy(host string) bool {
+	noProxy := os.Getenv("NO_PROXY")
+	if noProxy == "" {
+		noProxy = os.Getenv("no_proxy")
+	}
+
+	host = strings.ToLower(host)
+	for _, entry := range strings.Split(noProxy, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if entry == "*" {
+			return true
+		}
+		domain := strings.TrimPrefix(entry, ".")
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// isLocalHost reports whether host is localhost or a loopback address
+func isLocalHost(host string) bool {
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
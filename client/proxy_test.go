@@ -0,0 +1,131 @@
+package client
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+)
+
+// newProxyStub starts a forward HTTP proxy that records the hosts it relays to
+func newProxyStub(t *testing.T) (*url.URL, func() map[string]int) {
+	var mu sync.Mutex
+	hosts := make(map[string]int)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hosts[r.URL.Host]++
+		mu.Unlock()
+
+		outbound := r.Clone(r.Context())
+		outbound.RequestURI = ""
+		resp, err := http.DefaultTransport.RoundTrip(outbound)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+	}))
+	t.Cleanup(server.Close)
+
+	proxyURL, _ := url.Parse(server.URL)
+	return proxyURL, func() map[string]int {
+		mu.Lock()
+		defer mu.Unlock()
+		return hosts
+	}
+}
+
+func TestProxyURL(t *testing.T) {
+	_, serverInfos := newTestServers(t, 3)
+	proxyURL, relayed := newProxyStub(t)
+	identity := &Identity{UID: "user", DID: "app", BID: "even"}
+	opts := &Options{ProxyURL: proxyURL}
+
+	generated := GenerateEncryptionKeyWithOpts(identity, "password", 10, 0, serverInfos, opts)
+	if generated.Error != "" {
+		t.Fatalf("Key generation failed: %s", generated.Error)
+	}
+	recovered := RecoverEncryptionKeyWithOpts(identity, "password", serverInfos, generated.Threshold, generated.AuthCodes, opts)
+	if recovered.Error != "" {
+		t.Fatalf("Recovery failed: %s", recovered.Error)
+	}
+	if !bytes.Equal(recovered.EncryptionKey, generated.EncryptionKey) {
+		t.Error("Recovered key does not match")
+	}
+
+	// Every server was reached only through the proxy
+	hosts := relayed()
+	for _, serverInfo := range serverInfos {
+		serverURL, _ := url.Parse(serverInfo.URL)
+		if hosts[serverURL.Host] == 0 {
+			t.Errorf("No requests to %s were relayed by the proxy", serverURL.Host)
+		}
+	}
+}
+
+func TestTransportOverridesProxy(t *testing.T) {
+	_, serverInfos := newTestServers(t, 1)
+	proxyURL, relayed := newProxyStub(t)
+
+	var requests int
+	var mu sync.Mutex
+	transport := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		mu.Lock()
+		requests++
+		mu.Unlock()
+		return http.DefaultTransport.RoundTrip(r)
+	})
+
+	opts := &Options{Transport: transport, ProxyURL: proxyURL}
+	result := GenerateEncryptionKeyWithOpts(&Identity{UID: "user", DID: "app", BID: "even"}, "password", 10, 0, serverInfos, opts)
+	if result.Error != "" {
+		t.Fatalf("Key generation failed: %s", result.Error)
+	}
+	if requests == 0 {
+		t.Error("Custom transport was not used")
+	}
+	if len(relayed()) != 0 {
+		t.Error("Proxy was used although a transport was set")
+	}
+}
+
+func TestProxyFromEnvironment(t *testing.T) {
+	t.Setenv("ALL_PROXY", "socks5://127.0.0.1:9050")
+	t.Setenv("NO_PROXY", ".internal.example")
+
+	tests := []struct {
+		target string
+		want   string
+	}{
+		{"https://server.example.com/", "socks5://127.0.0.1:9050"},
+		{"https://db.internal.example/", ""},
+		{"http://localhost:8080/", ""},
+		{"http://127.0.0.1:8080/", ""},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest("GET", tt.target, nil)
+		proxy, err := proxyFromEnvironment(req)
+		if err != nil {
+			t.Fatalf("proxyFromEnvironment(%s) error: %v", tt.target, err)
+		}
+		got := ""
+		if proxy != nil {
+			got = proxy.String()
+		}
+		if got != tt.want {
+			t.Errorf("proxyFromEnvironment(%s) = %q, want %q", tt.target, got, tt.want)
+		}
+	}
+}
+
+// roundTripFunc adapts a function to http.RoundTripper
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
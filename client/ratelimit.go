@@ -0,0 +1,139 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited is wrapped by the errors of requests that a RateLimiter in
+// RateLimitReject mode refused to send
+var ErrRateLimited = errors.New("request exceeds the client rate limit")
+
+// RateLimitMode selects what a RateLimiter does with a request over the limit
+type RateLimitMode int
+
+const (
+	// RateLimitWait delays the request until the limit allows it, or until the
+	// operation's context ends
+	RateLimitWait RateLimitMode = iota
+	// RateLimitReject fails the request at once with ErrRateLimited
+	RateLimitReject
+)
+
+// RateLimiter bounds how fast this client sends requests, per server and across
+// all servers, so that a caller stuck in a loop cannot trip the servers' abuse
+// protections and lock the user out. Set it in Options.RateLimiter and reuse the
+// same RateLimiter for every operation: its budget carries over between them.
+//
+// Each limit is a token bucket holding up to burst requests, refilled at the
+// given rate. Every JSON-RPC request, including each retry attempt, takes one
+// token from the bucket of its server and one from the global bucket before it
+// is sent. Requests to several servers in parallel draw from the global bucket
+// in turn, so a fan-out proceeds at the global rate. A RateLimiter is safe for
+// concurrent use.
+type RateLimiter struct {
+	perServer float64 // Requests per second to each server, zero for no limit
+	global    float64 // Requests per second to all servers together, zero for no limit
+	burst     float64
+	mode      RateLimitMode
+
+	mu      sync.Mutex
+	servers map[string]*tokenBucket // By normalized URL
+	all     tokenBucket
+	now     func() time.Time // Replaced in tests
+}
+
+// tokenBucket is the state of one limit. Tokens may go negative in
+// RateLimitWait mode: each waiting request reserves the token it will take.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter returns a limiter allowing perServer requests per second to
+// each server and global requests per second in total, either of which may be
+// zero for no limit, in bursts of up to burst requests (at least 1)
+func NewRateLimiter(perServer, global float64, burst int, mode RateLimitMode) *RateLimiter {
+	return &RateLimiter{
+		perServer: math.Max(perServer, 0),
+		global:    math.Max(global, 0),
+		burst:     float64(max(burst, 1)),
+		mode:      mode,
+		servers:   make(map[string]*tokenBucket),
+		now:       time.Now,
+	}
+}
+
+// reserve takes a token for a request to server from each limited bucket, and
+// returns how long the request must wait for them. In RateLimitReject mode it
+// takes nothing and fails if the request would have to wait.
+func (l *RateLimiter) reserve(server string) (time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	url := normalizeServerURL(server)
+	bucket, ok := l.servers[url]
+	if !ok {
+		bucket = &tokenBucket{tokens: l.burst, last: now}
+		l.servers[url] = bucket
+	}
+	if l.all.last.IsZero() {
+		l.all = tokenBucket{tokens: l.burst, last: now}
+	}
+
+	var wait time.Duration
+	if l.perServer > 0 {
+		wait = bucket.refill(now, l.perServer, l.burst)
+	}
+	if l.global > 0 {
+		if globalWait := l.all.refill(now, l.global, l.burst); globalWait > wait {
+			wait = globalWait
+		}
+	}
+	if wait > 0 && l.mode == RateLimitReject {
+		return 0, ErrRateLimited
+	}
+
+	if l.perServer > 0 {
+		bucket.tokens--
+	}
+	if l.global > 0 {
+		l.all.tokens--
+	}
+	return wait, nil
+}
+
+// refill adds the tokens accrued since the last request at rate per second, up
+// to burst, and returns how long until a whole token is available
+func (b *tokenBucket) refill(now time.Time, rate, burst float64) time.Duration {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(burst, b.tokens+elapsed.Seconds()*rate)
+		b.last = now
+	}
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / rate * float64(time.Second))
+}
+
+// wait holds back a request of client until the limiter allows it. A nil
+// limiter allows every request.
+func (l *RateLimiter) wait(client *EncryptedOpenADPClient) error {
+	if l == nil {
+		return nil
+	}
+	delay, err := l.reserve(client.URL)
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, client.URL)
+	}
+	if delay > 0 {
+		if err := client.sleep(delay); err != nil {
+			return fmt.Errorf("waiting for the client rate limit: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/openadp/ocrypt/testserver"
+)
+
+func TestRateLimiterWaits(t *testing.T) {
+	server := testserver.New()
+	defer server.Close()
+
+	// 20 requests per second, one at a time: 6 calls take at least 5 intervals
+	opts := &Options{RateLimiter: NewRateLimiter(20, 0, 1, RateLimitWait)}
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		if _, err := opts.newClient(context.Background(), server.URL, nil).Echo("x", false); err != nil {
+			t.Fatalf("Echo() error: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 5*50*time.Millisecond-10*time.Millisecond {
+		t.Errorf("6 calls at 20 per second took %v, want at least 250ms", elapsed)
+	}
+
+	// A context that ends while waiting aborts the request
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	opts.RateLimiter = NewRateLimiter(1, 0, 1, RateLimitWait)
+	opts.newClient(ctx, server.URL, nil).Ping()
+	if err := opts.newClient(ctx, server.URL, nil).Ping(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Ping() while waiting error = %v, want the context error", err)
+	}
+}
+
+func TestRateLimiterGlobalFanOut(t *testing.T) {
+	_, serverInfos := newTestServers(t, 4)
+
+	ping := func(limiter *RateLimiter) time.Duration {
+		opts := &Options{RateLimiter: limiter}
+		start := time.Now()
+		forEachServer(len(serverInfos), len(serverInfos), func(i int) {
+			if err := opts.newClient(context.Background(), serverInfos[i].URL, nil).Ping(); err != nil {
+				t.Errorf("Ping() error: %v", err)
+			}
+		})
+		return time.Since(start)
+	}
+
+	// One request to each server stays within a per-server limit...
+	if elapsed := ping(NewRateLimiter(20, 0, 1, RateLimitWait)); elapsed > 100*time.Millisecond {
+		t.Errorf("Fan-out under a per-server limit took %v, want no waiting", elapsed)
+	}
+	// ...but the parallel workers share the global one
+	if elapsed := ping(NewRateLimiter(0, 20, 1, RateLimitWait)); elapsed < 3*50*time.Millisecond-10*time.Millisecond {
+		t.Errorf("Fan-out to 4 servers at 20 per second took %v, want at least 150ms", elapsed)
+	}
+}
+
+func TestRateLimiterRejects(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	limiter := NewRateLimiter(1, 0, 2, RateLimitReject)
+	limiter.now = func() time.Time { return now }
+
+	server := testserver.New()
+	defer server.Close()
+	client := (&Options{RateLimiter: limiter}).newClient(context.Background(), server.URL, nil)
+
+	for i := 0; i < 2; i++ {
+		if err := client.Ping(); err != nil {
+			t.Fatalf("Ping() %d within the burst error: %v", i+1, err)
+		}
+	}
+	err := client.Ping()
+	if !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("Ping() over the limit error = %v, want ErrRateLimited", err)
+	}
+	if isTransientError(err) {
+		t.Error("A rate-limited request is retried")
+	}
+
+	// Other servers have their own budget
+	if _, err := limiter.reserve("https://other.example"); err != nil {
+		t.Errorf("reserve() for another server error: %v", err)
+	}
+
+	// A rejected request takes no token, so the next one is due after one interval
+	now = now.Add(999 * time.Millisecond)
+	if err := client.Ping(); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("Ping() before the refill error = %v, want ErrRateLimited", err)
+	}
+	now = now.Add(time.Millisecond)
+	if err := client.Ping(); err != nil {
+		t.Errorf("Ping() after the refill error: %v", err)
+	}
+}
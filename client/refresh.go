@@ -0,0 +1,121 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// CapabilityResetGuesses is listed in the "capabilities" of GetServerInfo by
+// servers that accept ResetGuesses, which sets a backup's guess counter back to
+// zero. Servers cannot tell whether the client knows the password: they grant the
+// reset to any holder of the backup's auth code, so operators should only enable
+// it where auth codes are kept as safe as the data the backup protects.
+const CapabilityResetGuesses = "reset_guesses"
+
+// RefreshGuesses restores the full guess budget of a backup without rotating its
+// key. It recovers the key, which spends one guess on each server asked as any
+// recovery does, passes it to verify and, only if verify accepts it, asks every server
+// holding the backup that advertises CapabilityResetGuesses to reset its counter.
+// It returns the guess counters read afterwards; store them in the backup's
+// Metadata with RecordRefresh, or the next recovery through RecoveryOptions
+// reports the reset counters as having moved backwards.
+//
+// The verify argument is required, and is what keeps a wrong password from
+// resetting the counters. Recovery with a wrong password still yields a key, just
+// the wrong one, so only the caller can tell whether the password was right:
+// verify should check the key, for example by decrypting data protected with it
+// or with VerifyRecoveredKey, and return an error if it does not match. The key is
+// zeroed once verify returns, so verify must not keep it. A rejected key spends no
+// more than the recovery attempt itself.
+//
+// RefreshGuesses fails before spending any guess if fewer than threshold servers
+// support the reset. Servers without the capability keep their counters, which
+// the returned status shows. An error is also returned, with the status, if fewer
+// than threshold servers reset their counter.
+//
+// RefreshGuesses recovers with default options; backups registered with others
+// are refreshed through RefreshGuessesWithOpts.
+func RefreshGuesses(identity *Identity, password string, serverInfos []ServerInfo, threshold int, authCodes *AuthCodes,
+	verify func(key []byte) error) (*BackupStatus, error) {
+	return RefreshGuessesWithOpts(identity, password, serverInfos, threshold, authCodes, verify, nil)
+}
+
+// RefreshGuessesWithOpts is like RefreshGuesses, recovering the key with opts,
+// which must select the PIN parameters, key length, identity binding and
+// hash-to-curve tag of the backup, as returned by Metadata.RecoveryOptions. The
+// connection settings of opts apply to every server contacted.
+func RefreshGuessesWithOpts(identity *Identity, password string, serverInfos []ServerInfo, threshold int, authCodes *AuthCodes,
+	verify func(key []byte) error, opts *Options) (*BackupStatus, error) {
+	if err := identity.Validate(); err != nil {
+		return nil, err
+	}
+	if verify == nil {
+		return nil, errors.New("a key verification function is required")
+	}
+	if authCodes == nil {
+		return nil, errors.New("auth codes are required")
+	}
+
+	// Check the capability first, so an unsupported refresh spends no guess
+	clients := make([]*EncryptedOpenADPClient, len(serverInfos))
+	forEachServer(len(serverInfos), len(serverInfos), func(i int) {
+		if _, ok := authCodes.ForServer(serverInfos[i].URL); !ok {
+			return
+		}
+		publicKey, err := decodeServerPublicKey(serverInfos[i].PublicKey)
+		if err != nil || publicKey == nil {
+			return // The reset must not be sent in the clear
+		}
+		client := opts.newClient(context.Background(), serverInfos[i].URL, publicKey)
+		if client.Capabilities().ResetGuesses {
+			clients[i] = client
+		}
+	})
+	supported := 0
+	for _, client := range clients {
+		if client != nil {
+			supported++
+		}
+	}
+	if supported < threshold {
+		return nil, fmt.Errorf("only %d of %d servers support resetting the guess counter, need at least %d", supported, len(serverInfos), threshold)
+	}
+
+	result := RecoverEncryptionKeyWithOpts(identity, password, serverInfos, threshold, authCodes, opts)
+	if result.Error != "" {
+		return nil, fmt.Errorf("recovery failed: %s", result.Error)
+	}
+	err := verify(result.EncryptionKey)
+	zeroize(result.EncryptionKey)
+	if err != nil {
+		return nil, fmt.Errorf("recovered key rejected, guess counters not reset: %w", err)
+	}
+
+	errs := make([]error, len(serverInfos))
+	forEachServer(len(serverInfos), len(serverInfos), func(i int) {
+		if clients[i] == nil {
+			return
+		}
+		url := serverInfos[i].URL
+		authCode, _ := authCodes.ForServer(url)
+		if err := clients[i].ResetGuesses(authCode, identity.UID, identity.DID, identity.BID, true, nil); err != nil {
+			errs[i] = fmt.Errorf("%s: %w", url, err)
+		}
+	})
+	reset := supported
+	for _, err := range errs {
+		if err != nil {
+			reset--
+		}
+	}
+
+	status, statusErr := queryBackupStatus(identity, serverInfos, authCodes, opts)
+	if reset < threshold {
+		return status, fmt.Errorf("only %d servers reset the guess counter, need at least %d: %w", reset, threshold, errors.Join(errs...))
+	}
+	if statusErr != nil {
+		return nil, statusErr
+	}
+	return status, nil
+}
@@ -0,0 +1,177 @@
+package client
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRefreshGuesses(t *testing.T) {
+	servers, serverInfos := newTestServers(t, 3)
+
+	tests := []struct {
+		name      string
+		capable   int // Servers advertising CapabilityResetGuesses
+		password  string
+		wantErr   string
+		wantSpent []int // Guess counter of each server afterwards
+	}{
+		{"all capable", 3, "password", "", []int{0, 0, 0}},
+		{"threshold capable", 2, "password", "", []int{0, 0, 2}},
+		{"too few capable", 1, "password", "only 1 of 3 servers", []int{2, 2, 2}},
+		{"wrong password", 3, "wrong", "rejected", []int{3, 3, 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identity := &Identity{UID: "user", DID: "app", BID: strings.ReplaceAll(tt.name, " ", "-")}
+			generated := GenerateEncryptionKey(identity, "password", 10, 0, serverInfos)
+			if generated.Error != "" {
+				t.Fatalf("Key generation failed: %s", generated.Error)
+			}
+			for i, server := range servers {
+				server.SetNumGuesses(identity.UID, identity.DID, identity.BID, 2)
+				if i < tt.capable {
+					server.SetCapabilities(CapabilityResetGuesses)
+				} else {
+					server.SetCapabilities()
+				}
+			}
+
+			verify := func(key []byte) error {
+				if !bytes.Equal(key, generated.EncryptionKey) {
+					return errors.New("wrong key")
+				}
+				return nil
+			}
+			status, err := RefreshGuesses(identity, tt.password, serverInfos, generated.Threshold, generated.AuthCodes, verify)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("RefreshGuesses() error = %v, want %q", err, tt.wantErr)
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("RefreshGuesses() error: %v", err)
+				}
+				for i, server := range status.Servers {
+					if server.NumGuesses != tt.wantSpent[i] {
+						t.Errorf("Status of server %d: NumGuesses = %d, want %d", i, server.NumGuesses, tt.wantSpent[i])
+					}
+				}
+			}
+
+			for i, server := range servers {
+				if got := server.NumGuesses(identity.UID, identity.DID, identity.BID); got != tt.wantSpent[i] {
+					t.Errorf("Server %d: guess counter = %d, want %d", i, got, tt.wantSpent[i])
+				}
+			}
+		})
+	}
+
+	identity := &Identity{UID: "user", DID: "app", BID: "no-verify"}
+	if _, err := RefreshGuesses(identity, "password", serverInfos, 2, &AuthCodes{}, nil); err == nil {
+		t.Error("RefreshGuesses() without a verification function succeeded")
+	}
+}
+
+func TestRefreshGuessesThenRecover(t *testing.T) {
+	tests := []struct {
+		name          string
+		record        bool // Store the refreshed counters with RecordRefresh
+		wantAnomalies bool
+	}{
+		{"recorded", true, false},
+		{"not recorded", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			servers, serverInfos := newTestServers(t, 3)
+			for _, server := range servers {
+				server.SetCapabilities(CapabilityResetGuesses)
+			}
+			identity := &Identity{UID: "user", DID: "app", BID: "refreshed"}
+			generated := GenerateEncryptionKey(identity, "password", 10, 0, serverInfos)
+			if generated.Error != "" {
+				t.Fatalf("Key generation failed: %s", generated.Error)
+			}
+			metadata, _ := MetadataFromResult(identity, generated)
+			recover := func() *RecoverEncryptionKeyResult {
+				t.Helper()
+				opts, err := metadata.RecoveryOptions(nil)
+				if err != nil {
+					t.Fatalf("RecoveryOptions() error: %v", err)
+				}
+				result := RecoverEncryptionKeyWithOpts(identity, "password", MetadataToServerInfo(metadata), metadata.Threshold, metadata.AuthCodes, opts)
+				if result.Error != "" {
+					t.Fatalf("Recovery failed: %s", result.Error)
+				}
+				metadata.RecordGuessCounts(result)
+				return result
+			}
+			recover()
+
+			verify := func(key []byte) error { return nil }
+			status, err := RefreshGuesses(identity, "password", serverInfos, generated.Threshold, generated.AuthCodes, verify)
+			if err != nil {
+				t.Fatalf("RefreshGuesses() error: %v", err)
+			}
+			if tt.record {
+				metadata.RecordRefresh(status)
+				for url, count := range metadata.GuessCounts {
+					if count != 0 {
+						t.Errorf("Recorded counter of %s = %d, want 0", url, count)
+					}
+				}
+			}
+
+			result := recover()
+			if got := len(result.GuessCounterAnomalies) != 0; got != tt.wantAnomalies {
+				t.Errorf("Anomalies = %+v, want some: %v", result.GuessCounterAnomalies, tt.wantAnomalies)
+			}
+		})
+	}
+}
+
+func TestRefreshGuessesWithOpts(t *testing.T) {
+	servers, serverInfos := newTestServers(t, 3)
+	for _, server := range servers {
+		server.SetCapabilities(CapabilityResetGuesses)
+	}
+	identity := &Identity{UID: "user", DID: "app", BID: "options"}
+	opts := &Options{PinParams: &PinParams{KDF: PinKDFSHA256, Length: 8}, KeyLength: 16, KeyCommitment: true}
+	generated := GenerateEncryptionKeyWithOpts(identity, "password", 10, 0, serverInfos, opts)
+	if generated.Error != "" {
+		t.Fatalf("Key generation failed: %s", generated.Error)
+	}
+	metadata, err := MetadataFromResult(identity, generated)
+	if err != nil {
+		t.Fatalf("MetadataFromResult() error: %v", err)
+	}
+	verify := func(key []byte) error {
+		if !VerifyRecoveredKey(key, *metadata) {
+			return errors.New("wrong key")
+		}
+		return nil
+	}
+
+	// Default options derive another key, which verify rejects
+	if _, err := RefreshGuesses(identity, "password", serverInfos, generated.Threshold, generated.AuthCodes, verify); err == nil || !strings.Contains(err.Error(), "rejected") {
+		t.Errorf("RefreshGuesses() with default options: error = %v, want a rejected key", err)
+	}
+
+	recoveryOpts, err := metadata.RecoveryOptions(nil)
+	if err != nil {
+		t.Fatalf("RecoveryOptions() error: %v", err)
+	}
+	status, err := RefreshGuessesWithOpts(identity, "password", serverInfos, generated.Threshold, generated.AuthCodes, verify, recoveryOpts)
+	if err != nil {
+		t.Fatalf("RefreshGuessesWithOpts() error: %v", err)
+	}
+	for _, server := range status.Servers {
+		if server.NumGuesses != 0 {
+			t.Errorf("Status of %s: NumGuesses = %d, want 0", server.URL, server.NumGuesses)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package client
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// registrySignatureContext is prepended to the payload before signing, so that a
+// registry signature cannot be replayed as a signature over anything else
+const registrySignatureContext = "OpenADP-Registry-v1\x00"
+
+// maxRegistrySize bounds the size of a downloaded registry document
+const maxRegistrySize = 1 << 20
+
+// SignedRegistry is the document served by a signed server registry. Payload is
+// the JSON encoding of a ServersResponse; Signature is an Ed25519 signature over
+// the payload, prefixed with a fixed context string.
+type SignedRegistry struct {
+	Payload   string `json:"payload"`   // Base64-encoded ServersResponse JSON
+	Signature string `json:"signature"` // Base64-encoded Ed25519 signature
+}
+
+// RegistryOptions configures FetchServerRegistry
+type RegistryOptions struct {
+	// PublicKey verifies the registry signature. It is required; unsigned or
+	// wrongly signed registries are rejected.
+	PublicKey ed25519.PublicKey
+
+	// MinProtocolVersion drops servers that accept no share format version at
+	// least this high, as advertised in the "protocol_versions" of their
+	// GetServerInfo response. Setting it queries every listed server, and drops
+	// those that do not answer GetServerInfo. Zero keeps all versions.
+	MinProtocolVersion int
+
+	// Countries keeps only servers located in one of these countries (compared
+	// case-insensitively). Empty keeps all countries.
+	Countries []string
+
+	// RequireTags keeps only servers that carry every one of these tags, for
+	// example jurisdiction tags. Empty keeps all servers.
+	RequireTags []string
+
+	// Probe drops servers that fail ProbeServers, so that servers listed in the
+	// registry but unreachable from this client are not returned.
+	Probe bool
+
+	// AllowInsecure accepts plaintext http:// server URLs in the registry. Only
+	// set this for local test registries.
+	AllowInsecure bool
+}
+
+// SignServerRegistry builds the signed registry document for servers, for use by
+// registry publishers and in tests
+func SignServerRegistry(privateKey ed25519.PrivateKey, servers []ServerInfo) ([]byte, error) {
+	payload, err := json.Marshal(ServersResponse{Servers: servers})
+	if err != nil {
+		return nil, err
+	}
+	signature := ed25519.Sign(privateKey, append([]byte(registrySignatureContext), payload...))
+	return json.Marshal(SignedRegistry{
+		Payload:   base64.StdEncoding.EncodeToString(payload),
+		Signature: base64.StdEncoding.EncodeToString(signature),
+	})
+}
+
+// FetchServerRegistry downloads a signed server registry from registryURL (an
+// https:// or file:// URL of a SignedRegistry document), verifies its signature
+// against opts.PublicKey and returns the listed servers that pass the filters in
+// opts. Every returned server has a pinned public key and a normalized URL; entries
+// without a key or with an invalid URL are skipped. It fails if no server remains.
+func FetchServerRegistry(ctx context.Context, registryURL string, opts *RegistryOptions) ([]ServerInfo, error) {
+	if opts == nil || len(opts.PublicKey) != ed25519.PublicKeySize {
+		return nil, errors.New("a registry public key is required")
+	}
+
+	body, err := readRegistry(ctx, registryURL)
+	if err != nil {
+		return nil, err
+	}
+
+	servers, err := verifyRegistry(body, opts.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var selected []ServerInfo
+	for _, server := range servers {
+		if server.PublicKey == "" {
+			continue
+		}
+		if _, err := decodeServerPublicKey(server.PublicKey); err != nil {
+			continue
+		}
+		normalized, err := ConvertURLsToServerInfoStrict([]string{server.URL}, &Options{AllowInsecure: opts.AllowInsecure})
+		if err != nil {
+			continue
+		}
+		server.URL = normalized[0].URL
+		if !opts.matches(server) {
+			continue
+		}
+		selected = append(selected, server)
+	}
+
+	if (opts.Probe || opts.MinProtocolVersion > 0) && len(selected) > 0 {
+		probes := ProbeServers(ctx, selected)
+		selected = selected[:0]
+		for i := range probes {
+			if opts.Probe && !probes[i].Healthy() {
+				continue
+			}
+			if !opts.supportsProtocol(probes[i].Capabilities) {
+				continue
+			}
+			selected = append(selected, probes[i].Server)
+		}
+	}
+
+	if len(selected) == 0 {
+		return nil, errors.New("no usable servers found in registry")
+	}
+	return selected, nil
+}
+
+// readRegistry reads the registry document at registryURL
+func readRegistry(ctx context.Context, registryURL string) ([]byte, error) {
+	if strings.HasPrefix(registryURL, "file://") {
+		filePath := strings.TrimPrefix(registryURL, "file://")
+		body, err := os.ReadFile(filePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read file %s: %v", filePath, err)
+		}
+		return body, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", registryURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("User-Agent", "OpenADP-Client/1.0")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch registry from %s: %w", registryURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP error %d: %s", resp.StatusCode, resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxRegistrySize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %v", err)
+	}
+	if len(body) > maxRegistrySize {
+		return nil, fmt.Errorf("registry exceeds %d bytes", maxRegistrySize)
+	}
+	return body, nil
+}
+
+// verifyRegistry checks the signature of a SignedRegistry document and returns
+// the servers it lists
+func verifyRegistry(body []byte, publicKey ed25519.PublicKey) ([]ServerInfo, error) {
+	var signed SignedRegistry
+	if err := json.Unmarshal(body, &signed); err != nil {
+		return nil, fmt.Errorf("failed to parse registry: %v", err)
+	}
+
+	payload, err := base64.StdEncoding.DecodeString(signed.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("invalid registry payload: %v", err)
+	}
+	signature, err := base64.StdEncoding.DecodeString(signed.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("invalid registry signature: %v", err)
+	}
+	if !ed25519.Verify(publicKey, append([]byte(registrySignatureContext), payload...), signature) {
+		return nil, errors.New("registry signature verification failed")
+	}
+
+	var serversResp ServersResponse
+	if err := json.Unmarshal(payload, &serversResp); err != nil {
+		return nil, fmt.Errorf("failed to parse registry payload: %v", err)
+	}
+	return serversResp.Servers, nil
+}
+
+// supportsProtocol reports whether a server with caps passes the protocol
+// version filter
+func (o *RegistryOptions) supportsProtocol(caps Capabilities) bool {
+	if o.MinProtocolVersion <= 0 {
+		return true
+	}
+	if !caps.Known {
+		return false
+	}
+	for _, version := range caps.ProtocolVersions {
+		if version >= o.MinProtocolVersion {
+			return true
+		}
+	}
+	return false
+}
+
+// matches reports whether server passes the country and tag filters
+func (o *RegistryOptions) matches(server ServerInfo) bool {
+	if len(o.Countries) > 0 {
+		found := false
+		for _, country := range o.Countries {
+			if strings.EqualFold(country, server.Country) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	for _, required := range o.RequireTags {
+		found := false
+		for _, tag := range server.Tags {
+			if tag == required {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
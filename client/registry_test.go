@@ -0,0 +1,124 @@
+package client
+
+import (
+	"context"
+	"crypto/ed25519"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newRegistryServer serves document as the registry
+func newRegistryServer(t *testing.T, document []byte) string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(document)
+	}))
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func TestFetchServerRegistry(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(seededReader(1))
+	if err != nil {
+		t.Fatalf("GenerateKey() error: %v", err)
+	}
+
+	servers, live := newTestServers(t, 2)
+	servers[0].SetServerInfo("protocol_versions", []int{1, 2})
+	registry := []ServerInfo{
+		{URL: live[0].URL + "/", PublicKey: live[0].PublicKey, Country: "US", Version: "0.2.0", Tags: []string{"eu", "tee"}},
+		{URL: live[1].URL, PublicKey: live[1].PublicKey, Country: "DE", Version: "0.1.3", Tags: []string{"eu"}},
+		{URL: "http://127.0.0.1:1", PublicKey: live[0].PublicKey, Country: "CA", Version: "0.3.0"},
+		{URL: "http://unpinned.example.com", Country: "US", Version: "0.3.0"},
+	}
+	document, err := SignServerRegistry(privateKey, registry)
+	if err != nil {
+		t.Fatalf("SignServerRegistry() error: %v", err)
+	}
+	registryURL := newRegistryServer(t, document)
+
+	tests := []struct {
+		name string
+		opts RegistryOptions
+		want []string
+	}{
+		{"all pinned", RegistryOptions{}, []string{live[0].URL, live[1].URL, "http://127.0.0.1:1"}},
+		{"probe drops unreachable", RegistryOptions{Probe: true}, []string{live[0].URL, live[1].URL}},
+		{"min protocol version", RegistryOptions{MinProtocolVersion: 2}, []string{live[0].URL}},
+		{"min protocol version 1", RegistryOptions{MinProtocolVersion: 1}, []string{live[0].URL, live[1].URL}},
+		{"countries", RegistryOptions{Countries: []string{"de", "ca"}}, []string{live[1].URL, "http://127.0.0.1:1"}},
+		{"tags", RegistryOptions{RequireTags: []string{"eu", "tee"}}, []string{live[0].URL}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := tt.opts
+			opts.PublicKey = publicKey
+			opts.AllowInsecure = true
+
+			servers, err := FetchServerRegistry(context.Background(), registryURL, &opts)
+			if err != nil {
+				t.Fatalf("FetchServerRegistry() error: %v", err)
+			}
+			var got []string
+			for _, server := range servers {
+				if server.PublicKey == "" {
+					t.Errorf("Server %s has no pinned key", server.URL)
+				}
+				got = append(got, server.URL)
+			}
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("FetchServerRegistry() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// The same document can be read from a file
+	path := filepath.Join(t.TempDir(), "registry.json")
+	if err := os.WriteFile(path, document, 0o600); err != nil {
+		t.Fatalf("WriteFile() error: %v", err)
+	}
+	if _, err := FetchServerRegistry(context.Background(), "file://"+path,
+		&RegistryOptions{PublicKey: publicKey, AllowInsecure: true}); err != nil {
+		t.Errorf("FetchServerRegistry(file) error: %v", err)
+	}
+}
+
+func TestFetchServerRegistryErrors(t *testing.T) {
+	publicKey, privateKey, _ := ed25519.GenerateKey(seededReader(1))
+	otherKey, _, _ := ed25519.GenerateKey(seededReader(2))
+
+	_, live := newTestServers(t, 1)
+	document, err := SignServerRegistry(privateKey, live)
+	if err != nil {
+		t.Fatalf("SignServerRegistry() error: %v", err)
+	}
+	tampered := strings.Replace(string(document), `"signature":"`, `"signature":"AAAA`, 1)
+
+	tests := []struct {
+		name     string
+		document string
+		opts     *RegistryOptions
+		wantErr  string
+	}{
+		{"no options", string(document), nil, "public key is required"},
+		{"wrong key", string(document), &RegistryOptions{PublicKey: otherKey, AllowInsecure: true}, "signature verification failed"},
+		{"tampered signature", tampered, &RegistryOptions{PublicKey: publicKey, AllowInsecure: true}, "signature"},
+		{"unsigned", `{"servers":[]}`, &RegistryOptions{PublicKey: publicKey}, "signature verification failed"},
+		{"insecure servers", string(document), &RegistryOptions{PublicKey: publicKey}, "no usable servers"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registryURL := newRegistryServer(t, []byte(tt.document))
+			_, err := FetchServerRegistry(context.Background(), registryURL, tt.opts)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("FetchServerRegistry() error = %v, want containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
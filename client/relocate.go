@@ -0,0 +1,109 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+)
+
+// RelocateServers updates the servers a backup was registered with to the
+// addresses in current, matching them by pinned public key rather than by URL,
+// for operators who move a server to a new hostname while keeping its key and
+// its shares.
+//
+// A registered server whose pinned key appears in current under another URL is
+// replaced by that URL, and its auth code moves with it: the server still expects
+// the code it was registered with. Servers without a pinned key, or whose key is
+// not in current, are kept as they are. The inputs are not modified. It fails if
+// current lists one key under two URLs, or if a relocated server would take the
+// URL of another server of the backup.
+func RelocateServers(serverInfos []ServerInfo, authCodes *AuthCodes, current []ServerInfo) ([]ServerInfo, *AuthCodes, error) {
+	if authCodes == nil {
+		return nil, nil, errors.New("auth codes are required")
+	}
+
+	moved := make(map[string]ServerInfo) // Pinned key -> current address
+	for _, server := range current {
+		key := pinnedKey(server)
+		if key == "" {
+			continue
+		}
+		if other, ok := moved[key]; ok && other.URL != server.URL {
+			return nil, nil, fmt.Errorf("public key of %s is also listed for %s", server.URL, other.URL)
+		}
+		moved[key] = server
+	}
+
+	relocated := make([]ServerInfo, len(serverInfos))
+	for i, server := range serverInfos {
+		relocated[i] = server
+		if target, ok := moved[pinnedKey(server)]; ok {
+			relocated[i].URL = target.URL
+		}
+	}
+
+	seen := make(map[string]bool, len(relocated))
+	for _, server := range relocated {
+		if seen[server.URL] {
+			return nil, nil, fmt.Errorf("two servers of the backup would be reached at %s", server.URL)
+		}
+		seen[server.URL] = true
+	}
+
+	codes := authCodes.Clone()
+	codes.ServerAuthCodes = relocateEntries(codes.ServerAuthCodes, renamedURLs(serverInfos, relocated))
+	return relocated, codes, nil
+}
+
+// renamedURLs maps the URL of every server in serverInfos that relocated lists
+// under another URL to that URL
+func renamedURLs(serverInfos, relocated []ServerInfo) map[string]string {
+	renamed := make(map[string]string)
+	for i, server := range serverInfos {
+		if relocated[i].URL != server.URL {
+			renamed[server.URL] = relocated[i].URL
+		}
+	}
+	return renamed
+}
+
+// relocateEntries returns a copy of entries, keyed by server URL, with the entry
+// of every URL in renamed moved to its new URL. The copy is built afresh rather
+// than moved in place, so an entry moving to the URL another entry moves away
+// from, as in a chain or a swap, is neither overwritten nor deleted.
+func relocateEntries[V any](entries map[string]V, renamed map[string]string) map[string]V {
+	if entries == nil {
+		return nil
+	}
+	fresh := make(map[string]V, len(entries))
+	for url, entry := range entries {
+		if _, ok := renamed[url]; !ok {
+			fresh[url] = entry
+		}
+	}
+	for from, to := range renamed {
+		if entry, ok := entries[from]; ok {
+			fresh[to] = entry
+		}
+	}
+	return fresh
+}
+
+// Relocate returns a copy of m whose servers are moved to the addresses in
+// current, matched by pinned public key; see RelocateServers. The recorded guess
+// counters move with their servers.
+func (m *Metadata) Relocate(current []ServerInfo) (*Metadata, error) {
+	serverInfos := MetadataToServerInfo(m)
+	relocated, codes, err := RelocateServers(serverInfos, m.AuthCodes, current)
+	if err != nil {
+		return nil, err
+	}
+
+	moved := *m
+	moved.AuthCodes = codes
+	moved.Servers = make([]MetadataServer, len(relocated))
+	for i, server := range relocated {
+		moved.Servers[i] = MetadataServer{URL: server.URL, PublicKey: server.PublicKey}
+	}
+	moved.GuessCounts = relocateEntries(m.GuessCounts, renamedURLs(serverInfos, relocated))
+	return &moved, nil
+}
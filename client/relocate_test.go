@@ -0,0 +1,117 @@
+package client
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestRelocateServers(t *testing.T) {
+	servers, serverInfos := newTestServers(t, 3)
+	identity := &Identity{UID: "user", DID: "app", BID: "relocate"}
+
+	// Server 0 is registered under a hostname that later goes away
+	target, _ := url.Parse(servers[0].URL)
+	old := httptest.NewServer(httputil.NewSingleHostReverseProxy(target))
+	serverInfos[0].URL = old.URL
+	generated := GenerateEncryptionKeyWithOpts(identity, "password", 10, 0, serverInfos, &Options{Threshold: 3})
+	if generated.Error != "" {
+		t.Fatalf("Key generation failed: %s", generated.Error)
+	}
+	metadata, err := MetadataFromResult(identity, generated)
+	if err != nil {
+		t.Fatalf("MetadataFromResult() error: %v", err)
+	}
+	old.Close()
+
+	// The operator moves the server, keeping its key and shares
+	moved := httptest.NewServer(httputil.NewSingleHostReverseProxy(target))
+	defer moved.Close()
+	current := []ServerInfo{{URL: moved.URL, PublicKey: serverInfos[0].PublicKey}}
+
+	stale := RecoverEncryptionKeyWithOpts(identity, "password", MetadataToServerInfo(metadata), metadata.Threshold, metadata.AuthCodes, nil)
+	if stale.Error == "" {
+		t.Fatal("Recovery through the old hostname succeeded")
+	}
+
+	relocated, err := metadata.Relocate(current)
+	if err != nil {
+		t.Fatalf("Relocate() error: %v", err)
+	}
+	if relocated.Servers[0].URL != moved.URL || relocated.Servers[1] != metadata.Servers[1] {
+		t.Errorf("Relocate() servers = %v, want server 0 at %s", relocated.Servers, moved.URL)
+	}
+	if metadata.Servers[0].URL != old.URL {
+		t.Error("Relocate() modified the original metadata")
+	}
+	if _, ok := relocated.GuessCounts[moved.URL]; !ok {
+		t.Errorf("Relocate() guess counts = %v, want an entry for %s", relocated.GuessCounts, moved.URL)
+	}
+
+	recovered := RecoverEncryptionKeyWithOpts(identity, "password", MetadataToServerInfo(relocated), relocated.Threshold, relocated.AuthCodes, nil)
+	if recovered.Error != "" {
+		t.Fatalf("Recovery failed: %s", recovered.Error)
+	}
+	if !bytes.Equal(recovered.EncryptionKey, generated.EncryptionKey) {
+		t.Error("Recovered key does not match")
+	}
+	if got := servers[0].NumGuesses(identity.UID, identity.DID, identity.BID); got != 1 {
+		t.Errorf("Relocated server saw %d guesses, want 1", got)
+	}
+
+	tests := []struct {
+		name    string
+		current []ServerInfo
+	}{
+		{"one key under two URLs", append(current, ServerInfo{URL: "http://127.0.0.1:1", PublicKey: serverInfos[0].PublicKey})},
+		{"URL of another server", []ServerInfo{{URL: serverInfos[1].URL, PublicKey: serverInfos[0].PublicKey}}},
+	}
+	for _, tt := range tests {
+		if _, err := metadata.Relocate(tt.current); err == nil {
+			t.Errorf("%s: Relocate() succeeded", tt.name)
+		}
+	}
+}
+
+func TestRelocateServersChained(t *testing.T) {
+	_, serverInfos := newTestServers(t, 2)
+	keyA, keyB := serverInfos[0].PublicKey, serverInfos[1].PublicKey
+
+	tests := []struct {
+		name    string
+		current []ServerInfo
+		want    map[string]string // URL -> auth code
+	}{
+		{"chain", []ServerInfo{{URL: "https://b", PublicKey: keyA}, {URL: "https://c", PublicKey: keyB}}, map[string]string{"https://b": "codeA", "https://c": "codeB"}},
+		{"swap", []ServerInfo{{URL: "https://b", PublicKey: keyA}, {URL: "https://a", PublicKey: keyB}}, map[string]string{"https://b": "codeA", "https://a": "codeB"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metadata := &Metadata{
+				Version:     MetadataVersion,
+				Servers:     []MetadataServer{{URL: "https://a", PublicKey: keyA}, {URL: "https://b", PublicKey: keyB}},
+				Threshold:   2,
+				AuthCodes:   &AuthCodes{ServerAuthCodes: map[string]string{"https://a": "codeA", "https://b": "codeB"}},
+				GuessCounts: map[string]int{"https://a": 1, "https://b": 2},
+			}
+			relocated, err := metadata.Relocate(tt.current)
+			if err != nil {
+				t.Fatalf("Relocate() error: %v", err)
+			}
+			if !reflect.DeepEqual(relocated.AuthCodes.ServerAuthCodes, tt.want) {
+				t.Errorf("Relocate() auth codes = %v, want %v", relocated.AuthCodes.ServerAuthCodes, tt.want)
+			}
+			wantCounts := map[string]int{tt.current[0].URL: 1, tt.current[1].URL: 2}
+			if !reflect.DeepEqual(relocated.GuessCounts, wantCounts) {
+				t.Errorf("Relocate() guess counts = %v, want %v", relocated.GuessCounts, wantCounts)
+			}
+			if metadata.AuthCodes.ServerAuthCodes["https://a"] != "codeA" || metadata.GuessCounts["https://a"] != 1 {
+				t.Error("Relocate() modified the original metadata")
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package client
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"time"
+)
+
+// CapabilityReplayProtection is listed in the "capabilities" of GetServerInfo by
+// servers that reject replayed and stale encrypted requests. Clients then add
+// {"nonce", "timestamp"} under "replay" in the encrypted method call, and
+// "max_skew" in seconds if Options.MaxClockSkew is set. The fields travel inside
+// the Noise-NK channel, so they are authenticated by the session key and cannot
+// be altered or moved to another request. Servers reject a nonce they have seen,
+// or a timestamp further from their clock than their limit or max_skew, whichever
+// is smaller, with ReplayRejectedCode. Servers that do not advertise the
+// capability are sent the plain request.
+const CapabilityReplayProtection = "replay_protection"
+
+// ReplayRejectedCode is the JSON-RPC error code of a request rejected as replayed
+// or stale by a server advertising CapabilityReplayProtection
+const ReplayRejectedCode = -32010
+
+// ErrReplayDetected is wrapped by errors from requests the server rejected with
+// ReplayRejectedCode. A fresh request carries a fresh nonce, so it usually means
+// that the client and server clocks disagree by more than the allowed skew.
+var ErrReplayDetected = errors.New("server rejected the request as replayed or stale")
+
+// replayFields returns the "replay" object of an encrypted method call, with a
+// nonce read from random and the current time from clock
+func replayFields(random io.Reader, clock Clock, maxSkew time.Duration) (map[string]interface{}, error) {
+	if random == nil {
+		random = rand.Reader
+	}
+	if clock == nil {
+		clock = systemClock{}
+	}
+	nonce := make([]byte, 16)
+	if _, err := io.ReadFull(random, nonce); err != nil {
+		return nil, err
+	}
+
+	fields := map[string]interface{}{
+		"nonce":     hex.EncodeToString(nonce),
+		"timestamp": clock.Now().Unix(),
+	}
+	if maxSkew > 0 {
+		fields["max_skew"] = int64((maxSkew + time.Second - 1) / time.Second) // Rounded up to whole seconds
+	}
+	return fields, nil
+}
@@ -0,0 +1,97 @@
+package client
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/openadp/ocrypt/testserver"
+)
+
+// repeatingReader yields the same bytes on every read, so that every request
+// carries the same replay nonce
+type repeatingReader struct{}
+
+func (repeatingReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0x42
+	}
+	return len(p), nil
+}
+
+func TestReplayProtection(t *testing.T) {
+	server := testserver.New()
+	defer server.Close()
+	server.SetCapabilities(CapabilityReplayProtection)
+
+	client := NewEncryptedOpenADPClient(server.URL, server.PublicKey)
+	if !client.Capabilities().ReplayProtection {
+		t.Fatal("Capabilities().ReplayProtection = false")
+	}
+	if _, err := client.Echo("first", true); err != nil {
+		t.Fatalf("Echo() error: %v", err)
+	}
+	if _, err := client.Echo("second", true); err != nil {
+		t.Fatalf("Echo() with a fresh nonce error: %v", err)
+	}
+
+	// A request carrying a nonce the server has already seen is rejected
+	client.nonceReader = repeatingReader{}
+	if _, err := client.Echo("first", true); err != nil {
+		t.Fatalf("Echo() error: %v", err)
+	}
+	_, err := client.Echo("replayed", true)
+	if !errors.Is(err, ErrReplayDetected) {
+		t.Fatalf("Echo() with a repeated nonce error = %v, want ErrReplayDetected", err)
+	}
+	if isTransientError(err) {
+		t.Error("A replay rejection is retried")
+	}
+
+	// Servers that do not advertise the capability are sent the plain request
+	plain := testserver.New()
+	defer plain.Close()
+	legacy := NewEncryptedOpenADPClient(plain.URL, plain.PublicKey)
+	legacy.nonceReader = repeatingReader{}
+	for i := 0; i < 2; i++ {
+		if _, err := legacy.Echo("plain", true); err != nil {
+			t.Fatalf("Echo() to a server without replay protection error: %v", err)
+		}
+	}
+}
+
+func TestReplayProtectionClockSkew(t *testing.T) {
+	servers, serverInfos := newTestServers(t, 3)
+	for _, server := range servers {
+		server.SetCapabilities(CapabilityReplayProtection)
+	}
+	identity := &Identity{UID: "user", DID: "app", BID: "replay"}
+
+	generated := GenerateEncryptionKeyWithOpts(identity, "password", 10, 0, serverInfos, nil)
+	if generated.Error != "" {
+		t.Fatalf("Key generation failed: %s", generated.Error)
+	}
+
+	// The server clocks run two minutes ahead, within the servers' own limit
+	for _, server := range servers {
+		server.SetClockOffset(2 * time.Minute)
+	}
+	recovered := RecoverEncryptionKeyWithOpts(identity, "password", serverInfos, generated.Threshold, generated.AuthCodes, nil)
+	if recovered.Error != "" {
+		t.Fatalf("Recovery failed: %s", recovered.Error)
+	}
+	if !bytes.Equal(recovered.EncryptionKey, generated.EncryptionKey) {
+		t.Error("Recovered key does not match")
+	}
+
+	// A tighter skew makes the servers reject the requests as stale, before any
+	// guess is spent
+	strict := RecoverEncryptionKeyWithOpts(identity, "password", serverInfos, generated.Threshold, generated.AuthCodes, &Options{MaxClockSkew: time.Minute})
+	if strict.ErrCode != ErrReplayRejected {
+		t.Fatalf("ErrCode = %q, want %q (%s)", strict.ErrCode, ErrReplayRejected, strict.Error)
+	}
+	if got := servers[0].NumGuesses(identity.UID, identity.DID, identity.BID); got != 1 {
+		t.Errorf("Server 0 spent %d guesses, want 1", got)
+	}
+}
@@ -0,0 +1,118 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy configures retries of transient server errors with exponential
+// backoff and jitter.
+//
+// Only failures that say nothing about the request itself are retried: network
+// errors, connections dropped mid-response, and HTTP 429, 502, 503 and 504. A
+// JSON-RPC error from the server, including a "too many guesses" response
+// (GuessesExhaustedError), is definitive and never retried.
+//
+// A dropped connection may hide a request the server already processed, and for
+// RecoverSecret that request has already counted a guess. RecoverSecret is
+// therefore only retried when the request never reached the server: when the
+// connection could not be opened, and on HTTP 429 and 503.
+type RetryPolicy struct {
+	MaxAttempts int           // Total attempts per request, including the first; values below 2 disable retries
+	BaseDelay   time.Duration // Delay before the first retry; doubled for each further retry
+	MaxDelay    time.Duration // Upper bound on the delay between attempts; zero means no bound
+}
+
+// DefaultRetryPolicy is a reasonable policy for interactive use
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   100 * time.Millisecond,
+	MaxDelay:    2 * time.Second,
+}
+
+// statusError is an unexpected HTTP status returned by a server
+type statusError struct {
+	Code   int
+	Status string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("%d %s", e.Code, e.Status)
+}
+
+// shouldRetry reports whether a request for method that failed with err on the
+// given attempt (starting at 1) should be tried again
+func (p *RetryPolicy) shouldRetry(attempt int, method string, err error) bool {
+	if p == nil || attempt >= p.MaxAttempts {
+		return false
+	}
+	if method == "RecoverSecret" {
+		return isUnsentError(err)
+	}
+	return isTransientError(err)
+}
+
+// delay returns the backoff before the retry following the given attempt. The
+// exponential delay is jittered uniformly over its upper half so that clients
+// retrying together spread out.
+func (p *RetryPolicy) delay(attempt int) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < attempt && (p.MaxDelay <= 0 || d < p.MaxDelay); i++ {
+		d *= 2
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// isTransientError reports whether err is a failure that a retry may fix
+func isTransientError(err error) bool {
+	if err == nil || isGuessesExhaustedError(err) {
+		return false
+	}
+
+	// The caller gave up; retrying would not be wanted
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		switch statusErr.Code {
+		case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// isUnsentError reports whether err shows that the server turned the request away
+// before processing it: the connection could not be opened, or the server answered
+// HTTP 429 or 503
+func isUnsentError(err error) bool {
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Code == http.StatusTooManyRequests || statusErr.Code == http.StatusServiceUnavailable
+	}
+
+	var opErr *net.OpError
+	return (errors.As(err, &opErr) && opErr.Op == "dial") || errors.Is(err, syscall.ECONNREFUSED)
+}
@@ -0,0 +1,172 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestIsTransientError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"service unavailable", fmt.Errorf("HTTP error: %w", &statusError{Code: http.StatusServiceUnavailable, Status: "503 Service Unavailable"}), true},
+		{"gateway timeout", fmt.Errorf("handshake HTTP error: %w", &statusError{Code: http.StatusGatewayTimeout}), true},
+		{"too many requests", fmt.Errorf("HTTP error: %w", &statusError{Code: http.StatusTooManyRequests}), true},
+		{"not found", fmt.Errorf("HTTP error: %w", &statusError{Code: http.StatusNotFound}), false},
+		{"truncated response", fmt.Errorf("failed to read encrypted response: %w", io.ErrUnexpectedEOF), true},
+		{"cancelled", fmt.Errorf("failed to make HTTP request: %w", context.Canceled), false},
+		{"guesses exhausted", errors.New("decrypted JSON-RPC error -32000: too many guesses"), false},
+		{"JSON-RPC error", errors.New("JSON-RPC error -32000: invalid auth code"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isTransientError(tt.err); got != tt.want {
+				t.Errorf("isTransientError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestShouldRetryRecoverSecret(t *testing.T) {
+	policy := &RetryPolicy{MaxAttempts: 3}
+	dialErr := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("no route to host")}
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"dial error", fmt.Errorf("failed to make HTTP request: %w", dialErr), true},
+		{"connection refused", fmt.Errorf("failed to make HTTP request: %w", syscall.ECONNREFUSED), true},
+		{"too many requests", fmt.Errorf("HTTP error: %w", &statusError{Code: http.StatusTooManyRequests}), true},
+		{"service unavailable", fmt.Errorf("HTTP error: %w", &statusError{Code: http.StatusServiceUnavailable}), true},
+		{"bad gateway", fmt.Errorf("HTTP error: %w", &statusError{Code: http.StatusBadGateway}), false},
+		{"gateway timeout", fmt.Errorf("HTTP error: %w", &statusError{Code: http.StatusGatewayTimeout}), false},
+		{"truncated response", fmt.Errorf("failed to read encrypted response: %w", io.ErrUnexpectedEOF), false},
+		{"EOF", fmt.Errorf("failed to make HTTP request: %w", io.EOF), false},
+		{"connection reset", fmt.Errorf("failed to make HTTP request: %w", syscall.ECONNRESET), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := policy.shouldRetry(1, "RecoverSecret", tt.err); got != tt.want {
+				t.Errorf("shouldRetry(RecoverSecret, %v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := &RetryPolicy{MaxAttempts: 10, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+
+	tests := []struct {
+		attempt int
+		max     time.Duration
+	}{
+		{1, 100 * time.Millisecond},
+		{2, 200 * time.Millisecond},
+		{3, 400 * time.Millisecond},
+		{5, time.Second},
+		{9, time.Second},
+	}
+
+	for _, tt := range tests {
+		for i := 0; i < 20; i++ {
+			d := policy.delay(tt.attempt)
+			if d < tt.max/2 || d > tt.max {
+				t.Errorf("delay(%d) = %v, want in [%v, %v]", tt.attempt, d, tt.max/2, tt.max)
+			}
+		}
+	}
+
+	var nilPolicy *RetryPolicy
+	if nilPolicy.shouldRetry(1, "Echo", io.ErrUnexpectedEOF) {
+		t.Error("nil policy should not retry")
+	}
+	if policy.shouldRetry(10, "Echo", io.ErrUnexpectedEOF) {
+		t.Error("policy should not retry past MaxAttempts")
+	}
+}
+
+func TestGenerateEncryptionKeyRetry(t *testing.T) {
+	policy := &RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}
+
+	tests := []struct {
+		name        string
+		retry       *RetryPolicy
+		wantSuccess bool
+		wantCalls   int32
+	}{
+		{"no retry", nil, false, 1},
+		{"fails twice then succeeds", policy, true, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int32
+			flaky := newMockRPCServer(t, func(method string, params []interface{}) (interface{}, error) {
+				switch method {
+				case "Echo":
+					return params[0], nil
+				case "RegisterSecret":
+					if atomic.AddInt32(&calls, 1) <= 2 {
+						return nil, httpStatusError(http.StatusServiceUnavailable)
+					}
+					return true, nil
+				}
+				return nil, httpStatusError(http.StatusNotFound)
+			})
+
+			urls := []string{
+				flaky.URL,
+				newMockRegistrationServer(t, 0, false, nil, nil).URL,
+				newMockRegistrationServer(t, 0, false, nil, nil).URL,
+			}
+
+			identity := &Identity{UID: "user", DID: "app", BID: "even"}
+			result := GenerateEncryptionKeyWithOpts(identity, "password", 10, 0, insecureServerInfos(t, urls), &Options{Retry: tt.retry})
+			if result.Error != "" {
+				t.Fatalf("GenerateEncryptionKeyWithOpts() unexpected error: %s", result.Error)
+			}
+
+			if got := result.ServerResults[0].Success; got != tt.wantSuccess {
+				t.Errorf("Flaky server Success = %v, want %v (error: %s)", got, tt.wantSuccess, result.ServerResults[0].Error)
+			}
+			if got := atomic.LoadInt32(&calls); got != tt.wantCalls {
+				t.Errorf("RegisterSecret called %d times, want %d", got, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestRetryNotAppliedToGuessesExhausted(t *testing.T) {
+	var calls int32
+	server := newMockRPCServer(t, func(method string, params []interface{}) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		return nil, errors.New("too many guesses")
+	})
+
+	client := NewEncryptedOpenADPClient(server.URL, nil)
+	client.RetryPolicy = &RetryPolicy{MaxAttempts: 5, BaseDelay: time.Millisecond}
+
+	_, err := client.Echo("ping", false)
+	var exhausted *GuessesExhaustedError
+	if !errors.As(err, &exhausted) {
+		t.Fatalf("Echo() error = %v, want a GuessesExhaustedError", err)
+	}
+	if calls != 1 {
+		t.Errorf("Server called %d times, want 1", calls)
+	}
+}
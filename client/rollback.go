@@ -0,0 +1,84 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// CapabilityDeleteBackup is listed in the "capabilities" of GetServerInfo by
+// servers that accept DeleteBackup, which removes a backup given its auth code.
+// Key generation uses it to roll back a registration that fell short of the
+// threshold; see RollbackReport.
+const CapabilityDeleteBackup = "delete_backup"
+
+// RollbackReport describes the cleanup of a key generation that stored shares on
+// some servers but too few to reach the threshold. Such shares cannot recover the
+// key and only use up the servers' space, so key generation asks each server that
+// accepted one to delete it again. The cleanup is best effort: servers that are
+// down or do not support DeleteBackup keep their share.
+type RollbackReport struct {
+	// Deleted lists the servers that deleted the share they had accepted
+	Deleted []string
+
+	// Failed maps the servers that still hold a share to why it was not deleted
+	Failed map[string]string
+}
+
+// Complete reports whether no server still holds a share of the failed registration
+func (r *RollbackReport) Complete() bool {
+	return r != nil && len(r.Failed) == 0
+}
+
+// describe summarizes the rollback for the error message of the failed registration
+func (r *RollbackReport) describe() string {
+	if r.Complete() {
+		return fmt.Sprintf("rolled back the shares registered with %d servers", len(r.Deleted))
+	}
+	servers := make([]string, 0, len(r.Failed))
+	for url := range r.Failed {
+		servers = append(servers, url)
+	}
+	sort.Strings(servers)
+	return fmt.Sprintf("rollback incomplete: %d of %d servers still hold a share (%s)",
+		len(r.Failed), len(r.Failed)+len(r.Deleted), strings.Join(servers, ", "))
+}
+
+// rollbackRegistration asks every client whose registration succeeded in
+// serverResults to delete the backup of identity again. It returns nil if no
+// registration had succeeded. The deletions are not bound to ctx beyond its
+// values, so that they still run after the overall timeout has expired.
+func rollbackRegistration(ctx context.Context, clients []*EncryptedOpenADPClient, serverResults []ServerResult,
+	identity *Identity, authCodes *AuthCodes, logger Logger) *RollbackReport {
+	var registered []int
+	for i, serverResult := range serverResults {
+		if serverResult.Success {
+			registered = append(registered, i)
+		}
+	}
+	if len(registered) == 0 {
+		return nil
+	}
+
+	errs := make([]error, len(registered))
+	forEachServer(len(registered), len(registered), func(j int) {
+		i := registered[j]
+		client := clients[i].WithContext(context.WithoutCancel(ctx))
+		authCode, _ := authCodes.ForServer(serverResults[i].URL)
+		errs[j] = client.DeleteBackup(authCode, identity.UID, identity.DID, identity.BID, client.HasPublicKey(), nil)
+	})
+
+	report := &RollbackReport{Failed: make(map[string]string)}
+	for j, i := range registered {
+		url := serverResults[i].URL
+		if errs[j] != nil {
+			logger.Warn("rollback failed, server keeps a share", "server", url, "error", errs[j])
+			report.Failed[url] = errs[j].Error()
+			continue
+		}
+		logger.Debug("rolled back registration", "server", url)
+		report.Deleted = append(report.Deleted, url)
+	}
+	return report
+}
@@ -0,0 +1,71 @@
+package client
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/openadp/ocrypt/testserver"
+)
+
+func TestRegistrationRollback(t *testing.T) {
+	tests := []struct {
+		name         string
+		keepDeleting bool // Whether servers 0 and 1 still accept DeleteBackup
+		wantComplete bool
+		wantMessage  string
+	}{
+		{"all deleted", true, true, "rolled back the shares registered with 3 servers"},
+		{"server down", false, false, "rollback incomplete: 2 of 3 servers still hold a share"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			servers, serverInfos := newTestServers(t, 5)
+			for _, server := range servers {
+				server.SetCapabilities(CapabilityDeleteBackup)
+			}
+			// The fourth and fifth registrations fail, leaving 3 shares for a threshold of 4
+			servers[3].SetFault("RegisterSecret", testserver.FaultUnavailable)
+			servers[4].SetFault("RegisterSecret", testserver.FaultUnavailable)
+			if !tt.keepDeleting {
+				servers[0].SetFault("DeleteBackup", testserver.FaultUnavailable)
+				servers[1].SetFault("DeleteBackup", testserver.FaultUnavailable)
+			}
+
+			identity := &Identity{UID: "user", DID: "app", BID: strings.ReplaceAll(tt.name, " ", "-")}
+			result := GenerateEncryptionKeyWithOpts(identity, "password", 10, 0, serverInfos, &Options{Threshold: 4})
+			if result.ErrCode != ErrThresholdNotMet {
+				t.Fatalf("ErrCode = %q, want %q (%s)", result.ErrCode, ErrThresholdNotMet, result.Error)
+			}
+			if result.Rollback == nil {
+				t.Fatal("Rollback = nil, want a report")
+			}
+			if got := result.Rollback.Complete(); got != tt.wantComplete {
+				t.Errorf("Rollback.Complete() = %v, want %v (%+v)", got, tt.wantComplete, result.Rollback)
+			}
+			if !strings.Contains(result.Error, tt.wantMessage) {
+				t.Errorf("Error = %q, want it to mention %q", result.Error, tt.wantMessage)
+			}
+			if got := len(result.Rollback.Deleted) + len(result.Rollback.Failed); got != 3 {
+				t.Errorf("Rollback covers %d servers, want the 3 that accepted a share", got)
+			}
+
+			for i, server := range servers {
+				held := server.NumGuesses(identity.UID, identity.DID, identity.BID) >= 0
+				if want := !tt.keepDeleting && i < 2; held != want {
+					t.Errorf("Server %d holds a share: %v, want %v", i, held, want)
+				}
+			}
+		})
+	}
+
+	// Without any accepted share there is nothing to roll back
+	servers, serverInfos := newTestServers(t, 2)
+	for _, server := range servers {
+		server.SetFault("RegisterSecret", testserver.FaultUnavailable)
+	}
+	result := GenerateEncryptionKeyWithOpts(&Identity{UID: "user", DID: "app", BID: "none"}, "password", 10, 0, serverInfos, nil)
+	if result.ErrCode != ErrThresholdNotMet || result.Rollback != nil {
+		t.Errorf("ErrCode = %q, Rollback = %+v, want %q and no rollback", result.ErrCode, result.Rollback, ErrThresholdNotMet)
+	}
+}
@@ -0,0 +1,220 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// defaultMaxGuesses is the guess budget used for a rotated backup when the servers
+// do not report the budget of the current one
+const defaultMaxGuesses = 10
+
+// RecoverAndRotateResult is the result of RecoverAndRotate
+type RecoverAndRotateResult struct {
+	EncryptionKey []byte         // Key recovered from the current backup
+	Error         string         // Recovery failure; rotation is only attempted if empty
+	ErrCode       ErrCode        // Machine-readable classification of Error
+	ServerResults []ServerResult // Per-server recovery outcomes, in serverInfos order
+
+	Rotated          bool      // A new backup was registered under the alternate BID
+	RotationError    string    // Why rotation did not happen, if Rotated is false
+	NewEncryptionKey []byte    // Key protected by the new backup
+	Metadata         *Metadata // Recovery parameters of the new backup
+}
+
+// Zeroize overwrites both keys with zeros and clears them. Zeroing is best-effort;
+// see the package notes in zeroize.go.
+func (r *RecoverAndRotateResult) Zeroize() {
+	if r == nil {
+		return
+	}
+	zeroize(r.EncryptionKey)
+	zeroize(r.NewEncryptionKey)
+	r.EncryptionKey, r.NewEncryptionKey = nil, nil
+}
+
+// AlternateBID returns the BID that a backup stored under bid rotates to: "even"
+// and "odd" alternate, so that a valid backup exists at every point of a refresh.
+// It reports false for BIDs that do not follow this convention.
+func AlternateBID(bid string) (string, bool) {
+	switch bid {
+	case "even":
+		return "odd", true
+	case "odd":
+		return "even", true
+	}
+	return "", false
+}
+
+// RecoverAndRotate recovers the key of the backup identified by identity and then
+// registers a fresh backup under the alternate BID (see AlternateBID), with the
+// same threshold, guess budget and expiration, on the same servers.
+//
+// A fresh backup always protects a fresh key, because the servers never reveal the
+// secret behind the current one. The caller must therefore decrypt existing data
+// with EncryptionKey, re-encrypt it with NewEncryptionKey and store Metadata; the
+// old backup stays valid until then. If re-registration fails, recovery is still
+// reported as successful, with Rotated false and the reason in RotationError.
+func RecoverAndRotate(identity *Identity, password string, serverInfos []ServerInfo, threshold int, authCodes *AuthCodes) *RecoverAndRotateResult {
+	return RecoverAndRotateWithOpts(identity, password, serverInfos, threshold, authCodes, nil)
+}
+
+// RecoverAndRotateWithOpts is like RecoverAndRotate, with options for both the
+// recovery and the registration of the new backup. The PIN parameters, key
+// length, identity binding and hash-to-curve tag must be those of the current
+// backup, and the new backup keeps them, with a fresh Argon2id salt; Metadata
+// records them. The threshold argument overrides opts.Threshold.
+func RecoverAndRotateWithOpts(identity *Identity, password string, serverInfos []ServerInfo, threshold int, authCodes *AuthCodes, opts *Options) *RecoverAndRotateResult {
+	recovered := RecoverEncryptionKeyWithOpts(identity, password, serverInfos, threshold, authCodes, opts)
+	result := &RecoverAndRotateResult{
+		EncryptionKey: recovered.EncryptionKey,
+		Error:         recovered.Error,
+		ErrCode:       recovered.ErrCode,
+		ServerResults: recovered.ServerResults,
+	}
+	if recovered.Error != "" {
+		return result
+	}
+
+	nextBID, ok := AlternateBID(identity.BID)
+	if !ok {
+		result.RotationError = fmt.Sprintf("BID %q does not alternate between \"even\" and \"odd\"", identity.BID)
+		return result
+	}
+
+	// Keep the guess budget and expiration of the current backup
+	maxGuesses, expiration := currentLimits(identity, serverInfos, authCodes, opts)
+	nextIdentity := &Identity{UID: identity.UID, DID: identity.DID, BID: nextBID}
+	generated := GenerateEncryptionKeyWithOpts(nextIdentity, password, maxGuesses, expiration, serverInfos, rotationOptions(opts, threshold))
+	if generated.Error != "" {
+		result.RotationError = fmt.Sprintf("Failed to register backup %s: %s", nextIdentity.String(), generated.Error)
+		return result
+	}
+
+	metadata, err := MetadataFromResult(nextIdentity, generated)
+	if err != nil {
+		result.RotationError = err.Error()
+		return result
+	}
+
+	result.Rotated = true
+	result.NewEncryptionKey = generated.EncryptionKey
+	result.Metadata = metadata
+	return result
+}
+
+// currentLimits returns the guess budget and expiration the servers report for
+// the backup of identity. The budget is defaultMaxGuesses if no server reports
+// one, and the expiration zero if none reports one.
+func currentLimits(identity *Identity, serverInfos []ServerInfo, authCodes *AuthCodes, opts *Options) (maxGuesses, expiration int) {
+	if status, err := queryBackupStatus(identity, serverInfos, authCodes, opts); err == nil {
+		for _, server := range status.Servers {
+			maxGuesses = max(maxGuesses, server.MaxGuesses)
+			expiration = max(expiration, server.Expiration)
+		}
+	}
+	if maxGuesses == 0 {
+		maxGuesses = defaultMaxGuesses
+	}
+	return maxGuesses, expiration
+}
+
+// rotationOptions returns a copy of opts for registering the rotated backup
+// with threshold, which draws a fresh Argon2id salt instead of reusing the one
+// of the current backup
+func rotationOptions(opts *Options, threshold int) *Options {
+	var rotation Options
+	if opts != nil {
+		rotation = *opts
+	}
+	rotation.Threshold = threshold
+	if rotation.PinParams != nil {
+		params := *rotation.PinParams
+		params.Salt = nil
+		rotation.PinParams = &params
+	}
+	return &rotation
+}
+
+// RotateAuthCodesResult is the result of RotateAuthCodes
+type RotateAuthCodesResult struct {
+	RecoverAndRotateResult
+
+	// AuthCodes are the freshly generated auth codes of the new backup, also
+	// recorded in Metadata. Nil unless Rotated.
+	AuthCodes *AuthCodes
+
+	// RevokeResults are the per-server outcomes of deleting the old backup, in
+	// serverInfos order. Empty unless Rotated. The old auth codes keep working on
+	// the servers where revocation failed, including those that do not advertise
+	// CapabilityDeleteBackup.
+	RevokeResults []ServerResult
+}
+
+// RotateAuthCodes replaces the auth codes of a backup, for example after the
+// device holding them was lost. It recovers the key, registers a new backup with
+// fresh auth codes as RecoverAndRotate does, and then revokes the old backup by
+// deleting it, with the old auth codes, from every server that advertises
+// CapabilityDeleteBackup, so the old auth codes stop working there. Servers
+// without the capability keep the old backup and are reported in RevokeResults.
+//
+// The key cannot be kept: the servers never reveal the secret behind the old
+// backup, so the new backup necessarily protects a new key. As with
+// RecoverAndRotate, re-encrypt existing data with NewEncryptionKey and store
+// Metadata; the data can no longer be recovered through the old backup once it
+// is revoked. The old backup is only revoked after the new one is registered.
+func RotateAuthCodes(identity *Identity, password string, serverInfos []ServerInfo, threshold int, oldAuthCodes *AuthCodes) *RotateAuthCodesResult {
+	return RotateAuthCodesWithOpts(identity, password, serverInfos, threshold, oldAuthCodes, nil)
+}
+
+// RotateAuthCodesWithOpts is like RotateAuthCodes, with options as for
+// RecoverAndRotateWithOpts
+func RotateAuthCodesWithOpts(identity *Identity, password string, serverInfos []ServerInfo, threshold int, oldAuthCodes *AuthCodes, opts *Options) *RotateAuthCodesResult {
+	result := &RotateAuthCodesResult{
+		RecoverAndRotateResult: *RecoverAndRotateWithOpts(identity, password, serverInfos, threshold, oldAuthCodes, opts),
+	}
+	if !result.Rotated {
+		return result
+	}
+	result.AuthCodes = result.Metadata.AuthCodes
+	result.RevokeResults = revokeBackup(identity, serverInfos, oldAuthCodes, opts)
+	return result
+}
+
+// revokeBackup deletes the backup identified by identity, with its auth code in
+// authCodes, from each server that advertises CapabilityDeleteBackup. Servers
+// without the capability are reported as not revoked.
+func revokeBackup(identity *Identity, serverInfos []ServerInfo, authCodes *AuthCodes, opts *Options) []ServerResult {
+	results := make([]ServerResult, len(serverInfos))
+	forEachServer(len(serverInfos), opts.maxWorkers(len(serverInfos)), func(i int) {
+		serverInfo := serverInfos[i]
+		results[i] = ServerResult{URL: serverInfo.URL}
+		authCode, ok := authCodes.ForServer(serverInfo.URL)
+		if !ok {
+			results[i].Error = "no auth code for server"
+			return
+		}
+
+		publicKey, err := decodeServerPublicKey(serverInfo.PublicKey)
+		if err != nil {
+			results[i].Error = fmt.Sprintf("Invalid public key: %v", err)
+			return
+		}
+		client := opts.newClient(context.Background(), serverInfo.URL, publicKey)
+		if !client.Capabilities().DeleteBackup {
+			results[i].Error = "server does not support DeleteBackup, backup not revoked"
+			return
+		}
+
+		start := time.Now()
+		err = client.DeleteBackup(authCode, identity.UID, identity.DID, identity.BID, client.HasPublicKey(), nil)
+		results[i].Latency = time.Since(start)
+		if err != nil {
+			results[i].Error = err.Error()
+			return
+		}
+		results[i].Success = true
+	})
+	return results
+}
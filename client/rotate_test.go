@@ -0,0 +1,259 @@
+package client
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openadp/ocrypt/testserver"
+)
+
+func TestAlternateBID(t *testing.T) {
+	tests := []struct {
+		bid    string
+		want   string
+		wantOK bool
+	}{
+		{"even", "odd", true},
+		{"odd", "even", true},
+		{"file://notes.txt", "", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := AlternateBID(tt.bid)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("AlternateBID(%q) = %q, %v, want %q, %v", tt.bid, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestRecoverAndRotate(t *testing.T) {
+	servers, serverInfos := newTestServers(t, 3)
+	identity := &Identity{UID: "user", DID: "app", BID: "even"}
+
+	generated := GenerateEncryptionKey(identity, "password", 7, 0, serverInfos)
+	if generated.Error != "" {
+		t.Fatalf("Key generation failed: %s", generated.Error)
+	}
+
+	result := RecoverAndRotate(identity, "password", serverInfos, generated.Threshold, generated.AuthCodes)
+	if result.Error != "" {
+		t.Fatalf("RecoverAndRotate() error: %s", result.Error)
+	}
+	if !result.Rotated {
+		t.Fatalf("RecoverAndRotate() did not rotate: %s", result.RotationError)
+	}
+	if !bytes.Equal(result.EncryptionKey, generated.EncryptionKey) {
+		t.Error("Recovered key does not match the original key")
+	}
+	if result.Metadata.BID != "odd" {
+		t.Errorf("Metadata.BID = %q, want %q", result.Metadata.BID, "odd")
+	}
+
+	// The new backup has a fresh guess budget and recovers the new key
+	newIdentity := result.Metadata.Identity(identity.UID)
+	status, err := QueryBackupStatus(newIdentity, serverInfos, result.Metadata.AuthCodes)
+	if err != nil {
+		t.Fatalf("QueryBackupStatus() error: %v", err)
+	}
+	if status.MinRemaining != 7 {
+		t.Errorf("New backup has %d remaining guesses, want 7", status.MinRemaining)
+	}
+
+	recovered := RecoverEncryptionKeyWithServerInfo(newIdentity, "password",
+		MetadataToServerInfo(result.Metadata), result.Metadata.Threshold, result.Metadata.AuthCodes)
+	if recovered.Error != "" {
+		t.Fatalf("Recovery of rotated backup failed: %s", recovered.Error)
+	}
+	if !bytes.Equal(recovered.EncryptionKey, result.NewEncryptionKey) {
+		t.Error("Rotated backup does not recover NewEncryptionKey")
+	}
+
+	// If re-registration fails, recovery still succeeds
+	for _, server := range servers {
+		server.SetFault("RegisterSecret", testserver.FaultUnavailable)
+	}
+	failed := RecoverAndRotate(newIdentity, "password", serverInfos, result.Metadata.Threshold, result.Metadata.AuthCodes)
+	if failed.Error != "" {
+		t.Fatalf("RecoverAndRotate() error: %s", failed.Error)
+	}
+	if failed.Rotated || failed.RotationError == "" || failed.Metadata != nil {
+		t.Errorf("RecoverAndRotate() = Rotated %v, RotationError %q, want failed rotation", failed.Rotated, failed.RotationError)
+	}
+	if !bytes.Equal(failed.EncryptionKey, result.NewEncryptionKey) {
+		t.Error("Recovered key does not match after failed rotation")
+	}
+}
+
+func TestRecoverAndRotateKeepsSettings(t *testing.T) {
+	_, serverInfos := newTestServers(t, 3)
+	identity := &Identity{UID: "user", DID: "app", BID: "even"}
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Second)
+	opts := &Options{PinParams: &PinParams{KDF: PinKDFArgon2id, Time: 1, MemoryKiB: 1024, Threads: 1}, KeyLength: 16}
+	generated := GenerateEncryptionKeyWithExpiry(identity, "password", 7, expiresAt, serverInfos, opts)
+	if generated.Error != "" {
+		t.Fatalf("Key generation failed: %s", generated.Error)
+	}
+
+	metadata, err := MetadataFromResult(identity, generated)
+	if err != nil {
+		t.Fatalf("MetadataFromResult() error: %v", err)
+	}
+	recoveryOpts, err := metadata.RecoveryOptions(nil)
+	if err != nil {
+		t.Fatalf("RecoveryOptions() error: %v", err)
+	}
+	result := RecoverAndRotateWithOpts(identity, "password", serverInfos, generated.Threshold, generated.AuthCodes, recoveryOpts)
+	if result.Error != "" || !result.Rotated {
+		t.Fatalf("RecoverAndRotateWithOpts() error: %s, rotation error: %s", result.Error, result.RotationError)
+	}
+	if !bytes.Equal(result.EncryptionKey, generated.EncryptionKey) {
+		t.Error("Recovered key does not match the original key")
+	}
+
+	// The new backup keeps the expiration, PIN KDF and key length, with a new salt
+	newIdentity := result.Metadata.Identity(identity.UID)
+	status, err := QueryBackupStatus(newIdentity, serverInfos, result.Metadata.AuthCodes)
+	if err != nil {
+		t.Fatalf("QueryBackupStatus() error: %v", err)
+	}
+	for _, server := range status.Servers {
+		if server.Expiration != int(expiresAt.Unix()) {
+			t.Errorf("New backup on %s expires at %d, want %d", server.URL, server.Expiration, expiresAt.Unix())
+		}
+	}
+	params := result.Metadata.PinParams
+	if params == nil || params.KDF != PinKDFArgon2id || params.MemoryKiB != 1024 {
+		t.Errorf("New backup has PIN parameters %s, want %s", params.describe(), generated.PinParams.describe())
+	} else if bytes.Equal(params.Salt, generated.PinParams.Salt) {
+		t.Error("New backup reuses the Argon2id salt of the old one")
+	}
+	if len(result.NewEncryptionKey) != 16 {
+		t.Errorf("New key has %d bytes, want 16", len(result.NewEncryptionKey))
+	}
+
+	newOpts, err := result.Metadata.RecoveryOptions(nil)
+	if err != nil {
+		t.Fatalf("RecoveryOptions() error: %v", err)
+	}
+	recovered := RecoverEncryptionKeyWithOpts(newIdentity, "password", MetadataToServerInfo(result.Metadata), result.Metadata.Threshold, result.Metadata.AuthCodes, newOpts)
+	if recovered.Error != "" {
+		t.Fatalf("Recovery of rotated backup failed: %s", recovered.Error)
+	}
+	if !bytes.Equal(recovered.EncryptionKey, result.NewEncryptionKey) {
+		t.Error("Rotated backup does not recover NewEncryptionKey")
+	}
+}
+
+func TestRecoverAndRotateRecoveryFailure(t *testing.T) {
+	_, serverInfos := newTestServers(t, 3)
+	identity := &Identity{UID: "user", DID: "app", BID: "even"}
+
+	result := RecoverAndRotate(identity, "password", serverInfos, 2, GenerateAuthCodes(nil))
+	if result.ErrCode != ErrThresholdNotMet {
+		t.Errorf("ErrCode = %q, want %q (error: %s)", result.ErrCode, ErrThresholdNotMet, result.Error)
+	}
+	if result.Rotated {
+		t.Error("RecoverAndRotate() rotated after failed recovery")
+	}
+}
+
+func TestRotateAuthCodes(t *testing.T) {
+	servers, serverInfos := newTestServers(t, 3)
+	for _, server := range servers {
+		server.SetCapabilities(CapabilityDeleteBackup)
+	}
+	identity := &Identity{UID: "user", DID: "app", BID: "even"}
+
+	generated := GenerateEncryptionKey(identity, "password", 10, 0, serverInfos)
+	if generated.Error != "" {
+		t.Fatalf("Key generation failed: %s", generated.Error)
+	}
+	oldAuthCodes := generated.AuthCodes
+
+	result := RotateAuthCodes(identity, "password", serverInfos, generated.Threshold, oldAuthCodes)
+	if result.Error != "" || !result.Rotated {
+		t.Fatalf("RotateAuthCodes() error: %s%s", result.Error, result.RotationError)
+	}
+	if result.AuthCodes == nil || result.AuthCodes.BaseAuthCode == oldAuthCodes.BaseAuthCode {
+		t.Fatal("RotateAuthCodes() did not generate a new base auth code")
+	}
+	for _, server := range serverInfos {
+		if result.AuthCodes.ServerAuthCodes[server.URL] == oldAuthCodes.ServerAuthCodes[server.URL] {
+			t.Errorf("Auth code of %s was not rotated", server.URL)
+		}
+	}
+	for _, revoked := range result.RevokeResults {
+		if !revoked.Success {
+			t.Errorf("Revocation on %s failed: %s", revoked.URL, revoked.Error)
+		}
+	}
+
+	newIdentity := result.Metadata.Identity(identity.UID)
+	tests := []struct {
+		name      string
+		identity  *Identity
+		authCodes *AuthCodes
+		want      []byte // Nil if recovery must fail
+	}{
+		{"old codes on old backup", identity, oldAuthCodes, nil},
+		{"old codes on new backup", newIdentity, oldAuthCodes, nil},
+		{"new codes on new backup", newIdentity, result.AuthCodes, result.NewEncryptionKey},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recovered := RecoverEncryptionKeyWithServerInfo(tt.identity, "password", serverInfos, generated.Threshold, tt.authCodes)
+			if tt.want == nil {
+				if recovered.Error == "" {
+					t.Error("Recovery succeeded with revoked auth codes")
+				}
+				return
+			}
+			if recovered.Error != "" {
+				t.Fatalf("Recovery failed: %s", recovered.Error)
+			}
+			if !bytes.Equal(recovered.EncryptionKey, tt.want) {
+				t.Error("Recovered key does not match the new key")
+			}
+		})
+	}
+}
+
+func TestRotateAuthCodesWithoutDeleteBackup(t *testing.T) {
+	servers, serverInfos := newTestServers(t, 3)
+	servers[0].SetCapabilities(CapabilityDeleteBackup)
+	servers[1].SetCapabilities(CapabilityDeleteBackup)
+	identity := &Identity{UID: "user", DID: "app", BID: "even"}
+	generated := GenerateEncryptionKey(identity, "password", 10, 0, serverInfos)
+	if generated.Error != "" {
+		t.Fatalf("Key generation failed: %s", generated.Error)
+	}
+
+	result := RotateAuthCodes(identity, "password", serverInfos, generated.Threshold, generated.AuthCodes)
+	if result.Error != "" || !result.Rotated {
+		t.Fatalf("RotateAuthCodes() error: %s%s", result.Error, result.RotationError)
+	}
+	for i, revoked := range result.RevokeResults {
+		if want := i < 2; revoked.Success != want {
+			t.Errorf("Revocation on server %d: Success %v, want %v (%s)", i, revoked.Success, want, revoked.Error)
+		}
+	}
+	if !strings.Contains(result.RevokeResults[2].Error, "not revoked") {
+		t.Errorf("Revocation on server 2: Error %q, want it reported as not revoked", result.RevokeResults[2].Error)
+	}
+
+	// The server without the capability still holds the old backup, unchanged
+	client := NewEncryptedOpenADPClient(serverInfos[2].URL, servers[2].PublicKey)
+	backups, err := client.ListBackups(identity.UID, true, nil)
+	if err != nil {
+		t.Fatalf("ListBackups() error: %v", err)
+	}
+	for _, backup := range backups {
+		if backup["bid"] == identity.BID {
+			return
+		}
+	}
+	t.Errorf("ListBackups() = %v, want the old backup %s kept", backups, identity.BID)
+}
@@ -1,21 +1,30 @@
 package client
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/netip"
+	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 )
 
-// ServerInfo represents information about an OpenADP server
+// ServerInfo represents information about an OpenADP server. Because of Tags it is
+// not comparable: it cannot be compared with == or used as a map key, so identify
+// servers by URL instead.
 type ServerInfo struct {
-	URL              string `json:"url"`
-	PublicKey        string `json:"public_key"`
-	Country          string `json:"country"`
-	RemainingGuesses int    `json:"remaining_guesses,omitempty"` // -1 means unknown, >=0 means known remaining guesses
+	URL              string   `json:"url"`
+	PublicKey        string   `json:"public_key"` // Pinned Noise-NK key as "ed25519:<base64>"; see WithPublicKey
+	Country          string   `json:"country"`
+	RemainingGuesses int      `json:"remaining_guesses,omitempty"` // -1 means unknown, >=0 means known remaining guesses
+	Version          string   `json:"version,omitempty"`           // Software version advertised by a registry
+	Tags             []string `json:"tags,omitempty"`              // Registry tags, such as jurisdictions
 }
 
 // ServersResponse represents the JSON response from the server registry
@@ -171,6 +180,14 @@ func GetFallbackServerInfo() []ServerInfo {
 	}
 }
 
+// WithPublicKey returns a copy of s pinned to the Noise-NK public key pk, for example
+// the key observed by ProbeServers. Encrypted requests to a pinned server fail with
+// ErrPublicKeyMismatch unless the server holds the matching private key.
+func (s ServerInfo) WithPublicKey(pk []byte) ServerInfo {
+	s.PublicKey = "ed25519:" + base64.StdEncoding.EncodeToString(pk)
+	return s
+}
+
 // ConvertURLsToServerInfo converts a list of URLs to ServerInfo structs (for backward compatibility)
 func ConvertURLsToServerInfo(urls []string) []ServerInfo {
 	serverInfos := make([]ServerInfo, len(urls))
@@ -185,6 +202,94 @@ func ConvertURLsToServerInfo(urls []string) []ServerInfo {
 	return serverInfos
 }
 
+// ConvertURLsToServerInfoStrict converts a list of server URLs to ServerInfo
+// structs, normalizing each with NormalizeServerURL. Unlike ConvertURLsToServerInfo
+// it rejects plaintext http:// URLs unless opts.AllowInsecure is set, and fails on
+// the first URL that is not a valid server URL.
+func ConvertURLsToServerInfoStrict(urls []string, opts *Options) ([]ServerInfo, error) {
+	serverInfos := make([]ServerInfo, len(urls))
+	for i, rawURL := range urls {
+		normalized, err := NormalizeServerURL(rawURL)
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(normalized, "http://") && !opts.allowInsecure() {
+			return nil, fmt.Errorf("insecure server URL %q: use https or set AllowInsecure", rawURL)
+		}
+		serverInfos[i] = ServerInfo{
+			URL:              normalized,
+			PublicKey:        "",
+			Country:          "Unknown",
+			RemainingGuesses: -1,
+		}
+	}
+	return serverInfos, nil
+}
+
+// NormalizeServerURL validates an http:// or https:// server URL and returns it in
+// canonical form: lowercase scheme and host, IP addresses in their shortest form
+// (IPv6 in brackets), no default port (443 for https, 80 for http), other ports
+// without leading zeros, and no trailing slash. Query strings, fragments and user info are
+// rejected, since servers are identified by scheme, host and path alone.
+func NormalizeServerURL(rawURL string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return "", fmt.Errorf("invalid server URL %q: %v", rawURL, err)
+	}
+
+	scheme := strings.ToLower(u.Scheme)
+	if scheme != "https" && scheme != "http" {
+		return "", fmt.Errorf("invalid server URL %q: scheme must be https", rawURL)
+	}
+	if u.Hostname() == "" {
+		return "", fmt.Errorf("invalid server URL %q: missing host", rawURL)
+	}
+	if u.User != nil || u.RawQuery != "" || u.Fragment != "" {
+		return "", fmt.Errorf("invalid server URL %q: user info, query and fragment are not allowed", rawURL)
+	}
+
+	host, err := canonicalHost(scheme, u.Hostname(), u.Port())
+	if err != nil {
+		return "", fmt.Errorf("invalid server URL %q: %v", rawURL, err)
+	}
+
+	// An IPv6 zone is percent-encoded in the URL itself
+	host = strings.ReplaceAll(host, "%", "%25")
+	return scheme + "://" + host + strings.TrimRight(u.EscapedPath(), "/"), nil
+}
+
+// canonicalHost returns the host and port of a URL with the given scheme as they
+// appear in its canonical form: an IP address in the shortest form that
+// netip.Addr prints, so that "[0:0::1]" and "[::1]" agree, any other host name
+// lowercase, and the port in decimal without leading zeros, omitted when it is
+// the default of the scheme. IPv6 addresses are bracketed, their zone unescaped as in
+// url.URL.Host.
+func canonicalHost(scheme, hostname, port string) (string, error) {
+	host := strings.ToLower(hostname)
+	if addr, err := netip.ParseAddr(hostname); err == nil {
+		host = addr.String()
+	}
+
+	if port != "" {
+		n, err := strconv.Atoi(port)
+		if err != nil || n < 1 || n > 65535 {
+			return "", fmt.Errorf("port %q is not between 1 and 65535", port)
+		}
+		port = strconv.Itoa(n)
+	}
+	if (scheme == "https" && port == "443") || (scheme == "http" && port == "80") {
+		port = ""
+	}
+
+	if port != "" {
+		return net.JoinHostPort(host, port), nil
+	}
+	if strings.Contains(host, ":") {
+		return "[" + host + "]", nil
+	}
+	return host, nil
+}
+
 // DiscoverServers attempts to discover servers from registry with fallback
 func DiscoverServers(registryURL string) []ServerInfo {
 	// Try to fetch from registry first
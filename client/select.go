@@ -0,0 +1,272 @@
+package client
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"sort"
+	"strings"
+)
+
+// SelectStrategy chooses how SelectServers picks servers
+type SelectStrategy int
+
+const (
+	// SelectRandom picks servers uniformly at random
+	SelectRandom SelectStrategy = iota
+	// SelectLowestLatency picks the servers with the lowest latency in
+	// SelectOptions.Probes; servers that were not probed or were unreachable come last
+	SelectLowestLatency
+	// SelectDiverse spreads the selection over as many countries as possible,
+	// picking at random within each country
+	SelectDiverse
+	// SelectHealthiest picks the servers with the best ServerHealth.Score in
+	// SelectOptions.Health, so that servers that failed recently come last
+	SelectHealthiest
+)
+
+// SelectOptions configures SelectServers
+type SelectOptions struct {
+	Strategy SelectStrategy
+
+	// Probes are the results of a prior ProbeServers call, matched to candidates
+	// by URL. Only used by SelectLowestLatency.
+	Probes []ServerProbe
+
+	// Health holds the past behavior of the candidates, for example the store set
+	// as Options.HealthStore. Only used by SelectHealthiest; nil picks at random.
+	Health ServerHealthStore
+
+	// Rand is the source of randomness. Nil (the default) uses crypto/rand. Only
+	// inject a different reader in tests.
+	Rand io.Reader
+}
+
+// SelectServers chooses count servers out of candidates using opts.Strategy, for
+// example to build a 9-of-15 backup from a registry of 20 servers. It fails if
+// fewer than count candidates are available. The candidates are not modified.
+func SelectServers(candidates []ServerInfo, count int, opts SelectOptions) ([]ServerInfo, error) {
+	if count < 1 {
+		return nil, errors.New("count must be at least 1")
+	}
+	if len(candidates) < count {
+		return nil, fmt.Errorf("need %d servers, only %d candidates available", count, len(candidates))
+	}
+
+	random := opts.Rand
+	if random == nil {
+		random = rand.Reader
+	}
+
+	// Every strategy starts from a random order, so that ties are broken fairly
+	shuffled := append([]ServerInfo(nil), candidates...)
+	if err := shuffleServers(random, shuffled); err != nil {
+		return nil, err
+	}
+
+	switch opts.Strategy {
+	case SelectRandom:
+		return shuffled[:count], nil
+	case SelectLowestLatency:
+		return selectLowestLatency(shuffled, count, opts.Probes), nil
+	case SelectDiverse:
+		return selectDiverse(shuffled, count), nil
+	case SelectHealthiest:
+		return selectHealthiest(shuffled, count, opts.Health), nil
+	}
+	return nil, fmt.Errorf("unknown selection strategy %d", opts.Strategy)
+}
+
+// shuffleServers permutes servers uniformly at random (Fisher-Yates)
+func shuffleServers(random io.Reader, servers []ServerInfo) error {
+	for i := len(servers) - 1; i > 0; i-- {
+		j, err := rand.Int(random, big.NewInt(int64(i+1)))
+		if err != nil {
+			return fmt.Errorf("failed to generate random index: %v", err)
+		}
+		servers[i], servers[j.Int64()] = servers[j.Int64()], servers[i]
+	}
+	return nil
+}
+
+// selectLowestLatency returns the count servers with the lowest probed latency
+func selectLowestLatency(servers []ServerInfo, count int, probes []ServerProbe) []ServerInfo {
+	byURL := make(map[string]*ServerProbe, len(probes))
+	for i := range probes {
+		byURL[probes[i].Server.URL] = &probes[i]
+	}
+
+	// Unprobed and unreachable servers sort after all reachable ones
+	rank := func(server ServerInfo) (bool, int64) {
+		probe, ok := byURL[server.URL]
+		if !ok || !probe.Reachable {
+			return false, 0
+		}
+		return true, int64(probe.Latency)
+	}
+
+	sort.SliceStable(servers, func(i, j int) bool {
+		okI, latencyI := rank(servers[i])
+		okJ, latencyJ := rank(servers[j])
+		if okI != okJ {
+			return okI
+		}
+		return latencyI < latencyJ
+	})
+	return servers[:count]
+}
+
+// selectDiverse picks servers round-robin across countries, so that no country
+// holds a second server before every country holds one
+func selectDiverse(servers []ServerInfo, count int) []ServerInfo {
+	var countries []string
+	byCountry := make(map[string][]ServerInfo)
+	for _, server := range servers {
+		country := strings.ToUpper(server.Country)
+		if country == "" {
+			country = "UNKNOWN"
+		}
+		if _, ok := byCountry[country]; !ok {
+			countries = append(countries, country)
+		}
+		byCountry[country] = append(byCountry[country], server)
+	}
+
+	selected := make([]ServerInfo, 0, count)
+	for round := 0; len(selected) < count; round++ {
+		for _, country := range countries {
+			if round < len(byCountry[country]) && len(selected) < count {
+				selected = append(selected, byCountry[country][round])
+			}
+		}
+	}
+	return selected
+}
+
+// ErrInsufficientDiversity is returned by DiverseSubset when the servers do not
+// span enough tag groups for the requested count
+var ErrInsufficientDiversity = errors.New("not enough distinct tag groups")
+
+// FilterServers returns the servers that carry at least one of the include tags
+// and none of the exclude tags, in their original order. An empty include keeps
+// every server that is not excluded, so FilterServers(servers, nil, []string{"operator:acme"})
+// drops one operator's servers. Tags are compared exactly.
+func FilterServers(servers []ServerInfo, include, exclude []string) []ServerInfo {
+	var filtered []ServerInfo
+	for _, server := range servers {
+		if len(include) > 0 && !hasAnyTag(server, include) {
+			continue
+		}
+		if hasAnyTag(server, exclude) {
+			continue
+		}
+		filtered = append(filtered, server)
+	}
+	return filtered
+}
+
+// hasAnyTag reports whether server carries one of tags
+func hasAnyTag(server ServerInfo, tags []string) bool {
+	for _, tag := range server.Tags {
+		for _, wanted := range tags {
+			if tag == wanted {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// DiverseSubset picks count servers at random such that no two share a tag of the
+// given group, where a tag "jurisdiction:ch" belongs to the group "jurisdiction".
+// It guarantees, for example, that no two servers sit in the same jurisdiction or
+// are run by the same operator. Random is the source of randomness; nil uses
+// crypto/rand.
+//
+// Servers without a tag of the group are never picked, since their group is
+// unknown. A server with several tags of the group, such as an operator present
+// in two jurisdictions, rules out every other server in any of them. If the
+// servers cannot satisfy the constraint, DiverseSubset returns an error wrapping
+// ErrInsufficientDiversity that reports how many groups were available; the
+// selection is greedy, so in rare cases with multi-tagged servers it may fail even
+// though a valid subset exists.
+func DiverseSubset(servers []ServerInfo, count int, group string, random io.Reader) ([]ServerInfo, error) {
+	if count < 1 {
+		return nil, errors.New("count must be at least 1")
+	}
+	if group == "" {
+		return nil, errors.New("a tag group is required")
+	}
+	if random == nil {
+		random = rand.Reader
+	}
+
+	// Shuffle for a fair pick, then prefer servers that use up fewer groups
+	shuffled := append([]ServerInfo(nil), servers...)
+	if err := shuffleServers(random, shuffled); err != nil {
+		return nil, err
+	}
+
+	type candidate struct {
+		server ServerInfo
+		groups []string
+	}
+	var candidates []candidate
+	untagged := 0
+	available := make(map[string]bool)
+	for _, server := range shuffled {
+		groups := tagsInGroup(server, group)
+		if len(groups) == 0 {
+			untagged++
+			continue
+		}
+		for _, g := range groups {
+			available[g] = true
+		}
+		candidates = append(candidates, candidate{server, groups})
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return len(candidates[i].groups) < len(candidates[j].groups)
+	})
+
+	used := make(map[string]bool)
+	selected := make([]ServerInfo, 0, count)
+	for _, c := range candidates {
+		if len(selected) == count {
+			break
+		}
+		free := true
+		for _, g := range c.groups {
+			if used[g] {
+				free = false
+				break
+			}
+		}
+		if !free {
+			continue
+		}
+		for _, g := range c.groups {
+			used[g] = true
+		}
+		selected = append(selected, c.server)
+	}
+
+	if len(selected) < count {
+		return nil, fmt.Errorf("%w: need %d servers with distinct %q tags, found %d %q groups across %d tagged servers (%d servers carry no %q tag)",
+			ErrInsufficientDiversity, count, group, len(available), group, len(candidates), untagged, group)
+	}
+	return selected, nil
+}
+
+// tagsInGroup returns the tags of server that belong to group
+func tagsInGroup(server ServerInfo, group string) []string {
+	var tags []string
+	for _, tag := range server.Tags {
+		if strings.HasPrefix(tag, group+":") {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
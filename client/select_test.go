@@ -0,0 +1,202 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSelectServersRandomIsUnbiased(t *testing.T) {
+	candidates := []ServerInfo{{URL: "https://a"}, {URL: "https://b"}, {URL: "https://c"}, {URL: "https://d"}}
+	random := seededReader(7)
+
+	const trials = 8000
+	counts := make(map[string]int)
+	for i := 0; i < trials; i++ {
+		selected, err := SelectServers(candidates, 2, SelectOptions{Rand: random})
+		if err != nil {
+			t.Fatalf("SelectServers() error: %v", err)
+		}
+		if len(selected) != 2 || selected[0].URL == selected[1].URL {
+			t.Fatalf("SelectServers() = %v, want 2 distinct servers", selected)
+		}
+		for _, server := range selected {
+			counts[server.URL]++
+		}
+	}
+
+	// Each server is expected in half of the trials; allow 5% deviation
+	for _, candidate := range candidates {
+		if got := counts[candidate.URL]; got < trials/2*95/100 || got > trials/2*105/100 {
+			t.Errorf("Server %s selected %d times in %d trials, want about %d", candidate.URL, got, trials, trials/2)
+		}
+	}
+}
+
+func TestSelectServersLowestLatency(t *testing.T) {
+	candidates := []ServerInfo{{URL: "https://slow"}, {URL: "https://down"}, {URL: "https://fast"}, {URL: "https://unprobed"}, {URL: "https://medium"}}
+	probes := []ServerProbe{
+		{Server: ServerInfo{URL: "https://slow"}, Reachable: true, Latency: 300 * time.Millisecond},
+		{Server: ServerInfo{URL: "https://down"}, Reachable: false},
+		{Server: ServerInfo{URL: "https://fast"}, Reachable: true, Latency: 10 * time.Millisecond},
+		{Server: ServerInfo{URL: "https://medium"}, Reachable: true, Latency: 50 * time.Millisecond},
+	}
+
+	tests := []struct {
+		count int
+		want  string
+	}{
+		{1, "https://fast"},
+		{3, "https://fast https://medium https://slow"},
+	}
+
+	for _, tt := range tests {
+		selected, err := SelectServers(candidates, tt.count, SelectOptions{Strategy: SelectLowestLatency, Probes: probes, Rand: seededReader(1)})
+		if err != nil {
+			t.Fatalf("SelectServers() error: %v", err)
+		}
+		if got := serverURLs(selected); got != tt.want {
+			t.Errorf("SelectServers(count=%d) = %s, want %s", tt.count, got, tt.want)
+		}
+	}
+}
+
+func TestSelectServersDiverse(t *testing.T) {
+	var candidates []ServerInfo
+	for i := 0; i < 4; i++ {
+		candidates = append(candidates, ServerInfo{URL: fmt.Sprintf("https://us%d", i), Country: "US"})
+	}
+	candidates = append(candidates, ServerInfo{URL: "https://de", Country: "DE"}, ServerInfo{URL: "https://ch", Country: "ch"})
+
+	selected, err := SelectServers(candidates, 4, SelectOptions{Strategy: SelectDiverse, Rand: seededReader(3)})
+	if err != nil {
+		t.Fatalf("SelectServers() error: %v", err)
+	}
+
+	countries := make(map[string]int)
+	for _, server := range selected {
+		countries[strings.ToUpper(server.Country)]++
+	}
+	if countries["US"] != 2 || countries["DE"] != 1 || countries["CH"] != 1 {
+		t.Errorf("SelectServers() countries = %v, want US:2 DE:1 CH:1", countries)
+	}
+}
+
+func TestSelectServersErrors(t *testing.T) {
+	candidates := []ServerInfo{{URL: "https://a"}, {URL: "https://b"}}
+
+	tests := []struct {
+		name  string
+		count int
+		opts  SelectOptions
+	}{
+		{"too few candidates", 3, SelectOptions{}},
+		{"zero count", 0, SelectOptions{}},
+		{"unknown strategy", 1, SelectOptions{Strategy: SelectStrategy(99)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := SelectServers(candidates, tt.count, tt.opts); err == nil {
+				t.Error("SelectServers() expected error")
+			}
+		})
+	}
+}
+
+// taggedServers is a fixture of servers across jurisdictions and operators
+var taggedServers = []ServerInfo{
+	{URL: "https://us1", Tags: []string{"jurisdiction:us", "operator:acme"}},
+	{URL: "https://us2", Tags: []string{"jurisdiction:us", "operator:globex"}},
+	{URL: "https://de1", Tags: []string{"jurisdiction:de", "operator:acme"}},
+	{URL: "https://ch1", Tags: []string{"jurisdiction:ch", "operator:initech"}},
+	{URL: "https://eu1", Tags: []string{"jurisdiction:de", "jurisdiction:fr", "operator:umbrella"}},
+	{URL: "https://untagged"},
+}
+
+func TestFilterServers(t *testing.T) {
+	tests := []struct {
+		name    string
+		include []string
+		exclude []string
+		want    string
+	}{
+		{"no filters", nil, nil, "https://ch1 https://de1 https://eu1 https://untagged https://us1 https://us2"},
+		{"include any", []string{"jurisdiction:ch", "jurisdiction:de"}, nil, "https://ch1 https://de1 https://eu1"},
+		{"exclude operator", nil, []string{"operator:acme"}, "https://ch1 https://eu1 https://untagged https://us2"},
+		{"include and exclude", []string{"jurisdiction:us"}, []string{"operator:acme"}, "https://us2"},
+		{"no match", []string{"jurisdiction:jp"}, nil, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := serverURLs(FilterServers(taggedServers, tt.include, tt.exclude)); got != tt.want {
+				t.Errorf("FilterServers() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiverseSubset(t *testing.T) {
+	for seed := byte(0); seed < 20; seed++ {
+		selected, err := DiverseSubset(taggedServers, 3, "jurisdiction", seededReader(seed))
+		if err != nil {
+			t.Fatalf("DiverseSubset() error: %v", err)
+		}
+		if len(selected) != 3 {
+			t.Fatalf("DiverseSubset() returned %d servers, want 3", len(selected))
+		}
+		seen := make(map[string]string)
+		for _, server := range selected {
+			for _, tag := range tagsInGroup(server, "jurisdiction") {
+				if other, ok := seen[tag]; ok {
+					t.Errorf("Seed %d: %s and %s share %s", seed, other, server.URL, tag)
+				}
+				seen[tag] = server.URL
+			}
+			if server.URL == "https://untagged" {
+				t.Errorf("Seed %d: picked a server without a jurisdiction", seed)
+			}
+		}
+	}
+}
+
+func TestDiverseSubsetErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		count     int
+		group     string
+		diversity bool
+	}{
+		{"too many jurisdictions", 5, "jurisdiction", true},
+		{"too many operators", 5, "operator", true},
+		{"unknown group", 1, "region", true},
+		{"zero count", 0, "jurisdiction", false},
+		{"empty group", 1, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := DiverseSubset(taggedServers, tt.count, tt.group, seededReader(1))
+			if err == nil {
+				t.Fatal("DiverseSubset() expected error")
+			}
+			if errors.Is(err, ErrInsufficientDiversity) != tt.diversity {
+				t.Errorf("DiverseSubset() error = %v, ErrInsufficientDiversity expected: %v", err, tt.diversity)
+			}
+		})
+	}
+}
+
+// serverURLs joins the URLs of servers, sorted, for comparison in tests
+func serverURLs(servers []ServerInfo) string {
+	urls := make([]string, len(servers))
+	for i, server := range servers {
+		urls[i] = server.URL
+	}
+	sort.Strings(urls)
+	return strings.Join(urls, " ")
+}
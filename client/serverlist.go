@@ -0,0 +1,78 @@
+package client
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ServerListError reports which entry of a server list ParseServerList rejected
+type ServerListError struct {
+	Index  int    // Position of the entry in the list, counting from 0 and including blank entries
+	Entry  string // The entry as written, without surrounding whitespace
+	Reason string
+}
+
+func (e *ServerListError) Error() string {
+	return fmt.Sprintf("server list entry %d (%q): %s", e.Index, e.Entry, e.Reason)
+}
+
+// ParseServerList parses a list of servers as given on a command line, for
+// example the value of a --server flag. Entries are separated by commas or
+// newlines; surrounding whitespace and blank entries are ignored. Each entry is
+// an http:// or https:// URL, normalized with NormalizeServerURL, optionally
+// followed by "#" and the base64 Noise-NK public key to pin, with or without the
+// "ed25519:" prefix:
+//
+//	https://a.example.com,https://b.example.com#qfzf3TuhFjMyVa1ZQVubZJ1UZbfZMcUdBSbH29/hnHU=
+//
+// A server listed twice is kept once, with its pinned key if either entry has
+// one. Invalid entries, and a server listed twice with different keys, fail with
+// a *ServerListError. Like ConvertURLsToServerInfo, it does not reject plaintext
+// URLs; see ConvertURLsToServerInfoStrict.
+func ParseServerList(spec string) ([]ServerInfo, error) {
+	entries := strings.Split(strings.NewReplacer("\r\n", ",", "\n", ",").Replace(spec), ",")
+
+	var serverInfos []ServerInfo
+	seen := make(map[string]int) // Normalized URL -> index in serverInfos
+	for i, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		rawURL, key, pinned := strings.Cut(entry, "#")
+		normalized, err := NormalizeServerURL(rawURL)
+		if err != nil {
+			return nil, &ServerListError{Index: i, Entry: entry, Reason: err.Error()}
+		}
+
+		server := ServerInfo{URL: normalized, Country: "Unknown", RemainingGuesses: -1}
+		if pinned {
+			publicKey, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(strings.TrimSpace(key), "ed25519:"))
+			if err != nil || len(publicKey) != 32 {
+				return nil, &ServerListError{Index: i, Entry: entry, Reason: "public key must be 32 bytes of base64"}
+			}
+			server = server.WithPublicKey(publicKey)
+		}
+
+		if j, ok := seen[normalized]; ok {
+			previous := &serverInfos[j]
+			switch {
+			case previous.PublicKey == "":
+				previous.PublicKey = server.PublicKey
+			case server.PublicKey != "" && pinnedKey(*previous) != pinnedKey(server):
+				return nil, &ServerListError{Index: i, Entry: entry, Reason: "server is listed with another public key"}
+			}
+			continue
+		}
+		seen[normalized] = len(serverInfos)
+		serverInfos = append(serverInfos, server)
+	}
+
+	if len(serverInfos) == 0 {
+		return nil, errors.New("server list is empty")
+	}
+	return serverInfos, nil
+}
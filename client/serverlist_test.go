@@ -0,0 +1,92 @@
+package client
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseServerList(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 32)
+	encoded := base64.StdEncoding.EncodeToString(key)
+	pinned := "ed25519:" + encoded
+
+	tests := []struct {
+		name string
+		spec string
+		want []ServerInfo
+	}{
+		{"comma separated", "https://a.example.com,https://b.example.com", []ServerInfo{
+			{URL: "https://a.example.com"}, {URL: "https://b.example.com"},
+		}},
+		{"newlines and blanks", "\n  https://a.example.com \r\n\n, ,https://B.example.com:443/\n", []ServerInfo{
+			{URL: "https://a.example.com"}, {URL: "https://b.example.com"},
+		}},
+		{"pinned key", "https://a.example.com#" + encoded + ",https://b.example.com#" + pinned, []ServerInfo{
+			{URL: "https://a.example.com", PublicKey: pinned}, {URL: "https://b.example.com", PublicKey: pinned},
+		}},
+		{"duplicates merged", "https://a.example.com, https://a.example.com/#" + encoded + ",https://A.example.com", []ServerInfo{
+			{URL: "https://a.example.com", PublicKey: pinned},
+		}},
+		{"plaintext", "http://localhost:8080", []ServerInfo{{URL: "http://localhost:8080"}}},
+		{"documented example", "https://a.example.com,https://b.example.com#qfzf3TuhFjMyVa1ZQVubZJ1UZbfZMcUdBSbH29/hnHU=", []ServerInfo{
+			{URL: "https://a.example.com"}, {URL: "https://b.example.com", PublicKey: "ed25519:qfzf3TuhFjMyVa1ZQVubZJ1UZbfZMcUdBSbH29/hnHU="},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseServerList(tt.spec)
+			if err != nil {
+				t.Fatalf("ParseServerList() error: %v", err)
+			}
+			for i := range tt.want {
+				tt.want[i].Country = "Unknown"
+				tt.want[i].RemainingGuesses = -1
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseServerList() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseServerListErrors(t *testing.T) {
+	other := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{8}, 32))
+	key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32))
+
+	tests := []struct {
+		name      string
+		spec      string
+		wantIndex int
+	}{
+		{"scheme", "https://a.example.com,ftp://b.example.com", 1},
+		{"missing host", "https://a.example.com,,https://", 2},
+		{"query", "https://a.example.com\nhttps://b.example.com?x=1", 1},
+		{"bad base64", "https://a.example.com#not base64!", 0},
+		{"short key", "https://a.example.com#" + base64.StdEncoding.EncodeToString([]byte("short")), 0},
+		{"empty key", "https://a.example.com#", 0},
+		{"conflicting keys", "https://a.example.com#" + key + ",https://b.example.com,https://a.example.com#" + other, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseServerList(tt.spec)
+			var listErr *ServerListError
+			if !errors.As(err, &listErr) {
+				t.Fatalf("ParseServerList() error = %v, want a *ServerListError", err)
+			}
+			if listErr.Index != tt.wantIndex {
+				t.Errorf("Index = %d, want %d (%v)", listErr.Index, tt.wantIndex, err)
+			}
+		})
+	}
+
+	for _, spec := range []string{"", " , \n "} {
+		if _, err := ParseServerList(spec); err == nil {
+			t.Errorf("ParseServerList(%q) succeeded", spec)
+		}
+	}
+}
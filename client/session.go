@@ -0,0 +1,150 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/openadp/ocrypt/common"
+)
+
+// RecoverySession holds the shares a recovery has collected so far, so that a
+// recovery interrupted before reaching the threshold, say by a flaky mobile
+// network, can be resumed with ResumeRecovery instead of started over. Starting
+// over would spend a second guess on every server that already answered; a
+// resumed recovery skips them.
+//
+// A session marshals to JSON for storage between attempts. Its shares are
+// unblinded, s_i*U for the point U of the identity and password, so the session
+// must be kept as secret as the key: fewer than Threshold shares reveal nothing
+// about the password or the key, but Threshold of them determine the key. Discard
+// it once recovery succeeds.
+type RecoverySession struct {
+	Identity  Identity       `json:"identity"`
+	Threshold int            `json:"threshold"`
+	Shares    []SessionShare `json:"shares"`
+}
+
+// SessionShare is a share collected by a RecoverySession
+type SessionShare struct {
+	URL   string `json:"url"`
+	X     int64  `json:"x"`
+	Point []byte `json:"point"` // Compressed s_i*U
+
+	// ProtocolVersion is the share format version of the share, zero for
+	// ProtocolVersion
+	ProtocolVersion int `json:"protocol_version,omitempty"`
+}
+
+// NewRecoverySession returns an empty session for recovering the backup of
+// identity from threshold shares
+func NewRecoverySession(identity *Identity, threshold int) *RecoverySession {
+	return &RecoverySession{Identity: *identity, Threshold: threshold}
+}
+
+// Collected returns the number of shares collected so far
+func (s *RecoverySession) Collected() int {
+	if s == nil {
+		return 0
+	}
+	return len(s.Shares)
+}
+
+// ResumeRecovery continues a recovery from the shares in session, contacting the
+// servers of remainingServers that have not contributed a share yet, one at a
+// time, until the session holds Threshold shares; servers already in the session
+// are never contacted again, so no guess is spent on them twice. Each new share
+// is added to session as soon as it is received. If the threshold is still not
+// met, the result fails with ErrThresholdNotMet, or the error of the servers, and
+// session can be stored and resumed later with other servers.
+//
+// The password and auth codes are not stored in the session and must be given
+// again. A session resumed with a different password yields a wrong key, as a
+// recovery with the wrong password does. Options apply as to
+// RecoverEncryptionKeyWithOpts; ServerResults lists only the servers contacted
+// by this call.
+func ResumeRecovery(session *RecoverySession, remainingServers []ServerInfo, password string, authCodes *AuthCodes, opts *Options) *RecoverEncryptionKeyResult {
+	if err := session.validate(); err != nil {
+		return &RecoverEncryptionKeyResult{
+			Error:   fmt.Sprintf("Invalid recovery session: %v", err),
+			ErrCode: ErrInvalidInput,
+		}
+	}
+	resumed := Options{}
+	if opts != nil {
+		resumed = *opts
+	}
+	resumed.session = session
+	identity := session.Identity
+	return RecoverEncryptionKeyWithOpts(&identity, password, remainingServers, session.Threshold, authCodes, &resumed)
+}
+
+// validate checks that the shares of the session can be used
+func (s *RecoverySession) validate() error {
+	if s == nil {
+		return errors.New("no session")
+	}
+	if s.Threshold <= 0 {
+		return errors.New("threshold must be positive")
+	}
+	seen := make(map[string]bool, len(s.Shares))
+	for _, share := range s.Shares {
+		url := normalizeServerURL(share.URL)
+		if seen[url] {
+			return fmt.Errorf("server %s contributed two shares", share.URL)
+		}
+		seen[url] = true
+		if _, err := common.PointDecompress(share.Point); err != nil {
+			return fmt.Errorf("share of %s: %w", share.URL, err)
+		}
+	}
+	return nil
+}
+
+// pending returns the servers of serverInfos that have not contributed a share
+func (s *RecoverySession) pending(serverInfos []ServerInfo) []ServerInfo {
+	done := make(map[string]bool, len(s.Shares))
+	for _, share := range s.Shares {
+		done[normalizeServerURL(share.URL)] = true
+	}
+	var pending []ServerInfo
+	for _, server := range serverInfos {
+		if !done[normalizeServerURL(server.URL)] {
+			pending = append(pending, server)
+		}
+	}
+	return pending
+}
+
+// blinded returns the shares of the session blinded by r, s_i*r*U, to be combined
+// with the shares servers return for B = r*U, and the URL of each. Shares of a
+// protocol version this client does not support are left out.
+func (s *RecoverySession) blinded(r *big.Int) ([]*PointShare, []string) {
+	shares := make([]*PointShare, 0, len(s.Shares))
+	urls := make([]string, 0, len(s.Shares))
+	for _, share := range s.Shares {
+		point, err := common.PointDecompress(share.Point)
+		if err != nil || !supportsProtocolVersion(share.protocolVersion()) {
+			continue // Rejected by validate, or never combinable
+		}
+		shares = append(shares, &PointShare{X: big.NewInt(share.X), Point: common.Unexpand(common.PointMul(r, point))})
+		urls = append(urls, share.URL)
+	}
+	return shares, urls
+}
+
+// protocolVersion returns the share format version of the share
+func (s SessionShare) protocolVersion() int {
+	if s.ProtocolVersion == 0 {
+		return ProtocolVersion
+	}
+	return s.ProtocolVersion
+}
+
+// add records the share s_i*B a server returned for B = r*U, unblinding it with
+// rInv. Recovery only collects shares of ProtocolVersion, which is recorded as
+// zero.
+func (s *RecoverySession) add(url string, share *PointShare, rInv *big.Int) {
+	point := common.PointMul(rInv, common.Expand(share.Point))
+	s.Shares = append(s.Shares, SessionShare{URL: url, X: share.X.Int64(), Point: common.PointCompress(point)})
+}
@@ -0,0 +1,76 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestResumeRecovery(t *testing.T) {
+	servers, serverInfos := newTestServers(t, 5)
+	identity := &Identity{UID: "user", DID: "phone", BID: "resumed"}
+
+	generated := GenerateEncryptionKeyWithOpts(identity, "password", 10, 0, serverInfos, &Options{Threshold: 3})
+	if generated.Error != "" {
+		t.Fatalf("Key generation failed: %s", generated.Error)
+	}
+
+	// The first attempt only reaches two servers
+	session := NewRecoverySession(identity, generated.Threshold)
+	result := ResumeRecovery(session, serverInfos[:2], "password", generated.AuthCodes, nil)
+	if result.ErrCode != ErrThresholdNotMet {
+		t.Fatalf("ErrCode = %q (%s), want %q", result.ErrCode, result.Error, ErrThresholdNotMet)
+	}
+	if session.Collected() != 2 || result.SharesCollected != 2 {
+		t.Fatalf("Collected %d shares (result reports %d), want 2", session.Collected(), result.SharesCollected)
+	}
+
+	// The session survives being stored between attempts
+	encoded, err := json.Marshal(session)
+	if err != nil {
+		t.Fatalf("Marshal() error: %v", err)
+	}
+	var restored RecoverySession
+	if err := json.Unmarshal(encoded, &restored); err != nil {
+		t.Fatalf("Unmarshal() error: %v", err)
+	}
+
+	// The second attempt skips the servers that already answered and stops at the
+	// threshold
+	result = ResumeRecovery(&restored, serverInfos, "password", generated.AuthCodes, nil)
+	if result.Error != "" {
+		t.Fatalf("Resumed recovery failed: %s", result.Error)
+	}
+	if !bytes.Equal(result.EncryptionKey, generated.EncryptionKey) {
+		t.Error("Recovered key does not match")
+	}
+	if len(result.ServerResults) != 3 {
+		t.Errorf("Resumed recovery reports %d servers, want the 3 not in the session", len(result.ServerResults))
+	}
+	for i, want := range []int{1, 1, 1, 0, 0} {
+		if got := servers[i].NumGuesses(identity.UID, identity.DID, identity.BID); got != want {
+			t.Errorf("Server %d spent %d guesses, want %d", i, got, want)
+		}
+	}
+}
+
+func TestResumeRecoveryInvalidSession(t *testing.T) {
+	identity := &Identity{UID: "user", DID: "phone", BID: "resumed"}
+	share := SessionShare{URL: "https://a.example", X: 1, Point: []byte("not a point")}
+	tests := []struct {
+		name    string
+		session *RecoverySession
+	}{
+		{"nil", nil},
+		{"zero threshold", &RecoverySession{Identity: *identity}},
+		{"bad point", &RecoverySession{Identity: *identity, Threshold: 2, Shares: []SessionShare{share}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := ResumeRecovery(tt.session, nil, "password", &AuthCodes{}, nil)
+			if result.ErrCode != ErrInvalidInput {
+				t.Errorf("ErrCode = %q (%s), want %q", result.ErrCode, result.Error, ErrInvalidInput)
+			}
+		})
+	}
+}
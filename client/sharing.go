@@ -12,7 +12,9 @@ import (
 	"crypto/rand"
 	"errors"
 	"fmt"
+	"io"
 	"math/big"
+	"sync"
 
 	"github.com/openadp/ocrypt/common"
 	"github.com/openadp/ocrypt/debug"
@@ -21,14 +23,105 @@ import (
 // Share represents a (x, y) coordinate pair
 type Share struct {
 	X, Y *big.Int
+
+	// Threshold is the number of shares needed to recombine the secret. It is set
+	// by SplitSecret and checked by RecombineSecret; zero means unknown.
+	Threshold int
 }
 
+// MaxSplitSecretSize is the largest secret, in bytes, accepted by SplitSecret. The
+// secret is encoded as a single element of the field of order common.Q (about
+// 2^252) together with a marker byte that preserves leading zeros.
+const MaxSplitSecretSize = 31
+
 // PointShare represents a (x, point) pair
 type PointShare struct {
 	X     *big.Int
 	Point *common.Point2D
 }
 
+// SplitSecret splits secret into the given number of shares, any threshold of which
+// recombine it with RecombineSecret. It uses the same Shamir scheme over the field of
+// order common.Q as OpenADP key generation, with fresh randomness from crypto/rand.
+// The secret may be empty and at most MaxSplitSecretSize bytes long; split secrets
+// of up to MaxChunkedSecretSize bytes with SplitLargeSecret, and anything larger
+// by encrypting it under a random key and splitting that key.
+func SplitSecret(secret []byte, threshold, shares int) ([]Share, error) {
+	if len(secret) > MaxSplitSecretSize {
+		return nil, fmt.Errorf("secret is %d bytes, at most %d are supported", len(secret), MaxSplitSecretSize)
+	}
+	if threshold < 1 {
+		return nil, fmt.Errorf("threshold must be at least 1, got %d", threshold)
+	}
+	if shares < threshold {
+		return nil, fmt.Errorf("shares (%d) must be at least threshold (%d)", shares, threshold)
+	}
+
+	// Prefix a marker byte so that leading zeros of the secret survive the round
+	// trip through big.Int
+	encoded := append([]byte{1}, secret...)
+	value := new(big.Int).SetBytes(encoded)
+	defer zeroize(encoded)
+	defer zeroizeInt(value)
+
+	points, err := MakeRandomShares(value, threshold, shares)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]Share, len(points))
+	for i, point := range points {
+		result[i] = Share{X: point.X, Y: point.Y, Threshold: threshold}
+	}
+	return result, nil
+}
+
+// RecombineSecret recombines a secret split with SplitSecret. It fails if fewer
+// than threshold shares are given, if shares come from different splits or repeat
+// an index, or if the result is not a valid encoding of a secret.
+func RecombineSecret(shares []Share) ([]byte, error) {
+	if len(shares) == 0 {
+		return nil, errors.New("no shares provided")
+	}
+
+	threshold := shares[0].Threshold
+	points := make([]*Share, len(shares))
+	for i := range shares {
+		share := &shares[i]
+		if share.X == nil || share.Y == nil {
+			return nil, fmt.Errorf("share %d is incomplete", i)
+		}
+		if share.Threshold != threshold {
+			return nil, errors.New("shares have different thresholds")
+		}
+		for _, seen := range points[:i] {
+			if seen.X.Cmp(share.X) == 0 {
+				return nil, fmt.Errorf("duplicate share index %s", share.X)
+			}
+		}
+		points[i] = share
+	}
+	if threshold < 1 {
+		return nil, errors.New("shares do not record a threshold")
+	}
+	if len(shares) < threshold {
+		return nil, fmt.Errorf("need %d shares, got %d", threshold, len(shares))
+	}
+
+	value, err := RecoverSecret(points)
+	if err != nil {
+		return nil, err
+	}
+	defer zeroizeInt(value)
+
+	encoded := value.Bytes()
+	defer zeroize(encoded)
+	if len(encoded) == 0 || len(encoded) > MaxSplitSecretSize+1 || encoded[0] != 1 {
+		return nil, errors.New("shares do not recombine to a valid secret")
+	}
+	return append([]byte{}, encoded[1:]...), nil
+}
+
 // evalAt evaluates polynomial (coefficient slice) at x
 //
 // Used to generate Shamir shares in MakeRandomShares below.
@@ -52,12 +145,15 @@ func evalAt(poly []*big.Int, x *big.Int, prime *big.Int) *big.Int {
 
 // MakeRandomShares generates random Shamir shares for a given secret
 func MakeRandomShares(secret *big.Int, minimum, shares int) ([]*Share, error) {
+	return makeRandomShares(rand.Reader, secret, minimum, shares)
+}
+
+// makeRandomShares generates Shamir shares using random as the coefficient source
+func makeRandomShares(random io.Reader, secret *big.Int, minimum, shares int) ([]*Share, error) {
 	if minimum > shares {
 		return nil, errors.New("pool secret would be irrecoverable")
 	}
 
-	prime := common.Q
-
 	// Handle edge case where minimum = 0
 	if minimum == 0 {
 		// With threshold 0, any single share contains the secret
@@ -74,6 +170,18 @@ func MakeRandomShares(secret *big.Int, minimum, shares int) ([]*Share, error) {
 		return points, nil
 	}
 
+	poly, err := randomPolynomial(random, secret, minimum)
+	if err != nil {
+		return nil, err
+	}
+	return sharesFromPolynomial(poly, shares), nil
+}
+
+// randomPolynomial returns the coefficients of a random polynomial of degree
+// minimum-1 over Z_q whose constant term is secret
+func randomPolynomial(random io.Reader, secret *big.Int, minimum int) ([]*big.Int, error) {
+	prime := common.Q
+
 	// Create random polynomial with secret as constant term
 	poly := make([]*big.Int, minimum)
 	poly[0] = new(big.Int).Set(secret)
@@ -89,7 +197,7 @@ func MakeRandomShares(secret *big.Int, minimum, shares int) ([]*Share, error) {
 			debug.DebugLog(fmt.Sprintf("Using deterministic polynomial coefficient: %d", i))
 		} else {
 			// In normal mode, use cryptographically secure random
-			coeff, err = rand.Int(rand.Reader, prime)
+			coeff, err = rand.Int(random, prime)
 			if err != nil {
 				return nil, err
 			}
@@ -98,6 +206,13 @@ func MakeRandomShares(secret *big.Int, minimum, shares int) ([]*Share, error) {
 		poly[i] = coeff
 	}
 
+	return poly, nil
+}
+
+// sharesFromPolynomial evaluates poly at x = 1, 2, ..., shares
+func sharesFromPolynomial(poly []*big.Int, shares int) []*Share {
+	prime := common.Q
+
 	// Generate share points by evaluating polynomial at x = 1, 2, ..., shares
 	points := make([]*Share, shares)
 	for i := 0; i < shares; i++ {
@@ -109,7 +224,7 @@ func MakeRandomShares(secret *big.Int, minimum, shares int) ([]*Share, error) {
 		}
 	}
 
-	return points, nil
+	return points
 }
 
 // RecoverSB recovers s*B from threshold number of shares s[i]*B using Lagrange interpolation
@@ -181,50 +296,125 @@ func RecoverSecret(shares []*Share) (*big.Int, error) {
 		return nil, errors.New("no shares provided")
 	}
 
-	prime := common.Q
+	scratch := lagrangePool.Get().(*lagrangeScratch)
+	defer lagrangePool.Put(scratch)
+	defer scratch.zeroize()
 
-	// Compute Lagrange interpolation weights w[i]
-	weights := make([]*big.Int, len(shares))
+	weights, err := scratch.weights(len(shares), func(i int) *big.Int { return shares[i].X })
+	if err != nil {
+		return nil, err
+	}
 
-	for j, shareJ := range shares {
-		numerator := big.NewInt(1)
-		denominator := big.NewInt(1)
+	// Compute weighted sum: secret = sum(w[i] * y[i]) % prime
+	secret := big.NewInt(0)
+	for i, share := range shares {
+		scratch.mulMod(&scratch.term, &weights[i], share.Y)
+		secret.Add(secret, &scratch.term)
+		scratch.reduce(secret)
+	}
 
-		for m, shareM := range shares {
-			if j != m {
-				// numerator = numerator * x[m] % prime
-				numerator.Mul(numerator, shareM.X)
-				numerator.Mod(numerator, prime)
+	return secret, nil
+}
 
-				// denominator = denominator * (x[m] - x[j]) % prime
-				diff := new(big.Int).Sub(shareM.X, shareJ.X)
-				diff.Mod(diff, prime)
-				denominator.Mul(denominator, diff)
-				denominator.Mod(denominator, prime)
+// lagrangePool recycles the scratch buffers of RecoverSecret, so that repeated
+// reconstructions do not allocate fresh big.Ints for every intermediate value
+var lagrangePool = sync.Pool{New: func() any { return new(lagrangeScratch) }}
+
+// lagrangeScratch holds the intermediate values of a Lagrange interpolation at
+// zero over the field of order common.Q
+type lagrangeScratch struct {
+	weight      []big.Int // Lagrange weights, valid after weights
+	denominator []big.Int
+	prefix      []big.Int // Running products of the denominators
+	diff        big.Int
+	term        big.Int
+	product     big.Int
+	quotient    big.Int
+}
+
+// weights returns the Lagrange weights w[j] = product(m != j, x[m]/(x[m] - x[j]))
+// of the n points x(0), ..., x(n-1). The denominators are inverted together with a
+// single modular inverse (Montgomery's trick). The result is owned by s.
+func (s *lagrangeScratch) weights(n int, x func(i int) *big.Int) ([]big.Int, error) {
+	if cap(s.weight) < n {
+		s.weight = make([]big.Int, n)
+		s.denominator = make([]big.Int, n)
+		s.prefix = make([]big.Int, n)
+	}
+	numerators, denominators, prefix := s.weight[:n], s.denominator[:n], s.prefix[:n]
+
+	// prefix[j] is the product of denominators 0 through j
+	for j := 0; j < n; j++ {
+		numerators[j].SetInt64(1)
+		denominators[j].SetInt64(1)
+		for m := 0; m < n; m++ {
+			if m == j {
+				continue
 			}
+			s.mulMod(&numerators[j], &numerators[j], x(m))
+
+			s.diff.Sub(x(m), x(j))
+			s.reduce(&s.diff)
+			s.mulMod(&denominators[j], &denominators[j], &s.diff)
 		}
 
-		// w[i] = numerator * denominator^(-1) % prime
-		denominatorInv := new(big.Int).ModInverse(denominator, prime)
-		if denominatorInv == nil {
-			return nil, errors.New("failed to compute modular inverse")
+		if j == 0 {
+			prefix[j].Set(&denominators[j])
+		} else {
+			s.mulMod(&prefix[j], &prefix[j-1], &denominators[j])
 		}
+	}
 
-		wi := new(big.Int).Mul(numerator, denominatorInv)
-		wi.Mod(wi, prime)
-		weights[j] = wi
+	// A zero denominator, from a repeated x, makes the whole product zero
+	var inverse big.Int
+	if inverse.ModInverse(&prefix[n-1], common.Q) == nil {
+		return nil, errors.New("failed to compute modular inverse")
 	}
 
-	// Compute weighted sum: secret = sum(w[i] * y[i]) % prime
-	secret := big.NewInt(0)
+	// Walk back through the prefixes: inverse inverts prefix[j], so inverse *
+	// prefix[j-1] inverts denominator j, and inverse * denominator j inverts
+	// prefix[j-1]
+	for j := n - 1; j > 0; j-- {
+		s.mulMod(&s.term, &inverse, &prefix[j-1])
+		s.mulMod(&numerators[j], &numerators[j], &s.term)
 
-	for i, share := range shares {
-		term := new(big.Int).Mul(weights[i], share.Y)
-		secret.Add(secret, term)
-		secret.Mod(secret, prime)
+		s.mulMod(&inverse, &inverse, &denominators[j])
 	}
+	s.mulMod(&numerators[0], &numerators[0], &inverse)
 
-	return secret, nil
+	return numerators, nil
+}
+
+// mulMod sets z to x * y mod common.Q. Multiplying into the scratch product
+// rather than in place lets big.Int reuse its buffers; z may alias x or y.
+func (s *lagrangeScratch) mulMod(z, x, y *big.Int) {
+	s.product.Mul(x, y)
+	s.quotient.QuoRem(&s.product, common.Q, z)
+	if z.Sign() < 0 {
+		z.Add(z, common.Q)
+	}
+}
+
+// reduce sets z to z mod common.Q in [0, Q), reusing the scratch quotient
+func (s *lagrangeScratch) reduce(z *big.Int) {
+	s.quotient.QuoRem(z, common.Q, z)
+	if z.Sign() < 0 {
+		z.Add(z, common.Q)
+	}
+}
+
+// zeroize clears the scratch values, which include products with the shares,
+// before the scratch is reused
+func (s *lagrangeScratch) zeroize() {
+	for i := range s.weight {
+		zeroizeInt(&s.weight[i])
+		zeroizeInt(&s.denominator[i])
+		zeroizeInt(&s.prefix[i])
+	}
+	zeroizeInt(&s.diff)
+	zeroizeInt(&s.term)
+	zeroizeInt(&s.product)
+	zeroizeInt(&s.quotient)
 }
 
 // RecoverPointSecret recovers s*B from threshold number of point shares using Lagrange interpolation
@@ -1,9 +1,13 @@
 package client
 
 import (
+	"bytes"
 	"crypto/rand"
+	"flag"
+	"fmt"
 	"math/big"
 	"testing"
+	"time"
 
 	"github.com/openadp/ocrypt/common"
 )
@@ -224,3 +228,262 @@ func TestOpenADPWorkflow(t *testing.T) {
 		t.Logf("Recovered key: %x", encKeyRecovered[:16])
 	}
 }
+
+func TestSplitRecombineSecret(t *testing.T) {
+	secrets := [][]byte{
+		{},
+		{0x00, 0x00, 0x07},
+		bytes.Repeat([]byte{0xff}, MaxSplitSecretSize),
+		[]byte("correct horse battery staple"),
+	}
+
+	for _, secret := range secrets {
+		shares, err := SplitSecret(secret, 3, 5)
+		if err != nil {
+			t.Fatalf("SplitSecret(%x) error: %v", secret, err)
+		}
+		if len(shares) != 5 {
+			t.Fatalf("SplitSecret() returned %d shares, want 5", len(shares))
+		}
+
+		// Every 3-of-5 subset recombines to the same secret
+		for a := 0; a < 5; a++ {
+			for b := a + 1; b < 5; b++ {
+				for c := b + 1; c < 5; c++ {
+					got, err := RecombineSecret([]Share{shares[a], shares[b], shares[c]})
+					if err != nil {
+						t.Fatalf("RecombineSecret(%d,%d,%d) error: %v", a, b, c, err)
+					}
+					if !bytes.Equal(got, secret) {
+						t.Errorf("RecombineSecret(%d,%d,%d) = %x, want %x", a, b, c, got, secret)
+					}
+				}
+			}
+		}
+
+		// More shares than the threshold also work
+		if got, err := RecombineSecret(shares); err != nil || !bytes.Equal(got, secret) {
+			t.Errorf("RecombineSecret(all) = %x, %v, want %x", got, err, secret)
+		}
+	}
+}
+
+func TestRecombineSecretErrors(t *testing.T) {
+	shares, err := SplitSecret([]byte("secret"), 3, 5)
+	if err != nil {
+		t.Fatalf("SplitSecret() error: %v", err)
+	}
+	other, err := SplitSecret([]byte("secret"), 2, 5)
+	if err != nil {
+		t.Fatalf("SplitSecret() error: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		shares []Share
+	}{
+		{"no shares", nil},
+		{"below threshold", shares[:2]},
+		{"duplicate index", []Share{shares[0], shares[1], shares[1]}},
+		{"mixed splits", []Share{shares[0], shares[1], other[2]}},
+		{"no threshold", []Share{{X: shares[0].X, Y: shares[0].Y}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := RecombineSecret(tt.shares); err == nil {
+				t.Error("RecombineSecret() expected error")
+			}
+		})
+	}
+}
+
+func TestSplitSecretErrors(t *testing.T) {
+	tests := []struct {
+		name              string
+		secret            []byte
+		threshold, shares int
+	}{
+		{"too long", make([]byte, MaxSplitSecretSize+1), 2, 3},
+		{"zero threshold", []byte("s"), 0, 3},
+		{"threshold above shares", []byte("s"), 4, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := SplitSecret(tt.secret, tt.threshold, tt.shares); err == nil {
+				t.Error("SplitSecret() expected error")
+			}
+		})
+	}
+}
+
+// naiveRecoverSecret is the textbook Lagrange interpolation at zero that
+// RecoverSecret optimizes, with one modular inverse per weight
+func naiveRecoverSecret(shares []*Share) *big.Int {
+	secret := big.NewInt(0)
+	for j := range shares {
+		numerator, denominator := big.NewInt(1), big.NewInt(1)
+		for m := range shares {
+			if m != j {
+				numerator.Mod(numerator.Mul(numerator, shares[m].X), common.Q)
+				diff := new(big.Int).Sub(shares[m].X, shares[j].X)
+				denominator.Mod(denominator.Mul(denominator, diff.Mod(diff, common.Q)), common.Q)
+			}
+		}
+		weight := new(big.Int).Mul(numerator, new(big.Int).ModInverse(denominator, common.Q))
+		secret.Mod(secret.Add(secret, weight.Mul(weight.Mod(weight, common.Q), shares[j].Y)), common.Q)
+	}
+	return secret
+}
+
+func TestRecoverSecretMatchesNaive(t *testing.T) {
+	for threshold := 1; threshold <= 15; threshold++ {
+		for trial := 0; trial < 5; trial++ {
+			secret, err := rand.Int(rand.Reader, common.Q)
+			if err != nil {
+				t.Fatalf("rand.Int() error: %v", err)
+			}
+			shares, err := MakeRandomShares(secret, threshold, 15)
+			if err != nil {
+				t.Fatalf("MakeRandomShares() error: %v", err)
+			}
+
+			// Any threshold shares, in any order, and more than threshold shares work
+			for _, subset := range [][]*Share{shares[:threshold], shares[15-threshold:], shares} {
+				got, err := RecoverSecret(subset)
+				if err != nil {
+					t.Fatalf("%d-of-15: RecoverSecret() error: %v", threshold, err)
+				}
+				if got.Cmp(secret) != 0 {
+					t.Errorf("%d-of-15: RecoverSecret() = %x, want %x", threshold, got, secret)
+				}
+				if want := naiveRecoverSecret(subset); !bytes.Equal(got.Bytes(), want.Bytes()) {
+					t.Errorf("%d-of-15: RecoverSecret() = %x, the naive interpolation %x", threshold, got, want)
+				}
+			}
+		}
+	}
+
+	// Out-of-range and negative inputs are reduced exactly as before
+	odd := []*Share{
+		{X: big.NewInt(-3), Y: new(big.Int).Add(common.Q, big.NewInt(7))},
+		{X: new(big.Int).Add(common.Q, big.NewInt(2)), Y: big.NewInt(-11)},
+		{X: big.NewInt(5), Y: big.NewInt(13)},
+	}
+	got, err := RecoverSecret(odd)
+	if err != nil {
+		t.Fatalf("RecoverSecret() error: %v", err)
+	}
+	if want := naiveRecoverSecret(odd); got.Cmp(want) != 0 {
+		t.Errorf("RecoverSecret() = %x, the naive interpolation %x", got, want)
+	}
+
+	// A repeated index has no inverse
+	if _, err := RecoverSecret([]*Share{odd[2], odd[2]}); err == nil {
+		t.Error("RecoverSecret() accepted a repeated index")
+	}
+}
+
+// reconstructionBudget bounds the time TestReconstructionBudget allows a 9-of-15
+// reconstruction. The check depends on the speed of the machine, so it only runs
+// when a budget is given, for example:
+// go test ./client -run TestReconstructionBudget -args -reconstruction-budget=50ms
+var reconstructionBudget = flag.Duration("reconstruction-budget", 0, "maximum time per 9-of-15 reconstruction; zero skips the check")
+
+// benchmarkThresholds are the t-of-n configurations benchmarked, up to the 15
+// servers of a large deployment
+var benchmarkThresholds = []struct{ threshold, shares int }{{2, 3}, {3, 5}, {5, 9}, {9, 15}, {15, 15}}
+
+// benchmarkPointShares returns threshold of shares point shares of a random secret
+func benchmarkPointShares(tb testing.TB, threshold, shares int) []*PointShare {
+	secret, err := rand.Int(rand.Reader, common.Q)
+	if err != nil {
+		tb.Fatalf("rand.Int() error: %v", err)
+	}
+	scalars, err := MakeRandomShares(secret, threshold, shares)
+	if err != nil {
+		tb.Fatalf("MakeRandomShares() error: %v", err)
+	}
+	points := make([]*PointShare, threshold)
+	for i := range points {
+		points[i] = &PointShare{X: scalars[i].X, Point: common.Unexpand(common.PointMul(scalars[i].Y, common.G))}
+	}
+	return points
+}
+
+func BenchmarkRecombineSecret(b *testing.B) {
+	for _, tc := range benchmarkThresholds {
+		b.Run(fmt.Sprintf("%d-of-%d", tc.threshold, tc.shares), func(b *testing.B) {
+			shares, err := SplitSecret(bytes.Repeat([]byte{0xa5}, MaxSplitSecretSize), tc.threshold, tc.shares)
+			if err != nil {
+				b.Fatalf("SplitSecret() error: %v", err)
+			}
+			shares = shares[:tc.threshold]
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				if _, err := RecombineSecret(shares); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkRecoverSB(b *testing.B) {
+	for _, tc := range benchmarkThresholds {
+		b.Run(fmt.Sprintf("%d-of-%d", tc.threshold, tc.shares), func(b *testing.B) {
+			shares := benchmarkPointShares(b, tc.threshold, tc.shares)
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				if _, err := RecoverSB(shares); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// TestReconstructionBudget fails if reconstructing a 9-of-15 secret, from scalar
+// or from point shares as recovery does, takes longer than reconstructionBudget.
+// It is skipped unless -reconstruction-budget is set.
+func TestReconstructionBudget(t *testing.T) {
+	if *reconstructionBudget <= 0 {
+		t.Skip("set -reconstruction-budget to check reconstruction time")
+	}
+
+	scalars, err := SplitSecret(bytes.Repeat([]byte{0xa5}, MaxSplitSecretSize), 9, 15)
+	if err != nil {
+		t.Fatalf("SplitSecret() error: %v", err)
+	}
+	points := benchmarkPointShares(t, 9, 15)
+
+	for _, tc := range []struct {
+		name        string
+		reconstruct func() error
+	}{
+		{"RecombineSecret", func() error { _, err := RecombineSecret(scalars[:9]); return err }},
+		{"RecoverSB", func() error { _, err := RecoverSB(points); return err }},
+	} {
+		result := testing.Benchmark(func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := tc.reconstruct(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		if result.N == 0 {
+			t.Fatalf("%s: benchmark failed", tc.name)
+		}
+		perOp := time.Duration(result.NsPerOp())
+		t.Logf("%s 9-of-15: %v, %d allocs, %d bytes per reconstruction", tc.name, perOp, result.AllocsPerOp(), result.AllocedBytesPerOp())
+		if perOp > *reconstructionBudget {
+			t.Errorf("%s 9-of-15 takes %v, over the budget of %v", tc.name, perOp, *reconstructionBudget)
+		}
+	}
+}
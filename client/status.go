@@ -0,0 +1,134 @@
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ServerBackupStatus is the guess counter reported by a single server for a backup
+type ServerBackupStatus struct {
+	URL        string
+	Found      bool // The server holds a share for the backup
+	NumGuesses int  // Guesses already consumed on this server
+	MaxGuesses int  // Guess budget configured at registration
+	Remaining  int  // MaxGuesses - NumGuesses, never negative
+	Expiration int  // Unix time the backup expires at, zero if it never does
+	Error      string
+}
+
+// BackupStatus aggregates the guess counters reported by all servers for a backup.
+//
+// Servers update their counters independently, so they can disagree (for example
+// after a recovery attempt that only reached some of them). Rather than picking one
+// value, the spread is reported through the Min/Max fields and Consistent.
+type BackupStatus struct {
+	Servers       []ServerBackupStatus // One entry per requested server, in input order
+	Responding    int                  // Number of servers that reported the backup
+	MinRemaining  int                  // Fewest remaining guesses on any responding server
+	MaxRemaining  int                  // Most remaining guesses on any responding server
+	MinNumGuesses int                  // Lowest guess counter among responding servers
+	MaxNumGuesses int                  // Highest guess counter among responding servers
+	Consistent    bool                 // All responding servers agree on NumGuesses and MaxGuesses
+}
+
+// QueryBackupStatus reads the current guess counter and guess budget for the backup
+// identified by (UID, DID, BID) from each server, without consuming a guess.
+//
+// This lets applications warn a user how many attempts are left before the backup
+// locks. If authCodes is non-nil, only servers that have an auth code are treated as
+// holding a share; the others are reported with an error. An error is returned if the
+// inputs are invalid or no server reports the backup.
+func QueryBackupStatus(identity *Identity, serverInfos []ServerInfo, authCodes *AuthCodes) (*BackupStatus, error) {
+	return queryBackupStatus(identity, serverInfos, authCodes, nil)
+}
+
+// queryBackupStatus implements QueryBackupStatus, creating clients according to opts
+func queryBackupStatus(identity *Identity, serverInfos []ServerInfo, authCodes *AuthCodes, opts *Options) (*BackupStatus, error) {
+	if err := identity.Validate(); err != nil {
+		return nil, err
+	}
+	if len(serverInfos) == 0 {
+		return nil, errors.New("no OpenADP servers available")
+	}
+
+	servers := make([]ServerBackupStatus, len(serverInfos))
+
+	forEachServer(len(serverInfos), len(serverInfos), func(i int) {
+		serverInfo := serverInfos[i]
+		servers[i] = ServerBackupStatus{URL: serverInfo.URL}
+
+		if authCodes != nil {
+			if _, ok := authCodes.ForServer(serverInfo.URL); !ok {
+				servers[i].Error = "no auth code for server"
+				return
+			}
+		}
+
+		publicKey, err := decodeServerPublicKey(serverInfo.PublicKey)
+		if err != nil {
+			opts.logger().Warn("invalid server public key, querying unencrypted", "server", serverInfo.URL, "error", err.Error())
+			publicKey = nil
+		}
+
+		client := opts.newClient(context.Background(), serverInfo.URL, publicKey)
+		backups, err := client.listBackups(identity.UID, false, nil)
+		if err != nil {
+			servers[i].Error = err.Error()
+			return
+		}
+
+		// Find our backup in the list using the complete primary key (UID, DID, BID)
+		for _, backup := range backups {
+			if backup.UID == identity.UID && backup.DID == identity.DID && backup.BID == identity.BID {
+				servers[i].Found = true
+				servers[i].NumGuesses = backup.NumGuesses
+				servers[i].MaxGuesses = backup.MaxGuesses
+				servers[i].Remaining = max(0, backup.MaxGuesses-backup.NumGuesses)
+				servers[i].Expiration = backup.Expiration
+				return
+			}
+		}
+		servers[i].Error = "backup not found"
+	})
+
+	status := &BackupStatus{Servers: servers, Consistent: true}
+	var first *ServerBackupStatus
+	for i := range servers {
+		server := &servers[i]
+		if !server.Found {
+			continue
+		}
+
+		if first == nil {
+			first = server
+			status.MinRemaining, status.MaxRemaining = server.Remaining, server.Remaining
+			status.MinNumGuesses, status.MaxNumGuesses = server.NumGuesses, server.NumGuesses
+		} else if server.NumGuesses != first.NumGuesses || server.MaxGuesses != first.MaxGuesses {
+			status.Consistent = false
+		}
+
+		status.MinRemaining = min(status.MinRemaining, server.Remaining)
+		status.MaxRemaining = max(status.MaxRemaining, server.Remaining)
+		status.MinNumGuesses = min(status.MinNumGuesses, server.NumGuesses)
+		status.MaxNumGuesses = max(status.MaxNumGuesses, server.NumGuesses)
+		status.Responding++
+	}
+
+	if status.Responding == 0 {
+		return status, fmt.Errorf("backup %s not found on any of %d servers", identity.String(), len(serverInfos))
+	}
+
+	return status, nil
+}
+
+// decodeServerPublicKey decodes a server public key as published in servers.json,
+// accepting an optional "ed25519:" prefix. An empty string yields a nil key.
+func decodeServerPublicKey(publicKey string) ([]byte, error) {
+	if publicKey == "" {
+		return nil, nil
+	}
+	return base64.StdEncoding.DecodeString(strings.TrimPrefix(publicKey, "ed25519:"))
+}
@@ -0,0 +1,102 @@
+package client
+
+import (
+	"net/http"
+	"testing"
+)
+
+// newMockStatusServer starts a server whose ListBackups reports the given guess
+// counter for the identity. A negative numGuesses makes the server report no backups.
+func newMockStatusServer(t *testing.T, identity *Identity, numGuesses, maxGuesses int) string {
+	return newMockRPCServer(t, func(method string, params []interface{}) (interface{}, error) {
+		if method != "ListBackups" {
+			return nil, httpStatusError(http.StatusNotFound)
+		}
+		if numGuesses < 0 {
+			return []interface{}{}, nil
+		}
+		return []interface{}{
+			map[string]interface{}{"uid": identity.UID, "did": "other-app", "bid": identity.BID, "num_guesses": 9, "max_guesses": 10},
+			map[string]interface{}{"uid": identity.UID, "did": identity.DID, "bid": identity.BID, "num_guesses": numGuesses, "max_guesses": maxGuesses},
+		}, nil
+	}).URL
+}
+
+func TestQueryBackupStatus(t *testing.T) {
+	identity := &Identity{UID: "user", DID: "app", BID: "even"}
+
+	t.Run("servers agree", func(t *testing.T) {
+		urls := []string{
+			newMockStatusServer(t, identity, 2, 10),
+			newMockStatusServer(t, identity, 2, 10),
+		}
+
+		status, err := QueryBackupStatus(identity, insecureServerInfos(t, urls), nil)
+		if err != nil {
+			t.Fatalf("QueryBackupStatus() unexpected error: %v", err)
+		}
+		if status.Responding != 2 || !status.Consistent {
+			t.Errorf("Responding = %d, Consistent = %v, want 2, true", status.Responding, status.Consistent)
+		}
+		if status.MinRemaining != 8 || status.MaxRemaining != 8 {
+			t.Errorf("Remaining = [%d, %d], want [8, 8]", status.MinRemaining, status.MaxRemaining)
+		}
+	})
+
+	t.Run("servers disagree", func(t *testing.T) {
+		urls := []string{
+			newMockStatusServer(t, identity, 1, 5),
+			newMockStatusServer(t, identity, 3, 5),
+			newMockStatusServer(t, identity, -1, 0),
+		}
+
+		status, err := QueryBackupStatus(identity, insecureServerInfos(t, urls), nil)
+		if err != nil {
+			t.Fatalf("QueryBackupStatus() unexpected error: %v", err)
+		}
+		if status.Responding != 2 || status.Consistent {
+			t.Errorf("Responding = %d, Consistent = %v, want 2, false", status.Responding, status.Consistent)
+		}
+		if status.MinRemaining != 2 || status.MaxRemaining != 4 {
+			t.Errorf("Remaining = [%d, %d], want [2, 4]", status.MinRemaining, status.MaxRemaining)
+		}
+		if status.MinNumGuesses != 1 || status.MaxNumGuesses != 3 {
+			t.Errorf("NumGuesses = [%d, %d], want [1, 3]", status.MinNumGuesses, status.MaxNumGuesses)
+		}
+		if status.Servers[2].Found || status.Servers[2].Error == "" {
+			t.Errorf("Servers[2] = %+v, want not found with error", status.Servers[2])
+		}
+	})
+
+	t.Run("servers without auth code are skipped", func(t *testing.T) {
+		urls := []string{
+			newMockStatusServer(t, identity, 0, 10),
+			newMockStatusServer(t, identity, 4, 10),
+		}
+		authCodes := &AuthCodes{ServerAuthCodes: map[string]string{urls[0]: "code"}}
+
+		status, err := QueryBackupStatus(identity, insecureServerInfos(t, urls), authCodes)
+		if err != nil {
+			t.Fatalf("QueryBackupStatus() unexpected error: %v", err)
+		}
+		if status.Responding != 1 || status.MinRemaining != 10 {
+			t.Errorf("Responding = %d, MinRemaining = %d, want 1, 10", status.Responding, status.MinRemaining)
+		}
+	})
+
+	t.Run("backup missing everywhere", func(t *testing.T) {
+		urls := []string{newMockStatusServer(t, identity, -1, 0)}
+		if _, err := QueryBackupStatus(identity, insecureServerInfos(t, urls), nil); err == nil {
+			t.Error("QueryBackupStatus() expected error but got none")
+		}
+	})
+
+	t.Run("invalid input", func(t *testing.T) {
+		if _, err := QueryBackupStatus(nil, insecureServerInfos(t, []string{"http://server1.com"}), nil); err == nil {
+			t.Error("QueryBackupStatus() expected error for nil identity")
+		}
+		if _, err := QueryBackupStatus(identity, nil, nil); err == nil {
+			t.Error("QueryBackupStatus() expected error for no servers")
+		}
+	})
+}
@@ -0,0 +1,185 @@
+package client
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// Stream format (version 1):
+//
+//	version (1 byte) || salt (32 bytes) || chunk_0 || ... || chunk_n
+//
+// The salt is random per stream. HKDF-SHA256(key, salt, "OpenADP-Stream-v1")
+// yields a 32-byte AES-256-GCM key and a 7-byte nonce prefix, so streams
+// encrypted under the same key never share a nonce. Every chunk but the last
+// holds streamChunkSize bytes of plaintext followed by a 16-byte tag; the last
+// chunk holds the remainder, possibly nothing. The nonce of chunk i is
+//
+//	prefix (7 bytes) || i (4 bytes, big-endian) || last (1 byte, 1 for the last chunk)
+//
+// so chunks cannot be reordered, and a stream cut at a chunk boundary fails to
+// authenticate because its final chunk was not sealed as the last one.
+const (
+	streamVersion    = 1
+	streamSaltSize   = 32
+	streamPrefixSize = 7
+	streamChunkSize  = 64 * 1024
+	streamTagSize    = 16
+	streamHeaderSize = 1 + streamSaltSize
+	streamLabel      = "OpenADP-Stream-v1"
+)
+
+// ErrCorruptStream is returned by DecryptStream when the ciphertext was modified,
+// truncated or extended, or was encrypted under a different key
+var ErrCorruptStream = errors.New("ciphertext is corrupted or truncated")
+
+// EncryptStream encrypts plaintext to ciphertext with chunked AES-256-GCM under key,
+// which must be 32 bytes, typically the EncryptionKey of a GenerateEncryptionKey
+// result. Memory use is bounded by the 64 KiB chunk size, whatever the stream length.
+func EncryptStream(key []byte, plaintext io.Reader, ciphertext io.Writer) error {
+	salt := make([]byte, streamSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return fmt.Errorf("failed to generate salt: %v", err)
+	}
+
+	aead, prefix, err := newStreamCipher(key, salt)
+	if err != nil {
+		return err
+	}
+
+	if _, err := ciphertext.Write(append([]byte{streamVersion}, salt...)); err != nil {
+		return err
+	}
+
+	reader := bufio.NewReaderSize(plaintext, streamChunkSize)
+	chunk := make([]byte, streamChunkSize, streamChunkSize+streamTagSize)
+	defer zeroize(chunk[:cap(chunk)])
+
+	for counter := uint64(0); ; counter++ {
+		n, err := io.ReadFull(reader, chunk)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+
+		// The chunk is the last one if the input ends here
+		last := n < streamChunkSize
+		if !last {
+			if _, peekErr := reader.Peek(1); peekErr == io.EOF {
+				last = true
+			} else if peekErr != nil {
+				return peekErr
+			}
+		}
+
+		if counter > math.MaxUint32 {
+			return errors.New("stream too long")
+		}
+		sealed := aead.Seal(chunk[:0], streamNonce(prefix, counter, last), chunk[:n], nil)
+		if _, err := ciphertext.Write(sealed); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+	}
+}
+
+// DecryptStream decrypts a stream produced by EncryptStream under the same key.
+//
+// Plaintext is written chunk by chunk as each chunk is authenticated, so on error
+// part of the plaintext may already have been written: callers must discard the
+// output unless DecryptStream returns nil. Corruption, truncation and trailing data
+// are reported as ErrCorruptStream.
+func DecryptStream(key []byte, ciphertext io.Reader, plaintext io.Writer) error {
+	header := make([]byte, streamHeaderSize)
+	if _, err := io.ReadFull(ciphertext, header); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return ErrCorruptStream
+		}
+		return err
+	}
+	if header[0] != streamVersion {
+		return fmt.Errorf("unsupported stream version %d", header[0])
+	}
+
+	aead, prefix, err := newStreamCipher(key, header[1:])
+	if err != nil {
+		return err
+	}
+
+	reader := bufio.NewReaderSize(ciphertext, streamChunkSize+streamTagSize)
+	chunk := make([]byte, streamChunkSize+streamTagSize)
+	defer zeroize(chunk)
+
+	for counter := uint64(0); counter <= math.MaxUint32; counter++ {
+		n, err := io.ReadFull(reader, chunk)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+
+		last := n < len(chunk)
+		if !last {
+			if _, peekErr := reader.Peek(1); peekErr == io.EOF {
+				last = true
+			} else if peekErr != nil {
+				return peekErr
+			}
+		}
+
+		opened, err := aead.Open(chunk[:0], streamNonce(prefix, counter, last), chunk[:n], nil)
+		if err != nil {
+			return ErrCorruptStream
+		}
+		if _, err := plaintext.Write(opened); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+	}
+	return ErrCorruptStream
+}
+
+// newStreamCipher derives the AES-256-GCM cipher and nonce prefix of a stream
+func newStreamCipher(key, salt []byte) (cipher.AEAD, []byte, error) {
+	if len(key) != 32 {
+		return nil, nil, fmt.Errorf("key must be 32 bytes, got %d", len(key))
+	}
+
+	derived := make([]byte, 32+streamPrefixSize)
+	defer zeroize(derived[:32])
+	if _, err := io.ReadFull(hkdf.New(sha256.New, key, salt, []byte(streamLabel)), derived); err != nil {
+		return nil, nil, err
+	}
+
+	block, err := aes.NewCipher(derived[:32])
+	if err != nil {
+		return nil, nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, nil, err
+	}
+	return aead, derived[32:], nil
+}
+
+// streamNonce builds the nonce of chunk counter
+func streamNonce(prefix []byte, counter uint64, last bool) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[streamPrefixSize:], uint32(counter))
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
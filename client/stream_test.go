@@ -0,0 +1,99 @@
+package client
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestStreamRoundTrip(t *testing.T) {
+	key := bytes.Repeat([]byte{0x42}, 32)
+
+	sizes := []int{0, 1, streamChunkSize - 1, streamChunkSize, streamChunkSize + 1, 3 * streamChunkSize, 3<<20 + 12345}
+	for _, size := range sizes {
+		plaintext := make([]byte, size)
+		io.ReadFull(seededReader(byte(size)), plaintext)
+
+		var ciphertext bytes.Buffer
+		if err := EncryptStream(key, bytes.NewReader(plaintext), &ciphertext); err != nil {
+			t.Fatalf("EncryptStream(%d bytes) error: %v", size, err)
+		}
+
+		// A stream that fills its last chunk exactly has no empty trailing chunk
+		chunks := max(1, (size+streamChunkSize-1)/streamChunkSize)
+		if want := streamHeaderSize + size + chunks*streamTagSize; ciphertext.Len() != want {
+			t.Errorf("EncryptStream(%d bytes) produced %d bytes, want %d", size, ciphertext.Len(), want)
+		}
+
+		var decrypted bytes.Buffer
+		if err := DecryptStream(key, &ciphertext, &decrypted); err != nil {
+			t.Fatalf("DecryptStream(%d bytes) error: %v", size, err)
+		}
+		if !bytes.Equal(decrypted.Bytes(), plaintext) {
+			t.Errorf("DecryptStream(%d bytes) did not round-trip", size)
+		}
+	}
+}
+
+func TestStreamNoncesDifferPerStream(t *testing.T) {
+	key := bytes.Repeat([]byte{0x42}, 32)
+	var first, second bytes.Buffer
+	EncryptStream(key, bytes.NewReader([]byte("same plaintext")), &first)
+	EncryptStream(key, bytes.NewReader([]byte("same plaintext")), &second)
+	if bytes.Equal(first.Bytes(), second.Bytes()) {
+		t.Error("Encrypting the same plaintext twice produced the same ciphertext")
+	}
+}
+
+func TestDecryptStreamRejectsTampering(t *testing.T) {
+	key := bytes.Repeat([]byte{0x42}, 32)
+	plaintext := make([]byte, 3*streamChunkSize+100)
+	io.ReadFull(seededReader(1), plaintext)
+
+	var buf bytes.Buffer
+	if err := EncryptStream(key, bytes.NewReader(plaintext), &buf); err != nil {
+		t.Fatalf("EncryptStream() error: %v", err)
+	}
+	ciphertext := buf.Bytes()
+	fullChunk := streamChunkSize + streamTagSize
+
+	flipped := append([]byte{}, ciphertext...)
+	flipped[streamHeaderSize+fullChunk+10] ^= 1
+
+	swapped := append([]byte{}, ciphertext[:streamHeaderSize]...)
+	swapped = append(swapped, ciphertext[streamHeaderSize+fullChunk:streamHeaderSize+2*fullChunk]...)
+	swapped = append(swapped, ciphertext[streamHeaderSize:streamHeaderSize+fullChunk]...)
+	swapped = append(swapped, ciphertext[streamHeaderSize+2*fullChunk:]...)
+
+	tests := []struct {
+		name       string
+		ciphertext []byte
+		key        []byte
+	}{
+		{"truncated at chunk boundary", ciphertext[:streamHeaderSize+2*fullChunk], key},
+		{"truncated mid chunk", ciphertext[:len(ciphertext)-50], key},
+		{"final chunk dropped", ciphertext[:len(ciphertext)-116], key},
+		{"header only", ciphertext[:streamHeaderSize], key},
+		{"truncated header", ciphertext[:10], key},
+		{"trailing data", append(append([]byte{}, ciphertext...), 0), key},
+		{"bit flip", flipped, key},
+		{"chunks reordered", swapped, key},
+		{"wrong key", ciphertext, bytes.Repeat([]byte{0x43}, 32)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := DecryptStream(tt.key, bytes.NewReader(tt.ciphertext), io.Discard)
+			if !errors.Is(err, ErrCorruptStream) {
+				t.Errorf("DecryptStream() error = %v, want ErrCorruptStream", err)
+			}
+		})
+	}
+}
+
+func TestStreamInvalidKey(t *testing.T) {
+	if err := EncryptStream(make([]byte, 16), bytes.NewReader(nil), io.Discard); err == nil {
+		t.Error("EncryptStream() with a 16-byte key expected error")
+	}
+}
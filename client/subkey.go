@@ -0,0 +1,99 @@
+package client
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// subkeySalt separates subkeys from the HKDF used to derive the master key itself
+var subkeySalt = []byte("OpenADP-Subkey-v1")
+
+// maxSubkeyLength is the most output HKDF-SHA256 can produce
+const maxSubkeyLength = 255 * sha256.Size
+
+// DeriveSubkey expands masterKey, typically the EncryptionKey returned by
+// GenerateEncryptionKey or RecoverEncryptionKey, into a length-byte subkey using
+// HKDF-SHA256 with info as the context string.
+//
+// Different info strings yield independent keys, so one master key can serve
+// several purposes, e.g. "file-encryption" and "filename-encryption". The info
+// string is part of the derivation: it must be identical every time the same
+// subkey is derived, so use fixed constants rather than anything that varies
+// between runs. It fails if length is not between 1 and 8160.
+func DeriveSubkey(masterKey []byte, info string, length int) ([]byte, error) {
+	if length <= 0 || length > maxSubkeyLength {
+		return nil, fmt.Errorf("subkey length must be between 1 and %d, got %d", maxSubkeyLength, length)
+	}
+	return expandKey(masterKey, subkeySalt, []byte(info), length), nil
+}
+
+// expandKey derives a length-byte key from masterKey with HKDF-SHA256. The
+// length must be at most maxSubkeyLength.
+func expandKey(masterKey, salt, info []byte, length int) []byte {
+	key := make([]byte, length)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, masterKey, salt, info), key); err != nil {
+		// Unreachable for lengths within the HKDF limit
+		panic(fmt.Sprintf("expandKey: %v", err))
+	}
+	return key
+}
+
+// keyHierarchySalt separates the keys of a KeyHierarchy from those of
+// DeriveSubkey under the same master key
+var keyHierarchySalt = []byte("OpenADP-KeyHierarchy-v1")
+
+// KeyHierarchyKeyLength is the length in bytes of the keys of a KeyHierarchy
+const KeyHierarchyKeyLength = 32
+
+// ErrKeyHierarchyZeroized is returned by KeyHierarchy.Child after Zeroize
+var ErrKeyHierarchyZeroized = errors.New("key hierarchy used after Zeroize")
+
+// KeyHierarchy derives a tree of independent keys from one master key, so that
+// a single recovery, which costs a guess, serves every purpose of an app. Keys
+// are named by slash-separated paths such as "files/2024" or "metadata", and
+// Child derives each with HKDF-SHA256, using the path as the info string under
+// a salt of its own, so no key reveals anything about the master key, its
+// siblings or any other path.
+//
+// Paths are part of the derivation: the same path always yields the same key,
+// and any change to it, even in case or a trailing slash, yields an unrelated
+// key. Use fixed paths, and never rename one that protects stored data; add a
+// new path and re-encrypt instead.
+type KeyHierarchy struct {
+	master []byte
+}
+
+// NewKeyHierarchy returns the hierarchy under masterKey, typically the
+// EncryptionKey of a recovery. It keeps a copy of the key; call Zeroize when
+// done.
+func NewKeyHierarchy(masterKey []byte) *KeyHierarchy {
+	return &KeyHierarchy{master: append([]byte(nil), masterKey...)}
+}
+
+// Child returns the KeyHierarchyKeyLength-byte key at path. It fails if path
+// is empty or has an empty segment, as in "/files", "files/" or "files//2024",
+// since such paths are easily produced by mistake, and with
+// ErrKeyHierarchyZeroized if the hierarchy was zeroized.
+func (h *KeyHierarchy) Child(path string) ([]byte, error) {
+	if h.master == nil {
+		return nil, ErrKeyHierarchyZeroized
+	}
+	for _, segment := range strings.Split(path, "/") {
+		if segment == "" {
+			return nil, fmt.Errorf("invalid key hierarchy path %q: empty segment", path)
+		}
+	}
+	return expandKey(h.master, keyHierarchySalt, []byte(path), KeyHierarchyKeyLength), nil
+}
+
+// Zeroize overwrites the master key with zeros and discards it. Zeroing is
+// best-effort; see the package notes in zeroize.go.
+func (h *KeyHierarchy) Zeroize() {
+	zeroize(h.master)
+	h.master = nil
+}
@@ -0,0 +1,131 @@
+package client
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func TestDeriveSubkey(t *testing.T) {
+	masterKey := bytes.Repeat([]byte{0x42}, 32)
+	derive := func(masterKey []byte, info string, length int) []byte {
+		t.Helper()
+		subkey, err := DeriveSubkey(masterKey, info, length)
+		if err != nil {
+			t.Fatalf("DeriveSubkey() error: %v", err)
+		}
+		return subkey
+	}
+
+	fileKey := derive(masterKey, "file-encryption", 32)
+	nameKey := derive(masterKey, "filename-encryption", 32)
+
+	if len(fileKey) != 32 || len(nameKey) != 32 {
+		t.Fatalf("DeriveSubkey() lengths = %d, %d, want 32", len(fileKey), len(nameKey))
+	}
+	if bytes.Equal(fileKey, nameKey) {
+		t.Error("Different info strings produced the same subkey")
+	}
+	if !bytes.Equal(fileKey, derive(masterKey, "file-encryption", 32)) {
+		t.Error("Same info string produced different subkeys")
+	}
+	if bytes.Equal(fileKey, derive(bytes.Repeat([]byte{0x43}, 32), "file-encryption", 32)) {
+		t.Error("Different master keys produced the same subkey")
+	}
+
+	// A shorter subkey is a prefix of a longer one with the same info
+	if long := derive(masterKey, "file-encryption", 64); !bytes.Equal(long[:32], fileKey) {
+		t.Error("64-byte subkey does not extend the 32-byte subkey")
+	}
+
+	// Pin the output (RFC 5869 HKDF-SHA256, salt "OpenADP-Subkey-v1") so the
+	// derivation cannot change silently
+	const want = "00b591b0b2665b852f07018850d5be22bbf3a0330f52c9ac5a5bb1a4b9c2bb79"
+	if got := hex.EncodeToString(fileKey); got != want {
+		t.Errorf("DeriveSubkey() = %s, want %s", got, want)
+	}
+}
+
+func TestDeriveSubkeyInvalidLength(t *testing.T) {
+	for _, length := range []int{0, -1, maxSubkeyLength + 1} {
+		if subkey, err := DeriveSubkey([]byte("key"), "info", length); err == nil {
+			t.Errorf("DeriveSubkey(length=%d) = %x, want an error", length, subkey)
+		}
+	}
+}
+
+func TestKeyHierarchy(t *testing.T) {
+	masterKey := bytes.Repeat([]byte{0x42}, 32)
+	hierarchy := NewKeyHierarchy(masterKey)
+	child := func(hierarchy *KeyHierarchy, path string) []byte {
+		t.Helper()
+		key, err := hierarchy.Child(path)
+		if err != nil {
+			t.Fatalf("Child(%q) error: %v", path, err)
+		}
+		return key
+	}
+
+	// The same path always yields the same key, also from another hierarchy
+	files := child(hierarchy, "files/2024")
+	if len(files) != KeyHierarchyKeyLength {
+		t.Fatalf("Child() length = %d, want %d", len(files), KeyHierarchyKeyLength)
+	}
+	if !bytes.Equal(files, child(hierarchy, "files/2024")) || !bytes.Equal(files, child(NewKeyHierarchy(masterKey), "files/2024")) {
+		t.Error("Same path produced different keys")
+	}
+
+	// Every other path, and every other master key, yields a different key
+	seen := map[string]string{hex.EncodeToString(files): "files/2024"}
+	for _, path := range []string{"files/2025", "files", "Files/2024", "files/2024/x", "metadata"} {
+		key := hex.EncodeToString(child(hierarchy, path))
+		if other, ok := seen[key]; ok {
+			t.Errorf("Paths %q and %q produced the same key", path, other)
+		}
+		seen[key] = path
+	}
+	if bytes.Equal(files, child(NewKeyHierarchy(bytes.Repeat([]byte{0x43}, 32)), "files/2024")) {
+		t.Error("Different master keys produced the same key")
+	}
+	if subkey, _ := DeriveSubkey(masterKey, "metadata", 32); bytes.Equal(child(hierarchy, "metadata"), subkey) {
+		t.Error("Child() collides with DeriveSubkey() for the same info")
+	}
+
+	// The hierarchy keeps its own copy of the master key
+	masterKey[0] ^= 1
+	if !bytes.Equal(files, child(hierarchy, "files/2024")) {
+		t.Error("Modifying the master key changed the hierarchy")
+	}
+
+	// Pin the output (RFC 5869 HKDF-SHA256, salt "OpenADP-KeyHierarchy-v1")
+	const want = "578f0d4c790d8fc2eaec5caf28e625831ceeeba8aa3c642e5bddfc106452d59f"
+	if got := hex.EncodeToString(files); got != want {
+		t.Errorf("Child() = %s, want %s", got, want)
+	}
+}
+
+func TestKeyHierarchyErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+	}{
+		{"empty", ""},
+		{"leading slash", "/files"},
+		{"trailing slash", "files/"},
+		{"empty segment", "files//2024"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if key, err := NewKeyHierarchy(make([]byte, 32)).Child(tt.path); err == nil {
+				t.Errorf("Child(%q) = %x, want an error", tt.path, key)
+			}
+		})
+	}
+
+	hierarchy := NewKeyHierarchy(make([]byte, 32))
+	hierarchy.Zeroize()
+	if _, err := hierarchy.Child("files"); !errors.Is(err, ErrKeyHierarchyZeroized) {
+		t.Errorf("Child() after Zeroize() error = %v, want %v", err, ErrKeyHierarchyZeroized)
+	}
+}
@@ -0,0 +1,83 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+)
+
+// tlsTransportKey identifies a transport by its TLS config and proxy
+type tlsTransportKey struct {
+	config *tls.Config
+	proxy  string
+}
+
+// maxTLSTransports bounds tlsTransports, so that callers building a tls.Config
+// per call do not keep a transport, and its idle connections, for each one
+const maxTLSTransports = 16
+
+// tlsTransports caches a transport for each of the most recently used TLS
+// configs and proxies, so that clients sharing Options share a connection pool,
+// as proxyTransports does
+var (
+	tlsTransportsMu   sync.Mutex
+	tlsTransports     = make(map[tlsTransportKey]*http.Transport)
+	tlsTransportOrder []tlsTransportKey // Least recently used first
+)
+
+// tlsTransport returns the shared transport that uses config for TLS and routes
+// requests through proxy, or the proxies of the environment if proxy is nil. The
+// least recently used transport beyond maxTLSTransports is dropped and its idle
+// connections closed; clients still holding it keep working.
+func tlsTransport(config *tls.Config, proxy *url.URL) *http.Transport {
+	key := tlsTransportKey{config: config}
+	if proxy != nil {
+		key.proxy = proxy.String()
+	}
+
+	tlsTransportsMu.Lock()
+	defer tlsTransportsMu.Unlock()
+
+	for i, k := range tlsTransportOrder {
+		if k == key {
+			copy(tlsTransportOrder[i:], tlsTransportOrder[i+1:])
+			tlsTransportOrder[len(tlsTransportOrder)-1] = key
+			return tlsTransports[key]
+		}
+	}
+
+	transport := newProxyTransport(proxyFromEnvironment)
+	if proxy != nil {
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+	transport.TLSClientConfig = config
+	tlsTransports[key] = transport
+	tlsTransportOrder = append(tlsTransportOrder, key)
+
+	if len(tlsTransportOrder) > maxTLSTransports {
+		oldest := tlsTransportOrder[0]
+		tlsTransportOrder = tlsTransportOrder[1:]
+		tlsTransports[oldest].CloseIdleConnections()
+		delete(tlsTransports, oldest)
+	}
+	return transport
+}
+
+// LoadCABundle reads the PEM certificates at path into a pool for
+// tls.Config.RootCAs. The pool holds only those certificates, so servers whose
+// chains lead to other roots, including the system roots, are rejected.
+func LoadCABundle(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no PEM certificates found in %s", path)
+	}
+	return pool, nil
+}
@@ -0,0 +1,99 @@
+package client
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/pem"
+	"io"
+	"log"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTLSConfig(t *testing.T) {
+	servers, _ := newTestServers(t, 3)
+	identity := &Identity{UID: "user", DID: "app", BID: "even"}
+
+	// Front each server with TLS using the self-signed httptest certificate
+	var serverInfos []ServerInfo
+	var bundle []byte
+	for _, server := range servers {
+		target, _ := url.Parse(server.URL)
+		front := httptest.NewUnstartedServer(httputil.NewSingleHostReverseProxy(target))
+		front.Config.ErrorLog = log.New(io.Discard, "", 0) // Rejected handshakes are expected
+		front.StartTLS()
+		t.Cleanup(front.Close)
+		serverInfos = append(serverInfos, ServerInfo{URL: front.URL, PublicKey: server.PublicKeyString()})
+		bundle = append(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: front.Certificate().Raw})...)
+	}
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(path, bundle, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	pool, err := LoadCABundle(path)
+	if err != nil {
+		t.Fatalf("LoadCABundle() error: %v", err)
+	}
+	opts := &Options{TLSConfig: &tls.Config{RootCAs: pool}, Timeouts: Timeouts{PerServer: 5 * time.Second}}
+
+	generated := GenerateEncryptionKeyWithOpts(identity, "password", 10, 0, serverInfos, opts)
+	if generated.Error != "" {
+		t.Fatalf("Key generation failed: %s", generated.Error)
+	}
+	recovered := RecoverEncryptionKeyWithOpts(identity, "password", serverInfos, generated.Threshold, generated.AuthCodes, opts)
+	if recovered.Error != "" {
+		t.Fatalf("Recovery failed: %s", recovered.Error)
+	}
+	if !bytes.Equal(recovered.EncryptionKey, generated.EncryptionKey) {
+		t.Error("Recovered key does not match")
+	}
+	if opts.transport() != opts.transport() {
+		t.Error("Options with the same TLSConfig do not share a transport")
+	}
+
+	// The system roots do not trust the self-signed certificates
+	untrusted := RecoverEncryptionKeyWithOpts(identity, "password", serverInfos, generated.Threshold, generated.AuthCodes, nil)
+	if untrusted.ErrCode != ErrNetwork {
+		t.Errorf("Recovery without the CA bundle: ErrCode = %q, want %q (error: %s)", untrusted.ErrCode, ErrNetwork, untrusted.Error)
+	}
+}
+
+func TestLoadCABundleInvalid(t *testing.T) {
+	dir := t.TempDir()
+	empty := filepath.Join(dir, "empty.pem")
+	if err := os.WriteFile(empty, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{filepath.Join(dir, "missing.pem"), empty} {
+		if _, err := LoadCABundle(path); err == nil {
+			t.Errorf("LoadCABundle(%s) succeeded, want an error", filepath.Base(path))
+		}
+	}
+}
+
+func TestTLSTransportCacheBounded(t *testing.T) {
+	shared := &tls.Config{}
+	first := tlsTransport(shared, nil)
+
+	// Callers that build a config per call do not grow the cache without bound
+	for i := 0; i < 3*maxTLSTransports; i++ {
+		tlsTransport(&tls.Config{}, nil)
+		if i%(maxTLSTransports/2) == 0 && tlsTransport(shared, nil) != first {
+			t.Fatal("tlsTransport() evicted a transport still in use")
+		}
+	}
+
+	tlsTransportsMu.Lock()
+	size := len(tlsTransports)
+	tlsTransportsMu.Unlock()
+	if size > maxTLSTransports {
+		t.Errorf("tlsTransports holds %d transports, want at most %d", size, maxTLSTransports)
+	}
+}
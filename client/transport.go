@@ -0,0 +1,14 @@
+package client
+
+import "net/http"
+
+// Transport carries one HTTP request to a server and returns its response. It has
+// the method set of http.RoundTripper, so any RoundTripper can be used, and lets
+// environments without sockets inject their own: under GOOS=js the default
+// transport calls the browser's fetch API.
+//
+// The rest of the client only needs Transport and crypto/rand, which reads from
+// crypto.getRandomValues under js/wasm, so it compiles for WebAssembly unchanged.
+type Transport interface {
+	RoundTrip(req *http.Request) (*http.Response, error)
+}
@@ -0,0 +1,8 @@
+//go:build !(js && wasm)
+
+package client
+
+// defaultTransport is shared by all clients that do not set Options.Transport or
+// Options.ProxyURL, so parallel requests to different servers share one connection
+// pool and one proxy configuration
+var defaultTransport = newProxyTransport(proxyFromEnvironment)
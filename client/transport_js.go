@@ -0,0 +1,126 @@
+//go:build js && wasm
+
+package client
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"syscall/js"
+)
+
+// defaultTransport sends requests through the fetch API of the browser or of
+// Node.js, since WebAssembly has no sockets to pool connections on
+var defaultTransport http.RoundTripper = fetchTransport{}
+
+// fetchTransport is a Transport that calls the JavaScript fetch function
+type fetchTransport struct{}
+
+// RoundTrip sends req with fetch, aborting it when the request context is done
+func (fetchTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	fetch := js.Global().Get("fetch")
+	if fetch.Type() != js.TypeFunction {
+		return nil, errors.New("fetch is not available in this JavaScript environment")
+	}
+
+	headers := js.Global().Get("Headers").New()
+	for key, values := range req.Header {
+		for _, value := range values {
+			headers.Call("append", key, value)
+		}
+	}
+	init := js.Global().Get("Object").New()
+	init.Set("method", req.Method)
+	init.Set("headers", headers)
+
+	if req.Body != nil {
+		body, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if len(body) > 0 {
+			array := js.Global().Get("Uint8Array").New(len(body))
+			js.CopyBytesToJS(array, body)
+			init.Set("body", array)
+		}
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	if abortController := js.Global().Get("AbortController"); abortController.Type() == js.TypeFunction {
+		controller := abortController.New()
+		init.Set("signal", controller.Get("signal"))
+		go func() {
+			select {
+			case <-req.Context().Done():
+				controller.Call("abort")
+			case <-done:
+			}
+		}()
+	}
+
+	response, err := awaitPromise(fetch.Invoke(req.URL.String(), init))
+	if err != nil {
+		if ctxErr := req.Context().Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, fmt.Errorf("fetch %s: %v", req.URL, err)
+	}
+
+	buffer, err := awaitPromise(response.Call("arrayBuffer"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %v", err)
+	}
+	array := js.Global().Get("Uint8Array").New(buffer)
+	body := make([]byte, array.Get("length").Int())
+	js.CopyBytesToGo(body, array)
+
+	header := make(http.Header)
+	appendHeader := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		header.Add(args[1].String(), args[0].String())
+		return nil
+	})
+	defer appendHeader.Release()
+	response.Get("headers").Call("forEach", appendHeader)
+
+	status := response.Get("status").Int()
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, response.Get("statusText").String()),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+// awaitPromise blocks until promise settles and returns its value or rejection
+func awaitPromise(promise js.Value) (js.Value, error) {
+	values := make(chan js.Value, 1)
+	errs := make(chan error, 1)
+
+	onFulfilled := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		values <- args[0]
+		return nil
+	})
+	defer onFulfilled.Release()
+	onRejected := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		errs <- js.Error{Value: args[0]}
+		return nil
+	})
+	defer onRejected.Release()
+
+	promise.Call("then", onFulfilled, onRejected)
+	select {
+	case value := <-values:
+		return value, nil
+	case err := <-errs:
+		return js.Value{}, err
+	}
+}
@@ -0,0 +1,59 @@
+//go:build js && wasm
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+)
+
+func TestInjectedTransport(t *testing.T) {
+	var methods []string
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		var request struct {
+			Method string        `json:"method"`
+			Params []interface{} `json:"params"`
+			ID     int           `json:"id"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+			t.Fatalf("Invalid JSON-RPC request: %v", err)
+		}
+		methods = append(methods, request.Method)
+		response, _ := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "result": request.Params[0], "id": request.ID})
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": {"application/json"}},
+			Body:       io.NopCloser(bytes.NewReader(response)),
+			Request:    req,
+		}, nil
+	})
+
+	client := (&Options{Transport: transport}).newClient(context.Background(), "https://server.example.com", nil)
+	echoed, err := client.Echo("hello", false)
+	if err != nil {
+		t.Fatalf("Echo() error: %v", err)
+	}
+	if echoed != "hello" || len(methods) != 1 || methods[0] != "Echo" {
+		t.Errorf("Echo() = %q after requests %v, want \"hello\" after one Echo", echoed, methods)
+	}
+}
+
+func TestDefaultTransportUsesFetch(t *testing.T) {
+	if _, ok := (&Options{}).transport().(fetchTransport); !ok {
+		t.Fatalf("Default transport is %T, want fetchTransport", (&Options{}).transport())
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, `data:application/json,{"ok":true}`, nil)
+	resp, err := fetchTransport{}.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip() error: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != `{"ok":true}` {
+		t.Errorf("RoundTrip() = %d %q, want 200 {\"ok\":true}", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,152 @@
+package client
+
+import (
+	"fmt"
+	"time"
+)
+
+// InputField names the argument of key generation that failed validation, so
+// that a form can show the error next to the right input
+type InputField string
+
+// Arguments reported in GenerateEncryptionKeyResult.Field and GenerationReport.Field
+const (
+	FieldIdentity   InputField = "identity"
+	FieldMaxGuesses InputField = "max_guesses"
+	FieldExpiration InputField = "expiration"
+	FieldServers    InputField = "servers"
+	FieldThreshold  InputField = "threshold"
+	FieldOptions    InputField = "options" // Another setting of Options, such as KeyLength or PinParams
+)
+
+// DefaultMaxGuessesLimit is the largest guess budget accepted unless
+// Options.MaxGuessesLimit is set
+const DefaultMaxGuessesLimit = 1000
+
+// GenerationReport describes what GenerateEncryptionKey would do with the same
+// arguments, as determined by ValidateGeneration
+type GenerationReport struct {
+	// Valid is true if generation would register shares with enough healthy
+	// servers to meet the threshold
+	Valid   bool
+	Error   string     // Why generation would fail, empty if Valid
+	ErrCode ErrCode    // Machine-readable classification of Error
+	Field   InputField // Argument at fault if ErrCode reports invalid input
+
+	Probes     []ServerProbe // Probe of every server, in serverInfos order; nil if a client-side check failed
+	ServerURLs []string      // Reachable servers that would be sent a share
+	Threshold  int           // Recovery threshold that would be selected for ServerURLs
+	Healthy    int           // Servers in ServerURLs that would be expected to accept their share
+}
+
+// ValidateGeneration is a dry run of GenerateEncryptionKey: it runs the same
+// client-side checks on its arguments, probes every server with ProbeServers and
+// reports which servers would be used, the threshold that would be selected and
+// whether enough of them are healthy to meet it. No secret is created and no share
+// is registered, so no backup state changes on any server.
+//
+// A Valid report is not a guarantee: a server can still fail between validation and
+// generation.
+func ValidateGeneration(identity *Identity, maxGuesses, expiration int, serverInfos []ServerInfo) *GenerationReport {
+	return ValidateGenerationWithOpts(identity, maxGuesses, expiration, serverInfos, nil)
+}
+
+// ValidateGenerationWithOpts is like ValidateGeneration but accepts the options
+// that would be passed to GenerateEncryptionKeyWithOpts, which affect validation
+// as they affect generation. MaxGuessesLimit, MinServers, KeyLength and PinParams
+// are checked by the same rules, and an expiration is judged against Clock.
+// Duplicate servers are removed, and servers lacking the PIN KDF of PinParams or
+// the protocol version of the backup are left out, each with a warning through
+// Logger. Threshold selects the threshold of the servers that remain, and
+// Timeouts.Overall bounds the probes.
+//
+// Servers are probed with ProbeServers, which uses default HTTP settings, so
+// Transport, TLSConfig, Retry, Noise and Timeouts.PerServer do not affect the
+// probes; nor do the options that only govern registration.
+func ValidateGenerationWithOpts(identity *Identity, maxGuesses, expiration int, serverInfos []ServerInfo,
+	opts *Options) *GenerationReport {
+
+	serverInfos, msg, code, field := prepareGeneration(identity, maxGuesses, expiration, serverInfos, opts)
+	if code != ErrNone {
+		return &GenerationReport{Error: msg, ErrCode: code, Field: field}
+	}
+
+	ctx, cancel := opts.overallContext()
+	defer cancel()
+
+	report := &GenerationReport{Probes: ProbeServers(ctx, serverInfos)}
+	var live []*ServerProbe
+	var urls []string
+	var caps []Capabilities
+	for i := range report.Probes {
+		probe := &report.Probes[i]
+		if !probe.Reachable {
+			continue
+		}
+		live = append(live, probe)
+		urls = append(urls, probe.Server.URL)
+		caps = append(caps, probe.Capabilities)
+	}
+
+	if len(live) == 0 {
+		report.Error, report.ErrCode = timeoutError(ctx, "No live servers available", ErrNetwork)
+		return report
+	}
+
+	var pinKDF PinKDF
+	if opts != nil && opts.PinParams != nil {
+		pinKDF = opts.PinParams.KDF
+	}
+	supported, msg := opts.capableServers(urls, caps, pinKDF)
+	if msg != "" {
+		report.Error, report.ErrCode = msg, ErrInsufficientServers
+		return report
+	}
+	var supportedCaps []Capabilities
+	for _, i := range supported {
+		report.ServerURLs = append(report.ServerURLs, urls[i])
+		supportedCaps = append(supportedCaps, caps[i])
+		if live[i].Healthy() {
+			report.Healthy++
+		}
+	}
+	if msg := checkExpiration(opts.clock().Now(), expiration, report.ServerURLs, supportedCaps); msg != "" {
+		report.Error, report.ErrCode, report.Field = msg, ErrInvalidInput, FieldExpiration
+		return report
+	}
+	if len(report.ServerURLs) < opts.minServers() {
+		report.Error = fmt.Sprintf("Only %d usable servers, but MinServers requires at least %d", len(report.ServerURLs), opts.minServers())
+		report.ErrCode = ErrInsufficientServers
+		return report
+	}
+
+	report.Threshold = opts.threshold(len(report.ServerURLs))
+	if report.Healthy < report.Threshold {
+		report.Error = fmt.Sprintf("Need at least %d healthy servers, only %d available", report.Threshold, report.Healthy)
+		report.ErrCode = ErrThresholdNotMet
+		return report
+	}
+
+	report.Valid = true
+	return report
+}
+
+// checkExpiration returns why the servers in urls, with capabilities caps, would
+// refuse a backup expiring at expiration (Unix seconds, 0 for never) if
+// registered at now, or ""
+func checkExpiration(now time.Time, expiration int, urls []string, caps []Capabilities) string {
+	for i, c := range caps {
+		if c.MaxExpiration <= 0 {
+			continue
+		}
+		latest := now.Add(c.MaxExpiration)
+		if expiration == 0 {
+			return fmt.Sprintf("Server %s requires backups to expire within %s", urls[i], c.MaxExpiration)
+		}
+		if time.Unix(int64(expiration), 0).After(latest) {
+			return fmt.Sprintf("Expiration %s is beyond the maximum of server %s, %s from now",
+				time.Unix(int64(expiration), 0).UTC().Format(time.RFC3339), urls[i], c.MaxExpiration)
+		}
+	}
+	return ""
+}
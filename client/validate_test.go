@@ -0,0 +1,89 @@
+package client
+
+import "testing"
+
+func TestValidateGeneration(t *testing.T) {
+	servers, serverInfos := newTestServers(t, 4)
+	servers[3].Close()
+	wrongKey := serverInfos[0].WithPublicKey(servers[1].PublicKey)
+	identity := &Identity{UID: "user", DID: "laptop", BID: "dry-run"}
+
+	tests := []struct {
+		name        string
+		serverInfos []ServerInfo
+		opts        *Options
+		valid       bool
+		code        ErrCode
+		live        int
+		threshold   int
+		healthy     int
+	}{
+		{"all healthy", serverInfos[:3], nil, true, ErrNone, 3, 2, 3},
+		{"one unreachable", []ServerInfo{serverInfos[0], serverInfos[1], serverInfos[3]}, nil, true, ErrNone, 2, 2, 2},
+		{"wrong pinned key", []ServerInfo{wrongKey, serverInfos[1], serverInfos[2]}, &Options{Threshold: 3}, false, ErrThresholdNotMet, 3, 3, 2},
+		{"all unreachable", serverInfos[3:], nil, false, ErrNetwork, 0, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := ValidateGenerationWithOpts(identity, 10, 0, tt.serverInfos, tt.opts)
+			if report.Valid != tt.valid || report.ErrCode != tt.code {
+				t.Fatalf("Expected Valid=%v ErrCode=%q, got Valid=%v ErrCode=%q (%s)",
+					tt.valid, tt.code, report.Valid, report.ErrCode, report.Error)
+			}
+			if len(report.Probes) != len(tt.serverInfos) {
+				t.Errorf("Expected %d probes, got %d", len(tt.serverInfos), len(report.Probes))
+			}
+			if len(report.ServerURLs) != tt.live || report.Threshold != tt.threshold || report.Healthy != tt.healthy {
+				t.Errorf("Expected %d live servers, threshold %d and %d healthy, got %d, %d and %d",
+					tt.live, tt.threshold, tt.healthy, len(report.ServerURLs), report.Threshold, report.Healthy)
+			}
+		})
+	}
+
+	// A dry run registers nothing
+	for i, server := range servers[:3] {
+		if n := server.NumGuesses(identity.UID, identity.DID, identity.BID); n != -1 {
+			t.Errorf("Server %d holds a backup after validation", i)
+		}
+	}
+}
+
+func TestValidateGenerationMatchesGenerate(t *testing.T) {
+	_, serverInfos := newTestServers(t, 2)
+	valid := &Identity{UID: "user", DID: "laptop", BID: "dry-run"}
+
+	tests := []struct {
+		name        string
+		identity    *Identity
+		maxGuesses  int
+		serverInfos []ServerInfo
+		opts        *Options
+	}{
+		{"nil identity", nil, 10, serverInfos, nil},
+		{"empty UID", &Identity{DID: "laptop", BID: "dry-run"}, 10, serverInfos, nil},
+		{"empty DID", &Identity{UID: "user", BID: "dry-run"}, 10, serverInfos, nil},
+		{"empty BID", &Identity{UID: "user", DID: "laptop"}, 10, serverInfos, nil},
+		{"negative max guesses", valid, -1, serverInfos, nil},
+		{"no servers", valid, 10, nil, nil},
+		{"negative threshold", valid, 10, serverInfos, &Options{Threshold: -1}},
+		{"threshold above server count", valid, 10, serverInfos, &Options{Threshold: 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := ValidateGenerationWithOpts(tt.identity, tt.maxGuesses, 0, tt.serverInfos, tt.opts)
+			result := GenerateEncryptionKeyWithOpts(tt.identity, "password", tt.maxGuesses, 0, tt.serverInfos, tt.opts)
+			if report.Valid || report.ErrCode == ErrNone {
+				t.Fatal("ValidateGeneration() accepted invalid input")
+			}
+			if report.ErrCode != result.ErrCode || report.Error != result.Error {
+				t.Errorf("ValidateGeneration() = %q (%s), GenerateEncryptionKey() = %q (%s)",
+					report.ErrCode, report.Error, result.ErrCode, result.Error)
+			}
+			if report.Probes != nil {
+				t.Error("Servers were probed although a client-side check failed")
+			}
+		})
+	}
+}
@@ -0,0 +1,199 @@
+package client
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"reflect"
+
+	"github.com/openadp/ocrypt/common"
+	"github.com/openadp/ocrypt/debug"
+)
+
+// Test vectors pin the deterministic steps of key generation, so that every
+// OpenADP implementation can check it derives the same values from the same
+// inputs. Random values (the base auth code, the secret and the polynomial
+// coefficients) are drawn, in that order and as during generation, from the
+// stream
+//
+//	SHA256("OpenADP-TestVectors-v1" || seed || 0) || SHA256(... || 1) || ...
+//
+// with the block counter as 4 bytes big-endian. Scalars are drawn with Go's
+// crypto/rand.Int, but other implementations only need to check the
+// derivations from the random values, which are all recorded.
+const (
+	testVectorsVersion = 1
+	testVectorsLabel   = "OpenADP-TestVectors-v1"
+)
+
+// testVectors is the JSON document produced by ExportTestVectors. Byte strings
+// are hex, scalars are big-endian hex and points are compressed.
+type testVectors struct {
+	Version    int      `json:"version"`
+	Seed       string   `json:"seed"`
+	UID        string   `json:"uid"`
+	DID        string   `json:"did"`
+	BID        string   `json:"bid"`
+	Password   string   `json:"password"`
+	ServerURLs []string `json:"server_urls"`
+	Threshold  int      `json:"threshold"`
+
+	PIN             string            `json:"pin"`
+	U               string            `json:"u"`
+	BaseAuthCode    string            `json:"base_auth_code"`
+	ServerAuthCodes map[string]string `json:"server_auth_codes"`
+	Secret          string            `json:"secret"`
+	Coefficients    []string          `json:"coefficients"`
+	Shares          []testVectorShare `json:"shares"`
+	S               string            `json:"s"`
+	EncryptionKey   string            `json:"encryption_key"`
+}
+
+// testVectorShare is a share of the secret, sent to server X-1
+type testVectorShare struct {
+	X int    `json:"x"`
+	Y string `json:"y"`
+}
+
+// ExportTestVectors returns JSON test vectors for a fixed identity, password and
+// set of servers, with every random value drawn from a stream derived from seed.
+// The same seed always yields the same document. Commit the output as a shared
+// fixture and check it with VerifyTestVectors here and in other implementations.
+//
+// Debug mode replaces the random values with fixed ones, so it must be disabled.
+func ExportTestVectors(seed []byte) ([]byte, error) {
+	vectors, err := computeTestVectors(&testVectors{
+		Version:    testVectorsVersion,
+		Seed:       hex.EncodeToString(seed),
+		UID:        "alice@example.com",
+		DID:        "laptop",
+		BID:        "file://vectors.txt",
+		Password:   "correct horse battery stäple",
+		ServerURLs: []string{"https://server1.example.com", "https://server2.example.com", "https://server3.example.com"},
+		Threshold:  2,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(vectors, "", "  ")
+}
+
+// VerifyTestVectors recomputes the test vectors in data from their recorded inputs
+// (seed, identity, password, servers and threshold) and reports the first value that
+// differs.
+func VerifyTestVectors(data []byte) error {
+	var want testVectors
+	if err := json.Unmarshal(data, &want); err != nil {
+		return fmt.Errorf("invalid test vectors: %v", err)
+	}
+	if want.Version != testVectorsVersion {
+		return fmt.Errorf("unsupported test vectors version %d", want.Version)
+	}
+
+	got, err := computeTestVectors(&testVectors{
+		Version:    want.Version,
+		Seed:       want.Seed,
+		UID:        want.UID,
+		DID:        want.DID,
+		BID:        want.BID,
+		Password:   want.Password,
+		ServerURLs: want.ServerURLs,
+		Threshold:  want.Threshold,
+	})
+	if err != nil {
+		return err
+	}
+
+	gotValue, wantValue := reflect.ValueOf(*got), reflect.ValueOf(want)
+	for i := 0; i < gotValue.NumField(); i++ {
+		if !reflect.DeepEqual(gotValue.Field(i).Interface(), wantValue.Field(i).Interface()) {
+			name := reflect.TypeOf(want).Field(i).Tag.Get("json")
+			return fmt.Errorf("test vector %s: got %v, want %v", name, gotValue.Field(i).Interface(), wantValue.Field(i).Interface())
+		}
+	}
+	return nil
+}
+
+// computeTestVectors fills in the derived values of vectors from its inputs
+func computeTestVectors(vectors *testVectors) (*testVectors, error) {
+	if debug.IsDebugModeEnabled() {
+		return nil, errors.New("test vectors cannot be computed in debug mode")
+	}
+	if vectors.Threshold < 1 || vectors.Threshold > len(vectors.ServerURLs) {
+		return nil, fmt.Errorf("invalid threshold %d for %d servers", vectors.Threshold, len(vectors.ServerURLs))
+	}
+	seed, err := hex.DecodeString(vectors.Seed)
+	if err != nil {
+		return nil, fmt.Errorf("invalid seed: %v", err)
+	}
+	opts := &Options{Rand: &vectorReader{seed: seed}}
+
+	pin := PasswordToPin(vectors.Password)
+	U := common.H([]byte(vectors.UID), []byte(vectors.DID), []byte(vectors.BID), pin)
+	vectors.PIN = hex.EncodeToString(pin)
+	vectors.U = hex.EncodeToString(common.PointCompress(U))
+
+	authCodes, err := GenerateAuthCodesWithOpts(vectors.ServerURLs, opts)
+	if err != nil {
+		return nil, err
+	}
+	vectors.BaseAuthCode = authCodes.BaseAuthCode
+	vectors.ServerAuthCodes = authCodes.ServerAuthCodes
+
+	secret, err := randomSecret(opts.randReader())
+	if err != nil {
+		return nil, err
+	}
+	vectors.Secret = scalarHex(secret)
+
+	poly, err := randomPolynomial(opts.randReader(), secret, vectors.Threshold)
+	if err != nil {
+		return nil, err
+	}
+	vectors.Coefficients = make([]string, len(poly))
+	for i, coeff := range poly {
+		vectors.Coefficients[i] = scalarHex(coeff)
+	}
+	for _, share := range sharesFromPolynomial(poly, len(vectors.ServerURLs)) {
+		vectors.Shares = append(vectors.Shares, testVectorShare{X: int(share.X.Int64()), Y: scalarHex(share.Y)})
+	}
+
+	S := common.PointMul(secret, U)
+	vectors.S = hex.EncodeToString(common.PointCompress(S))
+	vectors.EncryptionKey = hex.EncodeToString(common.DeriveEncKey(S))
+
+	return vectors, nil
+}
+
+// scalarHex encodes a scalar as 32 bytes of big-endian hex
+func scalarHex(n *big.Int) string {
+	return hex.EncodeToString(n.FillBytes(make([]byte, 32)))
+}
+
+// vectorReader is the deterministic random stream of the test vectors
+type vectorReader struct {
+	seed    []byte
+	counter uint32
+	buf     []byte
+}
+
+func (r *vectorReader) Read(p []byte) (int, error) {
+	for n := 0; n < len(p); {
+		if len(r.buf) == 0 {
+			block := sha256.New()
+			block.Write([]byte(testVectorsLabel))
+			block.Write(r.seed)
+			binary.Write(block, binary.BigEndian, r.counter)
+			r.buf = block.Sum(nil)
+			r.counter++
+		}
+		copied := copy(p[n:], r.buf)
+		r.buf = r.buf[copied:]
+		n += copied
+	}
+	return len(p), nil
+}
@@ -0,0 +1,61 @@
+package client
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestExportTestVectors(t *testing.T) {
+	first, err := ExportTestVectors([]byte("seed"))
+	if err != nil {
+		t.Fatalf("ExportTestVectors() error: %v", err)
+	}
+	second, _ := ExportTestVectors([]byte("seed"))
+	other, _ := ExportTestVectors([]byte("other seed"))
+
+	if !bytes.Equal(first, second) {
+		t.Error("ExportTestVectors() is not deterministic")
+	}
+	if bytes.Equal(first, other) {
+		t.Error("ExportTestVectors() ignores the seed")
+	}
+	if err := VerifyTestVectors(first); err != nil {
+		t.Errorf("VerifyTestVectors() rejected exported vectors: %v", err)
+	}
+}
+
+// TestVerifyTestVectorsFixture checks the fixture shared with the other OpenADP
+// implementations; a failure means a derivation changed
+func TestVerifyTestVectorsFixture(t *testing.T) {
+	data, err := os.ReadFile("testdata/test_vectors.json")
+	if err != nil {
+		t.Fatalf("ReadFile() error: %v", err)
+	}
+	if err := VerifyTestVectors(data); err != nil {
+		t.Fatalf("VerifyTestVectors() error: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		old   string
+		new   string
+		field string
+	}{
+		{"pin", `"pin": "63`, `"pin": "64`, "pin"},
+		{"auth code", `"base_auth_code": "3a`, `"base_auth_code": "3b`, "base_auth_code"},
+		{"share", `"y": "0d`, `"y": "0e`, "shares"},
+		{"key", `"encryption_key": "3c`, `"encryption_key": "3d`, "encryption_key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tampered := strings.Replace(string(data), tt.old, tt.new, 1)
+			err := VerifyTestVectors([]byte(tampered))
+			if err == nil || !strings.Contains(err.Error(), tt.field) {
+				t.Errorf("VerifyTestVectors() error = %v, want a mismatch in %s", err, tt.field)
+			}
+		})
+	}
+}
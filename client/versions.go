@@ -0,0 +1,23 @@
+package client
+
+// This client reconstructs keys only from shares of ProtocolVersion, the one
+// share format it registers. A server that speaks no version this client
+// supports is asked for nothing, and a share a server reports in another version
+// is refused, so shares of different versions are never combined. Recovery fails
+// with ErrMixedProtocolVersions if the refused servers leave it short of the
+// threshold.
+
+// supportsProtocolVersion reports whether this client can reconstruct keys from
+// shares of version
+func supportsProtocolVersion(version int) bool {
+	return version == ProtocolVersion
+}
+
+// negotiateProtocolVersion returns the share format version that both a server
+// with caps and this client speak, or 0 if there is none
+func negotiateProtocolVersion(caps Capabilities) int {
+	if caps.SupportsProtocolVersion(ProtocolVersion) {
+		return ProtocolVersion
+	}
+	return 0
+}
@@ -0,0 +1,68 @@
+package client
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRecoverMixedProtocolVersions(t *testing.T) {
+	tests := []struct {
+		name        string
+		newServers  []int // Servers that speak only a version this client lacks
+		newShares   []int // Servers that return shares of a version this client lacks
+		wantCode    ErrCode
+		wantVersion int
+	}{
+		{"enough servers speak the version", []int{3, 4}, nil, ErrNone, ProtocolVersion},
+		{"shares of another version refused", nil, []int{0, 1}, ErrNone, ProtocolVersion},
+		{"too few servers speak the version", []int{0, 1}, []int{2}, ErrMixedProtocolVersions, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			servers, serverInfos := newTestServers(t, 5)
+			for _, i := range tt.newShares {
+				servers[i].SetShareVersion(ProtocolVersion + 1)
+			}
+			identity := &Identity{UID: "user", DID: "phone", BID: "versions"}
+			generated := GenerateEncryptionKeyWithOpts(identity, "password", 10, 0, serverInfos, &Options{Threshold: 3})
+			if generated.Error != "" {
+				t.Fatalf("Key generation failed: %s", generated.Error)
+			}
+			for _, i := range tt.newServers {
+				servers[i].SetServerInfo("protocol_versions", []int{ProtocolVersion + 1})
+			}
+
+			result := RecoverEncryptionKeyWithOpts(identity, "password", serverInfos, 3, generated.AuthCodes, nil)
+			if result.ErrCode != tt.wantCode {
+				t.Fatalf("ErrCode = %q (%s), want %q", result.ErrCode, result.Error, tt.wantCode)
+			}
+			if result.ProtocolVersion != tt.wantVersion {
+				t.Errorf("ProtocolVersion = %d, want %d", result.ProtocolVersion, tt.wantVersion)
+			}
+			if tt.wantCode == ErrNone && !bytes.Equal(result.EncryptionKey, generated.EncryptionKey) {
+				t.Error("Recovered key does not match")
+			}
+		})
+	}
+}
+
+func TestRecoverSkipsUnsupportedProtocolVersion(t *testing.T) {
+	servers, serverInfos := newTestServers(t, 3)
+	identity := &Identity{UID: "user", DID: "phone", BID: "versions"}
+	generated := GenerateEncryptionKeyWithOpts(identity, "password", 10, 0, serverInfos, &Options{Threshold: 2})
+	if generated.Error != "" {
+		t.Fatalf("Key generation failed: %s", generated.Error)
+	}
+	servers[0].SetServerInfo("protocol_versions", []int{2})
+
+	result := RecoverEncryptionKeyWithOpts(identity, "password", serverInfos, 2, generated.AuthCodes, nil)
+	if result.Error != "" {
+		t.Fatalf("Recovery failed: %s", result.Error)
+	}
+	if result.ProtocolVersion != ProtocolVersion {
+		t.Errorf("ProtocolVersion = %d, want %d", result.ProtocolVersion, ProtocolVersion)
+	}
+	if got := servers[0].NumGuesses(identity.UID, identity.DID, identity.BID); got != 0 {
+		t.Errorf("Server speaking only an unsupported version spent %d guesses, want 0", got)
+	}
+}
@@ -0,0 +1,143 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Wire format of the backup RPCs. Servers take positional params, so each
+// request type marshals to a JSON array in the order the server reads it; the
+// field order of the structs is the order on the wire. Results are decoded into
+// the matching result types, so a field of the wrong type fails the request
+// instead of being skipped.
+
+// registerSecretParams are the params of RegisterSecret:
+// [auth_code, uid, did, bid, version, x, y, max_guesses, expiration], followed by
+// the idempotency key when one is sent
+type registerSecretParams struct {
+	AuthCode       string
+	UID            string
+	DID            string
+	BID            string
+	Version        int
+	X              int
+	Y              string // Base64 encoded share
+	MaxGuesses     int
+	Expiration     int
+	IdempotencyKey string // Sent only to servers advertising CapabilityIdempotentRegister
+}
+
+func (p registerSecretParams) MarshalJSON() ([]byte, error) {
+	params := []interface{}{p.AuthCode, p.UID, p.DID, p.BID, p.Version, p.X, p.Y, p.MaxGuesses, p.Expiration}
+	if p.IdempotencyKey != "" {
+		params = append(params, p.IdempotencyKey)
+	}
+	return json.Marshal(params)
+}
+
+// recoverSecretParams are the params of RecoverSecret:
+// [auth_code, uid, did, bid, b, guess_num]
+type recoverSecretParams struct {
+	AuthCode string
+	UID      string
+	DID      string
+	BID      string
+	B        string // Base64 encoded blinded point
+	GuessNum int
+}
+
+func (p recoverSecretParams) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]interface{}{p.AuthCode, p.UID, p.DID, p.BID, p.B, p.GuessNum})
+}
+
+// recoverSecretResult is the result of RecoverSecret
+type recoverSecretResult struct {
+	Version         int    `json:"version"`
+	X               int    `json:"x"`
+	SiB             string `json:"si_b"` // Base64 encoded point
+	NumGuesses      int    `json:"num_guesses"`
+	MaxGuesses      int    `json:"max_guesses"`
+	Expiration      int    `json:"expiration"`
+	ProtocolVersion int    `json:"protocol_version,omitempty"` // Absent from servers that predate versioning
+}
+
+// listBackupsParams are the params of ListBackups: [uid]
+type listBackupsParams struct {
+	UID string
+}
+
+func (p listBackupsParams) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]interface{}{p.UID})
+}
+
+// echoParams are the params of Echo: [message]
+type echoParams struct {
+	Message string
+}
+
+func (p echoParams) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]interface{}{p.Message})
+}
+
+// backupParams are the params of DeleteBackup and ResetGuesses, which both name
+// a single backup: [auth_code, uid, did, bid]
+type backupParams struct {
+	AuthCode string
+	UID      string
+	DID      string
+	BID      string
+}
+
+func (p backupParams) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]interface{}{p.AuthCode, p.UID, p.DID, p.BID})
+}
+
+// decodeResult decodes the result of a JSON-RPC call into out
+func decodeResult(result interface{}, out interface{}) error {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("failed to marshal result: %v", err)
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("unexpected response: %v", err)
+	}
+	return nil
+}
+
+// call sends method with params and decodes its result into out. It is the one
+// path every backup RPC is encoded and decoded through.
+func (c *EncryptedOpenADPClient) call(method string, params interface{}, encrypted bool, authData map[string]interface{}, out interface{}) error {
+	result, err := c.makeRequest(method, params, encrypted, authData)
+	if err != nil {
+		return err
+	}
+	return decodeResult(result, out)
+}
+
+// call sends method with params over the unencrypted client and decodes its
+// result into out, through the same types as EncryptedOpenADPClient.call
+func (c *OpenADPClient) call(method string, params interface{}, out interface{}) error {
+	response, err := c.makeRequest(method, params)
+	if err != nil {
+		return err
+	}
+	return decodeResult(response.Result, out)
+}
+
+// recoverSecret sends a RecoverSecret request and decodes its result
+func (c *EncryptedOpenADPClient) recoverSecret(params recoverSecretParams, encrypted bool, authData map[string]interface{}) (*recoverSecretResult, error) {
+	var result recoverSecretResult
+	if err := c.call("RecoverSecret", params, encrypted, authData, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// listBackups sends a ListBackups request and decodes its result
+func (c *EncryptedOpenADPClient) listBackups(uid string, encrypted bool, authData map[string]interface{}) ([]ListBackupsResult, error) {
+	var backups []ListBackupsResult
+	if err := c.call("ListBackups", listBackupsParams{UID: uid}, encrypted, authData, &backups); err != nil {
+		return nil, err
+	}
+	return backups, nil
+}
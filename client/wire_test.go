@@ -0,0 +1,122 @@
+package client
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+// TestWireRequestsGolden checks the exact bytes each backup RPC puts on the wire
+// against the fixtures in testdata/wire; a failure means the protocol changed
+func TestWireRequestsGolden(t *testing.T) {
+	y := "AQAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="
+
+	tests := []struct {
+		golden string
+		result string
+		send   func(url string) error
+	}{
+		{"register_secret.json", "true", func(url string) error {
+			c := NewEncryptedOpenADPClient(url, nil)
+			_, err := c.RegisterSecret("auth", "user", "laptop", "even", 1, 2, y, 10, 1700000000, false, nil)
+			return err
+		}},
+		{"register_secret_idempotent.json", "true", func(url string) error {
+			c := NewEncryptedOpenADPClient(url, nil)
+			params := registerSecretParams{AuthCode: "auth", UID: "user", DID: "laptop", BID: "even", Version: 1, X: 2, Y: y, MaxGuesses: 10, IdempotencyKey: "0f1e2d3c"}
+			_, err := c.registerSecret(params, false, nil)
+			return err
+		}},
+		{"recover_secret.json", `{"version":1,"x":2,"si_b":"` + y + `","num_guesses":1,"max_guesses":10,"expiration":0}`, func(url string) error {
+			c := NewEncryptedOpenADPClient(url, nil)
+			_, err := c.RecoverSecret("auth", "user", "laptop", "even", y, 0, false, nil)
+			return err
+		}},
+		{"list_backups.json", "[]", func(url string) error {
+			c := NewEncryptedOpenADPClient(url, nil)
+			_, err := c.ListBackups("user", false, nil)
+			return err
+		}},
+		{"delete_backup.json", "true", func(url string) error {
+			c := NewEncryptedOpenADPClient(url, nil)
+			return c.DeleteBackup("auth", "user", "laptop", "even", false, nil)
+		}},
+		{"echo.json", `"hello"`, func(url string) error {
+			c := NewEncryptedOpenADPClient(url, nil)
+			_, err := c.Echo("hello", false)
+			return err
+		}},
+
+		// The unencrypted client shares the wire format
+		{"list_backups.json", "[]", func(url string) error {
+			_, err := NewOpenADPClient(url).ListBackups("user")
+			return err
+		}},
+		{"echo.json", `"hello"`, func(url string) error {
+			_, err := NewOpenADPClient(url).Echo("hello")
+			return err
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.golden, func(t *testing.T) {
+			var body []byte
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ = io.ReadAll(r.Body)
+				w.Write([]byte(`{"jsonrpc":"2.0","result":` + tt.result + `,"id":1}`))
+			}))
+			defer server.Close()
+
+			if err := tt.send(server.URL); err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			want, err := os.ReadFile("testdata/wire/" + tt.golden)
+			if err != nil {
+				t.Fatalf("ReadFile() error: %v", err)
+			}
+			if !bytes.Equal(body, bytes.TrimSpace(want)) {
+				t.Errorf("Request = %s, want %s", body, bytes.TrimSpace(want))
+			}
+		})
+	}
+}
+
+func TestDecodeRecoverSecretResult(t *testing.T) {
+	tests := []struct {
+		name    string
+		result  interface{}
+		want    recoverSecretResult
+		wantErr bool
+	}{
+		{
+			"complete",
+			map[string]interface{}{"version": 1.0, "x": 3.0, "si_b": "c2k=", "num_guesses": 1.0, "max_guesses": 10.0, "expiration": 0.0, "protocol_version": 2.0},
+			recoverSecretResult{Version: 1, X: 3, SiB: "c2k=", NumGuesses: 1, MaxGuesses: 10, ProtocolVersion: 2},
+			false,
+		},
+		{
+			"no protocol version",
+			map[string]interface{}{"version": 1.0, "x": 3.0, "si_b": "c2k="},
+			recoverSecretResult{Version: 1, X: 3, SiB: "c2k="},
+			false,
+		},
+		{"x of the wrong type", map[string]interface{}{"x": "3"}, recoverSecretResult{}, true},
+		{"not an object", true, recoverSecretResult{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got recoverSecretResult
+			err := decodeResult(tt.result, &got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decodeResult() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("decodeResult() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
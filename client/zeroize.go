@@ -0,0 +1,57 @@
+package client
+
+import (
+	"math/big"
+	"runtime"
+)
+
+// Wiping secrets in Go is best-effort: the garbage collector may have copied a
+// slice's contents while moving or growing it, strings cannot be wiped at all,
+// and values passed by copy leave their old copies behind. These helpers clear
+// the memory they are given, which shortens the lifetime of secrets without
+// guaranteeing that no copy remains.
+
+// zeroize overwrites b with zeros.
+//
+//go:noinline
+func zeroize(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+	runtime.KeepAlive(b)
+}
+
+// zeroizeInt overwrites the magnitude of x with zeros and sets x to 0.
+//
+//go:noinline
+func zeroizeInt(x *big.Int) {
+	if x == nil {
+		return
+	}
+	words := x.Bits()
+	for i := range words {
+		words[i] = 0
+	}
+	runtime.KeepAlive(words)
+	x.SetInt64(0)
+}
+
+// Zeroize overwrites EncryptionKey with zeros and clears it. Call it once the key is
+// no longer needed. Zeroing is best-effort; see the package notes in zeroize.go.
+func (r *GenerateEncryptionKeyResult) Zeroize() {
+	if r == nil {
+		return
+	}
+	zeroize(r.EncryptionKey)
+	r.EncryptionKey = nil
+}
+
+// Zeroize overwrites EncryptionKey with zeros and clears it. Call it once the key is
+// no longer needed. Zeroing is best-effort; see the package notes in zeroize.go.
+func (r *RecoverEncryptionKeyResult) Zeroize() {
+	if r == nil {
+		return
+	}
+	zeroize(r.EncryptionKey)
+	r.EncryptionKey = nil
+}
@@ -0,0 +1,67 @@
+package client
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+)
+
+func TestResultZeroize(t *testing.T) {
+	key := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+
+	tests := []struct {
+		name    string
+		zeroize func(key []byte) []byte
+	}{
+		{"GenerateEncryptionKeyResult", func(key []byte) []byte {
+			result := &GenerateEncryptionKeyResult{EncryptionKey: key}
+			result.Zeroize()
+			return result.EncryptionKey
+		}},
+		{"RecoverEncryptionKeyResult", func(key []byte) []byte {
+			result := &RecoverEncryptionKeyResult{EncryptionKey: key}
+			result.Zeroize()
+			return result.EncryptionKey
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			original := append([]byte(nil), key...)
+			saved := make([]byte, len(original))
+			copy(saved, original)
+
+			if remaining := tt.zeroize(original); remaining != nil {
+				t.Errorf("EncryptionKey = %x after Zeroize, want nil", remaining)
+			}
+			if !bytes.Equal(original, make([]byte, len(original))) {
+				t.Errorf("Backing array = %x after Zeroize, want all zeros", original)
+			}
+			if !bytes.Equal(saved, key) {
+				t.Error("Zeroize modified a copy of the key")
+			}
+		})
+	}
+
+	// Zeroize on a nil result must not panic
+	var nilResult *GenerateEncryptionKeyResult
+	nilResult.Zeroize()
+}
+
+func TestZeroizeInt(t *testing.T) {
+	x, _ := new(big.Int).SetString("123456789abcdef0123456789abcdef", 16)
+	words := x.Bits()
+
+	zeroizeInt(x)
+
+	if x.Sign() != 0 {
+		t.Errorf("zeroizeInt() left x = %v, want 0", x)
+	}
+	for i, w := range words {
+		if w != 0 {
+			t.Errorf("word %d = %x after zeroizeInt, want 0", i, w)
+		}
+	}
+
+	zeroizeInt(nil)
+}
@@ -5,6 +5,7 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"errors"
+	"fmt"
 	"math/big"
 
 	"golang.org/x/crypto/curve25519"
@@ -434,16 +435,44 @@ func IsValidPoint(p *Point4D) bool {
 	return !PointEqual(eightP, ZeroPoint)
 }
 
+// ProductionDST is the hash-to-curve domain separation tag of protocol version 1.
+// Version 1 predates domain separation and hashes its input untagged, so the
+// production tag is empty; keys registered with it stay recoverable.
+const ProductionDST = ""
+
 // H computes the hash function H(UID, DID, BID, pin) -> Point
 func H(uid, did, bid, pin []byte) *Point4D {
+	return HWithDST(ProductionDST, uid, did, bid, pin)
+}
+
+// HWithDST is H under the domain separation tag dst. A different tag maps the
+// same inputs to an unrelated point, and so derives a different key: the tag
+// must be fixed per protocol version and recorded with the backup.
+func HWithDST(dst string, uid, did, bid, pin []byte) *Point4D {
 	// Concatenate all inputs with length prefixes (matching Python implementation)
 	data := prefixed(uid)
 	data = append(data, prefixed(did)...)
 	data = append(data, prefixed(bid)...)
 	data = append(data, pin...)
+	return hashToPointDST(dst, data)
+}
 
-	// Hash and convert to point
-	hash := Sha256Hash(data)
+// hashToPoint hashes data to a point of the prime-order subgroup under ProductionDST
+func hashToPoint(data []byte) *Point4D {
+	return hashToPointDST(ProductionDST, data)
+}
+
+// hashToPointDST hashes data to a point of the prime-order subgroup. The empty
+// tag hashes data with plain SHA-256, as protocol version 1 does; any other tag
+// uses expand_message_xmd of RFC 9380 with the tag appended, whose input to the
+// final SHA-256 is never the untagged encoding of an identity and PIN.
+func hashToPointDST(dst string, data []byte) *Point4D {
+	var hash []byte
+	if dst == "" {
+		hash = Sha256Hash(data)
+	} else {
+		hash = expandMessageXMD(data, []byte(dst), 32)
+	}
 
 	// Convert hash to big integer and extract sign bit (matching Python)
 	yBase := new(big.Int).SetBytes(reverseBytes(hash)) // little-endian
@@ -476,16 +505,62 @@ func H(uid, did, bid, pin []byte) *Point4D {
 	return G
 }
 
+// expandMessageXMD is expand_message_xmd of RFC 9380, section 5.3.1, with
+// SHA-256. It returns length bytes, at most 255 blocks, derived from msg under the
+// domain separation tag dst; tags longer than 255 bytes are hashed first, as the
+// RFC specifies.
+func expandMessageXMD(msg, dst []byte, length int) []byte {
+	if len(dst) > 255 {
+		dst = Sha256Hash(append([]byte("H2C-OVERSIZE-DST-"), dst...))
+	}
+	dstPrime := append(append([]byte(nil), dst...), byte(len(dst)))
+	ell := (length + sha256.Size - 1) / sha256.Size
+	if ell > 255 {
+		panic("expand_message_xmd output too long")
+	}
+
+	h := sha256.New()
+	h.Write(make([]byte, sha256.BlockSize)) // Z_pad
+	h.Write(msg)
+	h.Write([]byte{byte(length >> 8), byte(length), 0})
+	h.Write(dstPrime)
+	b0 := h.Sum(nil)
+
+	out := make([]byte, 0, ell*sha256.Size)
+	b := make([]byte, sha256.Size) // b_0 XOR b_(i-1), with b_0 alone for b_1
+	for i := 1; i <= ell; i++ {
+		for j := range b {
+			b[j] ^= b0[j]
+		}
+		h.Reset()
+		h.Write(b)
+		h.Write([]byte{byte(i)})
+		h.Write(dstPrime)
+		b = h.Sum(nil)
+		out = append(out, b...)
+	}
+	return out[:length]
+}
+
 // DeriveEncKey derives an encryption key from a point
 func DeriveEncKey(p *Point4D) []byte {
+	return DeriveEncKeyWithLength(p, 32)
+}
+
+// DeriveEncKeyWithLength derives an encryption key of length bytes from a point.
+// The 32-byte key is the one DeriveEncKey has always returned; other lengths use
+// their own HKDF info, so a shorter key is not a prefix of a longer one.
+func DeriveEncKeyWithLength(p *Point4D, length int) []byte {
 	compressed := PointCompress(p)
 
-	// Use HKDF to derive a 32-byte key
 	salt := []byte("OpenADP-EncKey-v1")
 	info := []byte("AES-256-GCM")
+	if length != 32 {
+		info = []byte(fmt.Sprintf("OpenADP-EncKey-%d", length))
+	}
 
 	hkdf := hkdf.New(sha256.New, compressed, salt, info)
-	key := make([]byte, 32)
+	key := make([]byte, length)
 	hkdf.Read(key)
 
 	return key
@@ -290,3 +290,18 @@ func TestServerFixProductionValues(t *testing.T) {
 		t.Logf("Fixed result:  x=%s, y=%s", fixedX, fixedY)
 	}
 }
+
+func TestDeriveEncKeyWithLength(t *testing.T) {
+	point := PointMul(big.NewInt(424242), G)
+
+	if !bytes.Equal(DeriveEncKeyWithLength(point, 32), DeriveEncKey(point)) {
+		t.Error("32-byte key differs from DeriveEncKey")
+	}
+	short := DeriveEncKeyWithLength(point, 16)
+	if len(short) != 16 {
+		t.Fatalf("Key is %d bytes, want 16", len(short))
+	}
+	if bytes.Equal(short, DeriveEncKey(point)[:16]) {
+		t.Error("16-byte key is a prefix of the 32-byte key")
+	}
+}
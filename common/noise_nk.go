@@ -85,9 +85,46 @@ func GetDeterministicEphemeralSecret() []byte {
 	return ephemeralSecret
 }
 
+// DefaultNoiseSuite is the cipher suite used by NewNoiseNK, and the only one spoken
+// by servers that do not advertise their suites
+const DefaultNoiseSuite = "25519_AESGCM_SHA256"
+
+// noiseCiphers and noiseHashes are the cipher and hash functions a suite may name
+var (
+	noiseCiphers = []noise.CipherFunc{noise.CipherAESGCM, noise.CipherChaChaPoly}
+	noiseHashes  = []noise.HashFunc{noise.HashSHA256, noise.HashSHA512, noise.HashBLAKE2s, noise.HashBLAKE2b}
+)
+
+// NoiseSuites returns the names of every cipher suite NewNoiseNKWithSuite accepts,
+// in the form "25519_<cipher>_<hash>", starting with DefaultNoiseSuite
+func NoiseSuites() []string {
+	var suites []string
+	for _, cipher := range noiseCiphers {
+		for _, hash := range noiseHashes {
+			suites = append(suites, string(noise.NewCipherSuite(noise.DH25519, cipher, hash).Name()))
+		}
+	}
+	return suites
+}
+
+// ParseNoiseSuite returns the cipher suite with the given name, such as
+// "25519_ChaChaPoly_BLAKE2s". Only Curve25519 is supported for key agreement.
+func ParseNoiseSuite(name string) (noise.CipherSuite, error) {
+	for _, cipher := range noiseCiphers {
+		for _, hash := range noiseHashes {
+			suite := noise.NewCipherSuite(noise.DH25519, cipher, hash)
+			if string(suite.Name()) == name {
+				return suite, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("unsupported Noise cipher suite %q", name)
+}
+
 // NoiseNK represents a Noise-NK protocol handler
 type NoiseNK struct {
 	role              string
+	cipherSuite       noise.CipherSuite
 	isInitiator       bool
 	prologue          []byte
 	handshakeComplete bool
@@ -99,6 +136,7 @@ type NoiseNK struct {
 	handshakeHash     []byte
 	readMessage       bool
 	wroteMessage      bool
+	random            io.Reader // Source of the ephemeral key; nil uses crypto/rand
 }
 
 // DebugRandomReader provides deterministic randomness for debug mode
@@ -127,14 +165,26 @@ func (dr *DebugRandomReader) Read(p []byte) (int, error) {
 	return rand.Read(p)
 }
 
-// NewNoiseNK creates a new Noise-NK endpoint
+// NewNoiseNK creates a new Noise-NK endpoint using DefaultNoiseSuite
 func NewNoiseNK(role string, localStaticKey *noise.DHKey, remoteStaticKey []byte, prologue []byte) (*NoiseNK, error) {
+	return NewNoiseNKWithSuite(role, localStaticKey, remoteStaticKey, prologue, DefaultNoiseSuite)
+}
+
+// NewNoiseNKWithSuite creates a new Noise-NK endpoint using the named cipher suite
+// (see NoiseSuites). Both ends of a handshake must use the same suite.
+func NewNoiseNKWithSuite(role string, localStaticKey *noise.DHKey, remoteStaticKey []byte, prologue []byte, suite string) (*NoiseNK, error) {
 	if role != "initiator" && role != "responder" {
 		return nil, errors.New("role must be 'initiator' or 'responder'")
 	}
 
+	cipherSuite, err := ParseNoiseSuite(suite)
+	if err != nil {
+		return nil, err
+	}
+
 	nk := &NoiseNK{
 		role:        role,
+		cipherSuite: cipherSuite,
 		isInitiator: role == "initiator",
 		prologue:    prologue,
 	}
@@ -172,15 +222,18 @@ func NewNoiseNK(role string, localStaticKey *noise.DHKey, remoteStaticKey []byte
 func (nk *NoiseNK) initializeHandshake() error {
 	// Create random reader (deterministic in debug mode)
 	var randomReader io.Reader
-	if IsDebugModeEnabled() {
+	switch {
+	case nk.random != nil:
+		randomReader = nk.random
+	case IsDebugModeEnabled():
 		randomReader = &DebugRandomReader{}
-	} else {
+	default:
 		randomReader = rand.Reader
 	}
 
 	// Create Noise config for NK pattern
 	config := noise.Config{
-		CipherSuite: noise.NewCipherSuite(noise.DH25519, noise.CipherAESGCM, noise.HashSHA256),
+		CipherSuite: nk.cipherSuite,
 		Random:      randomReader,
 		Pattern:     noise.HandshakeNK,
 		Initiator:   nk.isInitiator,
@@ -206,6 +259,18 @@ func (nk *NoiseNK) initializeHandshake() error {
 	return nil
 }
 
+// SetRandom replaces the source from which the ephemeral key is drawn. It must be
+// called before any handshake message is written or read. It exists for test
+// servers that simulate broken implementations reusing their ephemeral key;
+// real endpoints must keep the default.
+func (nk *NoiseNK) SetRandom(random io.Reader) error {
+	if nk.readMessage || nk.wroteMessage {
+		return errors.New("cannot replace the randomness of a handshake in progress")
+	}
+	nk.random = random
+	return nk.initializeHandshake()
+}
+
 // GetPublicKey returns this party's static public key as bytes
 func (nk *NoiseNK) GetPublicKey() []byte {
 	if nk.isInitiator {
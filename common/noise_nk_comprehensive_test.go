@@ -1073,3 +1073,55 @@ func TestNoiseNKHandshakeMessageTracking(t *testing.T) {
 		t.Error("Responder handshake should be complete")
 	}
 }
+
+func TestNoiseNKSuites(t *testing.T) {
+	suites := NoiseSuites()
+	if len(suites) == 0 || suites[0] != DefaultNoiseSuite {
+		t.Fatalf("NoiseSuites() = %v, want %s first", suites, DefaultNoiseSuite)
+	}
+
+	responderKeypair, err := GenerateKeypair()
+	if err != nil {
+		t.Fatalf("Failed to generate keypair: %v", err)
+	}
+
+	for _, suite := range suites {
+		t.Run(suite, func(t *testing.T) {
+			initiator, err := NewNoiseNKWithSuite("initiator", nil, responderKeypair.Public, nil, suite)
+			if err != nil {
+				t.Fatalf("Failed to create initiator: %v", err)
+			}
+			responder, err := NewNoiseNKWithSuite("responder", &responderKeypair, nil, nil, suite)
+			if err != nil {
+				t.Fatalf("Failed to create responder: %v", err)
+			}
+
+			msg1, _ := initiator.WriteHandshakeMessage(nil)
+			if _, err := responder.ReadHandshakeMessage(msg1); err != nil {
+				t.Fatalf("Responder failed to read handshake message: %v", err)
+			}
+			msg2, _ := responder.WriteHandshakeMessage(nil)
+			if _, err := initiator.ReadHandshakeMessage(msg2); err != nil {
+				t.Fatalf("Initiator failed to read handshake message: %v", err)
+			}
+
+			ciphertext, _ := initiator.Encrypt([]byte("hello"), nil)
+			plaintext, err := responder.Decrypt(ciphertext, nil)
+			if err != nil || string(plaintext) != "hello" {
+				t.Errorf("Transport message did not round-trip: %v", err)
+			}
+		})
+	}
+
+	// Mismatched suites cannot complete a handshake
+	initiator, _ := NewNoiseNKWithSuite("initiator", nil, responderKeypair.Public, nil, "25519_ChaChaPoly_SHA256")
+	responder, _ := NewNoiseNK("responder", &responderKeypair, nil, nil)
+	msg1, _ := initiator.WriteHandshakeMessage(nil)
+	if _, err := responder.ReadHandshakeMessage(msg1); err == nil {
+		t.Error("Handshake with mismatched suites succeeded")
+	}
+
+	if _, err := NewNoiseNKWithSuite("initiator", nil, responderKeypair.Public, nil, "448_AESGCM_SHA256"); err == nil {
+		t.Error("NewNoiseNKWithSuite() accepted an unsupported suite")
+	}
+}
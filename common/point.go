@@ -0,0 +1,91 @@
+package common
+
+import "math/big"
+
+// Point is an element of the Ed25519 group used by the OpenADP OPRF, for building
+// custom protocols on the same primitives. The zero value is the identity element.
+// Points are immutable; operations return new points.
+type Point struct {
+	p *Point4D
+}
+
+// BasePoint returns the Ed25519 base point G
+func BasePoint() Point {
+	return Point{G}
+}
+
+// HashToPoint hashes data to a point of the prime-order subgroup, using the same
+// mapping as H: H(uid, did, bid, pin) equals HashToPoint of the length-prefixed
+// UID, DID and BID followed by the PIN.
+func HashToPoint(data []byte) Point {
+	return Point{hashToPoint(data)}
+}
+
+// HashToPointWithDST is HashToPoint under the domain separation tag dst; see
+// HWithDST. HashToPointWithDST(ProductionDST, data) equals HashToPoint(data).
+func HashToPointWithDST(dst string, data []byte) Point {
+	return Point{hashToPointDST(dst, data)}
+}
+
+// PointFromBytes decodes a 32-byte compressed point as produced by Bytes. It
+// rejects encodings that are not on the curve, non-canonical y coordinates, and
+// low-order points, including the identity. A point that passes may still have a
+// small-order component; multiply by 8 to clear it if the protocol needs a point
+// of the prime-order subgroup.
+func PointFromBytes(data []byte) (Point, error) {
+	p, err := PointDecompress(data)
+	if err != nil {
+		return Point{}, err
+	}
+	return Point{p}, nil
+}
+
+// Add returns p + q
+func (p Point) Add(q Point) Point {
+	return Point{PointAdd(p.point4D(), q.point4D())}
+}
+
+// ScalarMult returns scalar · p. A negative scalar multiplies the negation of p
+// by its absolute value, so for points of the prime-order subgroup the result is
+// that of the scalar reduced mod Q.
+func (p Point) ScalarMult(scalar *big.Int) Point {
+	if scalar.Sign() < 0 {
+		return p.Neg().ScalarMult(new(big.Int).Neg(scalar))
+	}
+	return Point{PointMul(scalar, p.point4D())}
+}
+
+// Neg returns -p
+func (p Point) Neg() Point {
+	q := p.point4D()
+	return Point{&Point4D{
+		X: new(big.Int).Mod(new(big.Int).Neg(q.X), P),
+		Y: new(big.Int).Set(q.Y),
+		Z: new(big.Int).Set(q.Z),
+		T: new(big.Int).Mod(new(big.Int).Neg(q.T), P),
+	}}
+}
+
+// Equal reports whether p and q are the same point
+func (p Point) Equal(q Point) bool {
+	return PointEqual(p.point4D(), q.point4D())
+}
+
+// IsIdentity reports whether p is the identity element
+func (p Point) IsIdentity() bool {
+	return PointEqual(p.point4D(), ZeroPoint)
+}
+
+// Bytes returns the 32-byte compressed encoding of p
+func (p Point) Bytes() []byte {
+	return PointCompress(p.point4D())
+}
+
+// point4D returns the extended coordinates of p, mapping the zero value to the
+// identity
+func (p Point) point4D() *Point4D {
+	if p.p == nil {
+		return ZeroPoint
+	}
+	return p.p
+}
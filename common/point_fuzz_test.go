@@ -0,0 +1,39 @@
+package common
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+)
+
+// FuzzPointFromBytes decodes arbitrary bytes as a point. Decoding must never
+// panic, and every accepted encoding must be the canonical encoding of a point
+// outside the small-order subgroup.
+func FuzzPointFromBytes(f *testing.F) {
+	f.Add(BasePoint().Bytes())
+	f.Add(HashToPoint([]byte("seed")).Bytes())
+	f.Add([]byte{})                                                              // Empty
+	f.Add(BasePoint().Bytes()[:31])                                              // Truncated
+	f.Add(append(BasePoint().Bytes(), 0))                                        // Oversized
+	f.Add(make([]byte, 32))                                                      // y = 0, a point of order 4
+	f.Add(append([]byte{1}, make([]byte, 31)...))                                // The identity
+	f.Add(bytes.Repeat([]byte{0xff}, 32))                                        // y >= p with the sign bit set
+	f.Add(append(bytes.Repeat([]byte{0xff}, 31), 0x7f))                          // y = 2^255 - 1, not reduced
+	f.Add(append([]byte{0xee}, append(bytes.Repeat([]byte{0xff}, 30), 0x7f)...)) // y = p
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		point, err := PointFromBytes(data)
+		if err != nil {
+			return
+		}
+		if len(data) != 32 {
+			t.Fatalf("PointFromBytes accepted %d bytes", len(data))
+		}
+		if encoded := point.Bytes(); !bytes.Equal(encoded, data) {
+			t.Fatalf("PointFromBytes(%x) accepted a non-canonical encoding of %x", data, encoded)
+		}
+		if point.IsIdentity() || point.ScalarMult(big.NewInt(8)).IsIdentity() {
+			t.Fatalf("PointFromBytes(%x) accepted a small-order point", data)
+		}
+	})
+}
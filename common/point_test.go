@@ -0,0 +1,207 @@
+package common
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha512"
+	"encoding/hex"
+	"math/big"
+	"testing"
+)
+
+func TestPointIdentity(t *testing.T) {
+	var identity Point
+	g := BasePoint()
+
+	if !identity.IsIdentity() {
+		t.Error("Zero value is not the identity")
+	}
+	if !g.Add(identity).Equal(g) || !identity.Add(g).Equal(g) {
+		t.Error("G + 0 != G")
+	}
+	if !g.ScalarMult(Q).IsIdentity() {
+		t.Error("q · G is not the identity")
+	}
+	if !g.ScalarMult(big.NewInt(0)).IsIdentity() {
+		t.Error("0 · G is not the identity")
+	}
+	if !g.ScalarMult(new(big.Int).Sub(Q, big.NewInt(1))).Add(g).IsIdentity() {
+		t.Error("(q-1) · G + G is not the identity")
+	}
+	if !g.ScalarMult(big.NewInt(-1)).Equal(g.Neg()) || !g.Neg().Add(g).IsIdentity() {
+		t.Error("-1 · G + G is not the identity")
+	}
+	if !g.ScalarMult(big.NewInt(-5)).Equal(g.ScalarMult(new(big.Int).Sub(Q, big.NewInt(5)))) {
+		t.Error("-5 · G != (q-5) · G")
+	}
+	h := HashToPoint([]byte("negative"))
+	if !h.ScalarMult(big.NewInt(-12345)).Add(h.ScalarMult(big.NewInt(12345))).IsIdentity() {
+		t.Error("-k · H + k · H is not the identity")
+	}
+
+	want := make([]byte, 32)
+	want[0] = 1
+	if !bytes.Equal(identity.Bytes(), want) {
+		t.Errorf("Identity encodes as %x, want %x", identity.Bytes(), want)
+	}
+}
+
+func TestPointVectors(t *testing.T) {
+	// The standard encoding of the Ed25519 base point
+	if got := hex.EncodeToString(BasePoint().Bytes()); got != "5866666666666666666666666666666666666666666666666666666666666666" {
+		t.Errorf("BasePoint().Bytes() = %s", got)
+	}
+
+	// An Ed25519 public key is the clamped SHA-512 of the seed times G
+	seed := bytes.Repeat([]byte{7}, ed25519.SeedSize)
+	public := ed25519.NewKeyFromSeed(seed).Public().(ed25519.PublicKey)
+	h := sha512.Sum512(seed)
+	h[0] &= 248
+	h[31] &= 127
+	h[31] |= 64
+	scalar := new(big.Int).SetBytes(reverseBytes(h[:32]))
+	if got := BasePoint().ScalarMult(scalar).Bytes(); !bytes.Equal(got, public) {
+		t.Errorf("ScalarMult() = %x, want the Ed25519 public key %x", got, []byte(public))
+	}
+
+	// HashToPoint is fixed by the protocol, and H is built on it
+	if got := hex.EncodeToString(HashToPoint([]byte("OpenADP")).Bytes()); got != "5315bae1d4ac0d9e2ddb7bb6b7f19e8f90d814a822791e84d3b0432a09727bc4" {
+		t.Errorf("HashToPoint() = %s", got)
+	}
+	data := append(prefixed([]byte("uid")), prefixed([]byte("did"))...)
+	data = append(data, prefixed([]byte("bid"))...)
+	data = append(data, []byte("pin")...)
+	if !HashToPoint(data).Equal(Point{H([]byte("uid"), []byte("did"), []byte("bid"), []byte("pin"))}) {
+		t.Error("HashToPoint() does not match H")
+	}
+
+	// Points round trip through their encoding
+	p := HashToPoint([]byte("round trip")).ScalarMult(big.NewInt(12345))
+	decoded, err := PointFromBytes(p.Bytes())
+	if err != nil {
+		t.Fatalf("PointFromBytes() error: %v", err)
+	}
+	if !decoded.Equal(p) {
+		t.Error("Decoded point differs")
+	}
+}
+
+func TestHashToPointDST(t *testing.T) {
+	// The production tag is fixed by protocol version 1, which hashes untagged
+	const production = "5315bae1d4ac0d9e2ddb7bb6b7f19e8f90d814a822791e84d3b0432a09727bc4"
+	if got := hex.EncodeToString(HashToPointWithDST(ProductionDST, []byte("OpenADP")).Bytes()); got != production {
+		t.Errorf("HashToPointWithDST(ProductionDST) = %s, want %s", got, production)
+	}
+	uid, did, bid, pin := []byte("uid"), []byte("did"), []byte("bid"), []byte("pin")
+	if !PointEqual(HWithDST(ProductionDST, uid, did, bid, pin), H(uid, did, bid, pin)) {
+		t.Error("HWithDST(ProductionDST) does not match H")
+	}
+
+	// Any other tag maps the same input elsewhere, and tags cannot be shifted
+	// into the data
+	points := map[string]Point{}
+	for _, tc := range []struct{ dst, data string }{
+		{ProductionDST, "OpenADP"},
+		{"OpenADP-v2", "OpenADP"},
+		{"OpenADP-v3", "OpenADP"},
+		{"OpenADP-v2O", "penADP"},
+	} {
+		p := HashToPointWithDST(tc.dst, []byte(tc.data))
+		if !IsValidPoint(p.p) {
+			t.Errorf("HashToPointWithDST(%q, %q) is not in the prime-order subgroup", tc.dst, tc.data)
+		}
+		for name, q := range points {
+			if p.Equal(q) {
+				t.Errorf("HashToPointWithDST(%q, %q) collides with %s", tc.dst, tc.data, name)
+			}
+		}
+		points[tc.dst+"|"+tc.data] = p
+	}
+	if PointEqual(HWithDST("OpenADP-v2", uid, did, bid, pin), H(uid, did, bid, pin)) {
+		t.Error("HWithDST() ignores the tag")
+	}
+
+	// Prefixing the tag to the untagged encoding would make it one more field:
+	// the tagged hash of (uid, did, bid, pin) must differ from the untagged hash
+	// with the tag as UID and the fields shifted along
+	shifted := append(prefixed(bid), pin...)
+	if PointEqual(HWithDST("OpenADP-v2", uid, did, bid, pin), H([]byte("OpenADP-v2"), uid, did, shifted)) {
+		t.Error("The tagged domain collides with the production domain")
+	}
+}
+
+func TestExpandMessageXMD(t *testing.T) {
+	// Test vectors of RFC 9380, appendix K.1
+	const dst = "QUUX-V01-CS02-with-expander-SHA256-128"
+	tests := []struct {
+		msg    string
+		length int
+		want   string
+	}{
+		{"", 0x20, "68a985b87eb6b46952128911f2a4412bbc302a9d759667f87f7a21d803f07235"},
+		{"abc", 0x20, "d8ccab23b5985ccea865c6c97b6e5b8350e794e603b4b97902f53a8a0d605615"},
+		{"", 0x80, "af84c27ccfd45d41914fdff5df25293e221afc53d8ad2ac06d5e3e29485dadbee0d121587713a3e0dd4d5e69e93eb7cd4f5df4cd103e188cf60cb02edc3edf18eda8576c412b18ffb658e3dd6ec849469b979d444cf7b26911a08e63cf31f9dcc541708d3491184472c2c29bb749d4286b004ceb5ee6b9a7fa5b646c993f0ced"},
+	}
+
+	for _, tt := range tests {
+		if got := hex.EncodeToString(expandMessageXMD([]byte(tt.msg), []byte(dst), tt.length)); got != tt.want {
+			t.Errorf("expandMessageXMD(%q, %d) = %s, want %s", tt.msg, tt.length, got, tt.want)
+		}
+	}
+}
+
+func TestPointFromBytesRejectsInvalid(t *testing.T) {
+	identity := make([]byte, 32)
+	identity[0] = 1
+	minusOne := bytes.Repeat([]byte{0xff}, 32) // (0, -1), of order 2
+	minusOne[0] = 0xec
+	minusOne[31] = 0x7f
+	nonCanonical := bytes.Repeat([]byte{0xff}, 32)
+	nonCanonical[31] = 0x7f
+	offCurve := make([]byte, 32)
+	offCurve[0] = 2
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"short", make([]byte, 31)},
+		{"long", make([]byte, 33)},
+		{"identity", identity},
+		{"order 2", minusOne},
+		{"non-canonical y", nonCanonical},
+		{"not on curve", offCurve},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := PointFromBytes(tt.data); err == nil {
+				t.Errorf("PointFromBytes(%x) accepted an invalid encoding", tt.data)
+			}
+		})
+	}
+}
+
+func BenchmarkScalarMult(b *testing.B) {
+	scalar := new(big.Int).Sub(Q, big.NewInt(12345))
+	point := HashToPoint([]byte("OpenADP"))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		point.ScalarMult(scalar)
+	}
+}
+
+func BenchmarkHashToPoint(b *testing.B) {
+	data := append(prefixed([]byte("user@example.com")), prefixed([]byte("laptop"))...)
+	data = append(data, prefixed([]byte("file://archive.tar"))...)
+	data = append(data, []byte("1234")...)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		HashToPoint(data)
+	}
+}
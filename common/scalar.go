@@ -0,0 +1,41 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// ScalarSize is the length in bytes of an encoded scalar
+const ScalarSize = 32
+
+// EncodeScalar encodes a scalar modulo the group order Q as 32 bytes,
+// little-endian: the least significant byte first, as in RFC 8032 and as the
+// Python and JavaScript implementations and the servers encode share values and
+// other scalars on the wire. Every scalar has exactly one encoding. It fails for
+// negative scalars and scalars of at least Q; reduce them modulo Q first.
+func EncodeScalar(s *big.Int) ([]byte, error) {
+	if s.Sign() < 0 || s.Cmp(Q) >= 0 {
+		return nil, errors.New("scalar is not in the range [0, Q)")
+	}
+	encoded := s.FillBytes(make([]byte, ScalarSize))
+	for i, j := 0, len(encoded)-1; i < j; i, j = i+1, j-1 {
+		encoded[i], encoded[j] = encoded[j], encoded[i]
+	}
+	return encoded, nil
+}
+
+// DecodeScalar decodes a scalar encoded by EncodeScalar. It rejects encodings
+// that are not 32 bytes long and non-canonical ones, whose value is Q or more,
+// so that a scalar cannot be encoded two ways; scalars are never silently
+// reduced.
+func DecodeScalar(data []byte) (*big.Int, error) {
+	if len(data) != ScalarSize {
+		return nil, fmt.Errorf("scalar encoding must be %d bytes, got %d", ScalarSize, len(data))
+	}
+	s := new(big.Int).SetBytes(reverseBytes(data))
+	if s.Cmp(Q) >= 0 {
+		return nil, errors.New("non-canonical scalar encoding: value is not less than Q")
+	}
+	return s, nil
+}
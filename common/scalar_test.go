@@ -0,0 +1,82 @@
+package common
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"os"
+	"testing"
+)
+
+// scalarVectors is the layout of testdata/scalar_vectors.json, shared with the
+// other OpenADP implementations
+type scalarVectors struct {
+	GroupOrder string `json:"group_order"`
+	Valid      []struct {
+		Scalar   string `json:"scalar"`
+		Encoding string `json:"encoding"`
+	} `json:"valid"`
+	Invalid []struct {
+		Reason   string `json:"reason"`
+		Encoding string `json:"encoding"`
+	} `json:"invalid"`
+}
+
+func TestScalarVectors(t *testing.T) {
+	data, err := os.ReadFile("testdata/scalar_vectors.json")
+	if err != nil {
+		t.Fatalf("ReadFile() error: %v", err)
+	}
+	var vectors scalarVectors
+	if err := json.Unmarshal(data, &vectors); err != nil {
+		t.Fatalf("Unmarshal() error: %v", err)
+	}
+	if vectors.GroupOrder != Q.String() {
+		t.Fatalf("Vectors are for group order %s, want %s", vectors.GroupOrder, Q)
+	}
+
+	for _, vector := range vectors.Valid {
+		scalar, ok := new(big.Int).SetString(vector.Scalar, 10)
+		if !ok {
+			t.Fatalf("Bad scalar %q in vectors", vector.Scalar)
+		}
+		want, err := hex.DecodeString(vector.Encoding)
+		if err != nil {
+			t.Fatalf("Bad encoding %q in vectors", vector.Encoding)
+		}
+
+		encoded, err := EncodeScalar(scalar)
+		if err != nil {
+			t.Errorf("EncodeScalar(%s) error: %v", scalar, err)
+		} else if !bytes.Equal(encoded, want) {
+			t.Errorf("EncodeScalar(%s) = %x, want %x", scalar, encoded, want)
+		}
+		decoded, err := DecodeScalar(want)
+		if err != nil {
+			t.Errorf("DecodeScalar(%x) error: %v", want, err)
+		} else if decoded.Cmp(scalar) != 0 {
+			t.Errorf("DecodeScalar(%x) = %s, want %s", want, decoded, scalar)
+		}
+	}
+
+	for _, vector := range vectors.Invalid {
+		t.Run(vector.Reason, func(t *testing.T) {
+			encoding, err := hex.DecodeString(vector.Encoding)
+			if err != nil {
+				t.Fatalf("Bad encoding %q in vectors", vector.Encoding)
+			}
+			if s, err := DecodeScalar(encoding); err == nil {
+				t.Errorf("DecodeScalar(%x) = %s, want an error", encoding, s)
+			}
+		})
+	}
+}
+
+func TestEncodeScalarRejectsOutOfRange(t *testing.T) {
+	for _, s := range []*big.Int{big.NewInt(-1), Q, new(big.Int).Add(Q, big.NewInt(1)), new(big.Int).Lsh(big.NewInt(1), 256)} {
+		if encoded, err := EncodeScalar(s); err == nil {
+			t.Errorf("EncodeScalar(%s) = %x, want an error", s, encoded)
+		}
+	}
+}
@@ -0,0 +1,317 @@
+// Package conformance checks that an OpenADP server behaves as the client
+// expects, for operators deploying their own server implementation.
+//
+// RunServerConformance registers test backups on a single live server and walks
+// them through the life of a backup: status queries, recovery with the right and
+// a wrong password, rotation to a new backup, and exhaustion of the guess budget.
+// Every step reports whether the server behaved as the protocol requires.
+package conformance
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/openadp/ocrypt/client"
+)
+
+// maxGuesses is the guess budget of the backups registered for the checks. It
+// covers the guesses spent by the steps before the budget is exhausted
+// deliberately.
+const maxGuesses = 5
+
+// password protects the backups registered for the checks
+const password = "conformance-password"
+
+// Names of the steps of a conformance run, in order
+const (
+	StepReachable      = "reachable"
+	StepRegister       = "register"
+	StepStatus         = "status"
+	StepRecover        = "recover"
+	StepWrongPassword  = "wrong_password"
+	StepRotate         = "rotate"
+	StepRecoverRotated = "recover_rotated"
+	StepExhaustGuesses = "exhaust_guesses"
+)
+
+// StepResult is the outcome of one step of a conformance run
+type StepResult struct {
+	Name     string
+	Passed   bool
+	Skipped  bool   // The step did not run because a step it builds on failed, or the context ended
+	Detail   string // What went wrong, or why the step was skipped; empty if it passed
+	Duration time.Duration
+}
+
+// ConformanceReport is the result of RunServerConformance
+type ConformanceReport struct {
+	Server string
+	Steps  []StepResult // One entry per step, in the order they ran
+}
+
+// Passed reports whether every step ran and passed
+func (r ConformanceReport) Passed() bool {
+	for _, step := range r.Steps {
+		if !step.Passed {
+			return false
+		}
+	}
+	return len(r.Steps) > 0
+}
+
+// Step returns the result of the named step, and false if the report has none
+func (r ConformanceReport) Step(name string) (StepResult, bool) {
+	for _, step := range r.Steps {
+		if step.Name == name {
+			return step, true
+		}
+	}
+	return StepResult{}, false
+}
+
+// String formats the report with one line per step
+func (r ConformanceReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Conformance of %s:\n", r.Server)
+	for _, step := range r.Steps {
+		status := "PASS"
+		switch {
+		case step.Skipped:
+			status = "SKIP"
+		case !step.Passed:
+			status = "FAIL"
+		}
+		fmt.Fprintf(&b, "  %s %-16s %8s", status, step.Name, step.Duration.Round(time.Millisecond))
+		if step.Detail != "" {
+			fmt.Fprintf(&b, "  %s", step.Detail)
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// step is one check of a conformance run
+type step struct {
+	name  string
+	needs []string // Steps that must have passed for this one to run
+	run   func(ctx context.Context) error
+}
+
+// run holds the state the steps of a conformance run pass on to each other
+type run struct {
+	servers  []client.ServerInfo
+	identity *client.Identity
+
+	generated *client.GenerateEncryptionKeyResult
+	rotated   *client.RecoverAndRotateResult
+	guesses   int // Guesses the steps have spent on the backup of identity
+}
+
+// RunServerConformance runs the conformance checks against server, which must
+// accept registrations from this client. It registers two backups, with BIDs
+// "even" and "odd", under a random UID that no real user has, and leaves them
+// behind with their guesses exhausted or partly spent; nothing is deleted.
+//
+// The steps run in order, and a step is skipped if one it builds on failed:
+//
+//   - reachable: the server answers and, if server.PublicKey is set, completes
+//     a Noise-NK handshake under it
+//   - register: the server accepts a share of a backup allowing 5 guesses
+//   - status: the server lists the backup with no guess spent
+//   - recover: the server returns the share for the right password, and counts
+//     the guess
+//   - wrong_password: the server returns a share for a wrong password too, which
+//     yields a different key, and counts the guess
+//   - rotate: RecoverAndRotate registers the next backup, spending a guess
+//   - recover_rotated: the rotated backup recovers to its new key
+//   - exhaust_guesses: the server answers exactly the remaining guesses, then
+//     locks the backup, refusing even the right password
+//
+// Cancelling ctx, or reaching its deadline, skips the remaining steps.
+func RunServerConformance(ctx context.Context, server client.ServerInfo) ConformanceReport {
+	r := &run{servers: []client.ServerInfo{server}}
+	steps := []step{
+		{StepReachable, nil, r.reachable},
+		{StepRegister, []string{StepReachable}, r.register},
+		{StepStatus, []string{StepRegister}, r.status},
+		{StepRecover, []string{StepRegister}, r.recover},
+		{StepWrongPassword, []string{StepRegister}, r.wrongPassword},
+		{StepRotate, []string{StepRecover}, r.rotate},
+		{StepRecoverRotated, []string{StepRotate}, r.recoverRotated},
+		{StepExhaustGuesses, []string{StepRegister}, r.exhaustGuesses},
+	}
+
+	report := ConformanceReport{Server: server.URL}
+	passed := make(map[string]bool, len(steps))
+	for _, s := range steps {
+		result := StepResult{Name: s.name}
+		if err := ctx.Err(); err != nil {
+			result.Skipped, result.Detail = true, err.Error()
+			report.Steps = append(report.Steps, result)
+			continue
+		}
+		if missing := unmet(s.needs, passed); missing != "" {
+			result.Skipped, result.Detail = true, fmt.Sprintf("needs %s to pass", missing)
+			report.Steps = append(report.Steps, result)
+			continue
+		}
+
+		start := time.Now()
+		err := s.run(ctx)
+		result.Duration = time.Since(start)
+		if err != nil {
+			result.Detail = err.Error()
+		} else {
+			result.Passed = true
+			passed[s.name] = true
+		}
+		report.Steps = append(report.Steps, result)
+	}
+	return report
+}
+
+// unmet returns the first of needs that has not passed, or ""
+func unmet(needs []string, passed map[string]bool) string {
+	for _, name := range needs {
+		if !passed[name] {
+			return name
+		}
+	}
+	return ""
+}
+
+// options returns the options for an operation under ctx
+func options(ctx context.Context) *client.Options {
+	opts := &client.Options{Threshold: 1}
+	if deadline, ok := ctx.Deadline(); ok {
+		opts.Timeouts.Overall = time.Until(deadline)
+	}
+	return opts
+}
+
+func (r *run) reachable(ctx context.Context) error {
+	probe := client.ProbeServers(ctx, r.servers)[0]
+	switch {
+	case !probe.Reachable:
+		return fmt.Errorf("server did not answer: %s", probe.Error)
+	case probe.HandshakeError != "":
+		return fmt.Errorf("Noise-NK handshake failed: %s", probe.HandshakeError)
+	case probe.KeyMismatch:
+		return errors.New("server reports a different public key than the one configured")
+	}
+	return nil
+}
+
+func (r *run) register(ctx context.Context) error {
+	uid := make([]byte, 8)
+	if _, err := rand.Read(uid); err != nil {
+		return err
+	}
+	r.identity = &client.Identity{UID: "conformance-" + hex.EncodeToString(uid), DID: "conformance", BID: "even"}
+
+	r.generated = client.GenerateEncryptionKeyWithOpts(r.identity, password, maxGuesses, 0, r.servers, options(ctx))
+	if r.generated.Error != "" {
+		return fmt.Errorf("registration failed: %s", r.generated.Error)
+	}
+	return nil
+}
+
+func (r *run) status(ctx context.Context) error {
+	return r.expectGuesses(0)
+}
+
+func (r *run) recover(ctx context.Context) error {
+	result := client.RecoverEncryptionKeyWithOpts(r.identity, password, r.servers, 1, r.generated.AuthCodes, options(ctx))
+	if result.Error != "" {
+		return fmt.Errorf("recovery failed: %s", result.Error)
+	}
+	r.guesses++
+	if !client.SecureEqual(result.EncryptionKey, r.generated.EncryptionKey) {
+		return errors.New("recovered key differs from the registered one")
+	}
+	return r.expectGuesses(r.guesses)
+}
+
+func (r *run) wrongPassword(ctx context.Context) error {
+	result := client.RecoverEncryptionKeyWithOpts(r.identity, "wrong-"+password, r.servers, 1, r.generated.AuthCodes, options(ctx))
+	if result.Error != "" {
+		return fmt.Errorf("recovery failed, but a server cannot tell a wrong password apart: %s", result.Error)
+	}
+	r.guesses++
+	if client.SecureEqual(result.EncryptionKey, r.generated.EncryptionKey) {
+		return errors.New("a wrong password recovered the registered key")
+	}
+	return r.expectGuesses(r.guesses)
+}
+
+func (r *run) rotate(ctx context.Context) error {
+	r.rotated = client.RecoverAndRotate(r.identity, password, r.servers, 1, r.generated.AuthCodes)
+	if r.rotated.Error != "" {
+		return fmt.Errorf("recovery failed: %s", r.rotated.Error)
+	}
+	r.guesses++
+	if !r.rotated.Rotated {
+		return fmt.Errorf("rotation failed: %s", r.rotated.RotationError)
+	}
+	return nil
+}
+
+func (r *run) recoverRotated(ctx context.Context) error {
+	metadata := r.rotated.Metadata
+	identity := metadata.Identity(r.identity.UID)
+	result := client.RecoverEncryptionKeyWithOpts(identity, password, client.MetadataToServerInfo(metadata), metadata.Threshold, metadata.AuthCodes, options(ctx))
+	if result.Error != "" {
+		return fmt.Errorf("recovery of the rotated backup failed: %s", result.Error)
+	}
+	if !client.SecureEqual(result.EncryptionKey, r.rotated.NewEncryptionKey) {
+		return errors.New("rotated backup recovered a different key than it was registered with")
+	}
+	return nil
+}
+
+func (r *run) exhaustGuesses(ctx context.Context) error {
+	// Earlier steps may have failed after spending their guess, so start from
+	// the counter the server reports
+	status, err := client.QueryBackupStatus(r.identity, r.servers, r.generated.AuthCodes)
+	if err != nil {
+		return fmt.Errorf("status query failed: %v", err)
+	}
+	for spent := status.Servers[0].NumGuesses; spent < maxGuesses; spent++ {
+		result := client.RecoverEncryptionKeyWithOpts(r.identity, "wrong-"+password, r.servers, 1, r.generated.AuthCodes, options(ctx))
+		if result.Error != "" {
+			return fmt.Errorf("guess %d of %d was refused: %s", spent+1, maxGuesses, result.Error)
+		}
+	}
+
+	result := client.RecoverEncryptionKeyWithOpts(r.identity, password, r.servers, 1, r.generated.AuthCodes, options(ctx))
+	if result.Error == "" {
+		return fmt.Errorf("recovery succeeded after all %d guesses were spent", maxGuesses)
+	}
+	if result.ErrCode != client.ErrGuessesExhausted {
+		return fmt.Errorf("recovery after all %d guesses were spent failed with %s, want %s: %s", maxGuesses, result.ErrCode, client.ErrGuessesExhausted, result.Error)
+	}
+	return nil
+}
+
+// expectGuesses checks that the server reports want guesses spent on the backup
+func (r *run) expectGuesses(want int) error {
+	status, err := client.QueryBackupStatus(r.identity, r.servers, r.generated.AuthCodes)
+	if err != nil {
+		return fmt.Errorf("status query failed: %v", err)
+	}
+	server := status.Servers[0]
+	switch {
+	case !server.Found:
+		return fmt.Errorf("server does not list the backup: %s", server.Error)
+	case server.MaxGuesses != maxGuesses:
+		return fmt.Errorf("server reports a budget of %d guesses, registered with %d", server.MaxGuesses, maxGuesses)
+	case server.NumGuesses != want:
+		return fmt.Errorf("server reports %d guesses spent, want %d", server.NumGuesses, want)
+	}
+	return nil
+}
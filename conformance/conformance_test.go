@@ -0,0 +1,98 @@
+package conformance
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/openadp/ocrypt/client"
+	"github.com/openadp/ocrypt/testserver"
+)
+
+func serverInfo(server *testserver.Server) client.ServerInfo {
+	return client.ServerInfo{URL: server.URL, PublicKey: server.PublicKeyString()}
+}
+
+func TestRunServerConformance(t *testing.T) {
+	server := testserver.New()
+	defer server.Close()
+
+	report := RunServerConformance(context.Background(), serverInfo(server))
+	if !report.Passed() {
+		t.Fatalf("Conforming server failed:\n%s", report)
+	}
+	want := []string{StepReachable, StepRegister, StepStatus, StepRecover, StepWrongPassword, StepRotate, StepRecoverRotated, StepExhaustGuesses}
+	if len(report.Steps) != len(want) {
+		t.Fatalf("Report has %d steps, want %d", len(report.Steps), len(want))
+	}
+	for i, name := range want {
+		if report.Steps[i].Name != name {
+			t.Errorf("Step %d = %s, want %s", i, report.Steps[i].Name, name)
+		}
+	}
+	if !strings.Contains(report.String(), "PASS exhaust_guesses") {
+		t.Errorf("String() = %q, want a line per step", report.String())
+	}
+}
+
+func TestRunServerConformanceFailures(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(server *testserver.Server)
+		ctx     func() context.Context
+		failed  []string
+		skipped []string
+	}{
+		{
+			name:    "unreachable",
+			setup:   func(server *testserver.Server) { server.SetFault("Echo", testserver.FaultUnavailable) },
+			failed:  []string{StepReachable},
+			skipped: []string{StepRegister, StepStatus, StepRecover, StepRotate, StepExhaustGuesses},
+		},
+		{
+			// A counter that lags behind misleads users about their remaining guesses
+			name:    "stale guess counter",
+			setup:   func(server *testserver.Server) { server.SetFault("ListBackups", testserver.FaultStaleGuessCounter) },
+			failed:  []string{StepRecover, StepWrongPassword},
+			skipped: []string{StepRotate, StepRecoverRotated},
+		},
+		{
+			name: "cancelled",
+			ctx: func() context.Context {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				return ctx
+			},
+			skipped: []string{StepReachable, StepRegister, StepExhaustGuesses},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := testserver.New()
+			defer server.Close()
+			if tt.setup != nil {
+				tt.setup(server)
+			}
+			ctx := context.Background()
+			if tt.ctx != nil {
+				ctx = tt.ctx()
+			}
+
+			report := RunServerConformance(ctx, serverInfo(server))
+			if report.Passed() {
+				t.Fatalf("Server passed:\n%s", report)
+			}
+			for _, name := range tt.failed {
+				if step, _ := report.Step(name); step.Passed || step.Skipped || step.Detail == "" {
+					t.Errorf("Step %s = %+v, want a failure with a reason", name, step)
+				}
+			}
+			for _, name := range tt.skipped {
+				if step, _ := report.Step(name); !step.Skipped {
+					t.Errorf("Step %s = %+v, want it skipped", name, step)
+				}
+			}
+		})
+	}
+}
@@ -5,6 +5,7 @@ go 1.23.0
 require (
 	github.com/flynn/noise v1.1.0
 	golang.org/x/crypto v0.39.0
+	golang.org/x/text v0.26.0
 )
 
 require golang.org/x/sys v0.33.0 // indirect
@@ -0,0 +1,111 @@
+package ocrypt
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/openadp/ocrypt/client"
+)
+
+// namedBackupPrefix prefixes the backup ID of named backups, so they never collide
+// with the "even" and "odd" backups of Register
+const namedBackupPrefix = "named:"
+
+// BackupManifest holds several independently protected secrets of one user and
+// application, each registered under its own name and backup ID. Each entry is
+// self-describing metadata as returned by RegisterWithServers, recording the backup
+// ID, servers, threshold and auth code, so recovering an entry needs only the
+// manifest and that entry's password.
+//
+// A manifest is serialized with encoding/json and parsed with ParseBackupManifest.
+// It is not safe for concurrent use.
+type BackupManifest struct {
+	UserID  string               `json:"user_id"`
+	AppID   string               `json:"app_id"`
+	Version string               `json:"version"`
+	Backups map[string]*Metadata `json:"backups"`
+}
+
+// NewBackupManifest returns an empty manifest for userID and appID
+func NewBackupManifest(userID, appID string) *BackupManifest {
+	return &BackupManifest{UserID: userID, AppID: appID, Version: "1.0", Backups: make(map[string]*Metadata)}
+}
+
+// ParseBackupManifest parses a manifest serialized with encoding/json
+func ParseBackupManifest(data []byte) (*BackupManifest, error) {
+	var manifest BackupManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, &OcryptError{Message: fmt.Sprintf("Invalid manifest format: %v", err), Code: "INVALID_MANIFEST"}
+	}
+	if manifest.UserID == "" || manifest.AppID == "" {
+		return nil, &OcryptError{Message: "Manifest has no user_id or app_id", Code: "INVALID_MANIFEST"}
+	}
+	if manifest.Backups == nil {
+		manifest.Backups = make(map[string]*Metadata)
+	}
+	for name, metadata := range manifest.Backups {
+		if metadata == nil || metadata.UserID != manifest.UserID || metadata.AppID != manifest.AppID || metadata.BackupID != namedBackupPrefix+name {
+			return nil, &OcryptError{Message: fmt.Sprintf("Backup %q does not belong to the manifest's identity", name), Code: "INVALID_MANIFEST"}
+		}
+	}
+	return &manifest, nil
+}
+
+// RegisterNamedBackup protects secret under password as the backup name and adds it
+// to the manifest. Each name gets its own backup ID, so its secret, password and
+// guess limit are independent of the other entries. Registering an existing name
+// fails; the manifest is only modified if registration succeeds.
+func (m *BackupManifest) RegisterNamedBackup(name string, secret []byte, password string, maxGuesses int, serverInfos []client.ServerInfo) error {
+	if name == "" {
+		return &OcryptError{Message: "backup name must be a non-empty string", Code: "INVALID_INPUT"}
+	}
+	if _, exists := m.Backups[name]; exists {
+		return &OcryptError{Message: fmt.Sprintf("Backup %q already exists", name), Code: "BACKUP_EXISTS"}
+	}
+	if err := validateRegisterInput(m.UserID, m.AppID, secret, password); err != nil {
+		return err
+	}
+	if len(serverInfos) == 0 {
+		return &OcryptError{Message: "No OpenADP servers available", Code: "NO_SERVERS"}
+	}
+
+	metadataBytes, err := registerWithServerInfos(m.UserID, m.AppID, secret, password, maxGuesses, namedBackupPrefix+name, serverInfos)
+	if err != nil {
+		return err
+	}
+	var metadata Metadata
+	if err := json.Unmarshal(metadataBytes, &metadata); err != nil {
+		return &OcryptError{Message: fmt.Sprintf("Metadata serialization failed: %v", err), Code: "SERIALIZATION_FAILED"}
+	}
+
+	if m.Backups == nil {
+		m.Backups = make(map[string]*Metadata)
+	}
+	m.Backups[name] = &metadata
+	return nil
+}
+
+// Names returns the names of the manifest's backups in sorted order
+func (m *BackupManifest) Names() []string {
+	names := make([]string, 0, len(m.Backups))
+	for name := range m.Backups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RecoverNamedBackup recovers the secret of the backup name, as RecoverWithServers
+// does for its metadata. It returns the secret and the number of guesses remaining
+// on that backup.
+func (m *BackupManifest) RecoverNamedBackup(name, password string) ([]byte, int, error) {
+	metadata, ok := m.Backups[name]
+	if !ok {
+		return nil, 0, &OcryptError{Message: fmt.Sprintf("No backup named %q", name), Code: "BACKUP_NOT_FOUND"}
+	}
+	if password == "" {
+		return nil, 0, &OcryptError{Message: "pin must be a non-empty string", Code: "INVALID_INPUT"}
+	}
+	return recoverFromMetadata(metadata, password)
+}
@@ -0,0 +1,91 @@
+package ocrypt
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestBackupManifest registers and recovers several named backups under one identity
+func TestBackupManifest(t *testing.T) {
+	serverInfos := newTestServers(t, 3)
+	manifest := NewBackupManifest("alice@example.com", "vault")
+
+	entries := []struct {
+		name     string
+		secret   []byte
+		password string
+	}{
+		{"email", []byte("email secret"), "password-1"},
+		{"bank", bytes.Repeat([]byte{0x42}, 32), "password-2"},
+		{"notes", []byte("notes secret"), "password-3"},
+	}
+	for _, e := range entries {
+		if err := manifest.RegisterNamedBackup(e.name, e.secret, e.password, 5, serverInfos); err != nil {
+			t.Fatalf("RegisterNamedBackup(%q) error: %v", e.name, err)
+		}
+	}
+	if err := manifest.RegisterNamedBackup("bank", []byte("other"), "password", 5, serverInfos); err == nil || !strings.Contains(err.Error(), "BACKUP_EXISTS") {
+		t.Errorf("Registering a duplicate name: error = %v, want BACKUP_EXISTS", err)
+	}
+
+	if got, want := manifest.Names(), []string{"bank", "email", "notes"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Names() = %v, want %v", got, want)
+	}
+
+	// The manifest survives serialization
+	encoded, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatalf("Marshal() error: %v", err)
+	}
+	parsed, err := ParseBackupManifest(encoded)
+	if err != nil {
+		t.Fatalf("ParseBackupManifest() error: %v", err)
+	}
+
+	for _, e := range entries {
+		t.Run(e.name, func(t *testing.T) {
+			secret, remaining, err := parsed.RecoverNamedBackup(e.name, e.password)
+			if err != nil {
+				t.Fatalf("RecoverNamedBackup() error: %v", err)
+			}
+			if !bytes.Equal(secret, e.secret) {
+				t.Errorf("RecoverNamedBackup() = %q, want %q", secret, e.secret)
+			}
+			if remaining != 4 {
+				t.Errorf("RecoverNamedBackup() remaining = %d, want 4", remaining)
+			}
+		})
+	}
+
+	// Passwords are per backup
+	if _, _, err := parsed.RecoverNamedBackup("email", "password-2"); err == nil || !strings.Contains(err.Error(), "INVALID_PIN") {
+		t.Errorf("Recovery with another entry's password: error = %v, want INVALID_PIN", err)
+	}
+	if _, _, err := parsed.RecoverNamedBackup("missing", "password"); err == nil || !strings.Contains(err.Error(), "BACKUP_NOT_FOUND") {
+		t.Errorf("Recovery of a missing name: error = %v, want BACKUP_NOT_FOUND", err)
+	}
+}
+
+func TestParseBackupManifestInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"not json", "not json"},
+		{"no identity", `{"backups":{}}`},
+		{"null entry", `{"user_id":"u","app_id":"a","backups":{"x":null}}`},
+		{"foreign entry", `{"user_id":"u","app_id":"a","backups":{"x":{"user_id":"v","app_id":"a","backup_id":"named:x"}}}`},
+		{"wrong backup ID", `{"user_id":"u","app_id":"a","backups":{"x":{"user_id":"u","app_id":"a","backup_id":"even"}}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseBackupManifest([]byte(tt.data)); err == nil || !strings.Contains(err.Error(), "INVALID_MANIFEST") {
+				t.Errorf("ParseBackupManifest() error = %v, want INVALID_MANIFEST", err)
+			}
+		})
+	}
+}
@@ -31,7 +31,6 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
-	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -73,6 +72,10 @@ type Metadata struct {
 	AppID                 string        `json:"app_id"`
 	MaxGuesses            int           `json:"max_guesses"`
 	OcryptVersion         string        `json:"ocrypt_version"`
+
+	// ServerPublicKeys maps server URLs to their Noise-NK public keys, so that
+	// RecoverWithServers can reach the servers without consulting a registry
+	ServerPublicKeys map[string]string `json:"server_public_keys,omitempty"`
 }
 
 // WrappedSecret represents an AES-GCM encrypted secret
@@ -104,28 +107,44 @@ func Register(userID, appID string, longTermSecret []byte, pin string, maxGuesse
 	return registerWithBID(userID, appID, longTermSecret, pin, maxGuesses, "even", serversURL)
 }
 
-// registerWithBID is the internal implementation that allows specifying backup ID
-func registerWithBID(userID, appID string, longTermSecret []byte, pin string, maxGuesses int, backupID string, serversURL string) ([]byte, error) {
-	// Input validation
+// RegisterWithServers is like Register but uses the given servers instead of
+// discovering them from a registry.
+//
+// The returned metadata is self-describing: it records the servers' public keys
+// along with everything else needed by RecoverWithServers, so recovery needs only
+// the metadata and the password.
+func RegisterWithServers(userID, appID string, longTermSecret []byte, password string, maxGuesses int, serverInfos []client.ServerInfo) ([]byte, error) {
+	if err := validateRegisterInput(userID, appID, longTermSecret, password); err != nil {
+		return nil, err
+	}
+	if len(serverInfos) == 0 {
+		return nil, &OcryptError{Message: "No OpenADP servers available", Code: "NO_SERVERS"}
+	}
+	return registerWithServerInfos(userID, appID, longTermSecret, password, maxGuesses, "even", serverInfos)
+}
+
+// validateRegisterInput checks the arguments shared by all registration entry points
+func validateRegisterInput(userID, appID string, longTermSecret []byte, pin string) error {
 	if userID == "" {
-		return nil, &OcryptError{Message: "user_id must be a non-empty string", Code: "INVALID_INPUT"}
+		return &OcryptError{Message: "user_id must be a non-empty string", Code: "INVALID_INPUT"}
 	}
 	if appID == "" {
-		return nil, &OcryptError{Message: "app_id must be a non-empty string", Code: "INVALID_INPUT"}
+		return &OcryptError{Message: "app_id must be a non-empty string", Code: "INVALID_INPUT"}
 	}
 	if len(longTermSecret) == 0 {
-		return nil, &OcryptError{Message: "long_term_secret cannot be empty", Code: "INVALID_INPUT"}
+		return &OcryptError{Message: "long_term_secret cannot be empty", Code: "INVALID_INPUT"}
 	}
 	if pin == "" {
-		return nil, &OcryptError{Message: "pin must be a non-empty string", Code: "INVALID_INPUT"}
-	}
-	if maxGuesses <= 0 {
-		maxGuesses = 10 // Default value
+		return &OcryptError{Message: "pin must be a non-empty string", Code: "INVALID_INPUT"}
 	}
+	return nil
+}
 
-	fmt.Printf("🔐 Protecting secret for user: %s\n", userID)
-	fmt.Printf("📱 Application: %s\n", appID)
-	fmt.Printf("🔑 Secret length: %d bytes\n", len(longTermSecret))
+// registerWithBID is the internal implementation that allows specifying backup ID
+func registerWithBID(userID, appID string, longTermSecret []byte, pin string, maxGuesses int, backupID string, serversURL string) ([]byte, error) {
+	if err := validateRegisterInput(userID, appID, longTermSecret, pin); err != nil {
+		return nil, err
+	}
 
 	// Step 1: Discover OpenADP servers
 	fmt.Println("🌐 Discovering OpenADP servers...")
@@ -140,6 +159,19 @@ func registerWithBID(userID, appID string, longTermSecret []byte, pin string, ma
 
 	fmt.Printf("   ✅ Successfully fetched %d servers from registry\n", len(serverInfos))
 
+	return registerWithServerInfos(userID, appID, longTermSecret, pin, maxGuesses, backupID, serverInfos)
+}
+
+// registerWithServerInfos registers a validated secret with the given servers
+func registerWithServerInfos(userID, appID string, longTermSecret []byte, pin string, maxGuesses int, backupID string, serverInfos []client.ServerInfo) ([]byte, error) {
+	if maxGuesses <= 0 {
+		maxGuesses = 10 // Default value
+	}
+
+	fmt.Printf("🔐 Protecting secret for user: %s\n", userID)
+	fmt.Printf("📱 Application: %s\n", appID)
+	fmt.Printf("🔑 Secret length: %d bytes\n", len(longTermSecret))
+
 	// Step 2: Generate encryption key using OpenADP
 	fmt.Printf("🔄 Using backup ID: %s\n", backupID)
 	fmt.Println("🔑 Generating encryption key using OpenADP servers...")
@@ -152,7 +184,7 @@ func registerWithBID(userID, appID string, longTermSecret []byte, pin string, ma
 		BID: backupID, // Backup identifier (managed by Ocrypt: "even"/"odd")
 	}
 
-	result := client.GenerateEncryptionKey(identity, pin, maxGuesses, 0, serverInfos)
+	result := client.GenerateEncryptionKeyWithExpiry(identity, pin, maxGuesses, time.Time{}, serverInfos, nil)
 	if result.Error != "" {
 		return nil, &OcryptError{Message: fmt.Sprintf("OpenADP registration failed: %s", result.Error), Code: "OPENADP_FAILED"}
 	}
@@ -167,6 +199,13 @@ func registerWithBID(userID, appID string, longTermSecret []byte, pin string, ma
 	}
 
 	// Step 4: Create metadata
+	serverPublicKeys := make(map[string]string)
+	for _, serverInfo := range result.ServerInfos {
+		if serverInfo.PublicKey != "" {
+			serverPublicKeys[serverInfo.URL] = serverInfo.PublicKey
+		}
+	}
+
 	metadata := &Metadata{
 		Servers:               result.ServerURLs,
 		Threshold:             result.Threshold,
@@ -178,6 +217,7 @@ func registerWithBID(userID, appID string, longTermSecret []byte, pin string, ma
 		AppID:                 appID,
 		MaxGuesses:            maxGuesses,
 		OcryptVersion:         "1.0",
+		ServerPublicKeys:      serverPublicKeys,
 	}
 
 	metadataBytes, err := json.Marshal(metadata)
@@ -287,6 +327,63 @@ func recoverWithoutRefresh(metadataBytes []byte, pin string, serversURL string)
 		return nil, 0, &OcryptError{Message: "No servers from metadata found in registry", Code: "SERVERS_NOT_FOUND"}
 	}
 
+	secret, _, err := recoverWithServerInfos(&metadata, pin, serverInfos)
+	if err != nil {
+		return nil, 0, err
+	}
+	return secret, 0, nil
+}
+
+// RecoverWithServers recovers a secret registered with RegisterWithServers, using
+// only the servers and public keys recorded in the metadata.
+//
+// It returns the secret and the number of guesses remaining on the backup after
+// this recovery: the lowest count reported by any server, or 0 if no server could
+// report it. Unlike Recover, it does not refresh the backup.
+func RecoverWithServers(metadataBytes []byte, password string) ([]byte, int, error) {
+	if len(metadataBytes) == 0 {
+		return nil, 0, &OcryptError{Message: "metadata cannot be empty", Code: "INVALID_INPUT"}
+	}
+	if password == "" {
+		return nil, 0, &OcryptError{Message: "pin must be a non-empty string", Code: "INVALID_INPUT"}
+	}
+
+	var metadata Metadata
+	if err := json.Unmarshal(metadataBytes, &metadata); err != nil {
+		return nil, 0, &OcryptError{Message: fmt.Sprintf("Invalid metadata format: %v", err), Code: "INVALID_METADATA"}
+	}
+	return recoverFromMetadata(&metadata, password)
+}
+
+// recoverFromMetadata recovers the secret described by self-describing metadata
+// from the servers it records
+func recoverFromMetadata(metadata *Metadata, password string) ([]byte, int, error) {
+	if len(metadata.Servers) == 0 {
+		return nil, 0, &OcryptError{Message: "Metadata lists no servers", Code: "INVALID_METADATA"}
+	}
+
+	serverInfos := make([]client.ServerInfo, len(metadata.Servers))
+	for i, serverURL := range metadata.Servers {
+		serverInfos[i] = client.ServerInfo{URL: serverURL, PublicKey: metadata.ServerPublicKeys[serverURL]}
+	}
+
+	secret, authCodes, err := recoverWithServerInfos(metadata, password, serverInfos)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	remaining := 0
+	identity := &client.Identity{UID: metadata.UserID, DID: metadata.AppID, BID: metadata.BackupID}
+	if status, err := client.QueryBackupStatus(identity, serverInfos, authCodes); err == nil {
+		remaining = status.MinRemaining
+	}
+
+	return secret, remaining, nil
+}
+
+// recoverWithServerInfos recovers the secret described by metadata from the given
+// servers, returning it together with the auth codes used
+func recoverWithServerInfos(metadata *Metadata, pin string, serverInfos []client.ServerInfo) ([]byte, *client.AuthCodes, error) {
 	// Recover encryption key from OpenADP
 	fmt.Println("🔑 Recovering encryption key from OpenADP servers...")
 
@@ -299,21 +396,11 @@ func recoverWithoutRefresh(metadataBytes []byte, pin string, serversURL string)
 	}
 
 	// Reconstruct auth codes
-	authCodes := &client.AuthCodes{
-		BaseAuthCode:    metadata.AuthCode,
-		ServerAuthCodes: make(map[string]string),
-	}
-
-	// Generate server-specific auth codes
-	for _, serverURL := range metadata.Servers {
-		combined := fmt.Sprintf("%s:%s", metadata.AuthCode, serverURL)
-		hash := sha256.Sum256([]byte(combined))
-		authCodes.ServerAuthCodes[serverURL] = fmt.Sprintf("%x", hash[:])
-	}
+	authCodes := client.AuthCodesFromBase(metadata.AuthCode, metadata.Servers)
 
 	result := client.RecoverEncryptionKeyWithServerInfo(identity, pin, serverInfos, metadata.Threshold, authCodes)
 	if result.Error != "" {
-		return nil, 0, &OcryptError{Message: fmt.Sprintf("OpenADP recovery failed: %s", result.Error), Code: "OPENADP_RECOVERY_FAILED"}
+		return nil, nil, &OcryptError{Message: fmt.Sprintf("OpenADP recovery failed: %s", result.Error), Code: "OPENADP_RECOVERY_FAILED"}
 	}
 
 	fmt.Println("✅ Successfully recovered encryption key")
@@ -322,12 +409,12 @@ func recoverWithoutRefresh(metadataBytes []byte, pin string, serversURL string)
 	fmt.Println("🔐 Validating PIN by unwrapping secret...")
 	secret, err := unwrapSecret(&metadata.WrappedLongTermSecret, result.EncryptionKey)
 	if err != nil {
-		return nil, 0, &OcryptError{Message: fmt.Sprintf("Invalid PIN or corrupted data: %v", err), Code: "INVALID_PIN"}
+		return nil, nil, &OcryptError{Message: fmt.Sprintf("Invalid PIN or corrupted data: %v", err), Code: "INVALID_PIN"}
 	}
 
 	fmt.Println("✅ PIN validation successful - secret unwrapped")
 
-	return secret, 0, nil
+	return secret, authCodes, nil
 }
 
 // registerWithCommitInternal implements two-phase commit for backup refresh
@@ -5,6 +5,9 @@ import (
 	"encoding/json"
 	"strings"
 	"testing"
+
+	"github.com/openadp/ocrypt/client"
+	"github.com/openadp/ocrypt/testserver"
 )
 
 // TestRegisterInputValidation tests input validation for Register function
@@ -365,6 +368,83 @@ func TestRegisterUsesDefaultBackupID(t *testing.T) {
 	}
 }
 
+// newTestServers starts n in-memory OpenADP servers
+func newTestServers(t *testing.T, n int) []client.ServerInfo {
+	serverInfos := make([]client.ServerInfo, n)
+	for i := range serverInfos {
+		server := testserver.New()
+		t.Cleanup(server.Close)
+		serverInfos[i] = client.ServerInfo{URL: server.URL, PublicKey: server.PublicKeyString()}
+	}
+	return serverInfos
+}
+
+// TestRegisterRecoverWithServers tests the registry-free round trip
+func TestRegisterRecoverWithServers(t *testing.T) {
+	serverInfos := newTestServers(t, 3)
+	secret := bytes.Repeat([]byte{0xab}, 32)
+
+	metadata, err := RegisterWithServers("alice@example.com", "test_app", secret, "password123", 5, serverInfos)
+	if err != nil {
+		t.Fatalf("RegisterWithServers() error: %v", err)
+	}
+
+	var parsed Metadata
+	if err := json.Unmarshal(metadata, &parsed); err != nil {
+		t.Fatalf("Metadata is not valid JSON: %v", err)
+	}
+	if len(parsed.ServerPublicKeys) != len(serverInfos) {
+		t.Errorf("Metadata records %d public keys, want %d", len(parsed.ServerPublicKeys), len(serverInfos))
+	}
+
+	recovered, remaining, err := RecoverWithServers(metadata, "password123")
+	if err != nil {
+		t.Fatalf("RecoverWithServers() error: %v", err)
+	}
+	if !bytes.Equal(recovered, secret) {
+		t.Errorf("RecoverWithServers() = %x, want %x", recovered, secret)
+	}
+	if remaining != 4 {
+		t.Errorf("RecoverWithServers() remaining = %d, want 4", remaining)
+	}
+
+	_, _, err = RecoverWithServers(metadata, "wrong-password")
+	if err == nil || !strings.Contains(err.Error(), "INVALID_PIN") {
+		t.Errorf("RecoverWithServers() with wrong password error = %v, want INVALID_PIN", err)
+	}
+}
+
+// TestRegisterRecoverWithServersInputValidation tests input validation of the registry-free API
+func TestRegisterRecoverWithServersInputValidation(t *testing.T) {
+	if _, err := RegisterWithServers("user", "app", []byte("secret"), "pin", 10, nil); err == nil || !strings.Contains(err.Error(), "NO_SERVERS") {
+		t.Errorf("RegisterWithServers() with no servers error = %v, want NO_SERVERS", err)
+	}
+	if _, err := RegisterWithServers("", "app", []byte("secret"), "pin", 10, nil); err == nil || !strings.Contains(err.Error(), "user_id") {
+		t.Errorf("RegisterWithServers() with empty user error = %v, want user_id error", err)
+	}
+
+	tests := []struct {
+		name          string
+		metadata      []byte
+		password      string
+		errorContains string
+	}{
+		{"empty metadata", nil, "pin", "metadata cannot be empty"},
+		{"empty password", []byte("{}"), "", "pin must be a non-empty string"},
+		{"invalid metadata", []byte("not json"), "pin", "INVALID_METADATA"},
+		{"no servers", []byte(`{"servers":[]}`), "pin", "lists no servers"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := RecoverWithServers(tt.metadata, tt.password)
+			if err == nil || !strings.Contains(err.Error(), tt.errorContains) {
+				t.Errorf("RecoverWithServers() error = %v, want error containing %q", err, tt.errorContains)
+			}
+		})
+	}
+}
+
 // Benchmark tests
 func BenchmarkWrapSecret(b *testing.B) {
 	secret := make([]byte, 1024) // 1KB secret
@@ -0,0 +1,895 @@
+// Package testserver provides an in-memory OpenADP server for tests.
+//
+// A Server speaks the same JSON-RPC 2.0 surface as a real OpenADP server
+// (Echo, GetServerInfo, RegisterSecret, RecoverSecret, ListBackups and the
+// Noise-NK noise_handshake / encrypted_call pair) over an httptest server, so
+// the client package can run full registration and recovery round trips
+// without network access. Faults can be injected per method with SetFault to
+// exercise client error paths.
+package testserver
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/flynn/noise"
+	"github.com/openadp/ocrypt/common"
+)
+
+// Fault selects a misbehavior to inject into a server's responses
+type Fault int
+
+const (
+	// FaultNone disables fault injection
+	FaultNone Fault = iota
+
+	// FaultStaleGuessCounter makes ListBackups report each backup's guess
+	// counter one behind its true value, as a lagging replica would.
+	FaultStaleGuessCounter
+
+	// FaultDropConnection processes the request and then closes the connection
+	// partway through writing the response. State changes such as a consumed
+	// guess are kept, as they would be on a real server.
+	FaultDropConnection
+
+	// FaultUnavailable answers every request with HTTP 503 without processing it.
+	FaultUnavailable
+
+	// FaultReuseEphemeral, set on "noise_handshake", answers every handshake with
+	// the same ephemeral key, as a broken or malicious server would. Sessions
+	// still complete, but lose forward secrecy.
+	FaultReuseEphemeral
+
+	// FaultCorruptShare makes RecoverSecret answer from a share other than the
+	// one registered, as a server with a corrupted store would. The guess is
+	// spent as usual.
+	FaultCorruptShare
+)
+
+// backupKey is the primary key of a stored share
+type backupKey struct {
+	uid, did, bid string
+}
+
+// backup is a share stored by the server
+type backup struct {
+	authCode   string
+	version    int
+	x          int
+	y          *big.Int
+	numGuesses int
+	maxGuesses int
+	expiration int
+	exhausted  time.Time // When the last guess was spent
+
+	idempotencyKey string // Key of the registration that stored the share, if any
+	shareVersion   int    // Share format version reported with the share, zero for none
+}
+
+// rpcError is an error reported to the client as a JSON-RPC error object
+type rpcError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// Server is an in-memory OpenADP server listening on a local httptest server
+type Server struct {
+	// URL is the base URL of the server, e.g. http://127.0.0.1:1234
+	URL string
+
+	// PublicKey is the server's Noise-NK static public key
+	PublicKey []byte
+
+	httpServer *httptest.Server
+	noiseKey   noise.DHKey
+
+	mu           sync.Mutex
+	backups      map[backupKey]*backup
+	sessions     map[string]*common.NoiseNK
+	faults       map[string]Fault
+	faultsOnce   map[string]Fault
+	latency      map[string]time.Duration
+	suites       []string
+	capabilities []string
+	serverInfo   map[string]interface{}
+	cooldown     time.Duration
+	nonces       map[string]bool // Replay nonces already accepted
+	clockOffset  time.Duration
+	ephemeral    []byte // Ephemeral secret reused under FaultReuseEphemeral
+	shareVersion int    // Share format version recorded for new registrations
+}
+
+// replayProtection is the capability under which the server checks the replay
+// fields of encrypted requests; see client.CapabilityReplayProtection
+const replayProtection = "replay_protection"
+
+// maxClockSkew is the furthest a request timestamp may be from the server's clock
+// when replay protection is advertised, unless the request asks for less
+const maxClockSkew = 5 * time.Minute
+
+// replayRejectedCode is the JSON-RPC error code of replayed and stale requests
+const replayRejectedCode = -32010
+
+// New starts a server with a fresh Noise-NK keypair. The caller must call Close
+// when finished.
+func New() *Server {
+	keypair, err := common.GenerateKeypair()
+	if err != nil {
+		panic(fmt.Sprintf("testserver: failed to generate keypair: %v", err))
+	}
+
+	s := &Server{
+		PublicKey:  keypair.Public,
+		noiseKey:   keypair,
+		backups:    make(map[backupKey]*backup),
+		sessions:   make(map[string]*common.NoiseNK),
+		faults:     make(map[string]Fault),
+		faultsOnce: make(map[string]Fault),
+		latency:    make(map[string]time.Duration),
+	}
+	s.httpServer = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	s.URL = s.httpServer.URL
+	return s
+}
+
+// Close shuts down the server and blocks until all outstanding requests complete
+func (s *Server) Close() {
+	s.httpServer.Close()
+}
+
+// PublicKeyString returns the public key in the "ed25519:<base64>" form used in
+// servers.json and ServerInfo.PublicKey.
+func (s *Server) PublicKeyString() string {
+	return "ed25519:" + base64.StdEncoding.EncodeToString(s.PublicKey)
+}
+
+// SetFault injects fault into every call of method, which may be a plain or an
+// encrypted method name such as "RecoverSecret". An empty method applies the
+// fault to all methods. FaultNone clears a previously set fault.
+func (s *Server) SetFault(method string, fault Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if fault == FaultNone {
+		delete(s.faults, method)
+	} else {
+		s.faults[method] = fault
+	}
+}
+
+// SetLatency delays the response to every call of method by d, to simulate a slow
+// server. As with SetFault, method may be an encrypted method name, and an empty
+// method applies to all methods. The request is processed before the delay, so
+// state changes are kept even if the client gives up waiting.
+func (s *Server) SetLatency(method string, d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if d <= 0 {
+		delete(s.latency, method)
+	} else {
+		s.latency[method] = d
+	}
+}
+
+// SetNoiseSuites sets the Noise cipher suites the server accepts and advertises
+// in GetServerInfo. By default the server accepts only common.DefaultNoiseSuite
+// and advertises nothing, like servers that predate suite negotiation.
+func (s *Server) SetNoiseSuites(suites ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.suites = suites
+}
+
+// SetFaultOnce injects fault into the next response to method only, for example
+// FaultDropConnection to lose the response to one request that the server did
+// process. FaultStaleGuessCounter and FaultCorruptShare are not supported.
+func (s *Server) SetFaultOnce(method string, fault Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faultsOnce[method] = fault
+}
+
+// SetCapabilities sets the capabilities advertised in GetServerInfo. By default
+// the server advertises none, like servers that predate capability discovery,
+// even though it always accepts an idempotency key with RegisterSecret.
+func (s *Server) SetCapabilities(capabilities ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.capabilities = capabilities
+}
+
+// SetClockOffset moves the server's clock, as seen by replay protection, by d
+// from the local clock, to simulate clock skew between client and server
+func (s *Server) SetClockOffset(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clockOffset = d
+}
+
+// SetServerInfo adds key with value to the GetServerInfo response, for example
+// "protocol_versions" or "pin_kdfs" to advertise differing capabilities. A nil
+// value removes the key again. The server's behaviour is not affected.
+func (s *Server) SetServerInfo(key string, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if value == nil {
+		delete(s.serverInfo, key)
+		return
+	}
+	if s.serverInfo == nil {
+		s.serverInfo = make(map[string]interface{})
+	}
+	s.serverInfo[key] = value
+}
+
+// SetShareVersion makes the server record version as the share format version
+// of the backups registered from now on, and report it as "protocol_version"
+// when they are recovered, as servers that keep the version of each share do.
+// Zero (the default) records and reports none. Backups registered earlier keep
+// the version they were recorded with.
+func (s *Server) SetShareVersion(version int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.shareVersion = version
+}
+
+// SetGuessCooldown makes the error for a backup whose guesses are exhausted
+// report, as {"retry_after": <Unix seconds>} in the JSON-RPC error data, that the
+// backup may be retried d after its last guess was spent. The server does not
+// actually unlock the backup. Zero (the default) reports no cooldown.
+func (s *Server) SetGuessCooldown(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cooldown = d
+}
+
+// NumGuesses returns the guess counter of a stored backup, or -1 if the server
+// does not hold it.
+func (s *Server) NumGuesses(uid, did, bid string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, ok := s.backups[backupKey{uid, did, bid}]
+	if !ok {
+		return -1
+	}
+	return b.numGuesses
+}
+
+// SetNumGuesses overwrites the guess counter of a stored backup, as a server
+// that resets its counters would, and reports whether the server holds the backup.
+func (s *Server) SetNumGuesses(uid, did, bid string, numGuesses int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, ok := s.backups[backupKey{uid, did, bid}]
+	if ok {
+		b.numGuesses = numGuesses
+	}
+	return ok
+}
+
+// SetBackupLimits overwrites the guess budget and expiration (Unix seconds, 0 for
+// never) of a stored backup, as a partial re-registration would, and reports
+// whether the server holds the backup.
+func (s *Server) SetBackupLimits(uid, did, bid string, maxGuesses, expiration int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, ok := s.backups[backupKey{uid, did, bid}]
+	if ok {
+		b.maxGuesses = maxGuesses
+		b.expiration = expiration
+	}
+	return ok
+}
+
+// fault returns the fault configured for method
+func (s *Server) fault(method string) Fault {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if fault, ok := s.faults[method]; ok {
+		return fault
+	}
+	return s.faults[""]
+}
+
+// responseFault returns the fault to apply to the response to method, consuming a
+// fault set with SetFaultOnce
+func (s *Server) responseFault(method string) Fault {
+	s.mu.Lock()
+	fault, ok := s.faultsOnce[method]
+	delete(s.faultsOnce, method)
+	s.mu.Unlock()
+
+	if ok {
+		return fault
+	}
+	return s.fault(method)
+}
+
+// methodLatency returns the response delay configured for method
+func (s *Server) methodLatency(method string) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if latency, ok := s.latency[method]; ok {
+		return latency
+	}
+	return s.latency[""]
+}
+
+// serveHTTP decodes a JSON-RPC request, dispatches it and writes the response
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Method string        `json:"method"`
+		Params []interface{} `json:"params"`
+		ID     interface{}   `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON-RPC request", http.StatusBadRequest)
+		return
+	}
+
+	method := req.Method
+	var result interface{}
+	var rpcErr *rpcError
+
+	switch req.Method {
+	case "noise_handshake":
+		result, rpcErr = s.noiseHandshake(req.Params)
+	case "encrypted_call":
+		// Faults apply to the inner method, which is only known after decryption
+		method, result, rpcErr = s.encryptedCall(req.Params)
+	default:
+		if s.fault(method) == FaultUnavailable {
+			http.Error(w, "service unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		result, rpcErr = s.call(req.Method, req.Params)
+	}
+
+	body := response(req.ID, result, rpcErr)
+
+	if latency := s.methodLatency(method); latency > 0 {
+		select {
+		case <-time.After(latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	switch s.responseFault(method) {
+	case FaultUnavailable:
+		http.Error(w, "service unavailable", http.StatusServiceUnavailable)
+	case FaultDropConnection:
+		dropConnection(w, body)
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	}
+}
+
+// response encodes a JSON-RPC 2.0 response carrying either result or rpcErr
+func response(id, result interface{}, rpcErr *rpcError) []byte {
+	resp := map[string]interface{}{"jsonrpc": "2.0", "id": id}
+	if rpcErr != nil {
+		resp["error"] = rpcErr
+	} else {
+		resp["result"] = result
+	}
+	body, _ := json.Marshal(resp)
+	return body
+}
+
+// dropConnection writes the response headers and half of body, then closes the
+// underlying connection so the client sees a truncated response.
+func dropConnection(w http.ResponseWriter, body []byte) {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		panic("testserver: response writer does not support hijacking")
+	}
+	conn, buf, err := hijacker.Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	fmt.Fprintf(buf, "HTTP/1.1 200 OK\r\nContent-Type: application/json\r\nContent-Length: %d\r\n\r\n", len(body))
+	buf.Write(body[:len(body)/2])
+	buf.Flush()
+}
+
+// call runs an unencrypted method
+func (s *Server) call(method string, params []interface{}) (interface{}, *rpcError) {
+	switch method {
+	case "Echo":
+		if len(params) != 1 {
+			return nil, invalidParams("Echo expects 1 parameter")
+		}
+		return params[0], nil
+	case "GetServerInfo":
+		info := map[string]interface{}{
+			"version":             "testserver",
+			"noise_nk_public_key": base64.StdEncoding.EncodeToString(s.PublicKey),
+		}
+		if suites := s.noiseSuites(); suites != nil {
+			info["noise_suites"] = suites
+		}
+		s.mu.Lock()
+		if s.capabilities != nil {
+			info["capabilities"] = s.capabilities
+		}
+		for key, value := range s.serverInfo {
+			info[key] = value
+		}
+		s.mu.Unlock()
+		return info, nil
+	case "RegisterSecret":
+		return s.registerSecret(params)
+	case "RecoverSecret":
+		return s.recoverSecret(params, s.fault(method) == FaultCorruptShare)
+	case "ListBackups":
+		return s.listBackups(params, s.fault(method) == FaultStaleGuessCounter)
+	case "ResetGuesses":
+		return s.resetGuesses(params)
+	case "DeleteBackup":
+		return s.deleteBackup(params)
+	}
+	return nil, &rpcError{Code: -32601, Message: fmt.Sprintf("method not found: %s", method)}
+}
+
+// registerSecret stores a share: [auth_code, uid, did, bid, version, x, y, max_guesses,
+// expiration] and an optional idempotency key. A repeated registration with the
+// key that stored the current share is acknowledged without storing it again.
+func (s *Server) registerSecret(params []interface{}) (interface{}, *rpcError) {
+	if len(params) != 9 && len(params) != 10 {
+		return nil, invalidParams("RegisterSecret expects 9 or 10 parameters")
+	}
+	var idempotencyKey string
+	if len(params) == 10 {
+		key, ok := params[9].(string)
+		if !ok {
+			return nil, invalidParams("idempotency key must be a string")
+		}
+		idempotencyKey = key
+	}
+
+	authCode, uid, did, bid, err := stringParams4(params)
+	if err != nil {
+		return nil, invalidParams(err.Error())
+	}
+	version, okVersion := params[4].(float64)
+	x, okX := params[5].(float64)
+	yB64, okY := params[6].(string)
+	maxGuesses, okMax := params[7].(float64)
+	expiration, okExp := params[8].(float64)
+	if !okVersion || !okX || !okY || !okMax || !okExp {
+		return nil, invalidParams("RegisterSecret parameters have the wrong type")
+	}
+
+	yBytes, err := base64.StdEncoding.DecodeString(yB64)
+	if err != nil || len(yBytes) != 32 {
+		return nil, invalidParams("y must be a base64-encoded 32-byte value")
+	}
+
+	// y is little-endian
+	reversed := make([]byte, len(yBytes))
+	for i, b := range yBytes {
+		reversed[len(yBytes)-1-i] = b
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := backupKey{uid, did, bid}
+	if existing, ok := s.backups[key]; ok && idempotencyKey != "" && existing.idempotencyKey == idempotencyKey {
+		return true, nil
+	}
+	s.backups[key] = &backup{
+		authCode:       authCode,
+		version:        int(version),
+		x:              int(x),
+		y:              new(big.Int).SetBytes(reversed),
+		maxGuesses:     int(maxGuesses),
+		expiration:     int(expiration),
+		idempotencyKey: idempotencyKey,
+		shareVersion:   s.shareVersion,
+	}
+	return true, nil
+}
+
+// recoverSecret consumes a guess and returns y*B: [auth_code, uid, did, bid, b, guess_num]
+func (s *Server) recoverSecret(params []interface{}, corrupt bool) (interface{}, *rpcError) {
+	if len(params) != 6 {
+		return nil, invalidParams("RecoverSecret expects 6 parameters")
+	}
+
+	authCode, uid, did, bid, err := stringParams4(params)
+	if err != nil {
+		return nil, invalidParams(err.Error())
+	}
+	bB64, okB := params[4].(string)
+	guessNum, okGuess := params[5].(float64)
+	if !okB || !okGuess {
+		return nil, invalidParams("RecoverSecret parameters have the wrong type")
+	}
+
+	bBytes, err := base64.StdEncoding.DecodeString(bB64)
+	if err != nil {
+		return nil, invalidParams("b must be base64-encoded")
+	}
+	B, err := common.PointDecompress(bBytes)
+	if err != nil || !common.IsValidPoint(B) {
+		return nil, invalidParams("b is not a valid point")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, ok := s.backups[backupKey{uid, did, bid}]
+	if !ok {
+		return nil, serverError("backup not found")
+	}
+	if subtle.ConstantTimeCompare([]byte(b.authCode), []byte(authCode)) != 1 {
+		return nil, serverError("invalid auth code")
+	}
+	if b.expiration > 0 && time.Now().Unix() > int64(b.expiration) {
+		return nil, serverError("backup expired")
+	}
+	if b.numGuesses >= b.maxGuesses {
+		rpcErr := serverError("too many guesses")
+		if s.cooldown > 0 {
+			exhausted := b.exhausted
+			if exhausted.IsZero() {
+				exhausted = time.Now()
+			}
+			rpcErr.Data = map[string]interface{}{"retry_after": exhausted.Add(s.cooldown).Unix()}
+		}
+		return nil, rpcErr
+	}
+	if int(guessNum) != b.numGuesses {
+		return nil, serverError(fmt.Sprintf("invalid guess_num %d, expecting guess_num = %d", int(guessNum), b.numGuesses))
+	}
+
+	b.numGuesses++
+	if b.numGuesses >= b.maxGuesses {
+		b.exhausted = time.Now()
+	}
+	y := b.y
+	if corrupt {
+		y = new(big.Int).Add(y, big.NewInt(1))
+	}
+	siB := common.PointMul(y, B)
+
+	result := map[string]interface{}{
+		"version":     b.version,
+		"x":           b.x,
+		"si_b":        base64.StdEncoding.EncodeToString(common.PointCompress(siB)),
+		"num_guesses": b.numGuesses,
+		"max_guesses": b.maxGuesses,
+		"expiration":  b.expiration,
+	}
+	if b.shareVersion != 0 {
+		result["protocol_version"] = b.shareVersion
+	}
+	return result, nil
+}
+
+// resetGuesses sets the guess counter of a backup back to zero: [auth_code, uid,
+// did, bid]. The server accepts it whether or not it advertises the capability.
+func (s *Server) resetGuesses(params []interface{}) (interface{}, *rpcError) {
+	if len(params) != 4 {
+		return nil, invalidParams("ResetGuesses expects 4 parameters")
+	}
+	authCode, uid, did, bid, err := stringParams4(params)
+	if err != nil {
+		return nil, invalidParams(err.Error())
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, ok := s.backups[backupKey{uid, did, bid}]
+	if !ok {
+		return nil, serverError("backup not found")
+	}
+	if subtle.ConstantTimeCompare([]byte(b.authCode), []byte(authCode)) != 1 {
+		return nil, serverError("invalid auth code")
+	}
+	b.numGuesses = 0
+	b.exhausted = time.Time{}
+	return true, nil
+}
+
+// deleteBackup removes a backup: [auth_code, uid, did, bid]. The server accepts
+// it whether or not it advertises the capability.
+func (s *Server) deleteBackup(params []interface{}) (interface{}, *rpcError) {
+	if len(params) != 4 {
+		return nil, invalidParams("DeleteBackup expects 4 parameters")
+	}
+	authCode, uid, did, bid, err := stringParams4(params)
+	if err != nil {
+		return nil, invalidParams(err.Error())
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := backupKey{uid, did, bid}
+	b, ok := s.backups[key]
+	if !ok {
+		return nil, serverError("backup not found")
+	}
+	if subtle.ConstantTimeCompare([]byte(b.authCode), []byte(authCode)) != 1 {
+		return nil, serverError("invalid auth code")
+	}
+	delete(s.backups, key)
+	return true, nil
+}
+
+// listBackups lists the backups of a user: [uid]
+func (s *Server) listBackups(params []interface{}, stale bool) (interface{}, *rpcError) {
+	if len(params) != 1 {
+		return nil, invalidParams("ListBackups expects 1 parameter")
+	}
+	uid, ok := params[0].(string)
+	if !ok {
+		return nil, invalidParams("uid must be a string")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	backups := []map[string]interface{}{}
+	for key, b := range s.backups {
+		if key.uid != uid {
+			continue
+		}
+		numGuesses := b.numGuesses
+		if stale && numGuesses > 0 {
+			numGuesses--
+		}
+		backups = append(backups, map[string]interface{}{
+			"uid":         key.uid,
+			"did":         key.did,
+			"bid":         key.bid,
+			"version":     b.version,
+			"num_guesses": numGuesses,
+			"max_guesses": b.maxGuesses,
+			"expiration":  b.expiration,
+		})
+	}
+	return backups, nil
+}
+
+// noiseHandshake answers the first Noise-NK handshake message: [{session, message}]
+func (s *Server) noiseHandshake(params []interface{}) (interface{}, *rpcError) {
+	session, message, err := sessionParams(params, "message")
+	if err != nil {
+		return nil, invalidParams(err.Error())
+	}
+
+	suite, _ := params[0].(map[string]interface{})["suite"].(string)
+	if suite == "" {
+		suite = common.DefaultNoiseSuite
+	}
+	if !s.acceptsSuite(suite) {
+		return nil, serverError(fmt.Sprintf("unsupported Noise cipher suite %q", suite))
+	}
+
+	responder, err := common.NewNoiseNKWithSuite("responder", &s.noiseKey, nil, []byte(""), suite)
+	if err != nil {
+		return nil, serverError(err.Error())
+	}
+	if s.fault("noise_handshake") == FaultReuseEphemeral {
+		if err := responder.SetRandom(repeatingReader(s.reusedEphemeral())); err != nil {
+			return nil, serverError(err.Error())
+		}
+	}
+	if _, err := responder.ReadHandshakeMessage(message); err != nil {
+		return nil, serverError(fmt.Sprintf("handshake failed: %v", err))
+	}
+	reply, err := responder.WriteHandshakeMessage(nil)
+	if err != nil {
+		return nil, serverError(fmt.Sprintf("handshake failed: %v", err))
+	}
+
+	s.mu.Lock()
+	s.sessions[session] = responder
+	s.mu.Unlock()
+
+	return map[string]interface{}{"message": base64.StdEncoding.EncodeToString(reply)}, nil
+}
+
+// reusedEphemeral returns the ephemeral secret of every handshake under
+// FaultReuseEphemeral, drawing it on first use
+func (s *Server) reusedEphemeral() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ephemeral == nil {
+		s.ephemeral = make([]byte, 32)
+		rand.Read(s.ephemeral)
+	}
+	return s.ephemeral
+}
+
+// repeatingReader yields its bytes over and over
+type repeatingReader []byte
+
+func (r repeatingReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = r[i%len(r)]
+	}
+	return len(p), nil
+}
+
+// noiseSuites returns the advertised cipher suites, nil if none are advertised
+func (s *Server) noiseSuites() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.suites
+}
+
+// acceptsSuite reports whether the server completes handshakes using suite
+func (s *Server) acceptsSuite(suite string) bool {
+	suites := s.noiseSuites()
+	if suites == nil {
+		return suite == common.DefaultNoiseSuite
+	}
+	for _, accepted := range suites {
+		if accepted == suite {
+			return true
+		}
+	}
+	return false
+}
+
+// encryptedCall decrypts and runs a method on an established session: [{session, data}].
+// It returns the inner method name so that faults can be applied to it.
+func (s *Server) encryptedCall(params []interface{}) (string, interface{}, *rpcError) {
+	session, data, err := sessionParams(params, "data")
+	if err != nil {
+		return "encrypted_call", nil, invalidParams(err.Error())
+	}
+
+	// Sessions are single-use, as on a real server
+	s.mu.Lock()
+	responder, ok := s.sessions[session]
+	delete(s.sessions, session)
+	s.mu.Unlock()
+	if !ok {
+		return "encrypted_call", nil, serverError("unknown session")
+	}
+
+	plaintext, err := responder.Decrypt(data, nil)
+	if err != nil {
+		return "encrypted_call", nil, serverError(fmt.Sprintf("decryption failed: %v", err))
+	}
+
+	var inner struct {
+		Method string        `json:"method"`
+		Params []interface{} `json:"params"`
+		ID     interface{}   `json:"id"`
+		Replay *replayFields `json:"replay"`
+	}
+	if err := json.Unmarshal(plaintext, &inner); err != nil {
+		return "encrypted_call", nil, invalidParams("invalid encrypted request")
+	}
+
+	if s.fault(inner.Method) == FaultUnavailable {
+		return inner.Method, nil, nil
+	}
+	var result interface{}
+	rpcErr := s.checkReplay(inner.Replay)
+	if rpcErr == nil {
+		result, rpcErr = s.call(inner.Method, inner.Params)
+	}
+
+	ciphertext, err := responder.Encrypt(response(inner.ID, result, rpcErr), nil)
+	if err != nil {
+		return inner.Method, nil, serverError(fmt.Sprintf("encryption failed: %v", err))
+	}
+	return inner.Method, map[string]interface{}{"data": base64.StdEncoding.EncodeToString(ciphertext)}, nil
+}
+
+// replayFields are the replay protection fields of an encrypted request
+type replayFields struct {
+	Nonce     string `json:"nonce"`
+	Timestamp int64  `json:"timestamp"`
+	MaxSkew   int64  `json:"max_skew"`
+}
+
+// checkReplay rejects a request whose nonce was seen before or whose timestamp is
+// too far from the server's clock, if the server advertises replay protection
+func (s *Server) checkReplay(replay *replayFields) *rpcError {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	advertised := false
+	for _, capability := range s.capabilities {
+		advertised = advertised || capability == replayProtection
+	}
+	if !advertised {
+		return nil
+	}
+	if replay == nil || replay.Nonce == "" {
+		return &rpcError{Code: replayRejectedCode, Message: "missing replay nonce"}
+	}
+
+	skew := maxClockSkew
+	if requested := time.Duration(replay.MaxSkew) * time.Second; requested > 0 && requested < skew {
+		skew = requested
+	}
+	offset := time.Now().Add(s.clockOffset).Sub(time.Unix(replay.Timestamp, 0))
+	if offset > skew || offset < -skew {
+		return &rpcError{Code: replayRejectedCode, Message: fmt.Sprintf("stale request: timestamp is %v from the server clock", offset.Round(time.Second))}
+	}
+	if s.nonces[replay.Nonce] {
+		return &rpcError{Code: replayRejectedCode, Message: "replayed request"}
+	}
+	if s.nonces == nil {
+		s.nonces = make(map[string]bool)
+	}
+	s.nonces[replay.Nonce] = true
+	return nil
+}
+
+// stringParams4 extracts the leading auth_code, uid, did and bid parameters
+func stringParams4(params []interface{}) (authCode, uid, did, bid string, err error) {
+	var values [4]string
+	for i := range values {
+		v, ok := params[i].(string)
+		if !ok {
+			return "", "", "", "", fmt.Errorf("parameter %d must be a string", i)
+		}
+		values[i] = v
+	}
+	return values[0], values[1], values[2], values[3], nil
+}
+
+// sessionParams extracts the session ID and a base64 field from [{session, field}]
+func sessionParams(params []interface{}, field string) (string, []byte, error) {
+	if len(params) != 1 {
+		return "", nil, errors.New("expected 1 parameter")
+	}
+	obj, ok := params[0].(map[string]interface{})
+	if !ok {
+		return "", nil, errors.New("parameter must be an object")
+	}
+	session, ok := obj["session"].(string)
+	if !ok || session == "" {
+		return "", nil, errors.New("missing session")
+	}
+	encoded, ok := obj[field].(string)
+	if !ok {
+		return "", nil, fmt.Errorf("missing %s", field)
+	}
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", nil, fmt.Errorf("%s must be base64-encoded", field)
+	}
+	return session, decoded, nil
+}
+
+func invalidParams(message string) *rpcError {
+	return &rpcError{Code: -32602, Message: message}
+}
+
+func serverError(message string) *rpcError {
+	return &rpcError{Code: -32000, Message: message}
+}